@@ -10,7 +10,9 @@ import (
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/tracing"
 )
 
 func init() {
@@ -19,6 +21,10 @@ func init() {
 
 const defaultMaxRetries = 5
 
+// maxExited is the containers that exited whose batches may wait to be
+// submitted, those of later ones are submitted with the DELAY as usual
+const maxExited = 100
+
 // Adapter is an adapter that streams JSON to AWS CloudwatchLogs.
 // It mostly just checkes ENV vars and other container info to determine
 // the LogGroup and LogStream for each message, then sends each message
@@ -30,96 +36,336 @@ type Adapter struct {
 	Ec2Instance string
 	maxRetries  int
 
-	client      *docker.Client
-	batcher     *Batcher          // batches up messages by log group and stream
-	groupnames  map[string]string // maps container names to log groups
-	streamnames map[string]string // maps container names to log streams
+	batcher        *Batcher                      // batches up messages by log group and stream
+	binary         *binaryPolicy                 // handles containers emitting binary output
+	priority       bool                          // flush batches on error-severity lines
+	logTime        bool                          // timestamp events with the time of their line
+	clock          *clock                        // timestamps events received, corrected by the skew from AWS
+	insights       bool                          // ship lines as JSON Logs Insights discovers the fields of
+	truncate       bool                          // truncate lines too large for an event rather than split them
+	dropOutOfRange bool                          // drop lines whose time CloudWatch rejects rather than clamp it
+	state          *stateFile                    // persists stream state across restarts
+	dedup          *dedup                        // skips lines shipped before a restart
+	tenants        *tenants                      // ships containers of tenants to their accounts
+	sharder        *streamSharder                // spreads the lines of containers over shards of their stream
+	starting       bool                          // ships the lines of containers starting to a stream of their own
+	exited         chan string                   // IDs of containers that exited, whose batches are submitted
+	flushed        chan string                   // names of streams whose batches were asked to be submitted
+	groupnames     map[string]string             // maps container names to log groups
+	streamnames    map[string]string             // maps container names to log streams
+	tenantnames    map[string]string             // maps container names to their tenants
+	namedFor       map[string]string             // maps container IDs to the name their names were rendered for
+	templates      map[string]*template.Template // parsed naming templates, by their text, until containers expire
+	retries        map[string]int                // maps container names to their retry budget
+	expiry         time.Duration                 // release the state of containers idle for this long
+	lastSeen       map[string]time.Time          // when each container last logged
+	lastExpiry     time.Time
 }
 
 // NewAdapter creates a CloudwatchAdapter for the current region.
 func NewAdapter(route *router.Route) (router.LogAdapter, error) {
-	maxRetries := defaultMaxRetries
-	if envVal := os.Getenv(`MAX_RETRIES`); envVal != "" {
-		i, err := strconv.Atoi(envVal)
-		if err != nil {
-			return nil, err
-		}
-		maxRetries = i
+	maxRetries := cfg.GetInt(`MAX_RETRIES`)
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
 	}
-	dockerHost := `unix:///var/run/docker.sock`
-	if envVal := os.Getenv(`DOCKER_HOST`); envVal != "" {
-		dockerHost = envVal
+	ec2info, err := NewEC2Info(route) // get info from EC2
+	if err != nil {
+		return nil, err
 	}
-	client, err := docker.NewClient(dockerHost)
+	binary, err := newBinaryPolicy(route)
 	if err != nil {
 		return nil, err
 	}
-	hostname, err := os.Hostname()
+	state, err := loadStateFile(getOption(route, `STATE_FILE`, ""))
 	if err != nil {
 		return nil, err
 	}
-	ec2info, err := NewEC2Info(route) // get info from EC2
+	tenantFile, err := loadTenants(route)
+	if err != nil {
+		return nil, err
+	}
+	sharder, err := newStreamSharder(route)
 	if err != nil {
 		return nil, err
 	}
 	adapter := Adapter{
-		Route:       route,
-		OsHost:      hostname,
-		Ec2Instance: ec2info.InstanceID,
-		Ec2Region:   ec2info.Region,
-		maxRetries:  maxRetries,
-		client:      client,
-		groupnames:  map[string]string{},
-		streamnames: map[string]string{},
+		Route:          route,
+		OsHost:         hostname,
+		Ec2Instance:    ec2info.InstanceID,
+		Ec2Region:      ec2info.Region,
+		maxRetries:     maxRetries,
+		binary:         binary,
+		state:          state,
+		dedup:          newDedup(route, state),
+		tenants:        tenantFile,
+		sharder:        sharder,
+		starting:       route.Options["health.starting"] == router.HealthSeparate,
+		exited:         make(chan string, maxExited),
+		flushed:        make(chan string, maxExited),
+		priority:       getOption(route, `PRIORITY_FLUSH`, "") == "true",
+		logTime:        getOption(route, `LOG_TIME`, "") == "true",
+		clock:          newClock(route),
+		insights:       getOption(route, `INSIGHTS_FIELDS`, "") == "true",
+		truncate:       getOption(route, `LONG_LINES`, longLinesSplit) == longLinesTruncate,
+		dropOutOfRange: getOption(route, `TIME_RANGE`, timeRangeClamp) == timeRangeDrop,
+		groupnames:     map[string]string{},
+		streamnames:    map[string]string{},
+		tenantnames:    map[string]string{},
+		namedFor:       map[string]string{},
+		templates:      map[string]*template.Template{},
+		retries:        map[string]int{},
+		expiry:         getDurationOption(route, `STATE_EXPIRY`, defaultStateExpiry),
+		lastSeen:       map[string]time.Time{},
+	}
+	if state != nil && adapter.expiry > 0 {
+		// containers that are gone expire from the STATE_FILE too
+		for _, id := range state.containerIDs() {
+			adapter.lastSeen[id] = time.Now()
+		}
+	}
+	for _, key := range []string{`LOGSPOUT_GROUP`, `LOGSPOUT_STREAM`} {
+		if text := getOption(route, key, ""); text != "" {
+			adapter.template(text) // parsed once, unless containers set their own
+		}
 	}
 	adapter.batcher = NewBatcher(&adapter)
 	return &adapter, nil
 }
 
-// Stream implements the router.LogAdapter interface.
+// Stream implements the router.LogAdapter interface. The batches of a
+// container that exited are submitted once its last lines were streamed.
 func (a *Adapter) Stream(logstream chan *router.Message) {
-	for m := range logstream {
-		// determine the log group name and log stream name
-		var groupName, streamName string
-		// first, check the in-memory cache so this work is done per-container
-		if cachedGroup, isCached := a.groupnames[m.Container.ID]; isCached {
-			groupName = cachedGroup
-		}
-		if cachedStream, isCached := a.streamnames[m.Container.ID]; isCached {
-			streamName = cachedStream
-		}
-		if (streamName == "") || (groupName == "") {
-			// make a render context with the required info
-			containerData, err := a.client.InspectContainer(m.Container.ID)
-			if err != nil {
-				log.Println("cloudwatch: error inspecting container:", err)
-				continue
+	for {
+		select {
+		case m, ok := <-logstream:
+			if !ok {
+				return
 			}
+			a.stream(m)
+		case id := <-a.exited:
+			a.batcher.exited <- id
+		case name := <-a.flushed:
+			a.batcher.streams <- name
+		}
+	}
+}
+
+// FlushContainer has the batches of a container that exited submitted
+// right away, rather than wait for more lines
+func (a *Adapter) FlushContainer(id string) {
+	select {
+	case a.exited <- id:
+	default: // submitted in time anyway
+	}
+}
+
+// FlushStream has the batches of a stream, and of its shards, submitted
+// right away, as asked through the flush API, unless flushes of other
+// streams are pending
+func (a *Adapter) FlushStream(name string) bool {
+	select {
+	case a.flushed <- name:
+		return true
+	default:
+		return false
+	}
+}
+
+// stream batches a line
+func (a *Adapter) stream(m *router.Message) {
+	// determine the log group name and log stream name
+	var groupName, streamName string
+	if named, isNamed := a.namedFor[m.Container.ID]; isNamed && named != m.Container.Name {
+		// renamed, the names may depend on the name of the container
+		delete(a.groupnames, m.Container.ID)
+		delete(a.streamnames, m.Container.ID)
+	}
+	// first, check the in-memory cache so this work is done per-container
+	if cachedGroup, isCached := a.groupnames[m.Container.ID]; isCached {
+		groupName = cachedGroup
+	}
+	if cachedStream, isCached := a.streamnames[m.Container.ID]; isCached {
+		streamName = cachedStream
+	}
+	if (streamName == "") || (groupName == "") {
+		if saved, isSaved := a.savedNames(m.Container); isSaved {
+			groupName, streamName = saved.Group, saved.Stream
+		} else {
+			span := tracing.Start(nil, "cloudwatch.attach").Set("container.id", m.Container.ID)
+			// make a render context with the required info, the
+			// container was inspected by the pump it came from
+			render := tracing.Start(span, "cloudwatch.render")
 			context := RenderContext{
-				Env:        parseEnv(m.Container.Config.Env),
-				Labels:     containerData.Config.Labels,
-				Name:       strings.TrimPrefix(m.Container.Name, `/`),
-				ID:         m.Container.ID,
-				Host:       m.Container.Config.Hostname,
-				LoggerHost: a.OsHost,
-				InstanceID: a.Ec2Instance,
-				Region:     a.Ec2Region,
+				Env:         parseEnv(m.Container.Config.Env),
+				Labels:      m.Container.Config.Labels,
+				ImageLabels: router.ImageLabels(m.Container),
+				Name:        strings.TrimPrefix(m.Container.Name, `/`),
+				ID:          m.Container.ID,
+				Host:        m.Container.Config.Hostname,
+				LoggerHost:  a.OsHost,
+				InstanceID:  a.Ec2Instance,
+				Region:      a.Ec2Region,
+				container:   m.Container,
 			}
-			groupName = a.renderEnvValue(`LOGSPOUT_GROUP`, &context, a.OsHost)
+			tenantName, tenant := a.tenants.of(m.Container.Config.Labels)
+			a.tenantnames[m.Container.ID] = tenantName
+			groupName = tenant.GroupPrefix + a.renderEnvValue(`LOGSPOUT_GROUP`, &context, a.OsHost)
 			streamName = a.renderEnvValue(`LOGSPOUT_STREAM`, &context, context.Name)
-			a.groupnames[m.Container.ID] = groupName   // cache the group name
-			a.streamnames[m.Container.ID] = streamName // and the stream name
+			render.End()
+			span.Set("group", groupName).Set("stream", streamName).Set("tenant", tenantName).End()
+			if a.state != nil { // persist them for the next start
+				a.state.setContainer(m.Container.ID, containerState{
+					Group: groupName, Stream: streamName, Templates: a.nameTemplates(), Name: m.Container.Name,
+				})
+			}
+		}
+		a.groupnames[m.Container.ID] = groupName   // cache the group name
+		a.streamnames[m.Container.ID] = streamName // and the stream name
+		a.namedFor[m.Container.ID] = m.Container.Name
+	}
+	a.seen(m.Container.ID, time.Now())
+	retries, isCached := a.retries[m.Container.ID]
+	if !isCached {
+		retries = a.retryBudget(m.Container)
+		a.retries[m.Container.ID] = retries
+	}
+	data, ok := a.binary.sanitize(m.Container.ID, m.Data)
+	if !ok {
+		m.Ack()
+		return
+	}
+	stream, shard := streamName, ""
+	if a.starting && router.Starting(m.Container) {
+		shard = startingShard
+		stream += "-" + shard
+	} else if a.sharder != nil {
+		shard = a.sharder.shard(data)
+		stream += "-" + shard
+	}
+	var hash uint64
+	if a.dedup != nil {
+		hash = hashLine(data)
+		if a.dedup.duplicate(streamKey(groupName, stream), hash) {
+			m.Ack()
+			return
 		}
-		a.batcher.Input <- Message{
-			Message:   m.Data,
-			Group:     groupName,
-			Stream:    streamName,
-			Time:      time.Now(),
-			Container: m.Container.ID,
+	}
+	eventTime, inRange := a.eventTime(m)
+	if !inRange {
+		if a.dropOutOfRange {
+			outOfRangeCounter.With(timeRangeDrop).Inc()
+			m.Ack()
+			return
+		}
+		outOfRangeCounter.With(timeRangeClamp).Inc()
+	}
+	event := data
+	if a.insights {
+		event = insightsEvent(m.Container, data)
+	}
+	msg := Message{
+		Message:   event,
+		Group:     groupName,
+		Stream:    stream,
+		Shard:     shard,
+		Time:      eventTime,
+		Container: m.Container.ID,
+		Tenant:    a.tenantnames[m.Container.ID],
+		Priority:  a.priority && router.DetectLevel(data).Severe(),
+		Retries:   retries,
+		Hash:      hash,
+		Origin:    m,
+	}
+	for _, part := range a.fit(msg) {
+		queued := time.Now()
+		a.batcher.Input <- part
+		if time.Since(queued) >= tracing.LockWaitThreshold { // the batcher is held up
+			tracing.StartAt(nil, "cloudwatch.enqueue", queued).Set("group", groupName).Set("stream", streamName).End()
 		}
 	}
 }
 
+// fit returns the events of a line: oversized lines are split, or
+// truncated with LONG_LINES=truncate
+func (a *Adapter) fit(msg Message) []Message {
+	if a.truncate {
+		return []Message{truncateMessage(msg)}
+	}
+	return splitMessage(msg)
+}
+
+// eventTime returns the timestamp of the event of a line: when it was
+// received, or with LOG_TIME the time of the line, like one read from a file.
+// The time of a line CloudWatch would reject, as more than 14 days old or 2
+// hours ahead, is replaced with when it was received, and false returned.
+func (a *Adapter) eventTime(m *router.Message) (time.Time, bool) {
+	now := a.clock.Now()
+	if !a.logTime || m.Time.IsZero() {
+		return now, true
+	}
+	if !inTimeRange(m.Time, now) {
+		return now, false
+	}
+	return m.Time, true
+}
+
+// Acknowledges tells the router the lines streamed are acknowledged once
+// uploaded, rather than once batched
+func (a *Adapter) Acknowledges() {}
+
+// Flush returns once the messages streamed were uploaded, or dead-lettered
+// if they could not be, for commands shipping a fixed set of lines
+func (a *Adapter) Flush() {
+	done := make(chan struct{})
+	a.batcher.flush <- done
+	<-done
+}
+
+// retryBudget returns how many times batches of a container are retried,
+// the retries option of its QoS class, or MAX_RETRIES
+func (a *Adapter) retryBudget(container *docker.Container) int {
+	class := router.QoSClass(container)
+	text := router.QoSOption(a.Route, "retries", class)
+	if text == "" {
+		return a.maxRetries
+	}
+	retries, err := strconv.Atoi(text)
+	if err != nil || retries < 0 {
+		log.Printf("cloudwatch: invalid retries for class %s: %s, using MAX_RETRIES\n", class, text)
+		return a.maxRetries
+	}
+	return retries
+}
+
+// savedNames returns the log group and stream of a container from the
+// STATE_FILE, unless the templates they were rendered from changed or the
+// container was renamed since. With a TENANT_FILE they are rendered again,
+// as it may have changed.
+func (a *Adapter) savedNames(container *docker.Container) (containerState, bool) {
+	if a.state == nil || a.tenants != nil {
+		return containerState{}, false
+	}
+	saved, isSaved := a.state.container(container.ID)
+	if !isSaved || saved.Templates != a.nameTemplates() ||
+		saved.Name != "" && saved.Name != container.Name { // older state files have no name
+		return containerState{}, false
+	}
+	return saved, true
+}
+
+// nameTemplates returns the LOGSPOUT_GROUP and LOGSPOUT_STREAM settings of
+// logspout, which group and stream names depend on besides the container
+func (a *Adapter) nameTemplates() string {
+	return getOption(a.Route, `LOGSPOUT_GROUP`, "") + "\n" + getOption(a.Route, `LOGSPOUT_STREAM`, "")
+}
+
+// Healthy implements the router.HealthChecker interface, it returns false
+// while batches are failing to upload.
+func (a *Adapter) Healthy() bool {
+	return a.batcher.uploader.Healthy()
+}
+
 // Searches the OS environment, then the route options, then the render context
 // Env for a given key, then uses the value (or the provided default value)
 // as template text, which is then rendered in the given context.
@@ -127,8 +373,8 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 func (a *Adapter) renderEnvValue(
 	envKey string, context *RenderContext, defaultVal string) string {
 	finalVal := defaultVal
-	if logspoutEnvVal := os.Getenv(envKey); logspoutEnvVal != "" {
-		finalVal = logspoutEnvVal // use $envKey, if set
+	if cfg.IsSet(envKey) {
+		finalVal = cfg.GetString(envKey) // use $envKey, if set
 	}
 	if routeOptionsVal, exists := a.Route.Options[envKey]; exists {
 		finalVal = routeOptionsVal
@@ -136,14 +382,16 @@ func (a *Adapter) renderEnvValue(
 	if containerEnvVal, exists := context.Env[envKey]; exists {
 		finalVal = containerEnvVal // or, $envKey from container!
 	}
-	template, err := template.New("template").Parse(finalVal)
-	if err != nil {
-		log.Println("cloudwatch: error parsing template", finalVal, ":", err)
+	template := a.template(finalVal)
+	if template == nil {
 		return defaultVal
 	}
 	// render the templates in the generated context
 	var renderedValue bytes.Buffer
-	err = template.Execute(&renderedValue, context)
+	bound, err := template.Clone()
+	if err == nil {
+		err = bound.Funcs(context.funcs()).Execute(&renderedValue, context)
+	}
 	if err != nil {
 		log.Printf("cloudwatch: error rendering template %s : %s\n",
 			finalVal, err)
@@ -152,6 +400,33 @@ func (a *Adapter) renderEnvValue(
 	return renderedValue.String()
 }
 
+// template returns the parsed naming template of a text, parsing each text
+// once, or nil if it is invalid
+func (a *Adapter) template(text string) *template.Template {
+	if tmpl, isParsed := a.templates[text]; isParsed {
+		return tmpl
+	}
+	tmpl, err := template.New("template").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		log.Println("cloudwatch: error parsing template", text, ":", err)
+		tmpl = nil
+	}
+	a.templates[text] = tmpl
+	return tmpl
+}
+
+// getOption returns the value of the given route option, or the logspout
+// ENV var of the same name, or the provided default value.
+func getOption(route *router.Route, key, defaultVal string) string {
+	if routeOptionsVal, exists := route.Options[key]; exists {
+		return routeOptionsVal
+	}
+	if cfg.IsSet(key) {
+		return cfg.GetString(key)
+	}
+	return defaultVal
+}
+
 func parseEnv(envLines []string) map[string]string {
 	env := map[string]string{}
 	for _, line := range envLines {
@@ -0,0 +1,71 @@
+package cloudwatch
+
+import (
+	"testing"
+	"text/template"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestAdapterRendersNamesAgainOnRename(t *testing.T) {
+	route := &router.Route{Options: map[string]string{"LOGSPOUT_STREAM": "{{.Name}}-stream"}}
+	binary, err := newBinaryPolicy(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Adapter{
+		Route:       route,
+		OsHost:      "host",
+		binary:      binary,
+		groupnames:  map[string]string{},
+		streamnames: map[string]string{},
+		tenantnames: map[string]string{},
+		namedFor:    map[string]string{},
+		templates:   map[string]*template.Template{},
+		retries:     map[string]int{},
+		lastSeen:    map[string]time.Time{},
+		batcher:     &Batcher{Input: make(chan Message, 10)},
+	}
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	logstream := make(chan *router.Message, 3)
+	logstream <- &router.Message{Container: container, Data: "first"}
+	logstream <- &router.Message{Container: container, Data: "second"}
+	close(logstream)
+	a.Stream(logstream)
+	container.Name = "/api" // renamed, as the pump does
+	logstream = make(chan *router.Message, 1)
+	logstream <- &router.Message{Container: container, Data: "third"}
+	close(logstream)
+	a.Stream(logstream)
+
+	for _, expected := range []string{"web-stream", "web-stream", "api-stream"} {
+		if msg := <-a.batcher.Input; msg.Stream != expected || msg.Group != "host" {
+			t.Errorf("expected %s in group host, got %s in %s", expected, msg.Stream, msg.Group)
+		}
+	}
+	if len(a.templates) != 2 { // the stream template and the default group
+		t.Errorf("expected each template to be parsed once, got %d", len(a.templates))
+	}
+}
+
+func TestAdapterRendersEnvFunction(t *testing.T) {
+	route := &router.Route{Options: map[string]string{"LOGSPOUT_STREAM": `{{env "APP" "app"}}-{{env "TIER"}}`}}
+	if err := validateTemplate(route.Options["LOGSPOUT_STREAM"]); err != nil {
+		t.Fatal(err)
+	}
+	a := &Adapter{Route: route, templates: map[string]*template.Template{}}
+	for expected, env := range map[string][]string{
+		"web-":     {"APP=web"},
+		"app-":     nil,
+		"app-back": {"APP=", "TIER=back"},
+	} {
+		container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{Env: env}}
+		context := &RenderContext{Env: parseEnv(container.Config.Env), container: container}
+		if stream := a.renderEnvValue(`LOGSPOUT_STREAM`, context, "default"); stream != expected {
+			t.Errorf("expected %s for %v, got %s", expected, env, stream)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package cloudwatch
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/logspout/metrics"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultMinDelay  = time.Second
+	defaultHighRate  = 100              // events per second
+	rateTimeConstant = 10 * time.Second // time constant of the exponentially weighted rates
+	sweepInterval    = 250 * time.Millisecond
+)
+
+var (
+	streamRateGauge = metrics.NewGauge("logspout_cloudwatch_stream_rate",
+		"Recent rate of events per second of each container, with adaptive batching.", "container")
+	targetAgeGauge = metrics.NewGauge("logspout_cloudwatch_batch_target_age_seconds",
+		"Age at which the batch of each container is submitted, with adaptive batching.", "container")
+	batchesCounter = metrics.NewCounter("logspout_cloudwatch_batches_total",
+		"Batches submitted to the uploader, by what triggered the submission.", "trigger")
+)
+
+// adaptiveBatching chooses the age at which to submit the batch of each
+// container from its recent event rate: quiet containers get small batches
+// submitted after MIN_DELAY, and containers logging HIGH_RATE events per
+// second or more get large batches submitted after DELAY.
+type adaptiveBatching struct {
+	minAge   time.Duration
+	maxAge   time.Duration
+	highRate float64
+	rates    map[string]*streamRate
+}
+
+type streamRate struct {
+	count   int     // events since the last update
+	rate    float64 // exponentially weighted events per second
+	updated time.Time
+}
+
+// newAdaptiveBatching returns nil unless ADAPTIVE_BATCHING is enabled
+func newAdaptiveBatching(route *router.Route, maxAge time.Duration) *adaptiveBatching {
+	if getOption(route, `ADAPTIVE_BATCHING`, "") != "true" {
+		return nil
+	}
+	highRate := float64(defaultHighRate)
+	if f, err := strconv.ParseFloat(getOption(route, `HIGH_RATE`, ""), 64); err == nil && f > 0 {
+		highRate = f
+	}
+	minAge := getDurationOption(route, `MIN_DELAY`, defaultMinDelay)
+	if minAge > maxAge {
+		minAge = maxAge
+	}
+	return &adaptiveBatching{
+		minAge:   minAge,
+		maxAge:   maxAge,
+		highRate: highRate,
+		rates:    map[string]*streamRate{},
+	}
+}
+
+// observe counts an event of a container
+func (a *adaptiveBatching) observe(container string) {
+	r, exists := a.rates[container]
+	if !exists {
+		r = &streamRate{updated: time.Now()}
+		a.rates[container] = r
+	}
+	r.count++
+}
+
+// update folds the events counted since the last update into the rates,
+// and forgets containers that went quiet and have no batch
+func (a *adaptiveBatching) update(now time.Time, batches map[string]*Batch) {
+	for container, r := range a.rates {
+		elapsed := now.Sub(r.updated)
+		if elapsed <= 0 {
+			continue
+		}
+		weight := 1 - math.Exp(-elapsed.Seconds()/rateTimeConstant.Seconds())
+		r.rate += weight * (float64(r.count)/elapsed.Seconds() - r.rate)
+		r.count, r.updated = 0, now
+		if _, hasBatch := batches[container]; !hasBatch && r.rate < 0.01 {
+			delete(a.rates, container)
+			streamRateGauge.Delete(container)
+			targetAgeGauge.Delete(container)
+			continue
+		}
+		streamRateGauge.With(container).Set(r.rate)
+		targetAgeGauge.With(container).Set(a.targetAge(container).Seconds())
+	}
+}
+
+// targetAge interpolates between MIN_DELAY and DELAY by the rate of the
+// container relative to HIGH_RATE
+func (a *adaptiveBatching) targetAge(container string) time.Duration {
+	rate := 0.0
+	if r, exists := a.rates[container]; exists {
+		rate = r.rate
+	}
+	fraction := math.Min(rate/a.highRate, 1)
+	return a.minAge + time.Duration(fraction*float64(a.maxAge-a.minAge))
+}
@@ -1,39 +1,112 @@
 package cloudwatch
 
-import "time"
+import (
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
 
 // Message is a simple JSON input to Cloudwatch.
 type Message struct {
-	Message   string    `json:"message"`
-	Group     string    `json:"group"`
-	Stream    string    `json:"stream"`
-	Time      time.Time `json:"time"`
-	Container string    `json:"container"`
+	Message   string          `json:"message"`
+	Group     string          `json:"group"`
+	Stream    string          `json:"stream"`
+	Time      time.Time       `json:"time"`
+	Container string          `json:"container"`
+	Tenant    string          `json:"-"`                 // whose account the message is shipped to, if not logspout's
+	PartID    string          `json:"part_id,omitempty"` // shared by pieces of a split line
+	Part      int             `json:"part,omitempty"`
+	Parts     int             `json:"parts,omitempty"`
+	Priority  bool            `json:"-"` // submit the batch as soon as this message is added
+	Retries   int             `json:"-"` // times to retry submitting the batch
+	Hash      uint64          `json:"-"` // of the line, set when deduplicating
+	Shard     string          `json:"-"` // of the stream of the container, when sharded or starting
+	Origin    *router.Message `json:"-"` // the line, written to the DEAD_LETTER_FILE if dropped
+}
+
+// batchKey returns what the messages of a batch share, their container, and
+// the shard of its stream when sharded, or that of its lines when starting
+func (m Message) batchKey() string {
+	if m.Shard == "" {
+		return m.Container
+	}
+	return m.Container + "-" + m.Shard
 }
 
 // Batch is a group of Messages to be submitted to Cloudwatch
 // as part of a single request
 type Batch struct {
-	Msgs []Message
-	Size int64
+	Msgs    []Message
+	Size    int64
+	Created time.Time
+	Updated time.Time // when the last message was appended
+	Oldest  time.Time // of the times of its messages
+	Newest  time.Time
 }
 
 const msgOverhead = 26 // bytes
 
+// maxBatchSpan is the longest time the events of a batch may span
+const maxBatchSpan = 24 * time.Hour
+
+// spans returns whether adding a message would make the batch span more
+// than a time, like that CloudWatch accepts, as batches of lines read from
+// files might
+func (b *Batch) spans(msg Message, span time.Duration) bool {
+	return len(b.Msgs) > 0 && (msg.Time.Sub(b.Oldest) >= span || b.Newest.Sub(msg.Time) >= span)
+}
+
+// msgSize is the bytes of a message CloudWatch counts against the size of
+// a batch, its UTF-8 bytes and the overhead of each event
 func msgSize(msg Message) int64 {
-	return int64((len(msg.Message) * 8) + msgOverhead)
+	return int64(len(msg.Message) + msgOverhead)
 }
 
+// msgsPool holds the message slices of batches that were uploaded or
+// dead-lettered, for new batches not to grow theirs anew
+var msgsPool = sync.Pool{New: func() interface{} { return new([]Message) }}
+
 // NewBatch creates and returns an empty Batch
 func NewBatch() *Batch {
+	msgs := msgsPool.Get().(*[]Message)
 	return &Batch{
-		Msgs: []Message{},
-		Size: 0,
+		Msgs:    (*msgs)[:0],
+		Size:    0,
+		Created: time.Now(),
 	}
 }
 
+// acknowledge acknowledges the lines of the messages of an uploaded batch,
+// with the last part of those split
+func (b *Batch) acknowledge() {
+	for _, msg := range b.Msgs {
+		if msg.Origin != nil && msg.Part == msg.Parts {
+			msg.Origin.Ack()
+		}
+	}
+}
+
+// release returns the messages of a batch that is done with to the pool,
+// the batch must not be used afterwards
+func (b *Batch) release() {
+	for i := range b.Msgs {
+		b.Msgs[i] = Message{} // not to hold on to the lines
+	}
+	msgs := b.Msgs[:0]
+	b.Msgs = nil
+	msgsPool.Put(&msgs)
+}
+
 // Append adds Messages to a Batch
 func (b *Batch) Append(msg Message) {
+	if len(b.Msgs) == 0 || msg.Time.Before(b.Oldest) {
+		b.Oldest = msg.Time
+	}
+	if len(b.Msgs) == 0 || msg.Time.After(b.Newest) {
+		b.Newest = msg.Time
+	}
 	b.Msgs = append(b.Msgs, msg)
 	b.Size = b.Size + msgSize(msg)
+	b.Updated = time.Now()
 }
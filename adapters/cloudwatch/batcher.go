@@ -2,40 +2,111 @@ package cloudwatch
 
 import (
 	"log"
-	"os"
 	"strconv"
 	"time"
 
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
 )
 
 const defaultDelay = 4 //seconds
 
 // Rules for creating Cloudwatch Log batches, from https://goo.gl/TrIN8c
-const maxBatchSize = 1048576 // bytem
+const maxBatchSize = 1048576 // bytes
 const maxBatchCount = 10000  // messages
 
+// batchLimits are the most bytes and events a batch holds, and the longest
+// time its events span, before it is submitted: at most, and by default,
+// those PutLogEvents accepts
+type batchLimits struct {
+	size  int64
+	count int
+	span  time.Duration
+}
+
+func newBatchLimits(route *router.Route) batchLimits {
+	span := getDurationOption(route, `MAX_BATCH_SPAN`, maxBatchSpan)
+	if span <= 0 || span > maxBatchSpan {
+		log.Printf("WARNING: ERROR parsing MAX_BATCH_SPAN %s, using default of %s\n", span, maxBatchSpan)
+		span = maxBatchSpan
+	}
+	return batchLimits{
+		size:  int64(getLimitOption(route, `MAX_BATCH_SIZE`, maxBatchSize)),
+		count: getLimitOption(route, `MAX_BATCH_LENGTH`, maxBatchCount),
+		span:  span,
+	}
+}
+
+// full returns whether a message does not fit in a batch
+func (l batchLimits) full(batch *Batch, msg Message) bool {
+	return batch.Size+msgSize(msg) > l.size || len(batch.Msgs) >= l.count
+}
+
+// fit returns a message larger than a batch may be cut to fit in an empty
+// one, with LONG_LINES=truncate or not, and false if batches are too small
+// for even the marker of a truncated line
+func (l batchLimits) fit(msg Message) (Message, bool) {
+	if msgSize(msg) <= l.size {
+		return msg, true
+	}
+	room := int(l.size) - msgOverhead
+	if room <= maxMarkerSize {
+		return msg, false
+	}
+	return truncateTo(msg, room), true
+}
+
+// getLimitOption returns the number of an option, from 1 up to a limit, or
+// the limit if unset or invalid
+func getLimitOption(route *router.Route, key string, limit int) int {
+	text := getOption(route, key, "")
+	if text == "" {
+		return limit
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < 1 || value > limit {
+		log.Printf("WARNING: ERROR parsing %s %s, using default of %d\n", key, text, limit)
+		return limit
+	}
+	return value
+}
+
 // Batcher receieves Cloudwatch messages on its input channel,
 // stores them in CloudwatchBatches until enough data is ready to send, then
 // sends each CloudwatchMessageBatch on its output channel.
 type Batcher struct {
-	Input  chan Message
-	output chan Batch
-	route  *router.Route
-	timer  chan bool
+	Input     chan Message
+	output    chan Batch
+	uploader  *Uploader
+	route     *router.Route
+	timer     chan bool
+	flush     chan chan struct{} // submit all batches, closing the channel once uploaded
+	exited    chan string        // submit the batches of a container that exited
+	streams   chan string        // submit the batches of a stream right away
+	idleFlush time.Duration      // submit batches that received nothing for this long
+	adaptive  *adaptiveBatching  // replaces the DELAY timer when enabled
+	limits    batchLimits        // submit batches before they exceed these
 	// maintain a batch for each container, indexed by its name
 	batches map[string]*Batch
 }
 
 // NewBatcher returns a new Batcher assigned to the given adapeter
 func NewBatcher(adapter *Adapter) *Batcher {
+	uploader := NewUploader(adapter)
 	batcher := Batcher{
-		Input:   make(chan Message),
-		output:  NewUploader(adapter).Input,
-		batches: map[string]*Batch{},
-		timer:   make(chan bool),
-		route:   adapter.Route,
+		Input:    make(chan Message),
+		output:   uploader.Input,
+		uploader: uploader,
+		batches:  map[string]*Batch{},
+		timer:    make(chan bool),
+		flush:    make(chan chan struct{}),
+		exited:   make(chan string),
+		streams:  make(chan string),
+		route:    adapter.Route,
 	}
+	batcher.idleFlush = getDurationOption(adapter.Route, `IDLE_FLUSH`, 0)
+	batcher.limits = newBatchLimits(adapter.Route)
+	batcher.adaptive = newAdaptiveBatching(adapter.Route, getDelay(adapter.Route))
 	go batcher.Start()
 	return &batcher
 }
@@ -43,41 +114,146 @@ func NewBatcher(adapter *Adapter) *Batcher {
 // Start begins the main loop for the Batcher - just sorts each messages into a
 // batch, but submits the batch first and replaces it if the message is too big.
 func (b *Batcher) Start() {
-	go b.runTimer()
+	var sweep <-chan time.Time
+	if b.adaptive != nil {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		sweep = ticker.C
+	} else {
+		go b.runTimer()
+	}
+	var idleCheck <-chan time.Time
+	if b.idleFlush > 0 {
+		ticker := time.NewTicker(idleCheckInterval(b.idleFlush))
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
 	for { // run forever, and...
 		select { // either batch up a message, or respond to the timer
 		case msg := <-b.Input: // a message - put it into its slice
 			if len(msg.Message) == 0 { // empty messages are not allowed
+				if msg.Origin != nil && msg.Part == msg.Parts {
+					msg.Origin.Ack() // dropped, not to be forwarded again
+				}
+				break
+			}
+			msg, fits := b.limits.fit(msg)
+			if !fits {
+				b.uploader.deadLetter(Batch{Msgs: []Message{msg}}, "larger than MAX_BATCH_SIZE")
 				break
 			}
+			key := msg.batchKey()
 			// get or create the correct slice of messages for this message
-			if _, exists := b.batches[msg.Container]; !exists {
-				b.batches[msg.Container] = NewBatch()
+			if _, exists := b.batches[key]; !exists {
+				b.batches[key] = NewBatch()
 			}
 			// if Msg is too long for the current batch, submit the batch
-			if (b.batches[msg.Container].Size+msgSize(msg)) > maxBatchSize ||
-				len(b.batches[msg.Container].Msgs) >= maxBatchCount {
-				b.output <- *b.batches[msg.Container]
-				b.batches[msg.Container] = NewBatch()
+			if len(b.batches[key].Msgs) > 0 && b.limits.full(b.batches[key], msg) {
+				b.submit(key, "size")
+				b.batches[key] = NewBatch()
+			} else if b.batches[key].spans(msg, b.limits.span) {
+				b.submit(key, "span")
+				b.batches[key] = NewBatch()
 			}
-			thisBatch := b.batches[msg.Container]
+			thisBatch := b.batches[key]
 			thisBatch.Append(msg)
+			if b.adaptive != nil {
+				b.adaptive.observe(key)
+			}
+			// submit errors right away, once all parts of a split line are in
+			if msg.Priority && msg.Part == msg.Parts {
+				b.submit(key, "priority")
+			}
 		case <-b.timer: // submit and delete all existing batches
+			for container := range b.batches {
+				b.submit(container, "delay")
+			}
+		case id := <-b.exited: // submit those of a container, which logs no more
+			for container, batch := range b.batches {
+				if batch.Msgs[0].Container == id {
+					b.submit(container, "exit")
+				}
+			}
+		case name := <-b.streams: // submit those of a stream, as asked
 			for container, batch := range b.batches {
-				b.output <- *batch
-				delete(b.batches, container)
+				if batch.Msgs[0].Stream == name {
+					b.submit(container, "api")
+				}
+			}
+		case done := <-b.flush: // submit everything, for the uploader to finish
+			for container := range b.batches {
+				b.submit(container, "flush")
+			}
+			b.uploader.flush <- done
+		case <-idleCheck: // submit batches of containers that went quiet
+			for container, batch := range b.batches {
+				if time.Since(batch.Updated) >= b.idleFlush {
+					b.submit(container, "idle")
+				}
+			}
+		case now := <-sweep: // submit batches that reached their target age
+			b.adaptive.update(now, b.batches)
+			for container, batch := range b.batches {
+				if now.Sub(batch.Created) >= b.adaptive.targetAge(container) {
+					b.submit(container, "age")
+				}
 			}
 		}
 	}
 }
 
+// submit sends the batch of a container to the uploader and deletes it
+func (b *Batcher) submit(container, trigger string) {
+	b.output <- *b.batches[container]
+	delete(b.batches, container)
+	batchesCounter.With(trigger).Inc()
+}
+
+// idleCheckInterval returns how often to look for idle batches, a fraction
+// of the idle timeout so batches are submitted soon after it passes
+func idleCheckInterval(idleFlush time.Duration) time.Duration {
+	interval := idleFlush / 4
+	if interval < 50*time.Millisecond {
+		interval = 50 * time.Millisecond
+	}
+	return interval
+}
+
+// getDurationOption returns the given option as a duration, which may also
+// be a number of seconds like DELAY, or the provided default value.
+func getDurationOption(route *router.Route, key string, defaultVal time.Duration) time.Duration {
+	text := getOption(route, key, "")
+	if text == "" {
+		return defaultVal
+	}
+	if seconds, err := strconv.Atoi(text); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		log.Printf("WARNING: ERROR parsing %s %s, using default of %s\n",
+			key, text, defaultVal)
+		return defaultVal
+	}
+	return d
+}
+
 func (b *Batcher) runTimer() {
+	delay := getDelay(b.route)
+	for {
+		time.Sleep(delay)
+		b.timer <- true
+	}
+}
+
+// getDelay returns the DELAY between batch submissions
+func getDelay(route *router.Route) time.Duration {
 	delayText := strconv.Itoa(defaultDelay)
-	if routeDelay, isSet := b.route.Options[`DELAY`]; isSet {
+	if routeDelay, isSet := route.Options[`DELAY`]; isSet {
 		delayText = routeDelay
 	}
-	if envDelay := os.Getenv(`DELAY`); envDelay != "" {
-		delayText = envDelay
+	if cfg.IsSet(`DELAY`) {
+		delayText = cfg.GetString(`DELAY`)
 	}
 	delay, err := strconv.Atoi(delayText)
 	if err != nil {
@@ -85,8 +261,5 @@ func (b *Batcher) runTimer() {
 			delayText, defaultDelay)
 		delay = defaultDelay
 	}
-	for {
-		time.Sleep(time.Duration(delay) * time.Second)
-		b.timer <- true
-	}
+	return time.Duration(delay) * time.Second
 }
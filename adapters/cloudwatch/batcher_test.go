@@ -0,0 +1,298 @@
+package cloudwatch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func newTestBatcher(options map[string]string) *Batcher {
+	route := &router.Route{Options: options}
+	b := &Batcher{
+		Input:   make(chan Message),
+		output:  make(chan Batch),
+		batches: map[string]*Batch{},
+		timer:   make(chan bool),
+		exited:  make(chan string),
+		streams: make(chan string),
+		route:   route,
+	}
+	b.idleFlush = getDurationOption(route, `IDLE_FLUSH`, 0)
+	b.limits = newBatchLimits(route)
+	go b.Start()
+	return b
+}
+
+func TestBatcherIdleFlush(t *testing.T) {
+	b := newTestBatcher(map[string]string{`IDLE_FLUSH`: "100ms", `DELAY`: "60"})
+	start := time.Now()
+	b.Input <- Message{Message: "hello", Container: "quiet"}
+	select {
+	case batch := <-b.output:
+		if len(batch.Msgs) != 1 || batch.Msgs[0].Message != "hello" {
+			t.Errorf("unexpected batch %+v", batch)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("batch submitted after %s, before the idle timeout", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected idle batch to be submitted before DELAY")
+	}
+}
+
+func TestGetDurationOption(t *testing.T) {
+	for text, expected := range map[string]time.Duration{
+		"":      time.Second,
+		"2":     2 * time.Second,
+		"500ms": 500 * time.Millisecond,
+		"soon":  time.Second,
+	} {
+		route := &router.Route{Options: map[string]string{}}
+		if text != "" {
+			route.Options[`IDLE_FLUSH`] = text
+		}
+		if d := getDurationOption(route, `IDLE_FLUSH`, time.Second); d != expected {
+			t.Errorf("expected %s for %q, got %s", expected, text, d)
+		}
+	}
+}
+
+func TestAdaptiveBatching(t *testing.T) {
+	route := &router.Route{Options: map[string]string{
+		`ADAPTIVE_BATCHING`: "true", `MIN_DELAY`: "1", `HIGH_RATE`: "100",
+	}}
+	a := newAdaptiveBatching(route, 5*time.Second)
+	if a == nil {
+		t.Fatal("expected adaptive batching to be enabled")
+	}
+	if age := a.targetAge("quiet"); age != time.Second {
+		t.Errorf("expected MIN_DELAY for unknown container, got %s", age)
+	}
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		a.observe("busy")
+	}
+	a.observe("quiet")
+	a.rates["busy"].updated, a.rates["quiet"].updated = start, start
+	// 1000 events in 10 seconds moves the rate to (1 - 1/e) * 100 events/s
+	a.update(start.Add(10*time.Second), map[string]*Batch{"busy": NewBatch(), "quiet": NewBatch()})
+	if rate := a.rates["busy"].rate; rate < 63 || rate > 64 {
+		t.Errorf("expected busy rate of about 63/s, got %f", rate)
+	}
+	if age := a.targetAge("busy"); age < 3500*time.Millisecond || age > 3600*time.Millisecond {
+		t.Errorf("expected busy target age of about 3.5s, got %s", age)
+	}
+	if age := a.targetAge("quiet"); age > 1100*time.Millisecond {
+		t.Errorf("expected quiet target age near MIN_DELAY, got %s", age)
+	}
+
+	a.update(start.Add(time.Minute), map[string]*Batch{"busy": NewBatch()})
+	if _, exists := a.rates["quiet"]; exists {
+		t.Error("expected quiet container without batch to be forgotten")
+	}
+	if newAdaptiveBatching(&router.Route{Options: map[string]string{}}, time.Second) != nil {
+		t.Error("expected adaptive batching to be disabled by default")
+	}
+}
+
+func TestBatcherPriorityFlush(t *testing.T) {
+	b := newTestBatcher(map[string]string{`DELAY`: "60"})
+	b.Input <- Message{Message: "starting", Container: "app"}
+	b.Input <- Message{Message: "part one", Container: "app", Priority: true, Part: 1, Parts: 2}
+	select {
+	case batch := <-b.output:
+		t.Fatalf("batch %+v submitted before the last part", batch)
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.Input <- Message{Message: "part two", Container: "app", Priority: true, Part: 2, Parts: 2}
+	select {
+	case batch := <-b.output:
+		if len(batch.Msgs) != 3 {
+			t.Errorf("expected the whole batch of 3 messages, got %+v", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected priority batch to be submitted before DELAY")
+	}
+}
+
+func TestBatcherSubmitsBatchesOfExitedContainers(t *testing.T) {
+	b := newTestBatcher(map[string]string{`DELAY`: "60"})
+	b.Input <- Message{Message: "done", Container: "job"}
+	b.Input <- Message{Message: "working", Container: "app"}
+	b.exited <- "job"
+	select {
+	case batch := <-b.output:
+		if len(batch.Msgs) != 1 || batch.Msgs[0].Container != "job" {
+			t.Errorf("expected the batch of the exited container, got %+v", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the batch of the exited container to be submitted before DELAY")
+	}
+	select {
+	case batch := <-b.output:
+		t.Errorf("expected the batches of other containers to wait, got %+v", batch)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBatcherSubmitsBatchesOfFlushedStream(t *testing.T) {
+	b := newTestBatcher(map[string]string{`DELAY`: "60"})
+	b.Input <- Message{Message: "missing", Container: "web", Stream: "web-1"}
+	b.Input <- Message{Message: "waiting", Container: "db", Stream: "db-1"}
+	b.streams <- "web-1"
+	select {
+	case batch := <-b.output:
+		if len(batch.Msgs) != 1 || batch.Msgs[0].Stream != "web-1" {
+			t.Errorf("expected the batch of the stream, got %+v", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the batch of the stream to be submitted before DELAY")
+	}
+}
+
+func TestBatcherSubmitsBatchesSpanningADay(t *testing.T) {
+	b := newTestBatcher(map[string]string{`DELAY`: "60"})
+	logged := time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)
+	b.Input <- Message{Message: "first", Container: "import", Time: logged}
+	b.Input <- Message{Message: "second", Container: "import", Time: logged.Add(23 * time.Hour)}
+	select {
+	case batch := <-b.output:
+		t.Fatalf("unexpected batch %+v", batch)
+	case <-time.After(50 * time.Millisecond):
+	}
+	go func() { b.Input <- Message{Message: "third", Container: "import", Time: logged.Add(25 * time.Hour)} }()
+	select {
+	case batch := <-b.output:
+		if len(batch.Msgs) != 2 || batch.Msgs[1].Message != "second" {
+			t.Errorf("unexpected batch %+v", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the batch to be submitted before spanning more than a day")
+	}
+}
+
+func TestBatcherSubmitsBatchesAtMaxLength(t *testing.T) {
+	b := newTestBatcher(map[string]string{`DELAY`: "60", `MAX_BATCH_LENGTH`: "2"})
+	b.Input <- Message{Message: "first", Container: "busy"}
+	b.Input <- Message{Message: "second", Container: "busy"}
+	go func() { b.Input <- Message{Message: "third", Container: "busy"} }()
+	select {
+	case batch := <-b.output:
+		if len(batch.Msgs) != 2 {
+			t.Errorf("unexpected batch %+v", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the batch to be submitted at MAX_BATCH_LENGTH events")
+	}
+}
+
+func TestBatcherTruncatesMessagesLargerThanMaxSize(t *testing.T) {
+	b := newTestBatcher(map[string]string{`DELAY`: "60", `MAX_BATCH_SIZE`: "100"})
+	b.Input <- Message{Message: "first", Container: "busy"}
+	go func() { b.Input <- Message{Message: strings.Repeat("x", 200), Container: "busy"} }()
+	for _, expected := range []string{"first", strings.Repeat("x", 26) + " [truncated 174 bytes]"} {
+		select {
+		case batch := <-b.output:
+			if len(batch.Msgs) != 1 || batch.Msgs[0].Message != expected || batch.Size > 100 {
+				t.Errorf("expected a batch of %q, got %+v", expected, batch)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the batch to be submitted at MAX_BATCH_SIZE bytes")
+		}
+		if expected == "first" {
+			b.timer <- true
+		}
+	}
+}
+
+func TestBatchLimitsFit(t *testing.T) {
+	msg := Message{Message: strings.Repeat("x", 200)}
+	if _, fits := (batchLimits{size: msgOverhead + maxMarkerSize}).fit(msg); fits {
+		t.Error("expected no room for a line in batches smaller than the marker of a truncated line")
+	}
+	if fitted, fits := (batchLimits{size: 1000}).fit(msg); !fits || fitted != msg {
+		t.Errorf("expected a line that fits to be left as is, got %+v", fitted)
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	limits := newBatchLimits(&router.Route{Options: map[string]string{
+		`MAX_BATCH_SIZE`: "2000000", `MAX_BATCH_LENGTH`: "500", `MAX_BATCH_SPAN`: "1h"}})
+	if limits.size != maxBatchSize || limits.count != 500 || limits.span != time.Hour {
+		t.Errorf("expected sizes above that of PutLogEvents to be refused, got %+v", limits)
+	}
+	if size := msgSize(Message{Message: "hello"}); size != 5+msgOverhead {
+		t.Errorf("expected the bytes of a line and the overhead of an event, got %d", size)
+	}
+}
+
+func BenchmarkBatchAppend(b *testing.B) {
+	msg := Message{Message: "a line of the size lines often have, give or take", Group: "group", Stream: "stream", Time: time.Now()}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		batch := NewBatch()
+		for j := 0; j < 1000; j++ {
+			batch.Append(msg)
+		}
+		batch.release()
+	}
+}
+
+// testLogRouter routes lines to the routes, as a pump of a container would
+type testLogRouter []*router.Message
+
+func (r testLogRouter) RoutingFrom(string) bool { return false }
+
+func (r testLogRouter) Route(route *router.Route, logstream chan *router.Message) {
+	for _, msg := range r {
+		logstream <- msg
+	}
+}
+
+func TestBatcherAcknowledgesEmptyLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SPOOL_FSYNC_INTERVAL", "10ms")
+	defer os.Unsetenv("SPOOL_FSYNC_INTERVAL")
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	router.LogRouters.Register(testLogRouter{{Container: container, Data: ""}}, "cloudwatch-test")
+	router.AdapterFactories.Register(func(route *router.Route) (router.LogAdapter, error) {
+		return &Adapter{
+			Route:       route,
+			groupnames:  map[string]string{},
+			streamnames: map[string]string{},
+			tenantnames: map[string]string{},
+			namedFor:    map[string]string{},
+			templates:   map[string]*template.Template{},
+			retries:     map[string]int{},
+			lastSeen:    map[string]time.Time{},
+			batcher:     newTestBatcher(route.Options),
+		}, nil
+	}, "cloudwatch-test")
+	route := &router.Route{ID: "r1", Adapter: "cloudwatch-test", Options: map[string]string{"spool": dir}}
+	if err := router.Routes.Add(route); err != nil {
+		t.Fatal(err)
+	}
+	defer router.Routes.Remove(route.ID)
+	go router.Routes.Run() //nolint:errcheck
+	var position struct{ Offset int64 }
+	for deadline := time.Now().Add(2 * time.Second); position.Offset == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the empty line to be acknowledged to the spool")
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, route.ID, "position"))
+		json.Unmarshal(data, &position) //nolint:errcheck
+	}
+}
@@ -0,0 +1,100 @@
+package cloudwatch
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// defaultSkewThreshold is the skew of the clock of the host from that of
+// AWS at which the time of events is corrected, well above the second the
+// Date of responses is precise to
+const defaultSkewThreshold = 5 * time.Minute
+
+// clock tells the time events are timestamped with: that of the host,
+// offset by its skew from the time of AWS once CloudWatch rejected events
+// for their time. The skew is measured from the Date of the responses of
+// AWS.
+type clock struct {
+	now       func() time.Time // of the host, replaced by tests
+	threshold time.Duration    // skew corrected, 0 to never correct it
+	skew      int64            // last measured, in nanoseconds, accessed atomically
+	offset    int64            // added to the time of the host, in nanoseconds, accessed atomically
+}
+
+func newClock(route *router.Route) *clock {
+	return &clock{now: time.Now, threshold: getDurationOption(route, `CLOCK_SKEW_THRESHOLD`, defaultSkewThreshold)}
+}
+
+// Now returns the time of the host, corrected by the offset if any
+func (c *clock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.now().Add(time.Duration(atomic.LoadInt64(&c.offset)))
+}
+
+// observeRequest measures the skew from the Date of the response to an
+// AWS request, as a handler of the clients
+func (c *clock) observeRequest(r *request.Request) {
+	if r.HTTPResponse == nil {
+		return
+	}
+	date, err := http.ParseTime(r.HTTPResponse.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	c.observe(date)
+}
+
+// observe records the skew of the host from the time of AWS, and stops
+// correcting it once the host is back in time
+func (c *clock) observe(server time.Time) {
+	if c == nil || c.threshold <= 0 {
+		return
+	}
+	skew := server.Sub(c.now())
+	atomic.StoreInt64(&c.skew, int64(skew))
+	if abs(skew) < c.threshold && atomic.SwapInt64(&c.offset, 0) != 0 {
+		log.Printf("cloudwatch: clock of the host is back in time with AWS, %s off, no longer correcting the time of events\n", skew)
+	}
+}
+
+// correct offsets the time of the host by the last skew measured, if
+// beyond the threshold, once CloudWatch rejected events for their time.
+// It returns whether the offset changed.
+func (c *clock) correct() bool {
+	if c == nil || c.threshold <= 0 {
+		return false
+	}
+	skew := atomic.LoadInt64(&c.skew)
+	if abs(time.Duration(skew)) < c.threshold || atomic.SwapInt64(&c.offset, skew) == skew {
+		return false
+	}
+	log.Printf("cloudwatch: clock of the host is %s off AWS, correcting the time of events\n", time.Duration(skew))
+	return true
+}
+
+// rejectedForTime returns whether CloudWatch rejected events of a request
+// for being too new, too old or expired, as with the clock of the host
+// skewed
+func rejectedForTime(resp *cloudwatchlogs.PutLogEventsOutput) bool {
+	if resp == nil || resp.RejectedLogEventsInfo == nil {
+		return false
+	}
+	info := resp.RejectedLogEventsInfo
+	return info.TooNewLogEventStartIndex != nil || info.TooOldLogEventEndIndex != nil || info.ExpiredLogEventEndIndex != nil
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,76 @@
+package cloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// tooNewLogs rejects the events of every request as too new
+type tooNewLogs struct {
+	*fakeLogs
+}
+
+func (f tooNewLogs) PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	f.uploaded <- *input.LogEvents[0].Message
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("next"),
+		RejectedLogEventsInfo: &cloudwatchlogs.RejectedLogEventsInfo{TooNewLogEventStartIndex: aws.Int64(0)}}, nil
+}
+
+func newTestClock(host time.Time) *clock {
+	return &clock{now: func() time.Time { return host }, threshold: defaultSkewThreshold}
+}
+
+func TestClockCorrectsSkew(t *testing.T) {
+	host := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newTestClock(host)
+	c.observe(host.Add(-time.Hour))
+	if got := c.Now(); !got.Equal(host) {
+		t.Errorf("expected the time of the host until events are rejected, got %s", got)
+	}
+	if !c.correct() {
+		t.Fatal("expected a skew of an hour to be corrected")
+	}
+	if got := c.Now(); !got.Equal(host.Add(-time.Hour)) {
+		t.Errorf("expected the time of AWS, got %s", got)
+	}
+	if c.correct() {
+		t.Error("expected the same skew not to be corrected again")
+	}
+	c.observe(host.Add(time.Second))
+	if got := c.Now(); !got.Equal(host) {
+		t.Errorf("expected the time of the host once back in time, got %s", got)
+	}
+}
+
+func TestClockIgnoresSmallSkew(t *testing.T) {
+	host := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := newTestClock(host)
+	c.observe(host.Add(time.Minute))
+	if c.correct() {
+		t.Error("expected a skew below the threshold not to be corrected")
+	}
+	c.threshold = 0
+	c.observe(host.Add(time.Hour))
+	if c.correct() {
+		t.Error("expected no skew to be corrected with a threshold of 0")
+	}
+}
+
+func TestUploaderCorrectsClockOnRejectedEvents(t *testing.T) {
+	host := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	u, uploaded := newTestUploader(nil, 0)
+	u.svc = tooNewLogs{&fakeLogs{uploaded: uploaded}}
+	u.clock = newTestClock(host)
+	u.clock.observe(host.Add(-time.Hour))
+	u.Input <- testBatch("a")
+	expectUploads(t, uploaded, "a")
+	u.Input <- testBatch("b") // submitted once the first was handled
+	expectUploads(t, uploaded, "b")
+	if got := u.clock.Now(); !got.Equal(host.Add(-time.Hour)) {
+		t.Errorf("expected the clock to be corrected to the time of AWS, got %s", got)
+	}
+}
@@ -0,0 +1,170 @@
+package cloudwatch
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/metrics"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// defaultCostPerGB is the price of ingesting a GB of logs in most regions
+const defaultCostPerGB = "0.50"
+
+const bytesPerGB = 1 << 30
+
+var (
+	shippedBytesCounter = metrics.NewCounter("logspout_cloudwatch_shipped_bytes_total",
+		"Bytes of events uploaded to each log group and stream, as CloudWatch Logs bills them.", "group", "stream")
+	costCounter = metrics.NewCounter("logspout_cloudwatch_estimated_cost_dollars_total",
+		"Estimated cost of ingesting the events of each container, and the value of its CLOUDWATCH_COST_LABEL.", "container", "label")
+)
+
+func init() {
+	router.Jobs.Register(&costReporter{}, "cloudwatch-costs")
+}
+
+// costKey is what ingestion costs are attributed to, a container and the
+// value of its CLOUDWATCH_COST_LABEL
+type costKey struct {
+	container string
+	label     string
+}
+
+// costs tracks the bytes shipped of each container since the last report
+type costs struct {
+	mu    sync.Mutex
+	bytes map[costKey]int64
+}
+
+var shippedCosts = &costs{bytes: map[costKey]int64{}}
+
+// eventBytes is the size of an event CloudWatch Logs bills, its message
+// and the overhead of each event
+func eventBytes(msg Message) int64 {
+	return int64(len(msg.Message) + msgOverhead)
+}
+
+// costOf returns the estimated cost of ingesting bytes
+func costOf(bytes int64) float64 {
+	perGB, _ := strconv.ParseFloat(cfg.GetString(`CLOUDWATCH_COST_PER_GB`), 64)
+	return float64(bytes) / bytesPerGB * perGB
+}
+
+// record attributes the bytes of an uploaded batch, of one log group and
+// stream, to the containers of its events
+func (c *costs) record(batch Batch) {
+	if len(batch.Msgs) == 0 {
+		return
+	}
+	label := cfg.GetString(`CLOUDWATCH_COST_LABEL`)
+	var total int64
+	shipped := map[costKey]int64{}
+	for _, msg := range batch.Msgs {
+		key := costKey{container: msg.Container}
+		if msg.Origin != nil && msg.Origin.Container != nil {
+			key.container = strings.TrimPrefix(msg.Origin.Container.Name, "/")
+			if label != "" && msg.Origin.Container.Config != nil {
+				key.label = msg.Origin.Container.Config.Labels[label]
+			}
+		}
+		size := eventBytes(msg)
+		shipped[key] += size
+		total += size
+	}
+	shippedBytesCounter.With(batch.Msgs[0].Group, batch.Msgs[0].Stream).Add(float64(total))
+	reported := cfg.GetDuration(`CLOUDWATCH_COST_INTERVAL`) > 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, bytes := range shipped {
+		costCounter.With(key.container, key.label).Add(costOf(bytes))
+		if reported {
+			c.bytes[key] += bytes
+		}
+	}
+}
+
+// report returns the summary lines of the estimated cost of the bytes
+// shipped since the last report: the total, then that of each value of
+// the CLOUDWATCH_COST_LABEL and each container, most costly first
+func (c *costs) report(period time.Duration) []string {
+	c.mu.Lock()
+	shipped := c.bytes
+	c.bytes = map[costKey]int64{}
+	c.mu.Unlock()
+	label := cfg.GetString(`CLOUDWATCH_COST_LABEL`)
+	var total int64
+	byLabel, byContainer := map[string]int64{}, map[string]int64{}
+	for key, bytes := range shipped {
+		total += bytes
+		if label != "" && key.label != "" {
+			byLabel[label+"="+key.label] += bytes
+		} else if label != "" {
+			byLabel[label+" unset"] += bytes
+		}
+		name := "container " + key.container
+		if label != "" && key.label != "" {
+			name += " (" + label + "=" + key.label + ")"
+		}
+		byContainer[name] += bytes
+	}
+	line := func(bytes int64, of string) string {
+		return fmt.Sprintf("cloudwatch: estimated ingestion cost over %s: $%.4f for %d bytes of %s", period, costOf(bytes), bytes, of)
+	}
+	lines := []string{line(total, "all containers")}
+	for _, group := range []map[string]int64{byLabel, byContainer} {
+		names := make([]string, 0, len(group))
+		for name := range group {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if group[names[i]] != group[names[j]] {
+				return group[names[i]] > group[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			lines = append(lines, line(group[name], name))
+		}
+	}
+	return lines
+}
+
+// costReporter is the job logging the estimated cost of the bytes shipped
+// every CLOUDWATCH_COST_INTERVAL, for platform teams to attribute the
+// spending on logs to the workloads logging
+type costReporter struct {
+	interval time.Duration
+}
+
+func (r *costReporter) Name() string {
+	if r.interval <= 0 {
+		return ""
+	}
+	return "cloudwatch-costs"
+}
+
+func (r *costReporter) Setup() error {
+	r.interval = cfg.GetDuration(`CLOUDWATCH_COST_INTERVAL`)
+	return nil
+}
+
+func (r *costReporter) Run() error {
+	if r.interval <= 0 {
+		select {}
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, line := range shippedCosts.report(r.interval) {
+			log.Println(line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package cloudwatch
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestCostsReport(t *testing.T) {
+	os.Setenv("CLOUDWATCH_COST_INTERVAL", "1h")
+	os.Setenv("CLOUDWATCH_COST_LABEL", "team")
+	os.Setenv("CLOUDWATCH_COST_PER_GB", "1")
+	defer os.Unsetenv("CLOUDWATCH_COST_INTERVAL")
+	defer os.Unsetenv("CLOUDWATCH_COST_LABEL")
+	defer os.Unsetenv("CLOUDWATCH_COST_PER_GB")
+	origin := func(name string, labels map[string]string) *router.Message {
+		return &router.Message{Container: &docker.Container{Name: "/" + name, Config: &docker.Config{Labels: labels}}}
+	}
+	web, api := origin("web", map[string]string{"team": "payments"}), origin("api", map[string]string{})
+	c := &costs{bytes: map[costKey]int64{}}
+	c.record(Batch{Msgs: []Message{
+		{Message: strings.Repeat("x", 74), Group: "g", Stream: "web", Origin: web},
+		{Message: strings.Repeat("x", 174), Group: "g", Stream: "web", Origin: web},
+	}})
+	c.record(Batch{Msgs: []Message{{Message: strings.Repeat("x", 24), Group: "g", Stream: "api", Origin: api}}})
+
+	expected := []string{
+		"cloudwatch: estimated ingestion cost over 1h0m0s: $0.0000 for 350 bytes of all containers",
+		"cloudwatch: estimated ingestion cost over 1h0m0s: $0.0000 for 300 bytes of team=payments",
+		"cloudwatch: estimated ingestion cost over 1h0m0s: $0.0000 for 50 bytes of team unset",
+		"cloudwatch: estimated ingestion cost over 1h0m0s: $0.0000 for 300 bytes of container web (team=payments)",
+		"cloudwatch: estimated ingestion cost over 1h0m0s: $0.0000 for 50 bytes of container api",
+	}
+	lines := c.report(time.Hour)
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+	if lines = c.report(time.Hour); len(lines) != 1 || !strings.Contains(lines[0], " 0 bytes ") {
+		t.Errorf("expected the bytes to be reset after a report, got %v", lines)
+	}
+}
+
+func TestCostOf(t *testing.T) {
+	os.Setenv("CLOUDWATCH_COST_PER_GB", "0.5")
+	defer os.Unsetenv("CLOUDWATCH_COST_PER_GB")
+	if cost := costOf(3 << 30); cost != 1.5 {
+		t.Errorf("expected 3GB to cost $1.5, got %v", cost)
+	}
+}
@@ -0,0 +1,132 @@
+package cloudwatch
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// how long after startup replayed lines are still compared with the lines
+// shipped before the restart
+const replayTimeout = 5 * time.Minute
+
+// dedup skips lines replayed by Docker after a restart that were already
+// shipped before it, by comparing them to the hashes of the last lines
+// shipped to each stream. Comparing stops for a stream once the replay
+// passes the last line shipped, so lines repeated later are kept.
+type dedup struct {
+	window int // number of recent lines remembered per stream
+	state  *stateFile
+	until  time.Time
+	mu     sync.Mutex
+	replay map[string]*replayState // streams still replaying, by streamKey
+}
+
+// replayState holds the lines shipped to a stream before the restart
+type replayState struct {
+	shipped map[uint64]struct{}
+	last    uint64
+	matched bool // the replay reached the shipped lines
+}
+
+// newDedup returns the dedup configured with DEDUP_WINDOW, or nil if it is
+// not enabled. It needs the STATE_FILE to remember lines across restarts.
+func newDedup(route *router.Route, state *stateFile) *dedup {
+	text := getOption(route, `DEDUP_WINDOW`, "")
+	if text == "" {
+		return nil
+	}
+	window, err := strconv.Atoi(text)
+	if err != nil || window <= 0 {
+		log.Printf("WARNING: ERROR parsing DEDUP_WINDOW %s, deduplication disabled\n", text)
+		return nil
+	}
+	if state == nil {
+		log.Println("WARNING: DEDUP_WINDOW needs a STATE_FILE, deduplication disabled")
+		return nil
+	}
+	d := &dedup{
+		window: window,
+		state:  state,
+		until:  time.Now().Add(replayTimeout),
+		replay: map[string]*replayState{},
+	}
+	for _, key := range state.keys() {
+		recent := state.stream(key).Recent
+		if len(recent) == 0 {
+			continue
+		}
+		shipped := make(map[uint64]struct{}, len(recent))
+		for _, hash := range recent {
+			shipped[hash] = struct{}{}
+		}
+		d.replay[key] = &replayState{shipped: shipped, last: recent[len(recent)-1]}
+	}
+	return d
+}
+
+// FNV-1a, as hash/fnv computes it
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// hashLine returns the hash a line is remembered by, the 64-bit FNV-1a of
+// hash/fnv, without copying the line to bytes
+func hashLine(line string) uint64 {
+	hash := uint64(fnvOffset64)
+	for i := 0; i < len(line); i++ {
+		hash ^= uint64(line[i])
+		hash *= fnvPrime64
+	}
+	return hash
+}
+
+// duplicate returns whether a replayed line was shipped before the restart
+func (d *dedup) duplicate(key string, hash uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, replaying := d.replay[key]
+	if !replaying {
+		return false
+	}
+	if time.Now().After(d.until) {
+		d.replay = map[string]*replayState{}
+		return false
+	}
+	if hash == r.last { // caught up, lines from here on are new
+		delete(d.replay, key)
+		return true
+	}
+	if _, shipped := r.shipped[hash]; shipped {
+		r.matched = true
+		return true
+	}
+	if r.matched { // the replay went past the shipped lines
+		delete(d.replay, key)
+	}
+	return false
+}
+
+// record remembers the lines of a batch that was shipped
+func (d *dedup) record(batch Batch) {
+	var hashes []uint64
+	for _, msg := range batch.Msgs {
+		if msg.Hash != 0 && msg.Part == msg.Parts { // once per split line
+			hashes = append(hashes, msg.Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return
+	}
+	msg := batch.Msgs[0]
+	d.state.update(streamKey(msg.Group, msg.Stream), func(state *streamState) {
+		state.Recent = append(state.Recent, hashes...)
+		if excess := len(state.Recent) - d.window; excess > 0 {
+			state.Recent = append([]uint64(nil), state.Recent[excess:]...)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package cloudwatch
+
+import (
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func shippedBatch(lines ...string) Batch {
+	batch := Batch{}
+	for _, line := range lines {
+		batch.Msgs = append(batch.Msgs, Message{Message: line, Group: "group", Stream: "stream", Hash: hashLine(line)})
+	}
+	return batch
+}
+
+// restartedDedup ships the lines, and returns the dedup of a restart
+func restartedDedup(t *testing.T, lines ...string) *dedup {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cloudwatch-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	route := &router.Route{Options: map[string]string{`DEDUP_WINDOW`: "3"}}
+	state, err := loadStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDedup(route, state).record(shippedBatch(lines...))
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := loadStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDedup(route, restarted)
+	if d == nil {
+		t.Fatal("expected dedup to be enabled")
+	}
+	return d
+}
+
+func expectDuplicates(t *testing.T, d *dedup, lines []string, expected []bool) {
+	t.Helper()
+	key := streamKey("group", "stream")
+	for i, line := range lines {
+		if duplicate := d.duplicate(key, hashLine(line)); duplicate != expected[i] {
+			t.Errorf("expected duplicate %v for replayed line %s, got %v", expected[i], line, duplicate)
+		}
+	}
+}
+
+func TestDedupSkipsShippedTail(t *testing.T) {
+	d := restartedDedup(t, "a", "b", "c", "d")
+	// only the last 3 lines are remembered, and lines repeated after the
+	// replay caught up are new
+	expectDuplicates(t, d,
+		[]string{"a", "b", "c", "d", "d", "e"},
+		[]bool{false, true, true, true, false, false})
+}
+
+func TestDedupStopsAfterReplayPassesShippedLines(t *testing.T) {
+	d := restartedDedup(t, "a", "b", "c")
+	expectDuplicates(t, d,
+		[]string{"a", "x", "b", "c"},
+		[]bool{true, false, false, false})
+}
+
+func TestDedupNeedsStateFile(t *testing.T) {
+	route := &router.Route{Options: map[string]string{`DEDUP_WINDOW`: "3"}}
+	if d := newDedup(route, nil); d != nil {
+		t.Error("expected dedup to be disabled without a STATE_FILE")
+	}
+}
+
+func TestHashLineIsFNV(t *testing.T) {
+	for _, line := range []string{"", "a line", "\x00\xff unicode ✓"} {
+		h := fnv.New64a()
+		h.Write([]byte(line)) //nolint:errcheck
+		if hashLine(line) != h.Sum64() {
+			t.Errorf("expected the hash of %q to be the FNV-1a of hash/fnv, as saved in STATE_FILE", line)
+		}
+	}
+}
+
+func BenchmarkHashLine(b *testing.B) {
+	line := "2020-11-02 10:00:00,000 INFO the kind of line containers log all day long"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		hashLine(line)
+	}
+}
@@ -3,10 +3,10 @@ package cloudwatch
 import (
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
 )
 
@@ -20,7 +20,7 @@ type EC2Info struct {
 // Region, or nil and an Error if not available.
 func NewEC2Info(route *router.Route) (EC2Info, error) {
 	_, skipEc2 := route.Options[`NOEC2`]
-	if skipEc2 || (os.Getenv(`NOEC2`) != "") {
+	if skipEc2 || (cfg.GetString(`NOEC2`) != "") {
 		return EC2Info{}, nil
 	}
 	// get my instance ID
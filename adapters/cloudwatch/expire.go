@@ -0,0 +1,97 @@
+package cloudwatch
+
+import (
+	"text/template"
+	"time"
+)
+
+// defaultStateExpiry is how long a container may log nothing before what
+// was cached for it is released, so the state of containers that are gone
+// does not pile up on hosts where many come and go
+const defaultStateExpiry = time.Hour
+
+// seen records that a container logged, and every so often releases the
+// state of containers that logged nothing for the STATE_EXPIRY
+func (a *Adapter) seen(container string, now time.Time) {
+	if a.expiry <= 0 {
+		return
+	}
+	a.lastSeen[container] = now
+	if now.Sub(a.lastExpiry) < idleCheckInterval(a.expiry) {
+		return
+	}
+	a.lastExpiry = now
+	expired := false
+	for id, last := range a.lastSeen {
+		if now.Sub(last) >= a.expiry {
+			a.expire(id)
+			expired = true
+		}
+	}
+	if expired { // those of the containers still logging are parsed again
+		a.templates = map[string]*template.Template{}
+	}
+}
+
+// expire releases the cached names and limits of a container, and its
+// state in the STATE_FILE, unless the log stream is shared with another
+func (a *Adapter) expire(container string) {
+	key := a.streamKeyOf(container)
+	delete(a.lastSeen, container)
+	delete(a.groupnames, container)
+	delete(a.streamnames, container)
+	delete(a.tenantnames, container)
+	delete(a.namedFor, container)
+	delete(a.retries, container)
+	delete(a.binary.windows, container)
+	if a.state == nil {
+		return
+	}
+	a.state.forgetContainer(container)
+	for id := range a.lastSeen {
+		if a.streamKeyOf(id) == key {
+			return
+		}
+	}
+	for _, key := range a.sharder.streamKeys(key) {
+		a.state.forgetStream(key)
+	}
+}
+
+// streamKeyOf returns the key of the log stream of a container, cached or
+// from the STATE_FILE
+func (a *Adapter) streamKeyOf(container string) string {
+	if group, isCached := a.groupnames[container]; isCached {
+		return streamKey(group, a.streamnames[container])
+	}
+	if a.state != nil {
+		if saved, isSaved := a.state.container(container); isSaved {
+			return streamKey(saved.Group, saved.Stream)
+		}
+	}
+	return ""
+}
+
+// used records when the token of a container was last used
+func (u *Uploader) used(container string, now time.Time) {
+	if u.expiry <= 0 {
+		return
+	}
+	u.lastUsed[container] = now
+}
+
+// expireTokens releases, every so often, the tokens of containers that
+// submitted nothing for the STATE_EXPIRY
+func (u *Uploader) expireTokens(now time.Time) {
+	if u.expiry <= 0 || now.Sub(u.lastExpiry) < idleCheckInterval(u.expiry) {
+		return
+	}
+	u.lastExpiry = now
+	for container, last := range u.lastUsed {
+		if _, held := u.queues[container]; held || now.Sub(last) < u.expiry {
+			continue
+		}
+		delete(u.lastUsed, container)
+		delete(u.tokens, container)
+	}
+}
@@ -0,0 +1,54 @@
+package cloudwatch
+
+import (
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestAdapterExpiresIdleContainers(t *testing.T) {
+	state := &stateFile{streams: map[string]*streamState{}, containers: map[string]containerState{}}
+	a := &Adapter{
+		binary:      &binaryPolicy{windows: map[string]*rateWindow{}},
+		state:       state,
+		groupnames:  map[string]string{},
+		streamnames: map[string]string{},
+		retries:     map[string]int{},
+		expiry:      time.Hour,
+		lastSeen:    map[string]time.Time{},
+		templates:   map[string]*template.Template{"{{.Name}}": nil},
+	}
+	start := time.Now()
+	for _, id := range []string{"idle", "shared", "busy"} {
+		stream := id
+		if id == "shared" {
+			stream = "busy"
+		}
+		a.groupnames[id], a.streamnames[id] = "group", stream
+		state.setContainer(id, containerState{Group: "group", Stream: stream})
+		state.update(streamKey("group", stream), func(s *streamState) { s.Token = "token" })
+		a.seen(id, start)
+	}
+	a.seen("busy", start.Add(30*time.Minute))
+	a.seen("busy", start.Add(time.Hour))
+	for _, id := range []string{"idle", "shared"} {
+		if _, cached := a.groupnames[id]; cached {
+			t.Errorf("expected names of %s to be released", id)
+		}
+		if _, saved := state.container(id); saved {
+			t.Errorf("expected %s to be removed from the state file", id)
+		}
+	}
+	if _, cached := a.groupnames["busy"]; !cached {
+		t.Error("expected names of a busy container to be kept")
+	}
+	if state.stream(streamKey("group", "idle")) != nil {
+		t.Error("expected the stream of the idle container to be removed from the state file")
+	}
+	if state.stream(streamKey("group", "busy")) == nil {
+		t.Error("expected a stream shared with a busy container to be kept")
+	}
+	if len(a.templates) != 0 {
+		t.Error("expected the parsed templates to be released with the containers")
+	}
+}
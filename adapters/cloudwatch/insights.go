@@ -0,0 +1,124 @@
+package cloudwatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// insightsReserved are the fields CloudWatch Logs Insights generates for
+// every event, which the fields of a line are renamed from not to shadow
+var insightsReserved = map[string]bool{
+	"@message":       true,
+	"@timestamp":     true,
+	"@ingestionTime": true,
+	"@logStream":     true,
+	"@log":           true,
+}
+
+// logfmtRegexp matches the key=value pairs of unstructured lines, with bare
+// or quoted values
+var logfmtRegexp = regexp.MustCompile(`(?:^|\s)([A-Za-z_][A-Za-z0-9_.\-]*)=("(?:[^"\\]|\\.)*"|[^\s"]*)`)
+
+// insightsEvent returns a line as a flat JSON object which CloudWatch Logs
+// Insights discovers the fields of, so they are queried without parse
+// statements: the fields of a JSON line, nested ones named by their path
+// like http.status, or else the key=value pairs of the line along with the
+// line as message. The level of the line and the container it came from
+// are added unless the line has fields of the same name.
+func insightsEvent(container *docker.Container, data string) string {
+	fields := map[string]interface{}{}
+	if !jsonFields(data, fields) {
+		for _, match := range logfmtRegexp.FindAllStringSubmatch(data, -1) {
+			setField(fields, match[1], logfmtValue(match[2]))
+		}
+		fields["message"] = data
+	}
+	if _, exists := fields["level"]; !exists {
+		if level := router.DetectLevel(data); level != router.LevelUnknown {
+			fields["level"] = string(level)
+		}
+	}
+	if container != nil {
+		addField(fields, "container_id", container.ID)
+		addField(fields, "container_name", strings.TrimPrefix(container.Name, "/"))
+		if container.Config != nil {
+			addField(fields, "image", container.Config.Image)
+		}
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(fields); err != nil {
+		return data
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// jsonFields adds the fields of a line that is a JSON object, flattened,
+// and returns false for other lines
+func jsonFields(data string, fields map[string]interface{}) bool {
+	if !strings.HasPrefix(strings.TrimSpace(data), "{") {
+		return false
+	}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber() // keep numbers as they were logged
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return false
+	}
+	if decoder.Decode(&struct{}{}) != io.EOF { // something follows the object
+		return false
+	}
+	flatten("", object, fields)
+	return true
+}
+
+// flatten adds the fields of a JSON object, those of nested objects named
+// by their path
+func flatten(prefix string, object map[string]interface{}, fields map[string]interface{}) {
+	for key, value := range object {
+		if nested, isObject := value.(map[string]interface{}); isObject {
+			flatten(prefix+key+".", nested, fields)
+			continue
+		}
+		setField(fields, prefix+key, value)
+	}
+}
+
+// setField adds a field of a line, renaming one named like a field
+// generated by Insights, eg: the @timestamp of Logstash to _timestamp
+func setField(fields map[string]interface{}, name string, value interface{}) {
+	if insightsReserved[name] {
+		name = "_" + name[1:]
+	}
+	fields[name] = value
+}
+
+// addField adds a field which the line has none of the name of
+func addField(fields map[string]interface{}, name, value string) {
+	if _, exists := fields[name]; !exists && value != "" {
+		fields[name] = value
+	}
+}
+
+// logfmtValue returns the value of a key=value pair, unquoted, and numbers
+// and booleans as such so Insights can aggregate them
+func logfmtValue(text string) interface{} {
+	if strings.HasPrefix(text, `"`) {
+		if unquoted, err := strconv.Unquote(text); err == nil {
+			return unquoted
+		}
+		return strings.Trim(text, `"`)
+	}
+	if text != "" && !strings.ContainsAny(text[:1], "{[") && json.Valid([]byte(text)) {
+		return json.RawMessage(text)
+	}
+	return text
+}
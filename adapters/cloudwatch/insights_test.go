@@ -0,0 +1,28 @@
+package cloudwatch
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestInsightsEvent(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{Image: "nginx"}}
+	for _, test := range []struct {
+		line     string
+		expected string
+	}{
+		{`{"level":"warn","http":{"status":503,"path":"/a&b"},"@timestamp":"2020-01-02T03:04:05Z"}`,
+			`{"_timestamp":"2020-01-02T03:04:05Z","container_id":"8dfafdbc3a40","container_name":"web","http.path":"/a&b","http.status":503,"image":"nginx","level":"warn"}`},
+		{`ts=1 msg="request done" duration=0.25 cached=true user=bob`,
+			`{"cached":true,"container_id":"8dfafdbc3a40","container_name":"web","duration":0.25,"image":"nginx","message":"ts=1 msg=\"request done\" duration=0.25 cached=true user=bob","msg":"request done","ts":1,"user":"bob"}`},
+		{`ERROR: disk full`,
+			`{"container_id":"8dfafdbc3a40","container_name":"web","image":"nginx","level":"error","message":"ERROR: disk full"}`},
+		{`{"container_name":"own"} trailing`,
+			`{"container_id":"8dfafdbc3a40","container_name":"web","image":"nginx","message":"{\"container_name\":\"own\"} trailing"}`},
+	} {
+		if event := insightsEvent(container, test.line); event != test.expected {
+			t.Errorf("%s:\nexpected %s\ngot      %s", test.line, test.expected, event)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package cloudwatch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// Most options may also be set per route, which takes precedence over the
+// env var, see getOption
+func init() {
+	cfg.Register(
+		cfg.Option{Name: `ADAPTIVE_BATCHING`, Validate: cfg.OneOf("true", "false"),
+			Description: "choose the age of each batch from the event rate of its container"},
+		cfg.Option{Name: `BINARY_OUTPUT`, Default: binaryEncodeBase64,
+			Validate:    cfg.OneOf(binaryEncodeBase64, binaryEncodeHex, binaryDrop),
+			Description: "what cloudwatch does with binary lines"},
+		cfg.Option{Name: `BINARY_RATE_LIMIT`, Validate: validateCount,
+			Description: "maximum number of binary lines per second cloudwatch ships for each container"},
+		cfg.Option{Name: `CLOCK_SKEW_THRESHOLD`, Default: defaultSkewThreshold.String(), Validate: validateSeconds,
+			Description: "skew of the clock of the host from that of AWS at which the time of events is corrected, once CloudWatch rejected events for their time, 0 to never correct it"},
+		cfg.Option{Name: `CLOUDWATCH_COST_INTERVAL`, Type: cfg.Duration, Default: "0",
+			Description: "how often the estimated ingestion cost of the bytes shipped is logged, 0 to not log it"},
+		cfg.Option{Name: `CLOUDWATCH_COST_LABEL`,
+			Description: "label of containers whose values ingestion costs are attributed to, eg: team"},
+		cfg.Option{Name: `CLOUDWATCH_COST_PER_GB`, Default: defaultCostPerGB, Validate: validateRate,
+			Description: "price of ingesting a GB of logs in CloudWatch Logs, for estimating costs"},
+		cfg.Option{Name: `CLOUDWATCH_METRICS_NAMESPACE`,
+			Description: "namespace to publish the metrics of logspout in as CloudWatch custom metrics, eg: Logspout"},
+		cfg.Option{Name: `CLOUDWATCH_METRICS_INTERVAL`, Type: cfg.Duration, Default: defaultMetricsInterval.String(),
+			Description: "how often metrics are published to CloudWatch"},
+		cfg.Option{Name: `CLOUDWATCH_METRICS_NAMES`,
+			Description: "comma separated metrics published to CloudWatch (default all but those of single containers)"},
+		cfg.Option{Name: `CLOUDWATCH_METRICS_REGION`,
+			Description: "region metrics are published in (default the EC2 region)"},
+		cfg.Option{Name: `CONTROL_RATE`, Default: strconv.Itoa(defaultControlRate), Validate: validateRate,
+			Description: "Describe and Create calls per second made to prepare the log groups and streams of containers that attached"},
+		cfg.Option{Name: `DEDUP_WINDOW`, Validate: validateCount,
+			Description: "number of lines shipped to each log stream remembered to skip when replayed after a restart"},
+		cfg.Option{Name: `DELAY`, Default: strconv.Itoa(defaultDelay), Validate: validateCount,
+			Description: "number of seconds between cloudwatch batch submissions, overrides the route option"},
+		cfg.Option{Name: `HIGH_RATE`, Default: strconv.Itoa(defaultHighRate), Validate: validateRate,
+			Description: "events per second at which adaptive batches are submitted after DELAY"},
+		cfg.Option{Name: `IDLE_FLUSH`, Validate: validateSeconds,
+			Description: "submit the batch of a container that logged nothing for this long"},
+		cfg.Option{Name: `INSIGHTS_FIELDS`, Validate: cfg.OneOf("true", "false"),
+			Description: "ship lines as flat JSON objects whose fields CloudWatch Logs Insights discovers"},
+		cfg.Option{Name: `LOGSPOUT_GROUP`, Validate: validateTemplate,
+			Description: "template of the log group of containers (default the host name)"},
+		cfg.Option{Name: `LOGSPOUT_STREAM`, Validate: validateTemplate,
+			Description: "template of the log stream of containers (default the container name)"},
+		cfg.Option{Name: `LOG_TIME`, Validate: cfg.OneOf("true", "false"),
+			Description: "timestamp events with the time of their line, like that parsed by the import command, rather than when received"},
+		cfg.Option{Name: `LONG_LINES`, Default: longLinesSplit, Validate: cfg.OneOf(longLinesSplit, longLinesTruncate),
+			Description: "split lines too large for an event into several, or truncate them"},
+		cfg.Option{Name: `MAX_BATCH_LENGTH`, Default: strconv.Itoa(maxBatchCount), Validate: validateLimit(maxBatchCount),
+			Description: "most events of a batch, up to the 10000 PutLogEvents accepts"},
+		cfg.Option{Name: `MAX_BATCH_SIZE`, Default: strconv.Itoa(maxBatchSize), Validate: validateLimit(maxBatchSize),
+			Description: "most bytes of a batch, counting 26 bytes per event, up to the 1048576 PutLogEvents accepts"},
+		cfg.Option{Name: `MAX_BATCH_SPAN`, Default: maxBatchSpan.String(), Validate: validateSeconds,
+			Description: "longest time the events of a batch span, up to the 24h PutLogEvents accepts"},
+		cfg.Option{Name: `MAX_RETRIES`, Type: cfg.Int, Default: strconv.Itoa(defaultMaxRetries), Validate: cfg.NotNegative,
+			Description: "number of times the AWS client retries failed requests"},
+		cfg.Option{Name: `MIN_DELAY`, Default: defaultMinDelay.String(), Validate: validateSeconds,
+			Description: "age at which adaptive batches of quiet containers are submitted"},
+		cfg.Option{Name: `NOEC2`,
+			Description: "skip looking up the instance ID and region from EC2 metadata when set to any value"},
+		cfg.Option{Name: `PRIORITY_FLUSH`, Validate: cfg.OneOf("true", "false"),
+			Description: "submit a batch as soon as an error severity line is added to it"},
+		cfg.Option{Name: `REQUEUE_ATTEMPTS`, Default: strconv.Itoa(defaultRequeueAttempts), Validate: validateCount,
+			Description: "number of times a failed batch is resubmitted before its events are dropped"},
+		cfg.Option{Name: `REQUEUE_DELAY`, Default: defaultRequeueDelay.String(), Validate: validateSeconds,
+			Description: "wait before the first resubmission of a failed batch"},
+		cfg.Option{Name: `REQUEUE_MAX_DELAY`, Default: defaultRequeueMaxDelay.String(), Validate: validateSeconds,
+			Description: "longest wait between resubmissions of a failed batch, whose REQUEUE_DELAY doubles every attempt"},
+		cfg.Option{Name: `STREAM_SHARDS`, Validate: validateCount,
+			Description: "number of log streams the lines of each container are spread over, named after its stream with a -0 to -N-1 suffix"},
+		cfg.Option{Name: `STREAM_SHARD_KEY`, Validate: validateShardKey,
+			Description: "regular expression of the part of lines, its first group if any, whose hash chooses their stream shard (default the whole line)"},
+		cfg.Option{Name: `STATE_EXPIRY`, Default: defaultStateExpiry.String(), Validate: validateSeconds,
+			Description: "release the cached state of containers that logged nothing for this long, 0 to keep it"},
+		cfg.Option{Name: `RETRIES_GUARANTEED`, Validate: validateCount,
+			Description: "MAX_RETRIES for containers of the guaranteed class"},
+		cfg.Option{Name: `RETRIES_BEST_EFFORT`, Validate: validateCount,
+			Description: "MAX_RETRIES for containers of the best-effort class"},
+		cfg.Option{Name: `TENANT_FILE`,
+			Description: "JSON file mapping values of the TENANT_LABEL to the AWS account role, region and log group prefix of tenants"},
+		cfg.Option{Name: `TENANT_LABEL`, Default: defaultTenantLabel,
+			Description: "label of containers naming their tenant in the TENANT_FILE"},
+		cfg.Option{Name: `TIME_RANGE`, Default: timeRangeClamp, Validate: cfg.OneOf(timeRangeClamp, timeRangeDrop),
+			Description: "clamp the time of LOG_TIME lines CloudWatch rejects, over 14 days old or 2 hours ahead, to when they were received, or drop them"},
+		cfg.Option{Name: `STATE_FILE`,
+			Description: "file persisting log stream names, sequence tokens and deduplication state across restarts"},
+	)
+}
+
+// validateCount accepts numbers that are not negative
+func validateCount(value string) error {
+	if i, err := strconv.Atoi(value); err != nil || i < 0 {
+		return errors.New("must be a number")
+	}
+	return nil
+}
+
+// validateLimit returns the validator of numbers from 1 up to a limit
+func validateLimit(limit int) func(string) error {
+	return func(value string) error {
+		if i, err := strconv.Atoi(value); err != nil || i < 1 || i > limit {
+			return fmt.Errorf("must be a number from 1 to %d", limit)
+		}
+		return nil
+	}
+}
+
+func validateRate(value string) error {
+	if f, err := strconv.ParseFloat(value, 64); err != nil || f <= 0 {
+		return errors.New("must be a positive number")
+	}
+	return nil
+}
+
+// validateSeconds accepts what getDurationOption does
+func validateSeconds(value string) error {
+	if _, err := strconv.Atoi(value); err == nil {
+		return nil
+	}
+	if _, err := time.ParseDuration(value); err != nil {
+		return errors.New("must be a duration or a number of seconds")
+	}
+	return nil
+}
+
+func validateTemplate(value string) error {
+	_, err := template.New("").Funcs(templateFuncs).Parse(value)
+	return err
+}
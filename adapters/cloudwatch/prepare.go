@@ -0,0 +1,160 @@
+package cloudwatch
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// defaultControlRate is the Describe and Create calls made per second to
+// prepare the streams of containers, below the quota of DescribeLogStreams
+const defaultControlRate = 5
+
+// prepareRequest is a stream whose sequence token is not known yet, with
+// the client of its account
+type prepareRequest struct {
+	msg Message
+	svc cloudwatchlogsiface.CloudWatchLogsAPI
+}
+
+// preparedStream is the sequence token of a stream, nil if it has none
+// yet, or the error fetching it
+type preparedStream struct {
+	msg   Message
+	token *string
+	err   error
+}
+
+// streamPreparer fetches the sequence tokens of the streams of containers
+// that attached, creating their groups and streams as needed, one at a time
+// and at most at the CONTROL_RATE, for the batches of streams already
+// prepared to be submitted meanwhile. Many containers attaching at once,
+// like during deploys, then wait in its queue rather than hold up those
+// already shipping or exhaust the quotas of the control plane.
+type streamPreparer struct {
+	uploader *Uploader
+	prepared chan preparedStream // read by the uploader
+	interval time.Duration       // between calls
+
+	mu      sync.Mutex
+	pending []prepareRequest
+	wake    chan struct{}
+
+	groups map[string]bool // known to exist, by tenant and name
+	last   time.Time       // of the last call
+}
+
+func newStreamPreparer(u *Uploader, route *router.Route) *streamPreparer {
+	rate := float64(defaultControlRate)
+	if text := getOption(route, `CONTROL_RATE`, ""); text != "" {
+		rate, _ = strconv.ParseFloat(text, 64) // validated
+	}
+	p := &streamPreparer{
+		uploader: u,
+		prepared: make(chan preparedStream),
+		interval: time.Duration(float64(time.Second) / rate),
+		wake:     make(chan struct{}, 1),
+		groups:   map[string]bool{},
+	}
+	go p.run()
+	return p
+}
+
+// add queues the preparation of the stream of a message
+func (p *streamPreparer) add(msg Message, svc cloudwatchlogsiface.CloudWatchLogsAPI) {
+	p.mu.Lock()
+	p.pending = append(p.pending, prepareRequest{msg: msg, svc: svc})
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest request queued, waiting for one if none is
+func (p *streamPreparer) next() prepareRequest {
+	for {
+		p.mu.Lock()
+		if len(p.pending) > 0 {
+			req := p.pending[0]
+			p.pending[0] = prepareRequest{}
+			p.pending = p.pending[1:]
+			p.mu.Unlock()
+			return req
+		}
+		p.mu.Unlock()
+		<-p.wake
+	}
+}
+
+func (p *streamPreparer) run() {
+	for {
+		req := p.next()
+		token, err := p.prepare(aws.BackgroundContext(), req)
+		p.prepared <- preparedStream{msg: req.msg, token: token, err: err}
+	}
+}
+
+// prepare returns the sequence token of the stream of a request, creating
+// its group unless known to exist, and the stream as needed
+func (p *streamPreparer) prepare(ctx aws.Context, req prepareRequest) (*string, error) {
+	svc, msg := limitedLogs{req.svc, p.wait}, req.msg
+	group := msg.Tenant + "/" + msg.Group
+	if !p.groups[group] {
+		if err := p.uploader.ensureGroup(ctx, svc, msg.Group); err != nil {
+			return nil, err
+		}
+		p.groups[group] = true
+	}
+	token, err := p.uploader.streamToken(ctx, svc, msg)
+	if err != nil {
+		delete(p.groups, group) // in case it was deleted
+	}
+	return token, err
+}
+
+// wait returns once a call may be made at the CONTROL_RATE
+func (p *streamPreparer) wait(ctx context.Context) {
+	if wait := p.interval - time.Since(p.last); wait > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+	p.last = time.Now()
+}
+
+// limitedLogs waits before the control plane calls of a client, to make
+// them at a rate
+type limitedLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	wait func(context.Context)
+}
+
+func (l limitedLogs) DescribeLogGroupsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	l.wait(ctx)
+	return l.CloudWatchLogsAPI.DescribeLogGroupsWithContext(ctx, input, opts...)
+}
+
+func (l limitedLogs) DescribeLogStreamsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	l.wait(ctx)
+	return l.CloudWatchLogsAPI.DescribeLogStreamsWithContext(ctx, input, opts...)
+}
+
+func (l limitedLogs) CreateLogGroupWithContext(ctx aws.Context, input *cloudwatchlogs.CreateLogGroupInput, opts ...request.Option) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	l.wait(ctx)
+	return l.CloudWatchLogsAPI.CreateLogGroupWithContext(ctx, input, opts...)
+}
+
+func (l limitedLogs) CreateLogStreamWithContext(ctx aws.Context, input *cloudwatchlogs.CreateLogStreamInput, opts ...request.Option) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	l.wait(ctx)
+	return l.CloudWatchLogsAPI.CreateLogStreamWithContext(ctx, input, opts...)
+}
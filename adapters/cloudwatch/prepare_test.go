@@ -0,0 +1,87 @@
+package cloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// slowLogs holds up describing the streams of the given name until
+// released, counting the calls describing groups
+type slowLogs struct {
+	*fakeLogs
+	slow     string
+	release  chan struct{}
+	groups   chan string
+	describe chan string
+}
+
+func (f slowLogs) DescribeLogGroupsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	f.groups <- *input.LogGroupNamePrefix
+	return f.fakeLogs.DescribeLogGroupsWithContext(ctx, input, opts...)
+}
+
+func (f slowLogs) DescribeLogStreamsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	f.describe <- *input.LogStreamNamePrefix
+	if *input.LogStreamNamePrefix == f.slow {
+		<-f.release
+	}
+	return f.fakeLogs.DescribeLogStreamsWithContext(ctx, input, opts...)
+}
+
+func newPreparingUploader(fake slowLogs) *Uploader {
+	u := &Uploader{
+		Input:        make(chan Batch),
+		flush:        make(chan chan struct{}),
+		svc:          fake,
+		tokens:       map[string]string{},
+		requeueDelay: 10 * time.Millisecond,
+		queues:       map[string]*requeueQueue{},
+		preparing:    map[string][]Batch{},
+	}
+	u.preparer = newStreamPreparer(u, &router.Route{Options: map[string]string{"CONTROL_RATE": "1000"}})
+	go u.Start()
+	return u
+}
+
+func streamBatch(message, stream string) Batch {
+	return Batch{Msgs: []Message{{Message: message, Group: "group", Stream: stream, Container: stream}}}
+}
+
+func TestUploaderPreparesNewStreamsAside(t *testing.T) {
+	uploaded := make(chan string, 10)
+	fake := slowLogs{fakeLogs: &fakeLogs{uploaded: uploaded}, slow: "new",
+		release: make(chan struct{}), groups: make(chan string, 10), describe: make(chan string, 10)}
+	u := newPreparingUploader(fake)
+	u.Input <- streamBatch("first", "attached")
+	expectUploads(t, uploaded, "first")
+	u.Input <- streamBatch("deploying", "new")
+	u.Input <- streamBatch("later", "new")
+	u.Input <- streamBatch("second", "attached")
+	// the attached stream ships while the new one is being prepared
+	expectUploads(t, uploaded, "second")
+	close(fake.release)
+	expectUploads(t, uploaded, "deploying", "later")
+	if len(fake.groups) != 1 {
+		t.Errorf("expected the group to be described once for both streams, got %d calls", len(fake.groups))
+	}
+	if len(fake.describe) != 2 {
+		t.Errorf("expected each stream to be described once, got %d calls", len(fake.describe))
+	}
+}
+
+func TestStreamPreparerLimitsRate(t *testing.T) {
+	p := &streamPreparer{interval: 50 * time.Millisecond}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		p.wait(aws.BackgroundContext())
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected 3 calls to take at least 2 intervals, took %s", elapsed)
+	}
+}
@@ -0,0 +1,179 @@
+package cloudwatch
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awscloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+
+	"github.com/gliderlabs/logspout/adapters/httpclient"
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/metrics"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultMetricsInterval = time.Minute
+	maxMetricData          = 20 // per PutMetricData request
+	maxMetricDimensions    = 10
+)
+
+func init() {
+	router.Jobs.Register(&metricsPublisher{}, "cloudwatch-metrics")
+}
+
+// metricsPublisher is the job publishing the metrics of logspout, like the
+// events shipped and dropped, as CloudWatch custom metrics, for alarms on
+// the health of the log pipeline without a Prometheus server
+type metricsPublisher struct {
+	svc        cloudwatchiface.CloudWatchAPI
+	namespace  string
+	interval   time.Duration
+	names      map[string]bool // the metrics published, all but per container ones if empty
+	dimensions []*awscloudwatch.Dimension
+	published  map[string]float64 // the last value of each counter, by name and labels
+}
+
+func (p *metricsPublisher) Name() string {
+	if p.svc == nil {
+		return ""
+	}
+	return "cloudwatch-metrics"
+}
+
+func (p *metricsPublisher) Setup() error {
+	p.namespace = cfg.GetString(`CLOUDWATCH_METRICS_NAMESPACE`)
+	if p.namespace == "" {
+		return nil
+	}
+	p.interval = cfg.GetDuration(`CLOUDWATCH_METRICS_INTERVAL`)
+	p.names = map[string]bool{}
+	for _, name := range strings.Split(cfg.GetString(`CLOUDWATCH_METRICS_NAMES`), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			p.names[name] = true
+		}
+	}
+	hostname, _ := os.Hostname()
+	p.dimensions = []*awscloudwatch.Dimension{{Name: aws.String("Host"), Value: aws.String(hostname)}}
+	p.published = map[string]float64{}
+	sess := session.New()
+	region := cfg.GetString(`CLOUDWATCH_METRICS_REGION`)
+	if region == "" && cfg.GetString(`NOEC2`) == "" {
+		if metadata := ec2metadata.New(sess); metadata.Available() {
+			region, _ = metadata.Region()
+		}
+	}
+	config := httpclient.AWSConfig()
+	if region != "" {
+		config.Region = aws.String(region)
+	}
+	p.svc = awscloudwatch.New(sess, config)
+	return nil
+}
+
+func (p *metricsPublisher) Run() error {
+	if p.svc == nil {
+		select {}
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		data := p.data(metrics.Gather(), now)
+		for len(data) > 0 {
+			n := len(data)
+			if n > maxMetricData {
+				n = maxMetricData
+			}
+			_, err := p.svc.PutMetricData(&awscloudwatch.PutMetricDataInput{
+				Namespace:  aws.String(p.namespace),
+				MetricData: data[:n],
+			})
+			if err != nil {
+				log.Println("cloudwatch: publishing metrics:", err)
+			}
+			data = data[n:]
+		}
+	}
+	return nil
+}
+
+// data returns the metric data of the samples published, the value of
+// gauges and the increase since the last publication of counters
+func (p *metricsPublisher) data(samples []metrics.Sample, now time.Time) []*awscloudwatch.MetricDatum {
+	var data []*awscloudwatch.MetricDatum
+	for _, sample := range samples {
+		if !p.publishes(sample) {
+			continue
+		}
+		value, unit := sample.Value, awscloudwatch.StandardUnitNone
+		switch {
+		case sample.Kind == metrics.CounterKind:
+			key := sample.Name + sampleKey(sample.Labels)
+			value, unit = sample.Value-p.published[key], awscloudwatch.StandardUnitCount
+			p.published[key] = sample.Value
+		case strings.HasSuffix(sample.Name, "_seconds"):
+			unit = awscloudwatch.StandardUnitSeconds
+		}
+		data = append(data, &awscloudwatch.MetricDatum{
+			MetricName: aws.String(sample.Name),
+			Dimensions: p.dimensionsOf(sample.Labels),
+			Timestamp:  aws.Time(now),
+			Unit:       aws.String(unit),
+			Value:      aws.Float64(value),
+		})
+	}
+	return data
+}
+
+// publishes returns whether a sample is published: if it is one of the
+// CLOUDWATCH_METRICS_NAMES, or if none are set, unless it is of a single
+// container or log stream, which would be costly with containers coming
+// and going
+func (p *metricsPublisher) publishes(sample metrics.Sample) bool {
+	if len(p.names) > 0 {
+		return p.names[sample.Name]
+	}
+	_, perContainer := sample.Labels["container"]
+	_, perStream := sample.Labels["stream"]
+	return !perContainer && !perStream
+}
+
+// dimensionsOf returns the dimensions of a sample, its labels and the host
+func (p *metricsPublisher) dimensionsOf(labels map[string]string) []*awscloudwatch.Dimension {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if labels[name] != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	dimensions := append([]*awscloudwatch.Dimension{}, p.dimensions...)
+	for _, name := range names {
+		if len(dimensions) == maxMetricDimensions {
+			break
+		}
+		dimensions = append(dimensions, &awscloudwatch.Dimension{Name: aws.String(name), Value: aws.String(labels[name])})
+	}
+	return dimensions
+}
+
+// sampleKey identifies the value of a metric for one set of labels
+func sampleKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString("\xff" + name + "=" + labels[name])
+	}
+	return key.String()
+}
@@ -0,0 +1,40 @@
+package cloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/gliderlabs/logspout/metrics"
+)
+
+func TestMetricsPublisherData(t *testing.T) {
+	p := &metricsPublisher{names: map[string]bool{}, published: map[string]float64{},
+		dimensions: []*awscloudwatch.Dimension{{Name: aws.String("Host"), Value: aws.String("host")}}}
+	samples := []metrics.Sample{
+		{Name: "logspout_cloudwatch_shipped_events_total", Kind: metrics.CounterKind, Value: 10},
+		{Name: "logspout_cloudwatch_backlog_age_seconds", Kind: metrics.GaugeKind, Labels: map[string]string{"route": "r1"}, Value: 4},
+		{Name: "logspout_cloudwatch_stream_rate", Kind: metrics.GaugeKind, Labels: map[string]string{"container": "app"}, Value: 3},
+	}
+	data := p.data(samples, time.Now())
+	if len(data) != 2 {
+		t.Fatalf("expected metrics of single containers to be skipped, got %d", len(data))
+	}
+	if aws.StringValue(data[1].Unit) != "Seconds" || len(data[1].Dimensions) != 2 ||
+		aws.StringValue(data[1].Dimensions[1].Name) != "route" {
+		t.Errorf("unexpected datum of a gauge: %v", data[1])
+	}
+
+	samples[0].Value = 25
+	data = p.data(samples, time.Now())
+	if value := aws.Float64Value(data[0].Value); value != 15 || aws.StringValue(data[0].Unit) != "Count" {
+		t.Errorf("expected the increase of the counter to be published, got %v", data[0])
+	}
+
+	p.names = map[string]bool{"logspout_cloudwatch_stream_rate": true}
+	if data = p.data(samples, time.Now()); len(data) != 1 || aws.StringValue(data[0].MetricName) != "logspout_cloudwatch_stream_rate" {
+		t.Errorf("expected only the named metrics to be published, got %v", data)
+	}
+}
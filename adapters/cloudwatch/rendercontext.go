@@ -2,19 +2,50 @@ package cloudwatch
 
 import (
 	"fmt"
+	"text/template"
+
+	docker "github.com/fsouza/go-dockerclient"
 )
 
+// templateFuncs are the functions of naming templates, those reading the
+// container are bound to it as each template is rendered
+var templateFuncs = template.FuncMap{
+	"env": func(key string, defaultVal ...string) string { return "" },
+}
+
 // RenderContext defines the info that can be used in
 // LogGroup and LogStream names.
 type RenderContext struct {
-	Host       string            // container host name
-	Env        map[string]string // container ENV
-	Labels     map[string]string // container Labels
-	Name       string            // container Name
-	ID         string            // container ID
-	LoggerHost string            // hostname of logging container (os.Hostname)
-	InstanceID string            // EC2 Instance ID
-	Region     string            // EC2 region
+	Host        string            // container host name
+	Env         map[string]string // container ENV
+	Labels      map[string]string // container Labels
+	ImageLabels map[string]string // OCI labels of the container image, eg: revision and version
+	Name        string            // container Name
+	ID          string            // container ID
+	LoggerHost  string            // hostname of logging container (os.Hostname)
+	InstanceID  string            // EC2 Instance ID
+	Region      string            // EC2 region
+
+	container *docker.Container // read by the template functions
+}
+
+// funcs returns the template functions bound to the container
+func (r *RenderContext) funcs() template.FuncMap {
+	return template.FuncMap{"env": r.env}
+}
+
+// env renders the value of a variable of the container's environment, read
+// as the template is rendered, or the default if it is not set or empty:
+// {{env "APP_NAME" "unknown"}}
+func (r *RenderContext) env(key string, defaultVal ...string) string {
+	value := r.Env[key]
+	if r.container != nil && r.container.Config != nil {
+		value = parseEnv(r.container.Config.Env)[key]
+	}
+	if value == "" && len(defaultVal) > 0 {
+		return defaultVal[0]
+	}
+	return value
 }
 
 // Lbl renders a label value based on a given key
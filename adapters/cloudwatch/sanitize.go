@@ -0,0 +1,122 @@
+package cloudwatch
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Cloudwatch rejects an entire PutLogEvents request if any event contains
+// invalid UTF-8, so every message is normalized before it enters a Batch.
+
+const (
+	binaryEncodeBase64 = "base64"
+	binaryEncodeHex    = "hex"
+	binaryDrop         = "drop"
+)
+
+// lines where more than this fraction of bytes are undecodable or control
+// characters are treated as binary output rather than mis-encoded text
+const binaryThreshold = 0.3
+
+// binaryPolicy decides what happens to lines detected as binary output.
+type binaryPolicy struct {
+	encoding  string // one of base64, hex or drop
+	rateLimit int    // binary lines per second per container, 0 is unlimited
+	windows   map[string]*rateWindow
+}
+
+// rateWindow counts the binary lines seen from a container in one second
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newBinaryPolicy reads the BINARY_OUTPUT and BINARY_RATE_LIMIT settings
+func newBinaryPolicy(route *router.Route) (*binaryPolicy, error) {
+	encoding := getOption(route, `BINARY_OUTPUT`, binaryEncodeBase64)
+	switch encoding {
+	case binaryEncodeBase64, binaryEncodeHex, binaryDrop:
+	default:
+		return nil, fmt.Errorf("unknown BINARY_OUTPUT value: %s", encoding)
+	}
+	rateLimit := 0
+	if limitText := getOption(route, `BINARY_RATE_LIMIT`, ""); limitText != "" {
+		i, err := strconv.Atoi(limitText)
+		if err != nil {
+			return nil, fmt.Errorf("ERROR parsing BINARY_RATE_LIMIT %s: %s", limitText, err)
+		}
+		rateLimit = i
+	}
+	return &binaryPolicy{
+		encoding:  encoding,
+		rateLimit: rateLimit,
+		windows:   map[string]*rateWindow{},
+	}, nil
+}
+
+// sanitize returns a version of msg that is safe to submit to Cloudwatch,
+// or false if the message should not be shipped at all.
+func (p *binaryPolicy) sanitize(container, msg string) (string, bool) {
+	if !isBinary(msg) {
+		return sanitizeMessage(msg), true
+	}
+	if p.encoding == binaryDrop || !p.allow(container) {
+		return "", false
+	}
+	if p.encoding == binaryEncodeHex {
+		return fmt.Sprintf("[binary hex, %d bytes] %s", len(msg), hex.EncodeToString([]byte(msg))), true
+	}
+	return fmt.Sprintf("[binary base64, %d bytes] %s", len(msg), base64.StdEncoding.EncodeToString([]byte(msg))), true
+}
+
+// allow reports whether another binary line from the given container
+// fits within the configured rate limit
+func (p *binaryPolicy) allow(container string) bool {
+	if p.rateLimit <= 0 {
+		return true
+	}
+	now := time.Now()
+	window, exists := p.windows[container]
+	if !exists || now.Sub(window.start) >= time.Second {
+		window = &rateWindow{start: now}
+		p.windows[container] = window
+	}
+	window.count++
+	return window.count <= p.rateLimit
+}
+
+// sanitizeMessage replaces invalid UTF-8 sequences in msg with the
+// Unicode replacement character.
+func sanitizeMessage(msg string) string {
+	if !utf8.ValidString(msg) {
+		return strings.ToValidUTF8(msg, string(utf8.RuneError))
+	}
+	return msg
+}
+
+// isBinary reports whether msg looks like binary output, based on the
+// proportion of bytes that are not printable UTF-8 text.
+func isBinary(msg string) bool {
+	if len(msg) == 0 {
+		return false
+	}
+	bad := 0
+	for i := 0; i < len(msg); {
+		r, size := utf8.DecodeRuneInString(msg[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			bad++
+		case r < 0x20 && r != '\t' && r != '\r' && r != '\n' && r != 0x1b:
+			bad++ // control characters, except whitespace and ANSI escapes
+		}
+		i += size
+	}
+	return float64(bad)/float64(len(msg)) > binaryThreshold
+}
@@ -0,0 +1,88 @@
+package cloudwatch
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestSanitizeMessage(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"plain text", "plain text"},
+		{"tabs\tand \x1b[31mcolors\x1b[0m", "tabs\tand \x1b[31mcolors\x1b[0m"},
+		{"caf\xe9 au lait", "caf� au lait"},
+	}
+	for _, test := range tests {
+		if actual := sanitizeMessage(test.in); actual != test.out {
+			t.Errorf("expected %q got %q", test.out, actual)
+		}
+	}
+}
+
+func TestSanitizeMessageIsValidUTF8(t *testing.T) {
+	inputs := []string{
+		"\xc3\x28",
+		strings.Repeat("\xe2\x82", 10),
+		"ok \xf0\x28\x8c\x28 ok",
+	}
+	for _, in := range inputs {
+		if actual := sanitizeMessage(in); !utf8.ValidString(actual) {
+			t.Errorf("expected valid UTF-8 for %q, got %q", in, actual)
+		}
+	}
+}
+
+func TestBinaryPolicyEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		out      string
+		ok       bool
+	}{
+		{binaryEncodeBase64, "[binary base64, 4 bytes] AAEC/w==", true},
+		{binaryEncodeHex, "[binary hex, 4 bytes] 000102ff", true},
+		{binaryDrop, "", false},
+	}
+	for _, test := range tests {
+		route := &router.Route{Options: map[string]string{`BINARY_OUTPUT`: test.encoding}}
+		policy, err := newBinaryPolicy(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, ok := policy.sanitize("abc", "\x00\x01\x02\xff")
+		if out != test.out || ok != test.ok {
+			t.Errorf("%s: expected (%q, %v) got (%q, %v)", test.encoding, test.out, test.ok, out, ok)
+		}
+	}
+}
+
+func TestBinaryPolicyRateLimit(t *testing.T) {
+	route := &router.Route{Options: map[string]string{`BINARY_RATE_LIMIT`: "2"}}
+	policy, err := newBinaryPolicy(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipped := 0
+	for i := 0; i < 5; i++ {
+		if _, ok := policy.sanitize("abc", "\x00\x00\x00"); ok {
+			shipped++
+		}
+	}
+	if shipped != 2 {
+		t.Errorf("expected 2 binary lines shipped, got %d", shipped)
+	}
+	if _, ok := policy.sanitize("abc", "text is not limited"); !ok {
+		t.Error("expected text line to be shipped")
+	}
+}
+
+func TestBinaryPolicyInvalidOption(t *testing.T) {
+	route := &router.Route{Options: map[string]string{`BINARY_OUTPUT`: "rot13"}}
+	if _, err := newBinaryPolicy(route); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,78 @@
+package cloudwatch
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// startingShard suffixes the stream the lines of containers whose health
+// status is starting are shipped to, with health.starting=separate, which
+// is not sharded
+const startingShard = "starting"
+
+// streamSharder spreads the lines of each container over STREAM_SHARDS log
+// streams, named after its stream with a -0 to -N-1 suffix, so a container
+// logging more than a stream ingests is still shipped whole. Lines with the
+// same key go to the same shard, in order.
+type streamSharder struct {
+	shards int
+	key    *regexp.Regexp // of the part of lines hashed, their first group if any
+}
+
+// newStreamSharder returns the sharder of a route, or nil if it does not
+// shard streams
+func newStreamSharder(route *router.Route) (*streamSharder, error) {
+	text := getOption(route, `STREAM_SHARDS`, "")
+	if text == "" {
+		return nil, nil
+	}
+	shards, err := strconv.Atoi(text)
+	if err != nil || shards < 0 {
+		return nil, fmt.Errorf("ERROR parsing STREAM_SHARDS %s: must be a number", text)
+	}
+	if shards < 2 {
+		return nil, nil
+	}
+	s := &streamSharder{shards: shards}
+	if pattern := getOption(route, `STREAM_SHARD_KEY`, ""); pattern != "" {
+		if s.key, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("ERROR parsing STREAM_SHARD_KEY %s: %s", pattern, err)
+		}
+	}
+	return s, nil
+}
+
+func validateShardKey(value string) error {
+	_, err := regexp.Compile(value)
+	return err
+}
+
+// shard returns the shard a line goes to, chosen by the hash of the key of
+// the line, or of the whole line if it has none
+func (s *streamSharder) shard(line string) string {
+	key := line
+	if s.key != nil {
+		if match := s.key.FindStringSubmatch(line); len(match) > 1 {
+			key = match[1]
+		} else if len(match) == 1 {
+			key = match[0]
+		}
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key)) //nolint:errcheck
+	return strconv.Itoa(int(hash.Sum32() % uint32(s.shards)))
+}
+
+// streamKeys returns the key of a stream, that of its stream of lines of
+// the container starting, and those of its shards if any
+func (s *streamSharder) streamKeys(key string) []string {
+	keys := []string{key, key + "-" + startingShard}
+	for i := 0; s != nil && i < s.shards; i++ {
+		keys = append(keys, key+"-"+strconv.Itoa(i))
+	}
+	return keys
+}
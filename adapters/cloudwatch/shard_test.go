@@ -0,0 +1,93 @@
+package cloudwatch
+
+import (
+	"strconv"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestAdapterShardsStreams(t *testing.T) {
+	route := &router.Route{Options: map[string]string{
+		"STREAM_SHARDS": "4", "STREAM_SHARD_KEY": `request=(\w+)`, "LOGSPOUT_STREAM": "web"}}
+	sharder, err := newStreamSharder(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := newBinaryPolicy(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Adapter{
+		Route:       route,
+		OsHost:      "host",
+		binary:      binary,
+		sharder:     sharder,
+		groupnames:  map[string]string{},
+		streamnames: map[string]string{},
+		tenantnames: map[string]string{},
+		namedFor:    map[string]string{},
+		templates:   map[string]*template.Template{},
+		retries:     map[string]int{},
+		lastSeen:    map[string]time.Time{},
+		batcher:     &Batcher{Input: make(chan Message, 100)},
+	}
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	logstream := make(chan *router.Message, 100)
+	for _, request := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "a", "b"} {
+		logstream <- &router.Message{Container: container, Data: "GET / request=" + request}
+	}
+	close(logstream)
+	a.Stream(logstream)
+
+	streams := map[string]string{} // by request
+	shards := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		msg := <-a.batcher.Input
+		request := msg.Message[len("GET / request="):]
+		if stream, seen := streams[request]; seen && stream != msg.Stream {
+			t.Errorf("expected the lines of request %s in the same stream, got %s and %s", request, stream, msg.Stream)
+		}
+		streams[request] = msg.Stream
+		shards[msg.Stream] = true
+		if msg.Stream != "web-"+msg.Shard || msg.batchKey() != "8dfafdbc3a40-"+msg.Shard {
+			t.Errorf("expected the stream and batches of shard %s, got %s and %s", msg.Shard, msg.Stream, msg.batchKey())
+		}
+	}
+	if len(shards) < 2 {
+		t.Errorf("expected the lines to be spread over shards, got %v", shards)
+	}
+}
+
+func TestStreamSharderInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"STREAM_SHARDS": "many"},
+		{"STREAM_SHARDS": "4", "STREAM_SHARD_KEY": "("},
+	} {
+		if _, err := newStreamSharder(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+	if sharder, err := newStreamSharder(&router.Route{Options: map[string]string{"STREAM_SHARDS": "1"}}); sharder != nil || err != nil {
+		t.Errorf("expected a single shard not to shard, got %v, %v", sharder, err)
+	}
+}
+
+func TestStreamTokenOfShards(t *testing.T) {
+	sharder := &streamSharder{shards: 12}
+	streams := sharder.streamKeys("web")[2:] // web-0 to web-11
+	u := &Uploader{tokens: map[string]string{}}
+	for i := 0; i < sharder.shards; i++ {
+		stream := "web-" + strconv.Itoa(i)
+		fake := &fakeLogs{token: "next", noStream: true, streams: streams, page: 5}
+		token, err := u.getSequenceToken(aws.BackgroundContext(), fake, Message{Group: "group", Stream: stream})
+		if err != nil || aws.StringValue(token) != "next" || !fake.noStream {
+			t.Errorf("%s: expected the token of the shard, which exists, got %v, %v", stream, aws.StringValue(token), err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package cloudwatch
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Rules for the size of a single Cloudwatch Log event, from https://goo.gl/TrIN8c
+const maxEventSize = 262144 // bytes, including msgOverhead
+
+// room reserved in each piece of a split message for its part marker, and
+// in a truncated one for its marker
+const maxMarkerSize = 48 // bytes
+
+// Values of LONG_LINES, what is done with the lines too large for an event
+const (
+	longLinesSplit    = "split"    // into several events
+	longLinesTruncate = "truncate" // to the first bytes that fit, with a marker
+)
+
+// splitMessage breaks a message that is too large for a single Cloudwatch
+// event into pieces. Each piece is prefixed with a marker holding its part
+// number and a correlation ID shared by all pieces of the original line, so
+// that downstream consumers can reassemble them. Messages that already fit
+// are returned unchanged.
+func splitMessage(msg Message) []Message {
+	limit := maxEventSize - msgOverhead
+	if len(msg.Message) <= limit {
+		return []Message{msg}
+	}
+	pieces := splitText(msg.Message, limit-maxMarkerSize)
+	partID := newPartID()
+	parts := make([]Message, 0, len(pieces))
+	for i, piece := range pieces {
+		part := msg
+		part.PartID = partID
+		part.Part = i + 1
+		part.Parts = len(pieces)
+		part.Message = fmt.Sprintf("[part %d/%d id=%s] %s", part.Part, part.Parts, partID, piece)
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// truncateMessage cuts a message that is too large for a single Cloudwatch
+// event to the bytes that fit, followed by a marker holding how many bytes
+// were dropped. Messages that already fit are returned unchanged.
+func truncateMessage(msg Message) Message {
+	return truncateTo(msg, maxEventSize-msgOverhead)
+}
+
+// truncateTo cuts a message to at most limit bytes, its marker included
+func truncateTo(msg Message, limit int) Message {
+	if len(msg.Message) <= limit {
+		return msg
+	}
+	end := runeStart(msg.Message, limit-maxMarkerSize)
+	msg.Message = fmt.Sprintf("%s [truncated %d bytes]", msg.Message[:end], len(msg.Message)-end)
+	return msg
+}
+
+// splitText breaks text into pieces of at most limit bytes,
+// without splitting any multi-byte characters.
+func splitText(text string, limit int) []string {
+	var pieces []string
+	for len(text) > limit {
+		end := runeStart(text, limit)
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return append(pieces, text)
+}
+
+// runeStart returns the start of the character at the given byte of text,
+// for text cut there not to end with part of a multi-byte character
+func runeStart(text string, end int) int {
+	start := end
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	if start == 0 {
+		return end
+	}
+	return start
+}
+
+// newPartID returns a random ID shared by all the pieces of a split message
+func newPartID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(id)
+}
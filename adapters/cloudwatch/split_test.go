@@ -0,0 +1,64 @@
+package cloudwatch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessageFits(t *testing.T) {
+	msg := Message{Message: "short line", Container: "abc"}
+	parts := splitMessage(msg)
+	if len(parts) != 1 || parts[0] != msg {
+		t.Errorf("expected message to be unchanged, got %v", parts)
+	}
+}
+
+func TestSplitMessageOversized(t *testing.T) {
+	text := strings.Repeat("é", maxEventSize) // two bytes per character
+	parts := splitMessage(Message{Message: text, Container: "abc"})
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts, got %d", len(parts))
+	}
+	var joined string
+	for i, part := range parts {
+		if msgLen := len(part.Message) + msgOverhead; msgLen > maxEventSize {
+			t.Errorf("part %d is %d bytes, over the event limit", i, msgLen)
+		}
+		if !utf8.ValidString(part.Message) {
+			t.Errorf("part %d is not valid UTF-8", i)
+		}
+		if part.PartID != parts[0].PartID || part.Part != i+1 || part.Parts != 3 {
+			t.Errorf("unexpected part fields: %s %d/%d", part.PartID, part.Part, part.Parts)
+		}
+		marker := fmt.Sprintf("[part %d/3 id=%s] ", i+1, part.PartID)
+		if !strings.HasPrefix(part.Message, marker) {
+			t.Errorf("expected part %d to start with %q", i, marker)
+		}
+		joined += strings.TrimPrefix(part.Message, marker)
+	}
+	if joined != text {
+		t.Error("expected parts to reassemble to the original text")
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	msg := Message{Message: "short line", Container: "abc"}
+	if truncated := truncateMessage(msg); truncated != msg {
+		t.Errorf("expected message to be unchanged, got %v", truncated)
+	}
+	text := strings.Repeat("é", maxEventSize) // two bytes per character
+	truncated := truncateMessage(Message{Message: text, Container: "abc"})
+	if msgLen := len(truncated.Message) + msgOverhead; msgLen > maxEventSize {
+		t.Errorf("truncated message is %d bytes, over the event limit", msgLen)
+	}
+	if !utf8.ValidString(truncated.Message) {
+		t.Error("truncated message is not valid UTF-8")
+	}
+	kept := truncated.Message[:strings.LastIndex(truncated.Message, " [truncated ")]
+	marker := fmt.Sprintf(" [truncated %d bytes]", len(text)-len(kept))
+	if !strings.HasPrefix(text, kept) || !strings.HasSuffix(truncated.Message, marker) {
+		t.Errorf("expected the first bytes followed by %q", marker)
+	}
+}
@@ -0,0 +1,197 @@
+package cloudwatch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// how often changes to the state file are written out
+const stateSaveInterval = time.Second
+
+// stateFile persists what the adapter knows about each log stream to the
+// STATE_FILE, so a restarted logspout can carry on where it stopped.
+type stateFile struct {
+	path       string
+	mu         sync.Mutex
+	streams    map[string]*streamState   // indexed by group and stream, see streamKey
+	containers map[string]containerState // indexed by container ID
+	dirty      bool
+}
+
+// stateData is the layout of the state file
+type stateData struct {
+	Streams    map[string]*streamState   `json:"streams"`
+	Containers map[string]containerState `json:"containers"`
+}
+
+// streamState is the persisted state of one log stream
+type streamState struct {
+	Recent []uint64 `json:"recent,omitempty"` // hashes of the last lines shipped, oldest first
+	Token  string   `json:"token,omitempty"`  // sequence token of the next PutLogEvents
+}
+
+// containerState is the log group and stream rendered for a container
+type containerState struct {
+	Group     string `json:"group"`
+	Stream    string `json:"stream"`
+	Templates string `json:"templates"`      // what they were rendered from, see nameTemplates
+	Name      string `json:"name,omitempty"` // of the container they were rendered for
+}
+
+// streamKey returns the key of a log stream in the state file
+func streamKey(group, stream string) string {
+	return group + "/" + stream
+}
+
+// loadStateFile reads the state file at path, which may not exist yet, and
+// writes changes back to it from then on. It returns nil if path is empty.
+func loadStateFile(path string) (*stateFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	s := &stateFile{path: path, streams: map[string]*streamState{}, containers: map[string]containerState{}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		saved := stateData{Streams: s.streams, Containers: s.containers}
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, err
+		}
+		if saved.Streams != nil {
+			s.streams = saved.Streams
+		}
+		if saved.Containers != nil {
+			s.containers = saved.Containers
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+// stream returns a copy of the state of a log stream, or nil if unknown
+func (s *stateFile) stream(key string) *streamState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, exists := s.streams[key]
+	if !exists {
+		return nil
+	}
+	copied := *state
+	copied.Recent = append([]uint64(nil), state.Recent...)
+	return &copied
+}
+
+// keys returns the keys of all known log streams
+func (s *stateFile) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.streams))
+	for key := range s.streams {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// update changes the state of a log stream, which is created as needed
+func (s *stateFile) update(key string, change func(*streamState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, exists := s.streams[key]
+	if !exists {
+		state = &streamState{}
+		s.streams[key] = state
+	}
+	change(state)
+	s.dirty = true
+}
+
+// container returns the log group and stream of a container, if known
+func (s *stateFile) container(id string) (containerState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists := s.containers[id]
+	return c, exists
+}
+
+// setContainer records the log group and stream of a container
+func (s *stateFile) setContainer(id string, c containerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers[id] = c
+	s.dirty = true
+}
+
+// containerIDs returns the IDs of all containers with saved names
+func (s *stateFile) containerIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.containers))
+	for id := range s.containers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// forgetContainer removes the saved names of a container
+func (s *stateFile) forgetContainer(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.containers[id]; exists {
+		delete(s.containers, id)
+		s.dirty = true
+	}
+}
+
+// forgetStream removes the state of a log stream
+func (s *stateFile) forgetStream(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.streams[key]; exists {
+		delete(s.streams, key)
+		s.dirty = true
+	}
+}
+
+func (s *stateFile) run() {
+	for range time.Tick(stateSaveInterval) {
+		if err := s.save(); err != nil {
+			log.Println("cloudwatch: error saving STATE_FILE:", err)
+		}
+	}
+}
+
+// save writes the state file if it changed, replacing it atomically so a
+// crash never leaves it half written
+func (s *stateFile) save() error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(stateData{Streams: s.streams, Containers: s.containers})
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
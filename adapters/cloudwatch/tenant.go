@@ -0,0 +1,103 @@
+package cloudwatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// defaultTenantLabel is the label of containers naming their tenant
+const defaultTenantLabel = "logspout.tenant"
+
+// tenant is where the logs of the containers of one tenant are shipped,
+// in the tenant's own AWS account
+type tenant struct {
+	RoleARN     string `json:"role_arn"`     // role assumed to ship the logs
+	ExternalID  string `json:"external_id"`  // optional, required by the role
+	Region      string `json:"region"`       // optional, the region of the route
+	GroupPrefix string `json:"group_prefix"` // optional, prepended to log groups
+}
+
+// tenants maps the values of the TENANT_LABEL to tenants, from the
+// TENANT_FILE, which is read again when it changes, so tenants are added
+// without restarting logspout
+type tenants struct {
+	path    string
+	label   string
+	mu      sync.Mutex
+	modTime time.Time
+	byName  map[string]tenant
+}
+
+// loadTenants reads the TENANT_FILE of a route, it returns nil if not set
+func loadTenants(route *router.Route) (*tenants, error) {
+	path := getOption(route, `TENANT_FILE`, "")
+	if path == "" {
+		return nil, nil
+	}
+	t := &tenants{path: path, label: getOption(route, `TENANT_LABEL`, defaultTenantLabel)}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// reload reads the file if it changed since it was last read
+func (t *tenants) reload() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+	if t.byName != nil && info.ModTime().Equal(t.modTime) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+	byName := map[string]tenant{}
+	if err := json.Unmarshal(data, &byName); err != nil {
+		return fmt.Errorf("cloudwatch: invalid TENANT_FILE %s: %s", t.path, err)
+	}
+	for name, tenant := range byName {
+		if tenant.RoleARN == "" {
+			return fmt.Errorf("cloudwatch: invalid TENANT_FILE %s: missing role_arn of tenant %s", t.path, name)
+		}
+	}
+	t.byName, t.modTime = byName, info.ModTime()
+	return nil
+}
+
+// of returns the name of the tenant of a container from its labels, and
+// the tenant, reading the file again if it changed. Containers without a
+// known tenant are shipped to the account of logspout, and get no name.
+func (t *tenants) of(labels map[string]string) (string, tenant) {
+	if t == nil {
+		return "", tenant{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.reload(); err != nil {
+		log.Println("cloudwatch: keeping the tenants read before:", err)
+	}
+	name := labels[t.label]
+	found, exists := t.byName[name]
+	if name == "" || !exists {
+		return "", tenant{}
+	}
+	return name, found
+}
+
+// get returns a tenant by name, as last read
+func (t *tenants) get(name string) (tenant, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	found, exists := t.byName[name]
+	return found, exists
+}
@@ -0,0 +1,66 @@
+package cloudwatch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestTenantsReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tenants.json")
+	write := func(data string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"acme": {"role_arn": "arn:aws:iam::111111111111:role/logs", "group_prefix": "acme/"}}`, time.Now().Add(-time.Minute))
+
+	route := &router.Route{Options: map[string]string{"TENANT_FILE": path, "TENANT_LABEL": "tenant"}}
+	tenantFile, err := loadTenants(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, acme := tenantFile.of(map[string]string{"tenant": "acme"}); name != "acme" || acme.GroupPrefix != "acme/" {
+		t.Errorf("unexpected tenant: %s %+v", name, acme)
+	}
+	if name, _ := tenantFile.of(map[string]string{"tenant": "globex"}); name != "" {
+		t.Errorf("expected containers of unknown tenants to have none, got %s", name)
+	}
+
+	write(`{"globex": {"role_arn": "arn:aws:iam::222222222222:role/logs", "region": "eu-west-1"}}`, time.Now())
+	if name, globex := tenantFile.of(map[string]string{"tenant": "globex"}); name != "globex" || globex.Region != "eu-west-1" {
+		t.Errorf("expected the changed file to be read, got %s %+v", name, globex)
+	}
+	// an invalid file keeps the tenants read before
+	write(`{"initech": {}}`, time.Now().Add(time.Minute))
+	if name, _ := tenantFile.of(map[string]string{"tenant": "globex"}); name != "globex" {
+		t.Errorf("expected the tenants read before to be kept, got %q", name)
+	}
+}
+
+func TestUploaderTenantClient(t *testing.T) {
+	u, uploaded := newTestUploader(nil, 0)
+	acme := tenant{RoleARN: "arn:aws:iam::111111111111:role/logs"}
+	u.tenants = &tenants{byName: map[string]tenant{"acme": acme}}
+	tenantUploaded := make(chan string, 10)
+	u.clients = map[string]tenantClient{"acme": {tenant: acme, svc: &fakeLogs{uploaded: tenantUploaded}}}
+
+	batch := testBatch("tenant")
+	batch.Msgs[0].Tenant = "acme"
+	u.Input <- batch
+	u.Input <- testBatch("own")
+	expectUploads(t, tenantUploaded, "tenant")
+	expectUploads(t, uploaded, "own")
+}
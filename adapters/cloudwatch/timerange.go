@@ -0,0 +1,28 @@
+package cloudwatch
+
+import (
+	"time"
+
+	"github.com/gliderlabs/logspout/metrics"
+)
+
+// Rules for the timestamps of Cloudwatch Log events, from https://goo.gl/TrIN8c
+const (
+	maxEventAge  = 14 * 24 * time.Hour // older events are rejected
+	maxEventLead = 2 * time.Hour       // and those further in the future
+)
+
+// Values of TIME_RANGE, what is done with the lines whose time CloudWatch
+// does not accept
+const (
+	timeRangeClamp = "clamp" // timestamped with when they were received
+	timeRangeDrop  = "drop"  // not shipped
+)
+
+var outOfRangeCounter = metrics.NewCounter("logspout_cloudwatch_out_of_range_events_total",
+	"Events whose time CloudWatch does not accept, by whether they were clamped or dropped.", "action")
+
+// inTimeRange returns whether CloudWatch accepts an event of a time at now
+func inTimeRange(t, now time.Time) bool {
+	return !t.Before(now.Add(-maxEventAge)) && !t.After(now.Add(maxEventLead))
+}
@@ -0,0 +1,31 @@
+package cloudwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestEventTimeInRange(t *testing.T) {
+	host := time.Date(2020, 1, 15, 12, 0, 0, 0, time.UTC)
+	a := &Adapter{logTime: true, clock: newTestClock(host)}
+	for logged, expected := range map[time.Time]bool{
+		host.Add(-time.Hour):           true,
+		host.Add(-13 * 24 * time.Hour): true,
+		host.Add(-15 * 24 * time.Hour): false,
+		host.Add(time.Hour):            true,
+		host.Add(3 * time.Hour):        false,
+	} {
+		got, inRange := a.eventTime(&router.Message{Time: logged})
+		if inRange != expected {
+			t.Errorf("%s: expected in range %v", logged, expected)
+		}
+		if inRange && !got.Equal(logged) || !inRange && !got.Equal(host) {
+			t.Errorf("%s: expected the time of the line when in range, else that of the host, got %s", logged, got)
+		}
+	}
+	if got, inRange := a.eventTime(&router.Message{}); !inRange || !got.Equal(host) {
+		t.Errorf("expected lines without a time to be timestamped when received, got %s", got)
+	}
+}
@@ -1,23 +1,99 @@
 package cloudwatch
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"math/rand"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+
+	"github.com/gliderlabs/logspout/adapters/httpclient"
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/metrics"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/tracing"
+)
+
+// defaults for resubmitting batches that failed to upload
+const (
+	defaultRequeueAttempts = 3
+	defaultRequeueDelay    = 5 * time.Second
+	defaultRequeueMaxDelay = time.Minute
+	maxHeldBatches         = 100 // per stream, later batches are dead-lettered
+	requeueCheckInterval   = 250 * time.Millisecond
+	maxTokenAttempts       = 3 // submissions of a batch with a refreshed sequence token, as other writers may take it again
+)
+
+var (
+	deadLetterCounter = metrics.NewCounter("logspout_cloudwatch_dead_lettered_events_total",
+		"Events dropped after their batch could not be uploaded.")
+	shippedCounter = metrics.NewCounter("logspout_cloudwatch_shipped_events_total",
+		"Events uploaded to CloudWatch Logs.")
+	backlogAgeGauge = metrics.NewGauge("logspout_cloudwatch_backlog_age_seconds",
+		"Age of the oldest batch of each route waiting to be resubmitted, 0 if none.", "route")
 )
 
 // Uploader receieves CloudwatchBatches on its input channel,
 // and sends them on to the AWS Cloudwatch Logs endpoint.
 type Uploader struct {
 	Input    chan Batch
-	svc      *cloudwatchlogs.CloudWatchLogs
+	route    string // ID of the route, for metrics
+	svc      cloudwatchlogsiface.CloudWatchLogsAPI
 	tokens   map[string]string
 	debugSet bool
+	failing  int32      // set while batches can't be submitted, accessed atomically
+	dedup    *dedup     // remembers the lines shipped, if enabled
+	state    *stateFile // persists sequence tokens, if enabled
+	clock    *clock     // of the adapter, corrected by the skew from AWS
+
+	region     string                  // of logspout's account, and tenants without one
+	maxRetries int                     // of the clients of tenants
+	tenants    *tenants                // if a TENANT_FILE is set
+	clients    map[string]tenantClient // of the tenants shipped to, by name
+
+	requeueAttempts int                      // times a failed batch is resubmitted before it is dropped
+	requeueDelay    time.Duration            // wait before the first resubmission of a failed batch, doubled every attempt
+	requeueMaxDelay time.Duration            // longest wait between resubmissions
+	queues          map[string]*requeueQueue // streams with a failed batch, by container
+	flush           chan chan struct{}       // closed once no batch is waiting to be resubmitted
+	preparer        *streamPreparer          // fetches the tokens of new streams, nil to fetch them when submitting
+	preparing       map[string][]Batch       // batches of the streams being prepared, by container
+	flushed         []chan struct{}
+
+	expiry     time.Duration        // release tokens of containers idle for this long
+	lastUsed   map[string]time.Time // when the token of each container was last used
+	lastExpiry time.Time
+}
+
+// requeueQueue holds the batches of a stream from its oldest failed batch
+// on, so they are submitted in order: later batches wait until the failed
+// one succeeds or is dead-lettered.
+type requeueQueue struct {
+	batches  []Batch
+	attempts int // failed submissions of the first batch
+	next     time.Time
+}
+
+// tenantClient is the client of the account of a tenant, which is created
+// again if the tenant changes in the TENANT_FILE
+type tenantClient struct {
+	tenant tenant
+	svc    cloudwatchlogsiface.CloudWatchLogsAPI
 }
 
 // NewUploader creates and returns a new Uploader for the current EC2 Region
@@ -32,134 +108,629 @@ func NewUploader(adapter *Adapter) *Uploader {
 	}
 	debugSet := false
 	_, debugOption := adapter.Route.Options[`DEBUG`]
-	if debugOption || (os.Getenv(`DEBUG`) != "") {
+	if debugOption || (cfg.GetString(`DEBUG`) != "") {
 		debugSet = true
 		log.Println("cloudwatch: Creating AWS Cloudwatch client for region",
 			region)
 	}
-	awsLogLevel := aws.LogOff
-	if debugSet {
-		awsLogLevel = aws.LogDebugWithRequestRetries
+	requeueAttempts := defaultRequeueAttempts
+	if text := getOption(adapter.Route, `REQUEUE_ATTEMPTS`, ""); text != "" {
+		attempts, err := strconv.Atoi(text)
+		if err != nil || attempts < 0 {
+			log.Printf("WARNING: ERROR parsing REQUEUE_ATTEMPTS %s, using default of %d\n",
+				text, defaultRequeueAttempts)
+		} else {
+			requeueAttempts = attempts
+		}
 	}
 	uploader := Uploader{
-		Input:    make(chan Batch),
-		tokens:   map[string]string{},
-		debugSet: debugSet,
-		svc: cloudwatchlogs.New(session.New(),
-			&aws.Config{
-				Region:     aws.String(region),
-				MaxRetries: &adapter.maxRetries,
-				LogLevel:   &awsLogLevel,
-			}),
+		Input:           make(chan Batch),
+		flush:           make(chan chan struct{}),
+		route:           adapter.Route.ID,
+		tokens:          map[string]string{},
+		debugSet:        debugSet,
+		dedup:           adapter.dedup,
+		state:           adapter.state,
+		clock:           adapter.clock,
+		requeueAttempts: requeueAttempts,
+		requeueDelay:    getDurationOption(adapter.Route, `REQUEUE_DELAY`, defaultRequeueDelay),
+		requeueMaxDelay: getDurationOption(adapter.Route, `REQUEUE_MAX_DELAY`, defaultRequeueMaxDelay),
+		queues:          map[string]*requeueQueue{},
+		preparing:       map[string][]Batch{},
+		expiry:          adapter.expiry,
+		lastUsed:        map[string]time.Time{},
+		region:          region,
+		maxRetries:      adapter.maxRetries,
+		tenants:         adapter.tenants,
+		clients:         map[string]tenantClient{},
 	}
+	uploader.svc = uploader.newClient(session.New(), region, nil)
+	uploader.preparer = newStreamPreparer(&uploader, adapter.Route)
 	go uploader.Start()
 	return &uploader
 }
 
+// newClient returns a CloudWatch Logs client for a region, with the given
+// credentials or those of logspout if nil
+func (u *Uploader) newClient(sess *session.Session, region string, creds *credentials.Credentials) cloudwatchlogsiface.CloudWatchLogsAPI {
+	if creds == nil {
+		creds = httpclient.AWSCredentials()
+	}
+	awsLogLevel := aws.LogOff
+	if u.debugSet {
+		awsLogLevel = aws.LogDebugWithRequestRetries
+	}
+	svc := cloudwatchlogs.New(sess,
+		&aws.Config{
+			Region:      aws.String(region),
+			Credentials: creds,
+			MaxRetries:  aws.Int(u.maxRetries),
+			LogLevel:    &awsLogLevel,
+			HTTPClient:  httpclient.Client(),
+		})
+	svc.Handlers.Complete.PushBack(traceRequest)
+	if u.clock != nil {
+		svc.Handlers.Complete.PushBack(u.clock.observeRequest)
+	}
+	return svc
+}
+
+// traceRequest records an AWS request as a span, when made in the context
+// of one
+func traceRequest(r *request.Request) {
+	parent := tracing.FromContext(r.Context())
+	if parent == nil {
+		return
+	}
+	span := tracing.StartAt(parent, "aws."+r.Operation.Name, r.Time).Set("aws.retries", r.RetryCount)
+	if r.HTTPResponse != nil {
+		span.Set("http.status_code", r.HTTPResponse.StatusCode)
+	}
+	span.Fail(r.Error)
+	span.End()
+}
+
+// client returns the client of the account a message is shipped to, that
+// of its tenant, assuming the role of the tenant, or that of logspout
+func (u *Uploader) client(msg Message) (cloudwatchlogsiface.CloudWatchLogsAPI, error) {
+	if msg.Tenant == "" || u.tenants == nil {
+		return u.svc, nil
+	}
+	t, exists := u.tenants.get(msg.Tenant)
+	if !exists {
+		return nil, fmt.Errorf("tenant %s is no longer in the TENANT_FILE", msg.Tenant)
+	}
+	if cached, isCached := u.clients[msg.Tenant]; isCached && cached.tenant == t {
+		return cached.svc, nil
+	}
+	region := t.Region
+	if region == "" {
+		region = u.region
+	}
+	u.log("Creating AWS Cloudwatch client for tenant %s, role %s, region %s", msg.Tenant, t.RoleARN, region)
+	sess := session.New(httpclient.AWSConfig())
+	creds := stscreds.NewCredentials(sess, t.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "logspout"
+		if t.ExternalID != "" {
+			p.ExternalID = aws.String(t.ExternalID)
+		}
+	})
+	svc := u.newClient(sess, region, creds)
+	u.clients[msg.Tenant] = tenantClient{tenant: t, svc: svc}
+	return svc, nil
+}
+
 // Start begins the ain loop for the Uploader- POSTs each batch to AWS Cloudwatch
 // Logs, while keeping track of the unique sequence token for each log stream.
+// Failed batches are resubmitted before any later batch of their stream.
 func (u *Uploader) Start() {
-	for batch := range u.Input {
-		if len(batch.Msgs) > 0 {
-			msg := batch.Msgs[0]
-			u.log("Submitting batch for %s-%s (length %d, size %v)",
-				msg.Group, msg.Stream, len(batch.Msgs), batch.Size)
-
-			// fetch and cache the upload sequence token
-			var token *string
-			if cachedToken, isCached := u.tokens[msg.Container]; isCached {
-				token = &cachedToken
-				u.log("Got token from cache: %s", *token)
-			} else {
-				u.log("Fetching token from AWS...")
-				awsToken, err := u.getSequenceToken(msg)
-				if err != nil {
-					u.log("ERROR: %s", err)
-					continue
-				}
-				if awsToken != nil {
-					u.tokens[msg.Container] = *(awsToken)
-					u.log("Got token from AWS: %s", *awsToken)
-					token = awsToken
-				}
+	ticker := time.NewTicker(requeueCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch, ok := <-u.Input:
+			if !ok {
+				return
 			}
-
-			// generate the array of InputLogEvent from the batch's contents
-			events := []*cloudwatchlogs.InputLogEvent{}
-			for _, msg := range batch.Msgs {
-				event := cloudwatchlogs.InputLogEvent{
-					Message:   aws.String(msg.Message),
-					Timestamp: aws.Int64(msg.Time.UnixNano() / 1000000),
-				}
-				events = append(events, &event)
+			if len(batch.Msgs) == 0 {
+				break
 			}
-			params := &cloudwatchlogs.PutLogEventsInput{
-				LogEvents:     events,
-				LogGroupName:  aws.String(msg.Group),
-				LogStreamName: aws.String(msg.Stream),
-				SequenceToken: token,
+			container := batch.Msgs[0].batchKey()
+			if u.prepare(container, batch) {
+				break
 			}
+			u.upload(container, batch)
+		case prepared := <-u.preparedStreams():
+			u.prepared(prepared)
+		case done := <-u.flush:
+			u.flushed = append(u.flushed, done)
+			u.finishFlush()
+		case now := <-ticker.C:
+			u.resubmit(now)
+			u.expireTokens(now)
+			u.measureBacklog(now)
+			u.finishFlush()
+		}
+	}
+}
 
-			u.log("POSTing PutLogEvents to %s-%s with %d messages, %d bytes",
-				msg.Group, msg.Stream, len(batch.Msgs), batch.Size)
-			resp, err := u.svc.PutLogEvents(params)
-			if err != nil {
-				u.log(err.Error())
-				u.log("Dropping %d messages", len(events))
+// upload submits a batch, or holds it behind the failed batch of its stream
+func (u *Uploader) upload(container string, batch Batch) {
+	if queue, held := u.queues[container]; held {
+		u.hold(queue, batch)
+		return
+	}
+	if err := u.submit(batch); err != nil {
+		u.failed(container, batch, err)
+		return
+	}
+	batch.release()
+}
+
+// failed dead-letters a batch that failed with a fatal error, or requeues
+// it, behind the failed batch of its stream if any
+func (u *Uploader) failed(container string, batch Batch, err error) {
+	if router.Classify(err) == router.ErrorFatal {
+		u.deadLetter(batch, "failed: "+err.Error())
+	} else if queue, held := u.queues[container]; held {
+		u.hold(queue, batch)
+	} else {
+		u.requeue(container, batch, time.Now())
+	}
+}
+
+// prepare holds a batch while the token of its stream is fetched by the
+// preparer, if not known yet, and returns whether it did
+func (u *Uploader) prepare(container string, batch Batch) bool {
+	if u.preparer == nil {
+		return false
+	}
+	if batches, preparing := u.preparing[container]; preparing {
+		if len(batches) >= maxHeldBatches {
+			u.deadLetter(batch, "too many batches are waiting for the stream")
+		} else {
+			u.preparing[container] = append(batches, batch)
+		}
+		return true
+	}
+	msg := batch.Msgs[0]
+	if _, known := u.knownToken(msg); known {
+		return false
+	}
+	svc, err := u.client(msg)
+	if err != nil {
+		return false // failing again when submitted
+	}
+	u.preparing[container] = []Batch{batch}
+	u.preparer.add(msg, svc)
+	return true
+}
+
+// preparedStreams returns the channel of the streams prepared, nil if the
+// tokens of streams are fetched when submitting
+func (u *Uploader) preparedStreams() chan preparedStream {
+	if u.preparer == nil {
+		return nil
+	}
+	return u.preparer.prepared
+}
+
+// prepared submits the batches that were held while the token of their
+// stream was fetched, or handles them as failed if it could not be
+func (u *Uploader) prepared(p preparedStream) {
+	container := p.msg.batchKey()
+	batches := u.preparing[container]
+	delete(u.preparing, container)
+	if p.err != nil {
+		u.log("ERROR: %s", p.err)
+		atomic.StoreInt32(&u.failing, 1)
+	} else {
+		u.setToken(p.msg, aws.StringValue(p.token))
+	}
+	for _, batch := range batches {
+		if p.err != nil {
+			u.failed(container, batch, p.err)
+		} else {
+			u.upload(container, batch)
+		}
+	}
+}
+
+// finishFlush closes the channels of flushes once every batch was uploaded
+// or dead-lettered
+func (u *Uploader) finishFlush() {
+	if len(u.queues) > 0 || len(u.preparing) > 0 {
+		return
+	}
+	for _, done := range u.flushed {
+		close(done)
+	}
+	u.flushed = nil
+}
+
+// requeue starts holding the batches of a stream, from a failed batch on
+func (u *Uploader) requeue(container string, batch Batch, now time.Time) {
+	queue := &requeueQueue{batches: []Batch{batch}, attempts: 1}
+	if !u.retryOrDrop(queue, now) {
+		return
+	}
+	u.queues[container] = queue
+}
+
+// hold queues a batch behind the failed batch of its stream
+func (u *Uploader) hold(queue *requeueQueue, batch Batch) {
+	if len(queue.batches) >= maxHeldBatches {
+		u.deadLetter(batch, "too many batches are waiting for the stream")
+		return
+	}
+	queue.batches = append(queue.batches, batch)
+}
+
+// resubmit submits the held batches of each stream that is due, in order,
+// until one of them fails again
+func (u *Uploader) resubmit(now time.Time) {
+	for container, queue := range u.queues {
+		if now.Before(queue.next) {
+			continue
+		}
+		for len(queue.batches) > 0 {
+			err := u.submit(queue.batches[0])
+			if err != nil && router.Classify(err) == router.ErrorFatal {
+				u.deadLetter(queue.batches[0], "failed: "+err.Error())
+				queue.batches = queue.batches[1:]
+				queue.attempts = 0
 				continue
 			}
-			u.log("Got 200 response")
-			if resp.NextSequenceToken != nil {
-				u.log("Caching new sequence token for %s-%s: %s",
-					msg.Group, msg.Stream, *resp.NextSequenceToken)
-				u.tokens[msg.Container] = *resp.NextSequenceToken
+			if err != nil {
+				queue.attempts++
+				u.retryOrDrop(queue, now)
+				break
 			}
+			queue.batches[0].release()
+			queue.batches = queue.batches[1:]
+			queue.attempts = 0
+		}
+		if len(queue.batches) == 0 {
+			delete(u.queues, container)
 		}
 	}
 }
 
+// measureBacklog sets the age of the oldest batch waiting to be resubmitted
+func (u *Uploader) measureBacklog(now time.Time) {
+	var age time.Duration
+	for _, queue := range u.queues {
+		if oldest := now.Sub(queue.batches[0].Created); oldest > age {
+			age = oldest
+		}
+	}
+	backlogAgeGauge.With(u.route).Set(age.Seconds())
+}
+
+// retryOrDrop schedules the next submission of the first batch of a queue,
+// or dead-letters it once it ran out of attempts. It returns false if the
+// queue is empty.
+func (u *Uploader) retryOrDrop(queue *requeueQueue, now time.Time) bool {
+	if queue.attempts > u.requeueAttempts {
+		u.deadLetter(queue.batches[0], fmt.Sprintf("failed %d times", queue.attempts))
+		queue.batches = queue.batches[1:]
+		queue.attempts = 0
+	}
+	queue.next = now.Add(u.backoff(queue.attempts))
+	return len(queue.batches) > 0
+}
+
+// backoff returns the wait before the next submission of a batch that
+// failed a number of times: the REQUEUE_DELAY doubled for every attempt
+// after the first, up to the REQUEUE_MAX_DELAY, of which a random part up
+// to half is waited less, for the streams failing together not to be
+// resubmitted together
+func (u *Uploader) backoff(attempts int) time.Duration {
+	delay, max := u.requeueDelay, u.requeueMaxDelay
+	if max < delay {
+		max = delay
+	}
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 1 {
+		return delay
+	}
+	return delay - time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (u *Uploader) deadLetter(batch Batch, reason string) {
+	msg := batch.Msgs[0]
+	log.Printf("cloudwatch: dropping %d messages for %s-%s, batch %s\n",
+		len(batch.Msgs), msg.Group, msg.Stream, reason)
+	deadLetterCounter.With().Add(float64(len(batch.Msgs)))
+	var lines []*router.Message
+	for _, msg := range batch.Msgs {
+		if msg.Origin != nil && (len(lines) == 0 || lines[len(lines)-1] != msg.Origin) { // once for the parts of a split line
+			lines = append(lines, msg.Origin)
+		}
+	}
+	router.WriteDeadLetters(u.route, reason, lines...)
+	batch.release()
+}
+
+// submit POSTs a batch, fetching the sequence token of its stream as needed
+func (u *Uploader) submit(batch Batch) (err error) {
+	msg := batch.Msgs[0]
+	u.log("Submitting batch for %s-%s (length %d, size %v)",
+		msg.Group, msg.Stream, len(batch.Msgs), batch.Size)
+	// the span of the batch shows how long it was batched before submitting
+	span := tracing.StartAt(nil, "cloudwatch.batch", batch.Created).
+		Set("group", msg.Group).Set("stream", msg.Stream).Set("tenant", msg.Tenant).
+		Set("events", len(batch.Msgs)).Set("bytes", batch.Size)
+	submitted := tracing.Start(span, "cloudwatch.submit")
+	ctx := tracing.ContextWith(aws.BackgroundContext(), submitted)
+	defer func() {
+		submitted.Fail(err)
+		submitted.End()
+		span.Fail(err)
+		span.End()
+	}()
+
+	svc, err := u.client(msg)
+	if err != nil {
+		u.log("ERROR: %s", err)
+		atomic.StoreInt32(&u.failing, 1)
+		return err
+	}
+	token, err := u.token(ctx, svc, msg)
+	if err != nil {
+		u.log("ERROR: %s", err)
+		atomic.StoreInt32(&u.failing, 1)
+		return err
+	}
+
+	// generate the array of InputLogEvent from the batch's contents
+	buffer := newLogEvents(batch)
+	defer buffer.release()
+	events := buffer.pointers
+	// in order, as CloudWatch requires, should lines be timestamped by LOG_TIME
+	sort.SliceStable(events, func(i, j int) bool { return *events[i].Timestamp < *events[j].Timestamp })
+	params := &cloudwatchlogs.PutLogEventsInput{
+		LogEvents:     events,
+		LogGroupName:  aws.String(msg.Group),
+		LogStreamName: aws.String(msg.Stream),
+		SequenceToken: token,
+	}
+
+	u.log("POSTing PutLogEvents to %s-%s with %d messages, %d bytes",
+		msg.Group, msg.Stream, len(batch.Msgs), batch.Size)
+	resp, err := svc.PutLogEventsWithContext(ctx, params,
+		withRetries(msg.Retries))
+	for attempt := 0; err != nil && router.Classify(err) == router.ErrorRecoverable && attempt < maxTokenAttempts; attempt++ {
+		// the saved token went out of date, as another writer took it, or
+		// the group or stream was deleted: use the token AWS expected, or
+		// fetch it, creating them as needed, and try again
+		u.log("Sequence token is out of date, or %s-%s was deleted: %s", msg.Group, msg.Stream, err)
+		if expected := expectedToken(err); expected != nil {
+			params.SequenceToken = expected
+		} else {
+			u.forgetToken(msg)
+			if params.SequenceToken, err = u.token(ctx, svc, msg); err != nil {
+				break
+			}
+		}
+		resp, err = svc.PutLogEventsWithContext(ctx, params,
+			withRetries(msg.Retries))
+	}
+	if isErrorCode(err, cloudwatchlogs.ErrCodeDataAlreadyAcceptedException) {
+		// an earlier submission got through, but its response did not
+		u.log("Batch was already accepted")
+		u.forgetToken(msg)
+		if expected := expectedToken(err); expected != nil {
+			u.setToken(msg, *expected)
+		}
+		err = nil
+	} else if err != nil {
+		u.log(err.Error())
+		// the token may be out of date, fetch it again with the next attempt
+		u.forgetToken(msg)
+		atomic.StoreInt32(&u.failing, 1)
+		return err
+	}
+	u.log("Got 200 response")
+	if rejectedForTime(resp) {
+		u.log("Events of %s-%s were rejected for their time: %s", msg.Group, msg.Stream, resp.RejectedLogEventsInfo)
+		u.clock.correct()
+	}
+	atomic.StoreInt32(&u.failing, 0)
+	u.used(msg.batchKey(), time.Now())
+	shippedCounter.With().Add(float64(len(batch.Msgs)))
+	shippedCosts.record(batch)
+	batch.acknowledge()
+	if u.dedup != nil {
+		u.dedup.record(batch)
+	}
+	if resp != nil && resp.NextSequenceToken != nil {
+		u.log("Caching new sequence token for %s-%s: %s",
+			msg.Group, msg.Stream, *resp.NextSequenceToken)
+		u.setToken(msg, *resp.NextSequenceToken)
+	}
+	return nil
+}
+
+// logEvents holds the events of a PutLogEvents request, which are reused
+// between requests rather than allocated for every message
+type logEvents struct {
+	events     []cloudwatchlogs.InputLogEvent
+	pointers   []*cloudwatchlogs.InputLogEvent
+	timestamps []int64
+}
+
+var logEventsPool = sync.Pool{New: func() interface{} { return &logEvents{} }}
+
+// newLogEvents returns the events of the messages of a batch, pointing to
+// them, for as long as the batch is not released
+func newLogEvents(batch Batch) *logEvents {
+	e := logEventsPool.Get().(*logEvents)
+	n := len(batch.Msgs)
+	if cap(e.events) < n {
+		e.events = make([]cloudwatchlogs.InputLogEvent, n)
+		e.pointers = make([]*cloudwatchlogs.InputLogEvent, n)
+		e.timestamps = make([]int64, n)
+	}
+	e.events, e.pointers, e.timestamps = e.events[:n], e.pointers[:n], e.timestamps[:n]
+	for i := range batch.Msgs {
+		e.timestamps[i] = batch.Msgs[i].Time.UnixNano() / 1000000
+		e.events[i] = cloudwatchlogs.InputLogEvent{Message: &batch.Msgs[i].Message, Timestamp: &e.timestamps[i]}
+		e.pointers[i] = &e.events[i]
+	}
+	return e
+}
+
+// release returns the events to the pool once the request is done
+func (e *logEvents) release() {
+	for i := range e.events {
+		e.events[i], e.pointers[i] = cloudwatchlogs.InputLogEvent{}, nil
+	}
+	logEventsPool.Put(e)
+}
+
+// token returns the upload sequence token of the stream of a message, from
+// the cache, the STATE_FILE, or AWS, and caches it
+func (u *Uploader) token(ctx aws.Context, svc cloudwatchlogsiface.CloudWatchLogsAPI, msg Message) (*string, error) {
+	if token, known := u.knownToken(msg); known {
+		u.log("Got token from cache or STATE_FILE: %s", aws.StringValue(token))
+		return token, nil
+	}
+	u.log("Fetching token from AWS...")
+	awsToken, err := u.getSequenceToken(ctx, svc, msg)
+	if err != nil {
+		return nil, err
+	}
+	if awsToken != nil {
+		u.log("Got token from AWS: %s", *awsToken)
+		u.setToken(msg, *awsToken)
+	}
+	return awsToken, nil
+}
+
+// knownToken returns the sequence token of the stream of a message from
+// the cache or the STATE_FILE, nil for a stream prepared that has none yet,
+// and whether it is known
+func (u *Uploader) knownToken(msg Message) (*string, bool) {
+	if cachedToken, isCached := u.tokens[msg.batchKey()]; isCached {
+		if cachedToken == "" {
+			return nil, true
+		}
+		return &cachedToken, true
+	}
+	if u.state != nil {
+		if saved := u.state.stream(streamKey(msg.Group, msg.Stream)); saved != nil && saved.Token != "" {
+			u.tokens[msg.batchKey()] = saved.Token
+			return &saved.Token, true
+		}
+	}
+	return nil, false
+}
+
+// setToken caches the sequence token of a stream, "" if it has none yet,
+// and persists it
+func (u *Uploader) setToken(msg Message, token string) {
+	u.tokens[msg.batchKey()] = token
+	if u.state != nil {
+		u.state.update(streamKey(msg.Group, msg.Stream), func(state *streamState) {
+			state.Token = token
+		})
+	}
+}
+
+// forgetToken removes the sequence token of a stream, so it is fetched again
+func (u *Uploader) forgetToken(msg Message) {
+	delete(u.tokens, msg.batchKey())
+	if u.state != nil {
+		u.state.update(streamKey(msg.Group, msg.Stream), func(state *streamState) {
+			state.Token = ""
+		})
+	}
+}
+
+// expectedToken returns the sequence token CloudWatch expected instead of
+// that of a request it refused, or that accepted the batch already, if any
+func expectedToken(err error) *string {
+	var invalid *cloudwatchlogs.InvalidSequenceTokenException
+	if errors.As(err, &invalid) {
+		return invalid.ExpectedSequenceToken
+	}
+	var accepted *cloudwatchlogs.DataAlreadyAcceptedException
+	if errors.As(err, &accepted) {
+		return accepted.ExpectedSequenceToken
+	}
+	return nil
+}
+
+// isErrorCode returns whether err is an AWS error with the given code
+func isErrorCode(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == code
+}
+
+// Healthy returns false if the last batch could not be submitted
+func (u *Uploader) Healthy() bool {
+	return atomic.LoadInt32(&u.failing) == 0
+}
+
 // AWS CLIENT METHODS
 
 // returns the next sequence token for the log stream associated
 // with the given message's group and stream. Creates the stream as needed.
-func (u *Uploader) getSequenceToken(msg Message) (*string, error) {
-	group, stream := msg.Group, msg.Stream
-	groupExists, err := u.groupExists(group)
-	if err != nil {
+func (u *Uploader) getSequenceToken(ctx aws.Context, svc cloudwatchlogsiface.CloudWatchLogsAPI, msg Message) (*string, error) {
+	if err := u.ensureGroup(ctx, svc, msg.Group); err != nil {
 		return nil, err
 	}
-	if !groupExists {
-		err = u.createGroup(group)
-		if err != nil {
-			return nil, err
-		}
+	return u.streamToken(ctx, svc, msg)
+}
+
+// ensureGroup creates a log group unless it exists
+func (u *Uploader) ensureGroup(ctx aws.Context, svc cloudwatchlogsiface.CloudWatchLogsAPI, group string) error {
+	groupExists, err := u.groupExists(ctx, svc, group)
+	if err != nil || groupExists {
+		return err
 	}
+	return u.createGroup(ctx, svc, group)
+}
+
+// streamToken returns the next sequence token of the log stream of a
+// message, of a group that exists, creating the stream as needed
+func (u *Uploader) streamToken(ctx aws.Context, svc cloudwatchlogsiface.CloudWatchLogsAPI, msg Message) (*string, error) {
+	group, stream := msg.Group, msg.Stream
 	params := &cloudwatchlogs.DescribeLogStreamsInput{
 		LogGroupName:        aws.String(group),
 		LogStreamNamePrefix: aws.String(stream),
 	}
 	u.log("Describing stream %s-%s...", group, stream)
-	resp, err := u.svc.DescribeLogStreams(params)
-	if err != nil {
-		return nil, err
-	}
-	if count := len(resp.LogStreams); count > 1 { // too many matching streams!
-		return nil, fmt.Errorf(
-			"%d streams match group %s, stream %s", count, group, stream)
-	}
-	if len(resp.LogStreams) == 0 { // no matching streams - create one and retry
-		if err = u.createStream(group, stream); err != nil {
+	for {
+		resp, err := svc.DescribeLogStreamsWithContext(ctx, params)
+		if err != nil {
 			return nil, err
 		}
-		token, err := u.getSequenceToken(msg)
-		return token, err
+		// the prefix also matches other streams, like shards or the
+		// -starting stream of the same container
+		for _, matched := range resp.LogStreams {
+			if aws.StringValue(matched.LogStreamName) == stream {
+				return matched.UploadSequenceToken, nil
+			}
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			break
+		}
+		params.NextToken = resp.NextToken
 	}
-	return resp.LogStreams[0].UploadSequenceToken, nil
+	// no stream of that name - create one, which has no token yet
+	return nil, u.createStream(ctx, svc, group, stream)
 }
 
-func (u *Uploader) groupExists(group string) (bool, error) {
+func (u *Uploader) groupExists(ctx aws.Context, svc cloudwatchlogsiface.CloudWatchLogsAPI, group string) (bool, error) {
 	u.log("Checking for group: %s...", group)
-	resp, err := u.svc.DescribeLogGroups(&cloudwatchlogs.DescribeLogGroupsInput{
+	resp, err := svc.DescribeLogGroupsWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{
 		LogGroupNamePrefix: aws.String(group),
 	})
 	if err != nil {
@@ -173,24 +744,24 @@ func (u *Uploader) groupExists(group string) (bool, error) {
 	return false, nil
 }
 
-func (u *Uploader) createGroup(group string) error {
+func (u *Uploader) createGroup(ctx aws.Context, svc cloudwatchlogsiface.CloudWatchLogsAPI, group string) error {
 	u.log("Creating group: %s...", group)
 	params := &cloudwatchlogs.CreateLogGroupInput{
 		LogGroupName: aws.String(group),
 	}
-	if _, err := u.svc.CreateLogGroup(params); err != nil {
+	if _, err := svc.CreateLogGroupWithContext(ctx, params); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (u *Uploader) createStream(group, stream string) error {
+func (u *Uploader) createStream(ctx aws.Context, svc cloudwatchlogsiface.CloudWatchLogsAPI, group, stream string) error {
 	u.log("Creating stream for group %s, stream %s...", group, stream)
 	params := &cloudwatchlogs.CreateLogStreamInput{
 		LogGroupName:  aws.String(group),
 		LogStreamName: aws.String(stream),
 	}
-	if _, err := u.svc.CreateLogStream(params); err != nil {
+	if _, err := svc.CreateLogStreamWithContext(ctx, params); err != nil {
 		return err
 	}
 	return nil
@@ -198,6 +769,14 @@ func (u *Uploader) createStream(group, stream string) error {
 
 // HELPER METHODS
 
+// withRetries sets the number of times a request is retried, which
+// depends on the QoS class of the container a batch came from
+func withRetries(retries int) request.Option {
+	return func(r *request.Request) {
+		r.Retryer = client.DefaultRetryer{NumMaxRetries: retries}
+	}
+}
+
 func (u *Uploader) log(format string, args ...interface{}) {
 	if u.debugSet {
 		msg := fmt.Sprintf(format, args...)
@@ -0,0 +1,290 @@
+package cloudwatch
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+)
+
+// fakeLogs fails PutLogEvents calls for a batch the given number of times,
+// by its first message, and sends the first message of each accepted batch
+// on uploaded. When token is set, calls with another sequence token fail,
+// telling the token expected when expects is set. DescribeLogStreams
+// returns the streams whose name starts with the prefix, like AWS: the
+// stream asked for unless noStream, and those of streams, page at a time.
+type fakeLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	failures  map[string]int
+	denied    map[string]int // attempts to upload messages refused as fatal
+	uploaded  chan string
+	token     string
+	expects   bool
+	describes int
+	deleted   bool     // the log group was deleted
+	noStream  bool     // the log stream was deleted
+	streams   []string // other streams of the group, described by prefix
+	page      int      // streams described per page, if set
+}
+
+func (f *fakeLogs) DescribeLogGroupsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	if f.deleted {
+		return &cloudwatchlogs.DescribeLogGroupsOutput{}, nil
+	}
+	return &cloudwatchlogs.DescribeLogGroupsOutput{
+		LogGroups: []*cloudwatchlogs.LogGroup{{LogGroupName: input.LogGroupNamePrefix}},
+	}, nil
+}
+
+func (f *fakeLogs) DescribeLogStreamsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	f.describes++
+	prefix := aws.StringValue(input.LogStreamNamePrefix)
+	var names []string
+	if !f.noStream {
+		names = append(names, prefix) // the stream asked for exists
+	}
+	for _, name := range f.streams {
+		if strings.HasPrefix(name, prefix) && (name != prefix || f.noStream) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	start, _ := strconv.Atoi(aws.StringValue(input.NextToken))
+	end := len(names)
+	if f.page > 0 && start+f.page < end {
+		end = start + f.page
+	}
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{}
+	for _, name := range names[start:end] {
+		stream := &cloudwatchlogs.LogStream{LogStreamName: aws.String(name)}
+		if f.token != "" {
+			stream.UploadSequenceToken = aws.String(f.token)
+		}
+		out.LogStreams = append(out.LogStreams, stream)
+	}
+	if end < len(names) {
+		out.NextToken = aws.String(strconv.Itoa(end))
+	}
+	return out, nil
+}
+
+func (f *fakeLogs) CreateLogGroupWithContext(ctx aws.Context, input *cloudwatchlogs.CreateLogGroupInput, opts ...request.Option) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	f.deleted = false
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func (f *fakeLogs) CreateLogStreamWithContext(ctx aws.Context, input *cloudwatchlogs.CreateLogStreamInput, opts ...request.Option) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	f.noStream = false
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+func (f *fakeLogs) PutLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.PutLogEventsInput, opts ...request.Option) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	if f.deleted || f.noStream {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "does not exist", nil)
+	}
+	message := *input.LogEvents[0].Message
+	if f.token != "" && aws.StringValue(input.SequenceToken) != f.token {
+		if f.expects {
+			return nil, &cloudwatchlogs.InvalidSequenceTokenException{ExpectedSequenceToken: aws.String(f.token)}
+		}
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidSequenceTokenException, "invalid token", nil)
+	}
+	if count, denied := f.denied[message]; denied {
+		f.denied[message] = count + 1
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "invalid event", nil)
+	}
+	if f.failures[message] > 0 {
+		f.failures[message]--
+		return nil, errors.New("service unavailable")
+	}
+	f.uploaded <- message
+	return &cloudwatchlogs.PutLogEventsOutput{NextSequenceToken: aws.String("next")}, nil
+}
+
+func newTestUploader(failures map[string]int, attempts int) (*Uploader, chan string) {
+	uploaded := make(chan string, 10)
+	u := &Uploader{
+		Input:           make(chan Batch),
+		flush:           make(chan chan struct{}),
+		svc:             &fakeLogs{failures: failures, uploaded: uploaded},
+		tokens:          map[string]string{},
+		requeueAttempts: attempts,
+		requeueDelay:    10 * time.Millisecond,
+		queues:          map[string]*requeueQueue{},
+	}
+	go u.Start()
+	return u, uploaded
+}
+
+func testBatch(message string) Batch {
+	return Batch{Msgs: []Message{{Message: message, Group: "group", Stream: "stream", Container: "app"}}}
+}
+
+func expectUploads(t *testing.T, uploaded chan string, expected ...string) {
+	t.Helper()
+	for _, message := range expected {
+		select {
+		case got := <-uploaded:
+			if got != message {
+				t.Errorf("expected %s to be uploaded, got %s", message, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected %s to be uploaded", message)
+		}
+	}
+}
+
+func TestUploaderRequeueKeepsStreamOrder(t *testing.T) {
+	u, uploaded := newTestUploader(map[string]int{"first": 2}, 3)
+	u.Input <- testBatch("first")
+	u.Input <- testBatch("second")
+	other := testBatch("other")
+	other.Msgs[0].Container = "other"
+	u.Input <- other
+	// other streams are not held up by the failed batch
+	expectUploads(t, uploaded, "other", "first", "second")
+}
+
+func TestUploaderDeadLettersAfterAttempts(t *testing.T) {
+	u, uploaded := newTestUploader(map[string]int{"first": 2}, 1)
+	u.Input <- testBatch("first")
+	u.Input <- testBatch("second")
+	expectUploads(t, uploaded, "second")
+}
+
+func TestUploaderDeadLettersFatalErrors(t *testing.T) {
+	u, uploaded := newTestUploader(map[string]int{}, 3)
+	logs := u.svc.(*fakeLogs)
+	logs.denied = map[string]int{"first": 0}
+	u.Input <- testBatch("first")
+	u.Input <- testBatch("second")
+	expectUploads(t, uploaded, "second")
+	if attempts := logs.denied["first"]; attempts != 1 {
+		t.Errorf("expected a batch refused as invalid to be dropped without resubmitting it, got %d attempts", attempts)
+	}
+}
+
+func TestUploaderBacksOffExponentially(t *testing.T) {
+	u := &Uploader{requeueDelay: time.Second, requeueMaxDelay: 10 * time.Second}
+	for attempts, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 50: 10 * time.Second} {
+		for i := 0; i < 10; i++ {
+			if delay := u.backoff(attempts); delay < expected/2 || delay > expected {
+				t.Errorf("expected a delay of %s to %s after %d attempts, got %s", expected/2, expected, attempts, delay)
+			}
+		}
+	}
+	u.requeueMaxDelay = 0 // below the first delay, which is then fixed
+	if delay := u.backoff(3); delay < time.Second/2 || delay > time.Second {
+		t.Errorf("expected a delay of at most the REQUEUE_DELAY, got %s", delay)
+	}
+}
+
+func TestUploaderFlushWaitsForRequeuedBatches(t *testing.T) {
+	u, uploaded := newTestUploader(map[string]int{"first": 1}, 2)
+	u.Input <- testBatch("first")
+	done := make(chan struct{})
+	u.flush <- done
+	select {
+	case <-done:
+		t.Fatal("expected the flush to wait for the failed batch")
+	default:
+	}
+	expectUploads(t, uploaded, "first")
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the flush to finish once the batch was uploaded")
+	}
+}
+
+func TestUploaderSavedTokens(t *testing.T) {
+	for saved, describes := range map[string]int{"current": 0, "stale": 1} {
+		state := &stateFile{streams: map[string]*streamState{
+			streamKey("group", "stream"): {Token: saved},
+		}}
+		u, uploaded := newTestUploader(nil, 0)
+		fake := u.svc.(*fakeLogs)
+		fake.token = "current"
+		u.state = state
+		u.Input <- testBatch("first")
+		expectUploads(t, uploaded, "first")
+		if fake.describes != describes {
+			t.Errorf("expected %d DescribeLogStreams calls with a %s token, got %d", describes, saved, fake.describes)
+		}
+		if token := state.stream(streamKey("group", "stream")).Token; token != "next" {
+			t.Errorf("expected the next token to be saved, got %q", token)
+		}
+	}
+}
+
+func TestUploaderUsesExpectedToken(t *testing.T) {
+	u, uploaded := newTestUploader(nil, 0)
+	fake := u.svc.(*fakeLogs)
+	fake.token, fake.expects = "current", true
+	u.tokens["app"] = "stale" // another writer took it
+	u.Input <- testBatch("first")
+	expectUploads(t, uploaded, "first")
+	if fake.describes != 0 {
+		t.Errorf("expected the token AWS expected to be used without describing the stream, got %d calls", fake.describes)
+	}
+}
+
+func TestUploaderRecreatesDeletedStream(t *testing.T) {
+	u, uploaded := newTestUploader(nil, 0)
+	u.Input <- testBatch("first")
+	expectUploads(t, uploaded, "first")
+	// the group and stream are deleted, while their token is still cached
+	fake := u.svc.(*fakeLogs)
+	fake.deleted, fake.noStream = true, true
+	u.Input <- testBatch("second")
+	expectUploads(t, uploaded, "second")
+	if fake.deleted || fake.noStream {
+		t.Error("expected the group and stream to be recreated")
+	}
+}
+
+func TestGetSequenceTokenMatchesExactName(t *testing.T) {
+	u := &Uploader{tokens: map[string]string{}}
+	msg := Message{Group: "group", Stream: "app"}
+	// only the stream of the app's lines while starting exists
+	fake := &fakeLogs{token: "next", noStream: true, streams: []string{"app-starting"}}
+	token, err := u.getSequenceToken(aws.BackgroundContext(), fake, msg)
+	if err != nil || token != nil || fake.noStream {
+		t.Errorf("expected the stream to be created, got %v, %v", aws.StringValue(token), err)
+	}
+	// both exist, on several pages
+	fake = &fakeLogs{token: "next", streams: []string{"app-starting", "app-other"}, page: 1}
+	token, err = u.getSequenceToken(aws.BackgroundContext(), fake, msg)
+	if err != nil || aws.StringValue(token) != "next" || fake.describes != 1 {
+		t.Errorf("expected the token of the stream, got %v, %v after %d calls", aws.StringValue(token), err, fake.describes)
+	}
+	fake = &fakeLogs{token: "next", noStream: true, streams: []string{"app-other", "app-starting"}, page: 1}
+	if _, err = u.getSequenceToken(aws.BackgroundContext(), fake, msg); err != nil || fake.noStream || fake.describes != 2 {
+		t.Errorf("expected every page to be described before creating the stream, got %v after %d calls", err, fake.describes)
+	}
+}
+
+func BenchmarkUploaderSubmit(b *testing.B) {
+	uploaded := make(chan string, 1)
+	u := &Uploader{svc: &fakeLogs{uploaded: uploaded}, tokens: map[string]string{}}
+	batch := NewBatch()
+	for i := 0; i < 1000; i++ {
+		batch.Append(Message{Message: "a line", Group: "group", Stream: "stream", Container: "app", Time: time.Now()})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := u.submit(*batch); err != nil {
+			b.Fatal(err)
+		}
+		<-uploaded
+	}
+}
@@ -0,0 +1,187 @@
+package failover
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultSendTimeout = 5 * time.Second
+	defaultRetryAfter  = 30 * time.Second
+)
+
+func init() {
+	router.AdapterFactories.Register(NewFailoverAdapter, "failover")
+}
+
+// sink states
+const (
+	up      = iota
+	down    // skipped until retryAt
+	probing // receives copies of log lines until it reports healthy again
+)
+
+// sink is a child route of the failover route
+type sink struct {
+	uri       string
+	adapter   router.LogAdapter
+	logstream chan *router.Message
+	state     int
+	until     time.Time // end of the down or probing state
+	probe     bool      // up to be probed, without a health check
+}
+
+// NewFailoverAdapter returns a configured failover.Adapter
+func NewFailoverAdapter(route *router.Route) (router.LogAdapter, error) {
+	var err error
+	uris := strings.Split(route.Options["sinks"], "|")
+	if len(uris) < 2 {
+		return nil, errors.New("failover: the sinks option must list at least two route URIs separated by |")
+	}
+	sendTimeout := defaultSendTimeout
+	if s := route.Options["send_timeout"]; s != "" {
+		if sendTimeout, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("failover: invalid value for send_timeout (must be duration): %s", s)
+		}
+	}
+	retryAfter := defaultRetryAfter
+	if s := route.Options["retry_after"]; s != "" {
+		if retryAfter, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("failover: invalid value for retry_after (must be duration): %s", s)
+		}
+	}
+	sinks := make([]*sink, 0, len(uris))
+	for _, uri := range uris {
+		child, err := router.ParseRouteURI(strings.TrimSpace(uri))
+		if err != nil {
+			return nil, fmt.Errorf("failover: invalid sink %s: %s", uri, err)
+		}
+		factory, found := router.AdapterFactories.Lookup(child.AdapterType())
+		if !found {
+			return nil, fmt.Errorf("failover: bad adapter for sink %s: %s", uri, child.Adapter)
+		}
+		adapter, err := factory(child)
+		if err != nil {
+			return nil, fmt.Errorf("failover: sink %s: %s", uri, err)
+		}
+		sinks = append(sinks, &sink{uri: uri, adapter: adapter, logstream: make(chan *router.Message)})
+	}
+	return &Adapter{
+		route:       route,
+		sinks:       sinks,
+		sendTimeout: sendTimeout,
+		retryAfter:  retryAfter,
+	}, nil
+}
+
+// Adapter sends log lines to the first healthy sink of an ordered list.
+// A sink is unhealthy when it doesn't accept a line within send_timeout, or
+// when its adapter implements router.HealthChecker and reports unhealthy.
+// Unhealthy sinks are retried after retry_after, and while they recover
+// lines are also sent to the next sink, so none are lost. The last sink
+// is always used as a last resort.
+type Adapter struct {
+	route       *router.Route
+	sinks       []*sink
+	sendTimeout time.Duration
+	retryAfter  time.Duration
+}
+
+// Stream sends each log line on to the sinks. Lines are handled in order,
+// so the lines of a container reach each sink in the order they were logged.
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	for _, s := range a.sinks {
+		go s.adapter.Stream(s.logstream)
+	}
+	for message := range logstream {
+		a.send(message)
+	}
+	for _, s := range a.sinks {
+		close(s.logstream)
+	}
+}
+
+func (a *Adapter) send(message *router.Message) {
+	now := time.Now()
+	for i, s := range a.sinks {
+		if i == len(a.sinks)-1 {
+			s.logstream <- message
+			return
+		}
+		switch s.state {
+		case down:
+			if now.Before(s.until) {
+				continue
+			}
+			if _, ok := s.adapter.(router.HealthChecker); !ok {
+				// without a health check, the next accepted line is the probe
+				s.state, s.probe = up, true
+			} else {
+				s.state, s.until = probing, now.Add(a.retryAfter)
+			}
+		case up:
+			if !healthy(s.adapter) {
+				a.fail(s, i, "reported unhealthy")
+				continue
+			}
+		}
+
+		select {
+		case s.logstream <- message:
+		case <-time.After(a.sendTimeout):
+			a.fail(s, i, "timed out")
+			continue
+		}
+		if s.state == up {
+			if s.probe {
+				a.recover(s, i)
+			}
+			return
+		}
+		// probing: keep sending to the next sink until this one is healthy
+		if healthy(s.adapter) {
+			a.recover(s, i)
+			return
+		}
+		if now.After(s.until) {
+			a.fail(s, i, "did not recover")
+		}
+	}
+}
+
+// fail marks a sink as down until retry_after has passed
+func (a *Adapter) fail(s *sink, i int, reason string) {
+	if s.state == up && !s.probe {
+		log.Printf("failover: sink %d %s %s, failing over to %s\n", i, s.uri, reason, a.sinks[i+1].uri)
+		router.RunHook(router.EventCircuitOpen, a.hookVars(s, i, reason))
+	}
+	s.state, s.until, s.probe = down, time.Now().Add(a.retryAfter), false
+}
+
+// recover marks a sink as up again
+func (a *Adapter) recover(s *sink, i int) {
+	log.Printf("failover: sink %d %s recovered\n", i, s.uri)
+	router.RunHook(router.EventCircuitClose, a.hookVars(s, i, ""))
+	s.state, s.probe = up, false
+}
+
+// hookVars returns the context of the events of a sink
+func (a *Adapter) hookVars(s *sink, i int, reason string) map[string]string {
+	vars := map[string]string{"ROUTE_ID": a.route.ID, "SINK": fmt.Sprint(i), "SINK_URI": s.uri}
+	if reason != "" {
+		vars["REASON"] = reason
+	}
+	return vars
+}
+
+func healthy(adapter router.LogAdapter) bool {
+	if checker, ok := adapter.(router.HealthChecker); ok {
+		return checker.Healthy()
+	}
+	return true
+}
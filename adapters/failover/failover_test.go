@@ -0,0 +1,112 @@
+package failover
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// testAdapter records the lines it receives, and reports the health set
+// with its healthy field
+type testAdapter struct {
+	lines   chan string
+	healthy int32
+	blocked bool
+}
+
+func (a *testAdapter) Stream(logstream chan *router.Message) {
+	for m := range logstream {
+		for a.blocked {
+			time.Sleep(time.Millisecond)
+		}
+		a.lines <- m.Data
+	}
+}
+
+type checkedAdapter struct{ *testAdapter }
+
+func (a *checkedAdapter) Healthy() bool {
+	return atomic.LoadInt32(&a.healthy) == 1
+}
+
+func newTestAdapter() *testAdapter {
+	return &testAdapter{lines: make(chan string, 10), healthy: 1}
+}
+
+func testFailover(adapters ...router.LogAdapter) (*Adapter, chan *router.Message) {
+	a := &Adapter{route: &router.Route{ID: "test"}, sendTimeout: 20 * time.Millisecond, retryAfter: 50 * time.Millisecond}
+	for _, adapter := range adapters {
+		a.sinks = append(a.sinks, &sink{uri: "test://", adapter: adapter, logstream: make(chan *router.Message)})
+	}
+	logstream := make(chan *router.Message)
+	go a.Stream(logstream)
+	return a, logstream
+}
+
+func expectLine(t *testing.T, adapter *testAdapter, line string) {
+	t.Helper()
+	select {
+	case got := <-adapter.lines:
+		if got != line {
+			t.Errorf("expected %q got %q", line, got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for %q", line)
+	}
+}
+
+func TestFailoverHealthCheck(t *testing.T) {
+	primary, secondary := newTestAdapter(), newTestAdapter()
+	_, logstream := testFailover(&checkedAdapter{primary}, secondary)
+	defer close(logstream)
+
+	logstream <- &router.Message{Data: "one"}
+	expectLine(t, primary, "one")
+
+	atomic.StoreInt32(&primary.healthy, 0)
+	logstream <- &router.Message{Data: "two"}
+	expectLine(t, secondary, "two")
+
+	// after retry_after the primary is probed, and lines are copied to the
+	// secondary until it reports healthy
+	time.Sleep(60 * time.Millisecond)
+	logstream <- &router.Message{Data: "three"}
+	expectLine(t, primary, "three")
+	expectLine(t, secondary, "three")
+
+	atomic.StoreInt32(&primary.healthy, 1)
+	logstream <- &router.Message{Data: "four"}
+	expectLine(t, primary, "four")
+	logstream <- &router.Message{Data: "five"}
+	expectLine(t, primary, "five")
+	if len(secondary.lines) != 0 {
+		t.Errorf("expected no more lines for the secondary after recovery, got %d", len(secondary.lines))
+	}
+}
+
+func TestFailoverSendTimeout(t *testing.T) {
+	primary, secondary := newTestAdapter(), newTestAdapter()
+	primary.blocked = true
+	_, logstream := testFailover(primary, secondary)
+	defer close(logstream)
+
+	logstream <- &router.Message{Data: "one"} // accepted, then blocks the primary
+	logstream <- &router.Message{Data: "two"}
+	expectLine(t, secondary, "two")
+	logstream <- &router.Message{Data: "three"}
+	expectLine(t, secondary, "three")
+}
+
+func TestFailoverInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"sinks": "syslog://localhost:514"},
+		{"sinks": "nope://a|nope://b"},
+		{"sinks": "a|b", "retry_after": "soon"},
+	} {
+		if _, err := NewFailoverAdapter(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v, got nil", options)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package file
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.AdapterFactories.Register(NewFileAdapter, "file")
+	router.RegisterPathAdapter("file")
+}
+
+// NewFileAdapter returns a configured file.Adapter
+func NewFileAdapter(route *router.Route) (router.LogAdapter, error) {
+	if route.Address == "" {
+		return nil, errors.New("file: route address must be a file path, eg: file:///var/log/containers.log")
+	}
+	formatter, err := format.New(route)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openFile(route.Address, formatter)
+	if err != nil {
+		return nil, err
+	}
+	return &Adapter{
+		route:     route,
+		file:      file,
+		formatter: formatter,
+	}, nil
+}
+
+// Adapter appends log output to a local file
+type Adapter struct {
+	route     *router.Route
+	file      *os.File
+	formatter format.Formatter
+}
+
+// Stream writes log data to the file, which is closed once the route is
+// removed
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	defer a.file.Close()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				return
+			}
+			buf, err := a.formatter.Format(message)
+			if err != nil {
+				log.Println("file:", err)
+				continue
+			}
+			if _, err = a.file.Write(buf); err != nil {
+				log.Println("file:", err)
+			}
+		case <-a.route.Closer():
+			return
+		}
+	}
+}
+
+// openFile opens path for appending, creating it and its directory as
+// needed. The format's header is written when the file is empty.
+func openFile(path string, formatter format.Formatter) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if header := formatter.Header(); info.Size() == 0 && header != nil {
+		if _, err = file.Write(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
@@ -0,0 +1,252 @@
+package firehose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+	"github.com/gliderlabs/logspout/adapters/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// PutRecordBatch limits, see
+// https://docs.aws.amazon.com/firehose/latest/dev/limits.html
+const (
+	maxBatchCount     = 500
+	maxBatchSize      = 4 * 1024 * 1024 // bytes
+	maxRecordSize     = 1000 * 1024     // bytes
+	defaultFlushAfter = time.Second
+)
+
+// route options with this prefix define dynamic partitioning keys
+const partitionKeyPrefix = "partition."
+
+func init() {
+	router.AdapterFactories.Register(NewFirehoseAdapter, "firehose")
+}
+
+// NewFirehoseAdapter returns a configured firehose.Adapter
+func NewFirehoseAdapter(route *router.Route) (router.LogAdapter, error) {
+	var err error
+	if route.Address == "" {
+		return nil, errors.New("firehose: route address must be a delivery stream name, eg: firehose://my-stream")
+	}
+	flushAfter := defaultFlushAfter
+	if s := route.Options["flush_after"]; s != "" {
+		if flushAfter, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("firehose: invalid value for flush_after (must be duration): %s", s)
+		}
+	}
+	keys, err := parsePartitionKeys(route.Options)
+	if err != nil {
+		return nil, err
+	}
+	compress, err := format.NewCompressor(route)
+	if err != nil {
+		return nil, fmt.Errorf("firehose: %s", err)
+	}
+	schema, err := router.EnvelopeSchemaOf(route)
+	if err != nil {
+		return nil, fmt.Errorf("firehose: %s", err)
+	}
+	config := httpclient.AWSConfig()
+	if region := route.Options["region"]; region != "" {
+		config = config.WithRegion(region)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &Adapter{
+		route:         route,
+		stream:        route.Address,
+		svc:           firehose.New(sess),
+		partitionKeys: keys,
+		compress:      compress,
+		schema:        schema,
+		flushAfter:    flushAfter,
+		hostname:      hostname,
+	}, nil
+}
+
+// parsePartitionKeys returns the templates of the partition.<key> options
+func parsePartitionKeys(options map[string]string) (map[string]*template.Template, error) {
+	keys := map[string]*template.Template{}
+	for option, value := range options {
+		if !strings.HasPrefix(option, partitionKeyPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(option, partitionKeyPrefix)
+		if key == "" {
+			return nil, fmt.Errorf("firehose: missing partition key name in %s", option)
+		}
+		tmpl, err := template.New(key).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("firehose: invalid value for %s: %s", option, err)
+		}
+		keys[key] = tmpl
+	}
+	return keys, nil
+}
+
+// Adapter sends log lines as JSON records to a Kinesis Data Firehose
+// delivery stream.
+type Adapter struct {
+	route         *router.Route
+	stream        string
+	svc           firehoseiface.FirehoseAPI
+	partitionKeys map[string]*template.Template
+	compress      format.Compressor
+	schema        int // of the envelopes of records
+	flushAfter    time.Duration
+	hostname      string
+}
+
+// Record is the JSON document sent for each log line. PartitionKeys holds
+// the rendered partition.<key> options, so delivery streams with dynamic
+// partitioning can extract them with inline JQ parsing, eg:
+// {team: .partition_keys.team}
+type Record struct {
+	*router.Envelope
+	PartitionKeys map[string]string `json:"partition_keys,omitempty"`
+}
+
+// Stream batches log lines and sends them with PutRecordBatch
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushAfter)
+	defer ticker.Stop()
+	var batch []*firehose.Record
+	var messages []*router.Message // of the records, to dead-letter them
+	size := 0
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				a.put(batch, messages)
+				return
+			}
+			data, err := a.record(message)
+			if err != nil {
+				log.Println("firehose:", err)
+				continue
+			}
+			if len(data) > maxRecordSize {
+				log.Printf("firehose: dropping %d byte record, the limit is %d\n", len(data), maxRecordSize)
+				continue
+			}
+			if len(batch) == maxBatchCount || size+len(data) > maxBatchSize {
+				a.put(batch, messages)
+				batch, messages, size = nil, nil, 0
+			}
+			batch = append(batch, &firehose.Record{Data: data})
+			messages = append(messages, message)
+			size += len(data)
+		case <-ticker.C:
+			a.put(batch, messages)
+			batch, messages, size = nil, nil, 0
+		}
+	}
+}
+
+// record returns the newline delimited JSON record for a message,
+// compressed with the compression option
+func (a *Adapter) record(message *router.Message) ([]byte, error) {
+	record := Record{Envelope: router.NewEnvelopeOf(message, a.schema)}
+	if len(a.partitionKeys) > 0 {
+		keys, err := renderPartitionKeys(a.partitionKeys, format.NewContext(message, a.hostname))
+		if err != nil {
+			return nil, err
+		}
+		record.PartitionKeys = keys
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	if a.compress != nil {
+		return a.compress(data)
+	}
+	return data, nil
+}
+
+// renderPartitionKeys renders the partition key templates in the given
+// context. Empty values are replaced with "unknown", as Firehose fails
+// records with empty partition keys.
+func renderPartitionKeys(keys map[string]*template.Template, context *format.Context) (map[string]string, error) {
+	rendered := make(map[string]string, len(keys))
+	for key, tmpl := range keys {
+		value, err := format.Render(tmpl, context)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering partition key %s: %s", key, err)
+		}
+		if value == "" {
+			value = "unknown"
+		}
+		rendered[key] = value
+	}
+	return rendered, nil
+}
+
+// put sends a batch of records, retrying records that failed once, or the
+// whole request if its error is not fatal. Records that could not be sent
+// are dead-lettered.
+func (a *Adapter) put(batch []*firehose.Record, messages []*router.Message) {
+	reason := ""
+	for attempt := 0; attempt < 2 && len(batch) > 0; attempt++ {
+		output, err := a.svc.PutRecordBatch(&firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(a.stream),
+			Records:            batch,
+		})
+		if err != nil {
+			reason = err.Error()
+			if router.Classify(err) == router.ErrorFatal {
+				break
+			}
+			continue
+		}
+		batch, messages, reason = failedRecords(batch, messages, output)
+	}
+	if len(batch) > 0 {
+		log.Printf("firehose: dropping %d records, error sending to %s: %s\n", len(batch), a.stream, reason)
+		router.WriteDeadLetters(a.route.ID, reason, messages...)
+	}
+}
+
+// failedRecords returns the records of a batch that were not accepted, their
+// messages and their error codes
+func failedRecords(batch []*firehose.Record, messages []*router.Message, output *firehose.PutRecordBatchOutput) ([]*firehose.Record, []*router.Message, string) {
+	if aws.Int64Value(output.FailedPutCount) == 0 {
+		return nil, nil, ""
+	}
+	var failed []*firehose.Record
+	var failedMessages []*router.Message
+	errs := map[string]bool{}
+	for i, response := range output.RequestResponses {
+		if response.ErrorCode != nil && i < len(batch) {
+			failed = append(failed, batch[i])
+			failedMessages = append(failedMessages, messages[i])
+			errs[aws.StringValue(response.ErrorCode)] = true
+		}
+	}
+	codes := make([]string, 0, len(errs))
+	for code := range errs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	log.Printf("firehose: %d records failed: %s\n", len(failed), strings.Join(codes, ", "))
+	return failed, failedMessages, strings.Join(codes, ", ")
+}
@@ -0,0 +1,111 @@
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/firehose/firehoseiface"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestRenderPartitionKeys(t *testing.T) {
+	keys, err := parsePartitionKeys(map[string]string{
+		"region":              "us-east-1",
+		"partition.container": "{{.Name}}",
+		"partition.team":      `{{.Lbl "team"}}`,
+		"partition.owner":     `{{.Lbl "owner"}}`,
+		"partition.date":      "{{.Date}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &router.Message{
+		Container: &docker.Container{
+			Name:   "/web",
+			Config: &docker.Config{Labels: map[string]string{"team": "payments"}},
+		},
+		Time: time.Date(2020, 6, 1, 23, 59, 0, 0, time.UTC),
+	}
+	rendered, err := renderPartitionKeys(keys, format.NewContext(message, "logspout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"container": "web",
+		"team":      "payments",
+		"owner":     "unknown",
+		"date":      "2020-06-01",
+	}
+	if !reflect.DeepEqual(rendered, expected) {
+		t.Errorf("expected %v got %v", expected, rendered)
+	}
+}
+
+func TestParsePartitionKeysInvalid(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"partition.": "{{.Name}}"},
+		{"partition.team": "{{.Lbl"},
+	} {
+		if _, err := parsePartitionKeys(options); err == nil {
+			t.Errorf("expected error for %v, got nil", options)
+		}
+	}
+}
+
+func TestRecordCompression(t *testing.T) {
+	compress, err := format.NewCompressor(&router.Route{Options: map[string]string{"compression": format.Gzip}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Adapter{compress: compress}
+	data, err := a.record(&router.Message{Data: "hello", Time: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected a gzip record: %s", err)
+	}
+	line, _ := ioutil.ReadAll(r)
+	if !bytes.HasPrefix(line, []byte(`{"time":"2020-06-01T00:00:00Z"`)) || !bytes.HasSuffix(line, []byte("\n")) {
+		t.Errorf("unexpected record %q", line)
+	}
+}
+
+type fakeFirehose struct {
+	firehoseiface.FirehoseAPI
+	err   error
+	calls int
+}
+
+func (f *fakeFirehose) PutRecordBatch(input *firehose.PutRecordBatchInput) (*firehose.PutRecordBatchOutput, error) {
+	f.calls++
+	return nil, f.err
+}
+
+func TestPutRetriesByErrorClass(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{awserr.New("ServiceUnavailableException", "try again", nil), 2},
+		{awserr.New("InvalidArgumentException", "too large", nil), 1},
+	}
+	for _, test := range tests {
+		svc := &fakeFirehose{err: test.err}
+		a := &Adapter{route: &router.Route{ID: "test"}, stream: "stream", svc: svc}
+		a.put([]*firehose.Record{{Data: []byte("x")}}, []*router.Message{{Data: "x"}})
+		if svc.calls != test.expected {
+			t.Errorf("expected %d requests for %s, got %d", test.expected, test.err, svc.calls)
+		}
+	}
+}
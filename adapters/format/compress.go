@@ -0,0 +1,36 @@
+package format
+
+import (
+	"errors"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/zstd"
+)
+
+// Codecs of the "compression" route option of the adapters that send
+// records. A compressed record is a whole gzip member or zstd frame, whose
+// magic number flags it, so consumers tell it from a JSON record by its
+// first byte, and records concatenated into an object by Firehose can be
+// read back with gunzip or zstd -d.
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Compressor compresses the payload of a record
+type Compressor func(data []byte) ([]byte, error)
+
+// NewCompressor returns the Compressor selected by the "compression" route
+// option, or nil if records are sent uncompressed
+func NewCompressor(route *router.Route) (Compressor, error) {
+	switch name := route.Options["compression"]; name {
+	case "", "none":
+		return nil, nil
+	case Gzip:
+		return gzipBytes, nil
+	case Zstd:
+		return func(data []byte) ([]byte, error) { return zstd.Compress(data), nil }, nil
+	default:
+		return nil, errors.New("unknown compression: " + name)
+	}
+}
@@ -0,0 +1,53 @@
+package format
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/zstd"
+)
+
+func TestCompressors(t *testing.T) {
+	var logs bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&logs, `{"time":"2020-06-01T12:00:%02dZ","container_name":"web","source":"stdout","message":"GET /api/%d 200"}`+"\n", i%60, i)
+	}
+	inputs := [][]byte{nil, []byte("a"), logs.Bytes()[:100], logs.Bytes(), bytes.Repeat([]byte{'x'}, 300*1024)}
+
+	zstdCompressor, err := NewCompressor(&router.Route{Options: map[string]string{"compression": Zstd}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := NewCompressor(&router.Route{Options: map[string]string{"compression": Gzip}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range inputs {
+		compressed, _ := zstdCompressor(in)
+		if out, err := zstd.Decompress(compressed); err != nil || !bytes.Equal(out, in) {
+			t.Errorf("expected %d bytes back from zstd, got %d", len(in), len(out))
+		}
+		compressed, _ = gz(in)
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := ioutil.ReadAll(r); !bytes.Equal(out, in) {
+			t.Errorf("expected %d bytes back from gzip, got %d", len(in), len(out))
+		}
+	}
+	if compressed, _ := zstdCompressor(logs.Bytes()); len(compressed) > logs.Len()/4 {
+		t.Errorf("expected repetitive logs to shrink to a quarter, got %d of %d bytes", len(compressed), logs.Len())
+	}
+
+	if c, err := NewCompressor(&router.Route{Options: map[string]string{}}); c != nil || err != nil {
+		t.Errorf("expected no compression by default, got %v", err)
+	}
+	if _, err := NewCompressor(&router.Route{Options: map[string]string{"compression": "lz4"}}); err == nil {
+		t.Error("expected an unknown compression to be refused")
+	}
+}
@@ -0,0 +1,66 @@
+package format
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Context defines the info about a log line that can be used in templated
+// route options, such as S3 key partitions or Firehose partition keys
+type Context struct {
+	Time        time.Time         // time of the log line
+	Name        string            // container name
+	ID          string            // container ID
+	Image       string            // container image
+	Host        string            // container host name
+	Labels      map[string]string // container labels
+	ImageLabels map[string]string // OCI labels of the container image, eg: revision and version
+	LoggerHost  string            // hostname of logging container (os.Hostname)
+}
+
+// NewContext returns the Context for a Message
+func NewContext(message *router.Message, loggerHost string) *Context {
+	envelope := router.NewEnvelope(message)
+	return &Context{
+		Time:        message.Time,
+		Name:        envelope.ContainerName,
+		ID:          envelope.ContainerID,
+		Image:       envelope.Image,
+		Host:        envelope.Hostname,
+		Labels:      envelope.Labels,
+		ImageLabels: envelope.ImageLabels,
+		LoggerHost:  loggerHost,
+	}
+}
+
+// UTC formats the time of the log line in UTC with the given layout
+func (c *Context) UTC(layout string) string {
+	return c.Time.UTC().Format(layout)
+}
+
+// Date returns the UTC date of the log line as YYYY-MM-DD
+func (c *Context) Date() string {
+	return c.UTC("2006-01-02")
+}
+
+// Hour returns the UTC hour of the log line as HH
+func (c *Context) Hour() string {
+	return c.UTC("15")
+}
+
+// Lbl returns the value of a container label, or an empty string
+func (c *Context) Lbl(key string) string {
+	return c.Labels[key]
+}
+
+// Render executes tmpl in the given context
+func Render(tmpl *template.Template, context *Context) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, context); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,127 @@
+package format
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// Raw writes each log line as is
+	Raw = "raw"
+	// NDJSON writes one router.Envelope JSON object per line
+	NDJSON = "ndjson"
+	// CSV writes selected router.Envelope fields as comma separated values
+	CSV = "csv"
+
+	defaultColumns = "time,container_name,source,message"
+)
+
+// Formatter renders Messages for adapters that archive logs to files or objects
+type Formatter interface {
+	// Header returns the data to write at the start of each file or object, if any
+	Header() []byte
+	// Format renders a single message, including its trailing newline
+	Format(m *router.Message) ([]byte, error)
+	// Extension returns the file extension for the format
+	Extension() string
+}
+
+// New returns the Formatter selected by the "format" route option, with
+// CSV columns selected by the "columns" route option
+func New(route *router.Route) (Formatter, error) {
+	switch name := route.Options["format"]; name {
+	case "", Raw:
+		return rawFormatter{}, nil
+	case NDJSON:
+		schema, err := router.EnvelopeSchemaOf(route)
+		if err != nil {
+			return nil, err
+		}
+		return ndjsonFormatter{schema: schema}, nil
+	case CSV:
+		schema, err := router.EnvelopeSchemaOf(route)
+		if err != nil {
+			return nil, err
+		}
+		columns := route.Options["columns"]
+		if columns == "" {
+			columns = defaultColumns
+		}
+		return newCSVFormatter(strings.Split(columns, ","), schema)
+	default:
+		return nil, errors.New("unknown format: " + name)
+	}
+}
+
+type rawFormatter struct{}
+
+func (rawFormatter) Header() []byte { return nil }
+
+func (rawFormatter) Format(m *router.Message) ([]byte, error) {
+	return append([]byte(m.Data), '\n'), nil
+}
+
+func (rawFormatter) Extension() string { return "log" }
+
+type ndjsonFormatter struct {
+	schema int // of the envelopes
+}
+
+func (ndjsonFormatter) Header() []byte { return nil }
+
+func (f ndjsonFormatter) Format(m *router.Message) ([]byte, error) {
+	b, err := json.Marshal(router.NewEnvelopeOf(m, f.schema))
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (ndjsonFormatter) Extension() string { return "ndjson" }
+
+type csvFormatter struct {
+	columns []string
+	header  []byte
+	schema  int // of the envelopes
+}
+
+func newCSVFormatter(columns []string, schema int) (*csvFormatter, error) {
+	for _, column := range columns {
+		if _, ok := new(router.Envelope).Field(column); !ok {
+			return nil, errors.New("unknown csv column: " + column)
+		}
+	}
+	header, err := csvLine(columns)
+	if err != nil {
+		return nil, err
+	}
+	return &csvFormatter{columns: columns, header: header, schema: schema}, nil
+}
+
+func (f *csvFormatter) Header() []byte { return f.header }
+
+func (f *csvFormatter) Format(m *router.Message) ([]byte, error) {
+	envelope := router.NewEnvelopeOf(m, f.schema)
+	record := make([]string, len(f.columns))
+	for i, column := range f.columns {
+		record[i], _ = envelope.Field(column)
+	}
+	return csvLine(record)
+}
+
+func (f *csvFormatter) Extension() string { return "csv" }
+
+func csvLine(record []string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	if err := w.Write(record); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,126 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+var message = &router.Message{
+	Container: &docker.Container{
+		ID:   "8dfafdbc3a40",
+		Name: "/container",
+		Config: &docker.Config{
+			Hostname: "8dfafdbc3a40",
+			Image:    "alpine",
+			Labels:   map[string]string{"team": "logging"},
+		},
+	},
+	Source: "stdout",
+	Data:   `say "hello", world`,
+	Time:   time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		options map[string]string
+		header  string
+		out     string
+	}{
+		{map[string]string{}, "", "say \"hello\", world\n"},
+		{map[string]string{"format": "ndjson"}, "",
+			`{"schema":3,"time":"2020-06-01T12:00:00Z","container_id":"8dfafdbc3a40","container_name":"container",` +
+				`"image":"alpine","hostname":"8dfafdbc3a40","source":"stdout","message":"say \"hello\", world",` +
+				`"labels":{"team":"logging"}}` + "\n"},
+		{map[string]string{"format": "ndjson", "schema": "1"}, "", // without the fields of later schemas
+			`{"time":"2020-06-01T12:00:00Z","container_id":"8dfafdbc3a40","container_name":"container",` +
+				`"image":"alpine","hostname":"8dfafdbc3a40","source":"stdout","message":"say \"hello\", world",` +
+				`"labels":{"team":"logging"}}` + "\n"},
+		{map[string]string{"format": "csv"}, "time,container_name,source,message\n",
+			`2020-06-01T12:00:00Z,container,stdout,"say ""hello"", world"` + "\n"},
+		{map[string]string{"format": "csv", "columns": "container_id,label.team"}, "container_id,label.team\n",
+			"8dfafdbc3a40,logging\n"},
+		{map[string]string{"format": "csv", "columns": "schema,container_name"}, "schema,container_name\n",
+			"3,container\n"},
+		{map[string]string{"format": "csv", "columns": "schema,container_name", "schema": "1"}, "schema,container_name\n",
+			"0,container\n"}, // without the fields of later schemas
+	}
+	for _, test := range tests {
+		formatter, err := New(&router.Route{Options: test.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header := string(formatter.Header()); header != test.header {
+			t.Errorf("expected header %q got %q", test.header, header)
+		}
+		out, err := formatter.Format(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != test.out {
+			t.Errorf("expected %q got %q", test.out, out)
+		}
+	}
+}
+
+func TestNDJSONImageLabels(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/container", Image: "sha256:4e38e38c8ce0",
+		Config: &docker.Config{Labels: map[string]string{"org.opencontainers.image.revision": "5f3c2a1"}}}
+	for schema, out := range map[string]string{
+		"": `{"schema":3,"time":"0001-01-01T00:00:00Z","container_id":"8dfafdbc3a40","container_name":"container",` +
+			`"image":"","hostname":"","source":"","message":"","labels":{"org.opencontainers.image.revision":"5f3c2a1"},` +
+			`"image_labels":{"revision":"5f3c2a1"}}` + "\n",
+		"2": `{"schema":2,"time":"0001-01-01T00:00:00Z","container_id":"8dfafdbc3a40","container_name":"container",` +
+			`"image":"","hostname":"","source":"","message":"","labels":{"org.opencontainers.image.revision":"5f3c2a1"}}` + "\n",
+	} {
+		formatter, err := New(&router.Route{Options: map[string]string{"format": "ndjson", "schema": schema}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := formatter.Format(&router.Message{Container: container})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != out {
+			t.Errorf("expected %q with schema %q, got %q", out, schema, data)
+		}
+	}
+}
+
+func TestFormatInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"format": "xml"},
+		{"format": "csv", "columns": "time,nope"},
+		{"format": "ndjson", "schema": "4"},
+		{"format": "csv", "schema": "4"},
+	} {
+		if _, err := New(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v, got nil", options)
+		}
+	}
+}
+
+func TestEncodeParquet(t *testing.T) {
+	out, err := EncodeParquet([]*router.Message{message, message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte("PAR1")) || !bytes.HasSuffix(out, []byte("PAR1")) {
+		t.Fatal("expected parquet magic at the start and end of the file")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(out[len(out)-8 : len(out)-4]))
+	if footerLen <= 0 || footerLen > len(out)-12 {
+		t.Fatalf("invalid footer length %d for %d byte file", footerLen, len(out))
+	}
+	footer := out[len(out)-8-footerLen : len(out)-8]
+	for _, column := range ParquetColumns {
+		if !bytes.Contains(footer, []byte(column)) {
+			t.Errorf("expected column %s in file metadata", column)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package format
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Parquet writes router.Envelope fields as a Parquet file with a fixed
+// schema, so archived logs can be queried directly by engines like Athena.
+// Only the small subset of https://github.com/apache/parquet-format needed
+// for flat, required columns is implemented: a single row group, with one
+// gzip compressed, PLAIN encoded data page per column.
+const Parquet = "parquet"
+
+// ParquetColumns are the columns of the schema, in order. Labels are
+// stored as a JSON encoded string.
+var ParquetColumns = []string{
+	"time", "container_id", "container_name", "image", "hostname", "source", "message", "labels",
+}
+
+// parquet physical types, converted types and other enums used below
+const (
+	parquetInt64         = 2
+	parquetByteArray     = 6
+	parquetRequired      = 0
+	parquetUTF8          = 0
+	parquetTimestampMS   = 9
+	parquetJSON          = 19
+	parquetDataPage      = 0
+	parquetPlain         = 0
+	parquetRLE           = 3
+	parquetCodecGzip     = 2
+	parquetFormatVersion = 1
+)
+
+var parquetMagic = []byte("PAR1")
+
+// EncodeParquet returns the Parquet file for the given messages
+func EncodeParquet(messages []*router.Message) ([]byte, error) {
+	envelopes := make([]*router.Envelope, len(messages))
+	for i, m := range messages {
+		envelopes[i] = router.NewEnvelope(m)
+	}
+
+	out := new(bytes.Buffer)
+	out.Write(parquetMagic)
+	chunks := make([]parquetChunk, 0, len(ParquetColumns))
+	var totalSize int64
+	for _, column := range ParquetColumns {
+		values, err := parquetValues(column, envelopes)
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := gzipBytes(values)
+		if err != nil {
+			return nil, err
+		}
+		header := new(thriftWriter)
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(values)))
+		header.i32(3, int32(len(compressed)))
+		header.beginStruct(5)
+		header.i32(1, int32(len(envelopes)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.stop()
+
+		chunk := parquetChunk{
+			column:           column,
+			offset:           int64(out.Len()),
+			uncompressedSize: int64(header.buf.Len() + len(values)),
+			compressedSize:   int64(header.buf.Len() + len(compressed)),
+		}
+		out.Write(header.buf.Bytes())
+		out.Write(compressed)
+		chunks = append(chunks, chunk)
+		totalSize += chunk.uncompressedSize
+	}
+
+	meta := parquetFileMetaData(chunks, int64(len(envelopes)), totalSize)
+	out.Write(meta)
+	binary.Write(out, binary.LittleEndian, uint32(len(meta))) //nolint:errcheck
+	out.Write(parquetMagic)
+	return out.Bytes(), nil
+}
+
+// parquetChunk records where a column's data was written
+type parquetChunk struct {
+	column           string
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// parquetValues returns the PLAIN encoded values of a column
+func parquetValues(column string, envelopes []*router.Envelope) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, e := range envelopes {
+		if column == "time" {
+			binary.Write(buf, binary.LittleEndian, e.Time.UnixNano()/int64(time.Millisecond)) //nolint:errcheck
+			continue
+		}
+		var value []byte
+		if column == "labels" {
+			labels, err := json.Marshal(e.Labels)
+			if err != nil {
+				return nil, err
+			}
+			value = labels
+		} else {
+			s, _ := e.Field(column)
+			value = []byte(s)
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(len(value))) //nolint:errcheck
+		buf.Write(value)
+	}
+	return buf.Bytes(), nil
+}
+
+func parquetFileMetaData(chunks []parquetChunk, rows, totalSize int64) []byte {
+	w := new(thriftWriter)
+	w.i32(1, parquetFormatVersion)
+
+	w.beginList(2, thriftStruct, len(ParquetColumns)+1)
+	w.beginListStruct() // the schema root
+	w.binary(4, []byte("schema"))
+	w.i32(5, int32(len(ParquetColumns)))
+	w.endListStruct()
+	for _, column := range ParquetColumns {
+		w.beginListStruct()
+		physical, converted := parquetTypes(column)
+		w.i32(1, physical)
+		w.i32(3, parquetRequired)
+		w.binary(4, []byte(column))
+		w.i32(6, converted)
+		w.endListStruct()
+	}
+
+	w.i64(3, rows)
+
+	w.beginList(4, thriftStruct, 1)
+	w.beginListStruct() // the row group
+	w.beginList(1, thriftStruct, len(chunks))
+	for _, chunk := range chunks {
+		physical, _ := parquetTypes(chunk.column)
+		w.beginListStruct()
+		w.i64(2, chunk.offset)
+		w.beginStruct(3)
+		w.i32(1, physical)
+		w.beginList(2, thriftI32, 2)
+		w.listI32(parquetPlain)
+		w.listI32(parquetRLE)
+		w.beginList(3, thriftBinary, 1)
+		w.listBinary([]byte(chunk.column))
+		w.i32(4, parquetCodecGzip)
+		w.i64(5, rows)
+		w.i64(6, chunk.uncompressedSize)
+		w.i64(7, chunk.compressedSize)
+		w.i64(9, chunk.offset)
+		w.endStruct()
+		w.endListStruct()
+	}
+	w.i64(2, totalSize)
+	w.i64(3, rows)
+	w.endListStruct()
+
+	w.binary(6, []byte("logspout"))
+	w.stop()
+	return w.buf.Bytes()
+}
+
+func parquetTypes(column string) (physical, converted int32) {
+	switch column {
+	case "time":
+		return parquetInt64, parquetTimestampMS
+	case "labels":
+		return parquetByteArray, parquetJSON
+	default:
+		return parquetByteArray, parquetUTF8
+	}
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// thrift compact protocol types, see
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes the thrift compact protocol encoding of the
+// structs Parquet uses for its page headers and file metadata
+type thriftWriter struct {
+	buf       bytes.Buffer
+	lastField int16
+	stack     []int16
+}
+
+func (w *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - w.lastField; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	w.lastField = id
+}
+
+func (w *thriftWriter) varint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.buf.Write(b[:binary.PutVarint(b, v)]) // zigzag encoded
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) binary(id int16, v []byte) {
+	w.fieldHeader(id, thriftBinary)
+	w.listBinary(v)
+}
+
+func (w *thriftWriter) beginList(id int16, elemType byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.uvarint(uint64(size))
+	}
+}
+
+func (w *thriftWriter) listI32(v int32) {
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) listBinary(v []byte) {
+	w.uvarint(uint64(len(v)))
+	w.buf.Write(v)
+}
+
+// beginStruct starts a struct field, endStruct must be called after its fields
+func (w *thriftWriter) beginStruct(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginListStruct()
+}
+
+func (w *thriftWriter) endStruct() {
+	w.endListStruct()
+}
+
+// beginListStruct starts a struct element of a list
+func (w *thriftWriter) beginListStruct() {
+	w.stack = append(w.stack, w.lastField)
+	w.lastField = 0
+}
+
+func (w *thriftWriter) endListStruct() {
+	w.stop()
+	w.lastField = w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+}
+
+func (w *thriftWriter) stop() {
+	w.buf.WriteByte(0)
+}
@@ -0,0 +1,79 @@
+package httpclient
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+var (
+	awsCredentials     *credentials.Credentials
+	awsCredentialsOnce sync.Once
+)
+
+// AWSConfig returns a configuration for the sessions and clients of the AWS
+// adapters, with the shared HTTP client and AWSCredentials, if any
+func AWSConfig() *aws.Config {
+	config := aws.NewConfig().WithHTTPClient(Client())
+	if creds := AWSCredentials(); creds != nil {
+		config = config.WithCredentials(creds)
+	}
+	return config
+}
+
+// AWSCredentials returns the credentials of the AWS adapters when the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY options are set, with the
+// AWS_SESSION_TOKEN option, or else nil, for the sessions of the AWS SDK to
+// find them as usual, like with the web identity of an EKS service account,
+// a profile assuming a role or the role of the instance. Unlike the SDK,
+// which reads the environment once, the options are taken again when they
+// change, like when rotated in their file or secret store, so running
+// adapters sign their next requests with the new credentials.
+func AWSCredentials() *credentials.Credentials {
+	if cfg.GetString("AWS_ACCESS_KEY_ID") == "" || cfg.GetString("AWS_SECRET_ACCESS_KEY") == "" {
+		return nil
+	}
+	awsCredentialsOnce.Do(func() {
+		config, handlers := defaults.Config(), defaults.Handlers()
+		awsCredentials = credentials.NewCredentials(&credentials.ChainProvider{
+			Providers: []credentials.Provider{
+				&optionsProvider{},
+				&credentials.SharedCredentialsProvider{},
+				defaults.RemoteCredProvider(*config, handlers),
+			},
+		})
+	})
+	return awsCredentials
+}
+
+// optionsProvider provides the credentials of the AWS_ options, which
+// expire when the options change
+type optionsProvider struct {
+	retrieved credentials.Value
+}
+
+func (p *optionsProvider) Retrieve() (credentials.Value, error) {
+	value := p.options()
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return credentials.Value{ProviderName: value.ProviderName}, credentials.ErrAccessKeyIDNotFound
+	}
+	p.retrieved = value
+	return value, nil
+}
+
+func (p *optionsProvider) IsExpired() bool {
+	return p.options() != p.retrieved
+}
+
+func (p *optionsProvider) options() credentials.Value {
+	return credentials.Value{
+		AccessKeyID:     cfg.GetString("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: cfg.GetString("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    cfg.GetString("AWS_SESSION_TOKEN"),
+		ProviderName:    "LogspoutOptionsProvider",
+	}
+}
@@ -0,0 +1,44 @@
+package httpclient
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOptionsCredentialsExpireWhenRotated(t *testing.T) {
+	p := &optionsProvider{}
+	if _, err := p.Retrieve(); err == nil {
+		t.Error("expected no credentials without the options")
+	}
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID1")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET1")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	value, err := p.Retrieve()
+	if err != nil || value.AccessKeyID != "AKID1" {
+		t.Fatalf("expected the credentials of the options, got %v %v", value, err)
+	}
+	if p.IsExpired() {
+		t.Error("expected unchanged credentials not to expire")
+	}
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET2")
+	if !p.IsExpired() {
+		t.Error("expected rotated credentials to expire")
+	}
+	if value, _ := p.Retrieve(); value.SecretAccessKey != "SECRET2" {
+		t.Errorf("expected the rotated credentials, got %v", value)
+	}
+}
+
+func TestAWSConfigLeavesCredentialsToSDKWithoutOptions(t *testing.T) {
+	if config := AWSConfig(); config.Credentials != nil {
+		t.Error("expected the credentials of the SDK, like those of a web identity, without the options")
+	}
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKID1")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET1")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	if config := AWSConfig(); config.Credentials == nil {
+		t.Error("expected the credentials of the options")
+	}
+}
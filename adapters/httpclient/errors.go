@@ -0,0 +1,78 @@
+package httpclient
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.ErrorClassifiers.Register(classifyAWSError, "aws")
+}
+
+// codes of AWS errors that are not throttling, by their class
+var awsErrorCodes = map[string]router.ErrorClass{
+	request.ErrCodeRequestError:    router.ErrorRetryable, // network errors, the SDK opens a new connection
+	request.ErrCodeResponseTimeout: router.ErrorRetryable,
+	request.ErrCodeRead:            router.ErrorRetryable,
+	request.CanceledErrorCode:      router.ErrorRetryable,
+	"InternalFailure":              router.ErrorRetryable,
+	"InternalError":                router.ErrorRetryable,
+	"ServiceUnavailable":           router.ErrorRetryable,
+	"ServiceUnavailableException":  router.ErrorRetryable,
+	"RequestTimeout":               router.ErrorRetryable,
+	"RequestTimeoutException":      router.ErrorRetryable,
+	"SlowDown":                     router.ErrorRetryable,
+	"LimitExceededException":       router.ErrorRetryable,
+	"OperationAbortedException":    router.ErrorRetryable,
+	"ResourceInUseException":       router.ErrorRetryable,
+	"KMSThrottlingException":       router.ErrorRetryable,
+
+	"InvalidSequenceTokenException": router.ErrorRecoverable, // fetch the token again
+	"ResourceNotFoundException":     router.ErrorRecoverable, // create the group or stream
+	"ExpiredToken":                  router.ErrorRecoverable, // sign with refreshed credentials
+	"ExpiredTokenException":         router.ErrorRecoverable,
+	"RequestExpired":                router.ErrorRecoverable,
+
+	"AccessDenied":                   router.ErrorFatal,
+	"AccessDeniedException":          router.ErrorFatal,
+	"UnrecognizedClientException":    router.ErrorFatal,
+	"InvalidClientTokenId":           router.ErrorFatal,
+	"InvalidAccessKeyId":             router.ErrorFatal,
+	"SignatureDoesNotMatch":          router.ErrorFatal,
+	"MissingAuthenticationToken":     router.ErrorFatal,
+	"NoCredentialProviders":          router.ErrorFatal,
+	"KMSAccessDeniedException":       router.ErrorFatal,
+	"KMSDisabledException":           router.ErrorFatal,
+	"KMSNotFoundException":           router.ErrorFatal,
+	"KMSInvalidStateException":       router.ErrorFatal,
+	"NoSuchBucket":                   router.ErrorFatal,
+	"ValidationException":            router.ErrorFatal,
+	"InvalidParameterException":      router.ErrorFatal,
+	"InvalidParameterValueException": router.ErrorFatal,
+	"InvalidArgumentException":       router.ErrorFatal,
+	"SerializationException":         router.ErrorFatal,
+}
+
+// classifyAWSError classifies the errors of the AWS SDK by their code, or
+// else by the status of their response
+func classifyAWSError(err error) (router.ErrorClass, bool) {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return 0, false
+	}
+	if request.IsErrorThrottle(aerr) {
+		return router.ErrorRetryable, true
+	}
+	if class, known := awsErrorCodes[aerr.Code()]; known {
+		return class, true
+	}
+	var failure awserr.RequestFailure
+	if errors.As(err, &failure) && failure.StatusCode() > 0 {
+		return router.ClassifyStatus(failure.StatusCode()), true
+	}
+	return router.ErrorRetryable, true
+}
@@ -0,0 +1,32 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestClassifyAWSError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected router.ErrorClass
+	}{
+		{awserr.New("ThrottlingException", "rate exceeded", nil), router.ErrorRetryable},
+		{awserr.New("RequestError", "send request failed", errors.New("connection reset")), router.ErrorRetryable},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "", nil), 503, ""), router.ErrorRetryable},
+		{awserr.New("InvalidSequenceTokenException", "", nil), router.ErrorRecoverable},
+		{awserr.New("ResourceNotFoundException", "", nil), router.ErrorRecoverable},
+		{awserr.New("AccessDeniedException", "", nil), router.ErrorFatal},
+		{awserr.NewRequestFailure(awserr.New("Unknown", "", nil), 400, ""), router.ErrorFatal},
+		{fmt.Errorf("wrapped: %w", awserr.New("ValidationException", "", nil)), router.ErrorFatal},
+	}
+	for _, test := range tests {
+		if class := router.Classify(test.err); class != test.expected {
+			t.Errorf("expected %s to be %s, got %s", test.err, test.expected, class)
+		}
+	}
+}
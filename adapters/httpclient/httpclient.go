@@ -0,0 +1,75 @@
+// Package httpclient provides the HTTP client shared by adapters that send
+// logs over HTTP, like the AWS adapters.
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+var (
+	client     *http.Client
+	clientOnce sync.Once
+)
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "HTTP_DISABLE_HTTP2", Type: cfg.Bool, Default: "false",
+			Description: "only use HTTP/1.1 for adapters sending logs over HTTP"},
+		cfg.Option{Name: "HTTP_IDLE_CONN_TIMEOUT", Type: cfg.Duration, Default: defaultIdleConnTimeout.String(),
+			Description: "how long idle connections of HTTP adapters are kept open"},
+		cfg.Option{Name: "HTTP_MAX_CONNS_PER_HOST", Type: cfg.Int, Default: "0", Validate: cfg.NotNegative,
+			Description: "maximum number of connections HTTP adapters open to each host, 0 for unlimited"},
+		cfg.Option{Name: "HTTP_MAX_IDLE_CONNS_PER_HOST", Type: cfg.Int, Default: strconv.Itoa(defaultMaxIdleConnsPerHost),
+			Validate: cfg.NotNegative, Description: "number of idle connections HTTP adapters keep open to each host"},
+		cfg.Option{Name: "HTTP_TIMEOUT", Type: cfg.Duration, Default: "0",
+			Description: "time limit for requests of HTTP adapters, 0 for none"},
+		// given to the AWS SDK by AWSCredentials, registered so they can be read from files
+		cfg.Option{Name: "AWS_ACCESS_KEY_ID", Secret: true, Description: "access key of the AWS adapters, unless they use a role"},
+		cfg.Option{Name: "AWS_SECRET_ACCESS_KEY", Secret: true, Description: "secret key of the AWS adapters"},
+		cfg.Option{Name: "AWS_SESSION_TOKEN", Secret: true, Description: "session token of temporary credentials of the AWS adapters"},
+	)
+}
+
+// Client returns the shared HTTP client. Its transport keeps a pool of
+// connections to each host alive and uses HTTP/2 where the server supports
+// it, which matters for adapters sending many small requests. It is tuned
+// with the HTTP_MAX_CONNS_PER_HOST, HTTP_MAX_IDLE_CONNS_PER_HOST,
+// HTTP_IDLE_CONN_TIMEOUT, HTTP_TIMEOUT and HTTP_DISABLE_HTTP2 env vars.
+func Client() *http.Client {
+	clientOnce.Do(func() {
+		client = &http.Client{
+			Transport: newTransport(),
+			Timeout:   cfg.GetDuration("HTTP_TIMEOUT"), // requests are not limited by default
+		}
+	})
+	return client
+}
+
+func newTransport() *http.Transport {
+	idlePerHost := cfg.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST")
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     !cfg.GetBool("HTTP_DISABLE_HTTP2"),
+		MaxIdleConns:          idlePerHost * 4,
+		MaxIdleConnsPerHost:   idlePerHost,
+		MaxConnsPerHost:       cfg.GetInt("HTTP_MAX_CONNS_PER_HOST"),
+		IdleConnTimeout:       cfg.GetDuration("HTTP_IDLE_CONN_TIMEOUT"),
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
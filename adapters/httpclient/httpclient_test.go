@@ -0,0 +1,30 @@
+package httpclient
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewTransport(t *testing.T) {
+	transport := newTransport()
+	if !transport.ForceAttemptHTTP2 || transport.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost ||
+		transport.MaxConnsPerHost != 0 {
+		t.Errorf("unexpected default transport settings: %+v", transport)
+	}
+
+	for name, value := range map[string]string{
+		"HTTP_MAX_CONNS_PER_HOST":      "8",
+		"HTTP_MAX_IDLE_CONNS_PER_HOST": "4",
+		"HTTP_IDLE_CONN_TIMEOUT":       "30s",
+		"HTTP_DISABLE_HTTP2":           "true",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	transport = newTransport()
+	if transport.ForceAttemptHTTP2 || transport.MaxConnsPerHost != 8 || transport.MaxIdleConnsPerHost != 4 ||
+		transport.MaxIdleConns != 16 || transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("unexpected transport settings from env: %+v", transport)
+	}
+}
@@ -0,0 +1,250 @@
+package kinesis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+	"github.com/gliderlabs/logspout/adapters/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultPartitionKey = "{{.ID}}"
+	defaultFlushAfter   = time.Second
+	maxAttempts         = 3
+	shardRefresh        = 5 * time.Minute
+	maxPartitionKeyLen  = 256
+)
+
+func init() {
+	router.AdapterFactories.Register(NewKinesisAdapter, "kinesis")
+}
+
+// NewKinesisAdapter returns a configured kinesis.Adapter
+func NewKinesisAdapter(route *router.Route) (router.LogAdapter, error) {
+	var err error
+	if route.Address == "" {
+		return nil, errors.New("kinesis: route address must be a stream name, eg: kinesis://my-stream")
+	}
+	flushAfter := defaultFlushAfter
+	if s := route.Options["flush_after"]; s != "" {
+		if flushAfter, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("kinesis: invalid value for flush_after (must be duration): %s", s)
+		}
+	}
+	partitionKey := defaultPartitionKey
+	if s := route.Options["partition_key"]; s != "" {
+		partitionKey = s
+	}
+	tmpl, err := template.New("partition_key").Parse(partitionKey)
+	if err != nil {
+		return nil, fmt.Errorf("kinesis: invalid value for partition_key: %s", err)
+	}
+	compress, err := format.NewCompressor(route)
+	if err != nil {
+		return nil, fmt.Errorf("kinesis: %s", err)
+	}
+	schema, err := router.EnvelopeSchemaOf(route)
+	if err != nil {
+		return nil, fmt.Errorf("kinesis: %s", err)
+	}
+	config := httpclient.AWSConfig()
+	if region := route.Options["region"]; region != "" {
+		config = config.WithRegion(region)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &Adapter{
+		route:      route,
+		stream:     route.Address,
+		svc:        kinesis.New(sess),
+		keyTmpl:    tmpl,
+		compress:   compress,
+		schema:     schema,
+		flushAfter: flushAfter,
+		hostname:   hostname,
+	}, nil
+}
+
+// Adapter sends log lines as JSON records to a Kinesis data stream.
+// Records of a container share a partition key by default, so they are
+// written to the same shard in order.
+type Adapter struct {
+	route      *router.Route
+	stream     string
+	svc        kinesisiface.KinesisAPI
+	keyTmpl    *template.Template
+	compress   format.Compressor
+	schema     int // of the envelopes of records
+	flushAfter time.Duration
+	hostname   string
+	shards     *shardMap
+}
+
+// Stream batches log lines and sends them with PutRecords
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	a.refreshShards()
+	ticker := time.NewTicker(a.flushAfter)
+	defer ticker.Stop()
+	refresh := time.NewTicker(shardRefresh)
+	defer refresh.Stop()
+	current := newBatch()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				a.put(current)
+				return
+			}
+			entry, err := a.entry(message)
+			if err != nil {
+				log.Println("kinesis:", err)
+				continue
+			}
+			shard := a.shards.shard(aws.StringValue(entry.PartitionKey))
+			size := recordSize(entry)
+			if size > maxRecordSize {
+				log.Printf("kinesis: dropping %d byte record, the limit is %d\n", size, maxRecordSize)
+				continue
+			}
+			if !current.fits(shard, size) {
+				a.put(current)
+				current = newBatch()
+			}
+			current.add(entry, message, shard, size)
+		case <-ticker.C:
+			a.put(current)
+			current = newBatch()
+		case <-refresh.C:
+			a.refreshShards()
+		}
+	}
+}
+
+// entry returns the PutRecords entry for a message
+func (a *Adapter) entry(message *router.Message) (*kinesis.PutRecordsRequestEntry, error) {
+	key, err := format.Render(a.keyTmpl, format.NewContext(message, a.hostname))
+	if err != nil {
+		return nil, fmt.Errorf("error rendering partition_key: %s", err)
+	}
+	if key == "" {
+		key = "unknown"
+	}
+	if len(key) > maxPartitionKeyLen {
+		// cut on the start of a rune, for the key to stay valid UTF-8
+		end := maxPartitionKeyLen
+		for end > 0 && !utf8.RuneStart(key[end]) {
+			end--
+		}
+		key = key[:end]
+	}
+	data, err := json.Marshal(router.NewEnvelopeOf(message, a.schema))
+	if err != nil {
+		return nil, err
+	}
+	if a.compress != nil {
+		if data, err = a.compress(data); err != nil {
+			return nil, err
+		}
+	}
+	return &kinesis.PutRecordsRequestEntry{Data: data, PartitionKey: aws.String(key)}, nil
+}
+
+// refreshShards updates the hash key ranges of the open shards of the
+// stream. Until they can be listed all records are batched as if they were
+// written to a single shard.
+func (a *Adapter) refreshShards() {
+	shards, err := a.listShards()
+	if err == nil {
+		var m *shardMap
+		if m, err = newShardMap(shards); err == nil {
+			a.shards = m
+			return
+		}
+	}
+	log.Printf("kinesis: error listing shards of %s: %s\n", a.stream, err)
+	if a.shards == nil {
+		a.shards = &shardMap{}
+	}
+}
+
+func (a *Adapter) listShards() ([]*kinesis.Shard, error) {
+	var shards []*kinesis.Shard
+	input := &kinesis.ListShardsInput{StreamName: aws.String(a.stream)}
+	for {
+		output, err := a.svc.ListShards(input)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, output.Shards...)
+		if output.NextToken == nil {
+			return shards, nil
+		}
+		input = &kinesis.ListShardsInput{NextToken: output.NextToken}
+	}
+}
+
+// put sends a batch of records, retrying the records that failed, or the
+// whole request if its error is not fatal. PutRecords does not keep the
+// order of the records of a request when some of them fail, so a batch
+// holding several records of a partition key is sent in rounds of one
+// record of each key, each once the one before it was sent. Records that
+// could not be sent are dead-lettered.
+func (a *Adapter) put(b *batch) {
+	if rounds := b.rounds(); len(rounds) > 1 {
+		for _, round := range rounds {
+			a.put(round)
+		}
+		return
+	}
+	records, messages := b.records, b.messages
+	reason := ""
+	for attempt := 0; attempt < maxAttempts && len(records) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(100<<uint(attempt)) * time.Millisecond)
+		}
+		output, err := a.svc.PutRecords(&kinesis.PutRecordsInput{
+			StreamName: aws.String(a.stream),
+			Records:    records,
+		})
+		if err != nil {
+			reason = err.Error()
+			if router.Classify(err) == router.ErrorFatal {
+				break
+			}
+			continue
+		}
+		if aws.Int64Value(output.FailedRecordCount) == 0 {
+			return
+		}
+		var failed []*kinesis.PutRecordsRequestEntry
+		var failedMessages []*router.Message
+		for i, result := range output.Records {
+			if result.ErrorCode != nil && i < len(records) {
+				failed = append(failed, records[i])
+				failedMessages = append(failedMessages, messages[i])
+				reason = aws.StringValue(result.ErrorCode)
+			}
+		}
+		records, messages = failed, failedMessages
+	}
+	if len(records) > 0 {
+		log.Printf("kinesis: dropping %d records, error sending to %s: %s\n", len(records), a.stream, reason)
+		router.WriteDeadLetters(a.route.ID, reason, messages...)
+	}
+}
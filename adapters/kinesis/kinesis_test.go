@@ -0,0 +1,119 @@
+package kinesis
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+type fakeKinesis struct {
+	kinesisiface.KinesisAPI
+	err     error
+	calls   int
+	fail    map[string]int // times to reject each record
+	written []string
+}
+
+func (f *fakeKinesis) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	for _, record := range input.Records {
+		result := &kinesis.PutRecordsResultEntry{}
+		if data := string(record.Data); f.fail[data] > 0 {
+			f.fail[data]--
+			result.ErrorCode = aws.String("ProvisionedThroughputExceededException")
+			*output.FailedRecordCount++
+		} else {
+			f.written = append(f.written, data)
+		}
+		output.Records = append(output.Records, result)
+	}
+	return output, nil
+}
+
+func TestPutRetriesByErrorClass(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{awserr.New("ServiceUnavailable", "try again", nil), maxAttempts},
+		{awserr.New("AccessDeniedException", "not allowed", nil), 1},
+	}
+	for _, test := range tests {
+		svc := &fakeKinesis{err: test.err}
+		a := &Adapter{route: &router.Route{ID: "test"}, stream: "stream", svc: svc}
+		b := newBatch()
+		entry := &kinesis.PutRecordsRequestEntry{Data: []byte("x")}
+		b.add(entry, &router.Message{Data: "x"}, 0, recordSize(entry))
+		a.put(b)
+		if svc.calls != test.expected {
+			t.Errorf("expected %d requests for %s, got %d", test.expected, test.err, svc.calls)
+		}
+	}
+}
+
+func TestPutKeepsOrderOfKeys(t *testing.T) {
+	svc := &fakeKinesis{fail: map[string]int{"a1": 1}}
+	a := &Adapter{route: &router.Route{ID: "test"}, stream: "stream", svc: svc}
+	b := newBatch()
+	for _, data := range []string{"a1", "b1", "a2", "a3", "b2"} {
+		entry := &kinesis.PutRecordsRequestEntry{Data: []byte(data), PartitionKey: aws.String(data[:1])}
+		b.add(entry, &router.Message{Data: data}, 0, recordSize(entry))
+	}
+	a.put(b)
+	var keyA, keyB []string
+	for _, data := range svc.written {
+		if data[0] == 'a' {
+			keyA = append(keyA, data)
+		} else {
+			keyB = append(keyB, data)
+		}
+	}
+	if strings.Join(keyA, ",") != "a1,a2,a3" || strings.Join(keyB, ",") != "b1,b2" {
+		t.Errorf("expected the records of each key in order after a partial failure, got %v", svc.written)
+	}
+}
+
+func TestBatchRoundsKeepOrderOfKeys(t *testing.T) {
+	b := newBatch()
+	for _, data := range []string{"a1", "b1", "a2", "a3", "b2"} {
+		entry := &kinesis.PutRecordsRequestEntry{Data: []byte(data), PartitionKey: aws.String(data[:1])}
+		b.add(entry, &router.Message{Data: data}, 0, recordSize(entry))
+	}
+	var rounds []string
+	for _, round := range b.rounds() {
+		var records []string
+		for _, record := range round.records {
+			records = append(records, string(record.Data))
+		}
+		rounds = append(rounds, strings.Join(records, ","))
+	}
+	if strings.Join(rounds, " ") != "a1,b1 a2,b2 a3" {
+		t.Errorf("expected rounds of one record of each key, in order, got %v", rounds)
+	}
+}
+
+func TestEntryCutsPartitionKeyOnRune(t *testing.T) {
+	name := "a" + strings.Repeat("é", maxPartitionKeyLen)
+	a := &Adapter{keyTmpl: template.Must(template.New("partition_key").Parse("{{.Name}}"))}
+	entry, err := a.entry(&router.Message{Container: &docker.Container{Name: "/" + name}, Data: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := *entry.PartitionKey
+	if len(key) != maxPartitionKeyLen-1 || !utf8.ValidString(key) || !strings.HasPrefix(name, key) {
+		t.Errorf("expected the key cut to the last whole rune within %d bytes, got %d bytes: %q", maxPartitionKeyLen, len(key), key)
+	}
+}
@@ -0,0 +1,110 @@
+package kinesis
+
+import (
+	"crypto/md5" //nolint:gosec
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// PutRecords and per shard limits, see
+// https://docs.aws.amazon.com/streams/latest/dev/service-sizes-and-limits.html
+const (
+	maxBatchCount  = 500
+	maxBatchSize   = 5 * 1024 * 1024 // bytes, including partition keys
+	maxRecordSize  = 1024 * 1024     // bytes, including the partition key
+	maxShardSize   = 1024 * 1024     // bytes per second
+	unknownShardID = -1
+)
+
+// shardMap maps partition keys to the open shards of a stream
+type shardMap struct {
+	ends []*big.Int // ending hash keys of the shards, in order
+}
+
+func newShardMap(shards []*kinesis.Shard) (*shardMap, error) {
+	m := &shardMap{}
+	for _, shard := range shards {
+		if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+			continue // closed by resharding
+		}
+		if shard.HashKeyRange == nil {
+			continue
+		}
+		end, ok := new(big.Int).SetString(aws.StringValue(shard.HashKeyRange.EndingHashKey), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid hash key range of shard %s", aws.StringValue(shard.ShardId))
+		}
+		m.ends = append(m.ends, end)
+	}
+	sort.Slice(m.ends, func(i, j int) bool { return m.ends[i].Cmp(m.ends[j]) < 0 })
+	return m, nil
+}
+
+// shard returns the index of the shard a partition key is written to, which
+// is the shard whose hash key range contains the MD5 hash of the key
+func (m *shardMap) shard(partitionKey string) int {
+	if len(m.ends) == 0 {
+		return unknownShardID
+	}
+	sum := md5.Sum([]byte(partitionKey)) //nolint:gosec
+	hash := new(big.Int).SetBytes(sum[:])
+	i := sort.Search(len(m.ends), func(i int) bool { return m.ends[i].Cmp(hash) >= 0 })
+	if i == len(m.ends) {
+		return len(m.ends) - 1
+	}
+	return i
+}
+
+// batch is a PutRecords request that stays within the request limits and
+// the per second write limit of each shard it writes to
+type batch struct {
+	records    []*kinesis.PutRecordsRequestEntry
+	messages   []*router.Message // of the records, to dead-letter them
+	size       int
+	shardSizes map[int]int
+}
+
+func newBatch() *batch {
+	return &batch{shardSizes: map[int]int{}}
+}
+
+// fits returns whether a record of the given size for a shard can be added
+func (b *batch) fits(shard, size int) bool {
+	return len(b.records) < maxBatchCount &&
+		b.size+size <= maxBatchSize &&
+		b.shardSizes[shard]+size <= maxShardSize
+}
+
+func (b *batch) add(entry *kinesis.PutRecordsRequestEntry, message *router.Message, shard, size int) {
+	b.records = append(b.records, entry)
+	b.messages = append(b.messages, message)
+	b.size += size
+	b.shardSizes[shard] += size
+}
+
+// rounds splits a batch into batches holding at most one record of each
+// partition key, for the records of a key to be sent one after the other
+func (b *batch) rounds() []*batch {
+	var rounds []*batch
+	counts := map[string]int{}
+	for i, record := range b.records {
+		key := aws.StringValue(record.PartitionKey)
+		n := counts[key]
+		counts[key]++
+		if n == len(rounds) {
+			rounds = append(rounds, newBatch())
+		}
+		rounds[n].add(record, b.messages[i], 0, recordSize(record))
+	}
+	return rounds
+}
+
+func recordSize(entry *kinesis.PutRecordsRequestEntry) int {
+	return len(entry.Data) + len(aws.StringValue(entry.PartitionKey))
+}
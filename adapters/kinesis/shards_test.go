@@ -0,0 +1,74 @@
+package kinesis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+func testShard(id, start, end string, closed bool) *kinesis.Shard {
+	shard := &kinesis.Shard{
+		ShardId:             aws.String(id),
+		HashKeyRange:        &kinesis.HashKeyRange{StartingHashKey: aws.String(start), EndingHashKey: aws.String(end)},
+		SequenceNumberRange: &kinesis.SequenceNumberRange{StartingSequenceNumber: aws.String("1")},
+	}
+	if closed {
+		shard.SequenceNumberRange.EndingSequenceNumber = aws.String("2")
+	}
+	return shard
+}
+
+func TestShardMap(t *testing.T) {
+	m, err := newShardMap([]*kinesis.Shard{
+		testShard("shardId-000000000002", "170141183460469231731687303715884105728",
+			"340282366920938463463374607431768211455", false),
+		testShard("shardId-000000000000", "0", "340282366920938463463374607431768211455", true),
+		testShard("shardId-000000000001", "0", "170141183460469231731687303715884105727", false),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// md5("a") = 0cc175b9..., md5("b") = 92eb5ffe...
+	for key, shard := range map[string]int{"a": 0, "b": 1} {
+		if got := m.shard(key); got != shard {
+			t.Errorf("expected shard %d for %q, got %d", shard, key, got)
+		}
+	}
+	if got := (&shardMap{}).shard("a"); got != unknownShardID {
+		t.Errorf("expected unknown shard without shards, got %d", got)
+	}
+	if _, err := newShardMap([]*kinesis.Shard{testShard("shardId-1", "0", "nope", false)}); err == nil {
+		t.Error("expected error for invalid hash key range, got nil")
+	}
+}
+
+func TestBatchShardLimits(t *testing.T) {
+	entry := &kinesis.PutRecordsRequestEntry{
+		Data:         []byte(strings.Repeat("x", 300*1024)),
+		PartitionKey: aws.String("a"),
+	}
+	size := recordSize(entry)
+	b := newBatch()
+	for i := 0; i < 3; i++ {
+		if !b.fits(0, size) {
+			t.Fatalf("expected record %d to fit", i)
+		}
+		b.add(entry, nil, 0, size)
+	}
+	if b.fits(0, size) {
+		t.Error("expected a fourth 300KiB record to exceed the shard limit")
+	}
+	if !b.fits(1, size) {
+		t.Error("expected a record for another shard to fit")
+	}
+	b = newBatch()
+	small := &kinesis.PutRecordsRequestEntry{Data: []byte("x"), PartitionKey: aws.String("a")}
+	for i := 0; i < maxBatchCount; i++ {
+		b.add(small, nil, i%4, recordSize(small))
+	}
+	if b.fits(5, recordSize(small)) {
+		t.Errorf("expected batch to be full after %d records", maxBatchCount)
+	}
+}
@@ -0,0 +1,350 @@
+// Package lua filters the log lines of a route with a Lua script, which may
+// change or drop each of them before they reach the adapter of the route.
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultFunction = "filter"
+	defaultTimeout  = 100 * time.Millisecond
+)
+
+// errorLogInterval is how often the errors of a script are logged at most
+const errorLogInterval = time.Minute
+
+func init() {
+	router.AdapterFactories.Register(NewLuaAdapter, "lua")
+}
+
+// Adapter calls the function of a script with each log line, as a table,
+// and sends the line it returns to the next adapter. The function drops the
+// line by returning false, and ships it as it changed it by returning
+// nothing or true, or another table. Lines the function fails on are sent
+// on unchanged.
+type Adapter struct {
+	route      *router.Route
+	subAdapter router.LogAdapter
+	proto      *lua.FunctionProto
+	function   string
+	timeout    time.Duration
+
+	state     *lua.LState
+	lastError time.Time // when an error of the script was last logged
+	failures  int       // errors since then
+}
+
+// NewLuaAdapter returns a lua.Adapter running the script of the script
+// route option before the adapter following lua+ in the adapter of the
+// route
+func NewLuaAdapter(route *router.Route) (router.LogAdapter, error) {
+	path := route.Options["script"]
+	if path == "" {
+		return nil, errors.New("lua: the script option must be the path of a Lua script")
+	}
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("lua: %s", err)
+	}
+	chunk, err := parse.Parse(strings.NewReader(string(source)), path)
+	if err != nil {
+		return nil, fmt.Errorf("lua: %s", err)
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, fmt.Errorf("lua: %s", err)
+	}
+	function := defaultFunction
+	if s := route.Options["function"]; s != "" {
+		function = s
+	}
+	timeout := defaultTimeout
+	if s := route.Options["script_timeout"]; s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("lua: invalid value for script_timeout (must be a positive duration): %s", s)
+		}
+	}
+
+	parts := strings.SplitN(route.Adapter, "+", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("lua: adapter must have a sub-adapter, eg: lua+raw+tcp")
+	}
+	originalAdapter := route.Adapter
+	route.Adapter = parts[1]
+	factory, found := router.AdapterFactories.Lookup(route.AdapterType())
+	if !found {
+		route.Adapter = originalAdapter
+		return nil, errors.New("bad adapter: " + originalAdapter)
+	}
+	subAdapter, err := factory(route)
+	route.Adapter = originalAdapter
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Adapter{route: route, subAdapter: subAdapter, proto: proto, function: function, timeout: timeout}
+	// the script runs once to check it defines the function
+	state, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	state.Close()
+	return a, nil
+}
+
+// load runs the script in a new Lua state, with the libraries that do not
+// reach outside of it
+func (a *Adapter) load() (*lua.LState, error) {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	state.Push(state.NewFunctionFromProto(a.proto))
+	err := state.PCall(0, lua.MultRet, nil)
+	state.RemoveContext()
+	if err != nil {
+		state.Close()
+		return nil, fmt.Errorf("lua: %s", err)
+	}
+	if _, ok := state.GetGlobal(a.function).(*lua.LFunction); !ok {
+		state.Close()
+		return nil, fmt.Errorf("lua: the script does not define the function %s", a.function)
+	}
+	return state, nil
+}
+
+// Stream sends the lines the script returns to the next adapter
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	out := router.NewQueue(router.StageTransform, a.route.ID, cfg.GetInt("TRANSFORM_QUEUE_SIZE"))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		a.subAdapter.Stream(out.C)
+		wg.Done()
+	}()
+	defer func() {
+		out.Close()
+		wg.Wait()
+		if a.state != nil {
+			a.state.Close()
+		}
+	}()
+	for message := range logstream {
+		if filtered, ok := a.filter(message); ok {
+			out.Send(filtered)
+		}
+	}
+}
+
+// filter returns the line the script returns for a line, and false if it
+// drops it
+func (a *Adapter) filter(message *router.Message) (*router.Message, bool) {
+	if a.state == nil {
+		state, err := a.load()
+		if err != nil {
+			a.fail(err)
+			return message, true
+		}
+		a.state = state
+	}
+	filtered, ok, err := a.call(a.state, message)
+	if err != nil {
+		// the state may be left inconsistent, the script runs again
+		a.state.Close()
+		a.state = nil
+		a.fail(err)
+		return message, true
+	}
+	return filtered, ok
+}
+
+// call returns the line the function of the script returns for a line in
+// a state, and false if it drops it
+func (a *Adapter) call(state *lua.LState, message *router.Message) (*router.Message, bool, error) {
+	event := newEvent(state, message)
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	err := state.CallByParam(lua.P{Fn: state.GetGlobal(a.function), NRet: 1, Protect: true}, event)
+	state.RemoveContext()
+	if err != nil {
+		return nil, false, err
+	}
+	result := state.Get(-1)
+	state.Pop(1)
+	switch result := result.(type) {
+	case lua.LBool:
+		if !result {
+			return nil, false, nil
+		}
+	case *lua.LTable:
+		event = result
+	case *lua.LNilType:
+	default:
+		return nil, false, fmt.Errorf("%s returned a %s, not a table or boolean", a.function, result.Type())
+	}
+	return eventMessage(event, message), true, nil
+}
+
+// Render returns a line as the next adapter would write it once the script
+// changed it, or the line the script returns if the next adapter does not
+// render lines. The script runs in a state of its own, not to change that
+// of the lines of the route.
+func (a *Adapter) Render(message *router.Message) ([]byte, error) {
+	state, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	defer state.Close()
+	filtered, ok, err := a.call(state, message)
+	if err != nil {
+		return nil, fmt.Errorf("lua: %s", err)
+	}
+	if !ok {
+		return nil, router.ErrDropped
+	}
+	if renderer, ok := a.subAdapter.(router.Renderer); ok {
+		return renderer.Render(filtered)
+	}
+	return []byte(filtered.Data), nil
+}
+
+// fail logs an error of the script, at most once every errorLogInterval
+func (a *Adapter) fail(err error) {
+	a.failures++
+	if time.Since(a.lastError) < errorLogInterval {
+		return
+	}
+	log.Printf("lua: route %s: %s (%d errors), sending lines on unchanged\n", a.route.ID, err, a.failures)
+	a.lastError, a.failures = time.Now(), 0
+}
+
+// Healthy reports the health of the next adapter
+func (a *Adapter) Healthy() bool {
+	if checker, ok := a.subAdapter.(router.HealthChecker); ok {
+		return checker.Healthy()
+	}
+	return true
+}
+
+// Flush flushes the next adapter
+func (a *Adapter) Flush() {
+	if flusher, ok := a.subAdapter.(router.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// FlushContainer flushes the lines of a container from the next adapter
+func (a *Adapter) FlushContainer(id string) {
+	if flusher, ok := a.subAdapter.(router.ContainerFlusher); ok {
+		flusher.FlushContainer(id)
+	}
+}
+
+// FlushStream flushes the lines of a stream from the next adapter
+func (a *Adapter) FlushStream(name string) bool {
+	if flusher, ok := a.subAdapter.(router.StreamFlusher); ok {
+		return flusher.FlushStream(name)
+	}
+	return true
+}
+
+// newEvent returns the table a line is passed to the script as
+func newEvent(state *lua.LState, message *router.Message) *lua.LTable {
+	event := state.NewTable()
+	event.RawSetString("data", lua.LString(message.Data))
+	event.RawSetString("source", lua.LString(message.Source))
+	event.RawSetString("time", lua.LString(message.Time.Format(time.RFC3339Nano)))
+	container := state.NewTable()
+	labels := state.NewTable()
+	if c := message.Container; c != nil {
+		container.RawSetString("id", lua.LString(c.ID))
+		container.RawSetString("name", lua.LString(strings.TrimPrefix(c.Name, "/")))
+		if c.Config != nil {
+			container.RawSetString("image", lua.LString(c.Config.Image))
+			container.RawSetString("hostname", lua.LString(c.Config.Hostname))
+			for key, value := range c.Config.Labels {
+				labels.RawSetString(key, lua.LString(value))
+			}
+		}
+	}
+	container.RawSetString("labels", labels)
+	event.RawSetString("container", container)
+	return event
+}
+
+// eventMessage returns a line as the script changed it. Only its data,
+// source, time and labels can be changed.
+func eventMessage(event *lua.LTable, message *router.Message) *router.Message {
+	changed := *message
+	if data, ok := event.RawGetString("data").(lua.LString); ok {
+		changed.Data = string(data)
+	}
+	if source, ok := event.RawGetString("source").(lua.LString); ok {
+		changed.Source = string(source)
+	}
+	if s, ok := event.RawGetString("time").(lua.LString); ok {
+		if logged, err := time.Parse(time.RFC3339Nano, string(s)); err == nil {
+			changed.Time = logged
+		}
+	}
+	container, _ := event.RawGetString("container").(*lua.LTable)
+	if container == nil || message.Container == nil || message.Container.Config == nil {
+		return &changed
+	}
+	labels, _ := container.RawGetString("labels").(*lua.LTable)
+	if labels == nil || sameLabels(labels, message.Container.Config.Labels) {
+		return &changed
+	}
+	// the container is shared by the lines of every route
+	copied, config := *message.Container, *message.Container.Config
+	config.Labels = map[string]string{}
+	labels.ForEach(func(key, value lua.LValue) {
+		if key.Type() == lua.LTString {
+			config.Labels[key.String()] = value.String()
+		}
+	})
+	copied.Config = &config
+	changed.Container = &copied
+	return &changed
+}
+
+// sameLabels returns whether the script left the labels of a container as
+// they were
+func sameLabels(table *lua.LTable, labels map[string]string) bool {
+	same, n := true, 0
+	table.ForEach(func(key, value lua.LValue) {
+		n++
+		if v, ok := labels[key.String()]; !ok || v != value.String() {
+			same = false
+		}
+	})
+	return same && n == len(labels)
+}
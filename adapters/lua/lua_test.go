@@ -0,0 +1,134 @@
+package lua
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+type testAdapter struct{ lines chan *router.Message }
+
+func (a *testAdapter) Stream(logstream chan *router.Message) {
+	for m := range logstream {
+		a.lines <- m
+	}
+}
+
+var sink = &testAdapter{lines: make(chan *router.Message, 10)}
+
+func init() {
+	router.AdapterFactories.Register(func(*router.Route) (router.LogAdapter, error) { return sink, nil }, "luatest")
+}
+
+const script = `
+function filter(event)
+	if string.find(event.data, "debug") then
+		return false
+	end
+	if event.data == "fail" then
+		error("failing")
+	end
+	if event.data == "loop" then
+		while true do end
+	end
+	event.data = string.upper(event.data)
+	event.container.labels.team = "core"
+end
+`
+
+func TestLuaAdapter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.lua")
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	route := &router.Route{ID: "test", Adapter: "lua+luatest", Options: map[string]string{"script": path, "script_timeout": "50ms"}}
+	adapter, err := NewLuaAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Adapter != "lua+luatest" {
+		t.Errorf("expected the adapter of the route to be restored, got %s", route.Adapter)
+	}
+	logstream := make(chan *router.Message)
+	go adapter.Stream(logstream)
+	defer close(logstream)
+
+	container := &docker.Container{ID: "abc", Name: "/api", Config: &docker.Config{Labels: map[string]string{"app": "api"}}}
+	for _, data := range []string{"a debug line", "hello", "fail", "loop", "again"} {
+		logstream <- &router.Message{Container: container, Source: "stdout", Data: data, Time: time.Now()}
+	}
+	for _, expected := range []string{"HELLO", "fail", "loop", "AGAIN"} {
+		select {
+		case m := <-sink.lines:
+			if m.Data != expected {
+				t.Errorf("expected %q, got %q", expected, m.Data)
+			}
+			if expected == "HELLO" && (m.Container.Config.Labels["team"] != "core" || m.Container.Config.Labels["app"] != "api") {
+				t.Errorf("unexpected labels %v", m.Container.Config.Labels)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %q", expected)
+		}
+	}
+	if _, changed := container.Config.Labels["team"]; changed {
+		t.Error("expected the labels of the shared container not to change")
+	}
+}
+
+func TestLuaAdapterRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.lua")
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	route := &router.Route{ID: "test", Adapter: "lua+luatest", Options: map[string]string{"script": path}}
+	adapter, err := NewLuaAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer := adapter.(router.Renderer)
+	container := &docker.Container{ID: "abc", Name: "/api", Config: &docker.Config{}}
+	if output, err := renderer.Render(&router.Message{Container: container, Data: "hello"}); err != nil || string(output) != "HELLO" {
+		t.Errorf("expected the line as the script changed it, got %q %v", output, err)
+	}
+	if _, err := renderer.Render(&router.Message{Container: container, Data: "a debug line"}); err != router.ErrDropped {
+		t.Errorf("expected the line the script drops to be reported, got %v", err)
+	}
+	if _, err := renderer.Render(&router.Message{Container: container, Data: "fail"}); err == nil {
+		t.Error("expected the error of the script to be returned")
+	}
+}
+
+func TestLuaAdapterInvalidScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.lua")
+	for _, source := range []string{"function filter(", "function other() end"} {
+		if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		route := &router.Route{Adapter: "lua+luatest", Options: map[string]string{"script": path}}
+		if _, err := NewLuaAdapter(route); err == nil {
+			t.Errorf("expected %q to be refused", source)
+		}
+	}
+}
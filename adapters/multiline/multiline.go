@@ -2,15 +2,14 @@ package multiline
 
 import (
 	"errors"
-	"os"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
 )
 
@@ -23,11 +22,32 @@ const (
 
 func init() {
 	router.AdapterFactories.Register(NewMultilineAdapter, "multiline")
+	cfg.Register(
+		cfg.Option{Name: "MULTILINE_ENABLE_DEFAULT", Type: cfg.Bool, Default: "true",
+			Description: "enable multiline logging for all containers, not only those setting LOGSPOUT_MULTILINE"},
+		cfg.Option{Name: "MULTILINE_PATTERN", Default: `^\s`, Validate: validatePattern,
+			Description: "pattern of the lines MULTILINE_MATCH matches"},
+		cfg.Option{Name: "MULTILINE_SEPARATOR", Default: "\n",
+			Description: "separator between the lines of a multiline log entry"},
+		cfg.Option{Name: "MULTILINE_MATCH", Default: matchNonFirst, Validate: validateMatch,
+			Description: "which lines the pattern matches, one of first, last, nonfirst or nonlast"},
+		cfg.Option{Name: "MULTILINE_FLUSH_AFTER", Type: cfg.Int, Default: "500", Validate: cfg.NotNegative,
+			Description: "maximum milliseconds between the first and last lines of a multiline log entry"},
+	)
+}
+
+func validatePattern(value string) error {
+	_, err := regexp.Compile(value)
+	return err
+}
+
+func validateMatch(value string) error {
+	return cfg.OneOf(matchFirst, matchLast, matchNonFirst, matchNonLast)(strings.ToLower(value))
 }
 
 // Adapter collects multi-lint log entries and sends them to the next adapter as a single entry
 type Adapter struct {
-	out             chan *router.Message
+	out             *router.Queue
 	subAdapter      router.LogAdapter
 	enableByDefault bool
 	pattern         *regexp.Regexp
@@ -42,34 +62,15 @@ type Adapter struct {
 
 // NewMultilineAdapter returns a configured multiline.Adapter
 func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) { //nolint:gocyclo
-	enableByDefault := true
-	enableStr := os.Getenv("MULTILINE_ENABLE_DEFAULT")
-	if enableStr != "" {
-		enableByDefault, err = strconv.ParseBool(enableStr)
-		if err != nil {
-			return nil, errors.New("multiline: invalid value for MULTILINE_ENABLE_DEFAULT (must be true|false): " + enableStr)
-		}
-	}
-
-	pattern := os.Getenv("MULTILINE_PATTERN")
-	if pattern == "" {
-		pattern = `^\s`
-	}
-
-	separator := os.Getenv("MULTILINE_SEPARATOR")
-	if separator == "" {
-		separator = "\n"
-	}
+	enableByDefault := cfg.GetBool("MULTILINE_ENABLE_DEFAULT")
+	pattern := cfg.GetString("MULTILINE_PATTERN")
+	separator := cfg.GetString("MULTILINE_SEPARATOR")
 	patternRegexp, err := regexp.Compile(pattern)
 	if err != nil {
 		return nil, errors.New("multiline: invalid value for MULTILINE_PATTERN (must be regexp): " + pattern)
 	}
 
-	matchType := os.Getenv("MULTILINE_MATCH")
-	if matchType == "" {
-		matchType = matchNonFirst
-	}
-	matchType = strings.ToLower(matchType)
+	matchType := strings.ToLower(cfg.GetString("MULTILINE_MATCH"))
 	matchFirstLine := false
 	negateMatch := false
 	switch matchType {
@@ -89,15 +90,7 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 		return nil, errors.New("multiline: invalid value for MULTILINE_MATCH (must be one of first|last|nonfirst|nonlast): " + matchType)
 	}
 
-	flushAfter := 500 * time.Millisecond
-	flushAfterStr := os.Getenv("MULTILINE_FLUSH_AFTER")
-	if flushAfterStr != "" {
-		timeoutMS, errConv := strconv.Atoi(flushAfterStr)
-		if errConv != nil {
-			return nil, errors.New("multiline: invalid value for multiline_timeout (must be number): " + flushAfterStr)
-		}
-		flushAfter = time.Duration(timeoutMS) * time.Millisecond
-	}
+	flushAfter := time.Duration(cfg.GetInt("MULTILINE_FLUSH_AFTER")) * time.Millisecond
 
 	parts := strings.SplitN(route.Adapter, "+", 2)
 	if len(parts) != 2 {
@@ -116,7 +109,7 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 	}
 	route.Adapter = originalAdapter
 
-	out := make(chan *router.Message)
+	out := router.NewQueue(router.StageTransform, route.ID, cfg.GetInt("TRANSFORM_QUEUE_SIZE"))
 	checkInterval := flushAfter / 2
 
 	return &Adapter{
@@ -139,15 +132,15 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		a.subAdapter.Stream(a.out)
+		a.subAdapter.Stream(a.out.C)
 		wg.Done()
 	}()
 	defer func() {
 		for _, message := range a.buffers {
-			a.out <- message
+			a.out.Send(message)
 		}
 
-		close(a.out)
+		a.out.Close()
 		wg.Wait()
 	}()
 
@@ -159,7 +152,7 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo
 			}
 
 			if !multilineContainer(message.Container, a.enableByDefault) {
-				a.out <- message
+				a.out.Send(message)
 				continue
 			}
 
@@ -167,7 +160,7 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo
 			old, oldExists := a.buffers[cID]
 			if a.isFirstLine(message) {
 				if oldExists {
-					a.out <- old
+					a.out.Send(old)
 				}
 
 				a.buffers[cID] = message
@@ -180,7 +173,7 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo
 				}
 
 				if isLastLine {
-					a.out <- message
+					a.out.Send(message)
 					if oldExists {
 						delete(a.buffers, cID)
 					}
@@ -193,7 +186,7 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo
 
 			for key, message := range a.buffers {
 				if message.Time.Add(a.flushAfter).After(now) {
-					a.out <- message
+					a.out.Send(message)
 					delete(a.buffers, key)
 				}
 			}
@@ -203,6 +196,15 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo
 	}
 }
 
+// Render returns a line as the next adapter would write it, on its own, as
+// the lines of an entry are joined only as they are streamed
+func (a *Adapter) Render(message *router.Message) ([]byte, error) {
+	if renderer, ok := a.subAdapter.(router.Renderer); ok {
+		return renderer.Render(message)
+	}
+	return nil, nil
+}
+
 func (a *Adapter) isFirstLine(message *router.Message) bool {
 	if !a.matchFirstLine {
 		return false
@@ -132,7 +132,7 @@ func TestMultiline(t *testing.T) {
 
 	for _, test := range tests {
 		in := make(chan *router.Message)
-		out := make(chan *router.Message)
+		out := router.NewQueue(router.StageTransform, "test", 0)
 		container := &docker.Container{
 			ID:     "test",
 			Config: &docker.Config{},
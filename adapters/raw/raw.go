@@ -6,14 +6,23 @@ import (
 	"errors"
 	"log"
 	"net"
-	"os"
+	"strconv"
+	"strings"
 	"text/template"
 
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
 )
 
 func init() {
 	router.AdapterFactories.Register(NewRawAdapter, "raw")
+	cfg.Register(cfg.Option{Name: "RAW_FORMAT", Default: "{{.Data}}\n", Validate: validateFormat,
+		Description: "template of the lines the raw adapter writes"})
+}
+
+func validateFormat(value string) error {
+	_, err := template.New("raw").Funcs(funcs).Parse(value)
+	return err
 }
 
 var funcs = template.FuncMap{
@@ -37,33 +46,42 @@ func NewRawAdapter(route *router.Route) (router.LogAdapter, error) {
 	if err != nil {
 		return nil, err
 	}
-	tmplStr := "{{.Data}}\n"
-	if os.Getenv("RAW_FORMAT") != "" {
-		tmplStr = os.Getenv("RAW_FORMAT")
-	}
-	tmpl, err := template.New("raw").Funcs(funcs).Parse(tmplStr)
+	tmpl, err := template.New("raw").Funcs(funcs).Parse(cfg.GetString("RAW_FORMAT"))
 	if err != nil {
 		return nil, err
 	}
+	var prefix []string
+	if s := route.Options["prefix"]; s != "" {
+		prefix = strings.Split(s, ",")
+		for _, field := range prefix {
+			if _, ok := new(router.Envelope).Field(field); !ok {
+				return nil, errors.New("raw: unknown prefix field: " + field)
+			}
+		}
+	}
 	return &Adapter{
-		route: route,
-		conn:  conn,
-		tmpl:  tmpl,
+		route:  route,
+		conn:   conn,
+		tmpl:   tmpl,
+		prefix: prefix,
 	}, nil
 }
 
 // Adapter is a simple adapter that streams log output to a connection without any templating
 type Adapter struct {
-	conn  net.Conn
-	route *router.Route
-	tmpl  *template.Template
+	conn   net.Conn
+	route  *router.Route
+	tmpl   *template.Template
+	prefix []string // envelope fields written before each line
 }
 
 // Stream sends log data to a connection
 func (a *Adapter) Stream(logstream chan *router.Message) {
+	var buf bytes.Buffer // reused, as the write is done with it
 	for message := range logstream {
-		buf := new(bytes.Buffer)
-		err := a.tmpl.Execute(buf, message)
+		buf.Reset()
+		a.writePrefix(&buf, message)
+		err := a.tmpl.Execute(&buf, message)
 		if err != nil {
 			log.Println("raw:", err)
 			return
@@ -77,3 +95,31 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 		}
 	}
 }
+
+// Render returns a message as the adapter writes it
+func (a *Adapter) Render(message *router.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	a.writePrefix(&buf, message)
+	err := a.tmpl.Execute(&buf, message)
+	return buf.Bytes(), err
+}
+
+// writePrefix writes the prefix fields of a message as name=value pairs
+// followed by a space, so receivers can demultiplex lines without parsing
+// them. Values with spaces, quotes or equal signs, or empty ones, are quoted.
+func (a *Adapter) writePrefix(buf *bytes.Buffer, message *router.Message) {
+	if len(a.prefix) == 0 {
+		return
+	}
+	envelope := router.NewEnvelope(message)
+	for _, field := range a.prefix {
+		value, _ := envelope.Field(field)
+		buf.WriteString(field)
+		buf.WriteByte('=')
+		if value == "" || strings.ContainsAny(value, " \"=\t\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+		buf.WriteByte(' ')
+	}
+}
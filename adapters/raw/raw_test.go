@@ -0,0 +1,37 @@
+package raw
+
+import (
+	"bytes"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestWritePrefix(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		prefix   []string
+		labels   map[string]string
+		expected string
+	}{
+		{"none", nil, nil, ""},
+		{"plain", []string{"container_name", "source"}, nil, "container_name=web source=stdout "},
+		{"space", []string{"label.team"}, map[string]string{"team": "data platform"}, `label.team="data platform" `},
+		{"equals", []string{"label.query"}, map[string]string{"query": "a=b"}, `label.query="a=b" `},
+		{"quotes", []string{"label.quote"}, map[string]string{"quote": `say "hi"`}, `label.quote="say \"hi\"" `},
+		{"empty", []string{"label.missing", "source"}, nil, `label.missing="" source=stdout `},
+	} {
+		adapter := &Adapter{prefix: test.prefix}
+		message := &router.Message{
+			Container: &docker.Container{Name: "/web", Config: &docker.Config{Labels: test.labels}},
+			Source:    "stdout",
+		}
+		var buf bytes.Buffer
+		adapter.writePrefix(&buf, message)
+		if buf.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package s3
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+)
+
+// the default partitioning is by day, as YYYY/MM/DD of the UTC time of lines
+const defaultKeyTemplate = `{{.UTC "2006/01/02"}}`
+
+// renderPartition returns the partition of the key for a log line, without
+// leading or trailing slashes
+func renderPartition(tmpl *template.Template, context *format.Context) (string, error) {
+	partition, err := format.Render(tmpl, context)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(partition, "/"), nil
+}
@@ -0,0 +1,41 @@
+package s3
+
+import (
+	"testing"
+	"text/template"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestRenderPartition(t *testing.T) {
+	message := &router.Message{
+		Container: &docker.Container{
+			ID:     "8dfafdbc3a40",
+			Name:   "/web",
+			Config: &docker.Config{Labels: map[string]string{"team": "logging"}},
+		},
+		Time: time.Date(2020, 6, 1, 23, 30, 0, 0, time.FixedZone("EST", -5*3600)),
+	}
+	tests := []struct {
+		tmpl string
+		out  string
+	}{
+		{defaultKeyTemplate, "2020/06/02"},
+		{"dt={{.Date}}/hour={{.Hour}}/container={{.Name}}/", "dt=2020-06-02/hour=04/container=web"},
+		{`/team={{.Lbl "team"}}/missing={{.Lbl "nope"}}`, "team=logging/missing="},
+	}
+	for _, test := range tests {
+		tmpl := template.Must(template.New("key").Parse(test.tmpl))
+		out, err := renderPartition(tmpl, format.NewContext(message, "logspout"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != test.out {
+			t.Errorf("expected %q got %q", test.out, out)
+		}
+	}
+}
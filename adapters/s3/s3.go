@@ -0,0 +1,243 @@
+package s3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+	"github.com/gliderlabs/logspout/adapters/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultMaxSize    = 5 * 1024 * 1024 // bytes
+	defaultFlushAfter = time.Minute
+	maxAttempts       = 3 // of uploads that failed with an error that is not fatal
+)
+
+func init() {
+	router.AdapterFactories.Register(NewS3Adapter, "s3")
+	router.RegisterPathAdapter("s3")
+}
+
+// NewS3Adapter returns a configured s3.Adapter
+func NewS3Adapter(route *router.Route) (router.LogAdapter, error) {
+	var err error
+	parts := strings.SplitN(route.Address, "/", 2)
+	bucket := parts[0]
+	if bucket == "" {
+		return nil, errors.New("s3: route address must be a bucket name, eg: s3://my-bucket/logs")
+	}
+	prefix := ""
+	if len(parts) > 1 {
+		prefix = strings.Trim(parts[1], "/")
+	}
+	var formatter format.Formatter
+	parquet := route.Options["format"] == format.Parquet
+	if !parquet {
+		if formatter, err = format.New(route); err != nil {
+			return nil, err
+		}
+	}
+	maxSize := defaultMaxSize
+	if s := route.Options["max_size"]; s != "" {
+		if maxSize, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("s3: invalid value for max_size (must be bytes): %s", s)
+		}
+	}
+	flushAfter := defaultFlushAfter
+	if s := route.Options["flush_after"]; s != "" {
+		if flushAfter, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("s3: invalid value for flush_after (must be duration): %s", s)
+		}
+	}
+	keyTemplate := defaultKeyTemplate
+	if s := route.Options["key_template"]; s != "" {
+		keyTemplate = s
+	}
+	tmpl, err := template.New("key").Parse(keyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid value for key_template: %s", err)
+	}
+	config := httpclient.AWSConfig()
+	if region := route.Options["region"]; region != "" {
+		config = config.WithRegion(region)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &Adapter{
+		route:      route,
+		bucket:     bucket,
+		prefix:     prefix,
+		formatter:  formatter,
+		parquet:    parquet,
+		keyTmpl:    tmpl,
+		uploader:   s3manager.NewUploader(sess),
+		maxSize:    maxSize,
+		flushAfter: flushAfter,
+		hostname:   hostname,
+	}, nil
+}
+
+// Adapter buffers log output into objects that are uploaded to an S3 bucket
+// once they reach max_size bytes, or flush_after has passed.
+type Adapter struct {
+	route      *router.Route
+	bucket     string
+	prefix     string
+	formatter  format.Formatter
+	parquet    bool // objects are encoded as Parquet when uploaded
+	keyTmpl    *template.Template
+	uploader   *s3manager.Uploader
+	maxSize    int
+	flushAfter time.Duration
+	hostname   string
+	seq        int
+}
+
+// object is the buffered content of a single S3 object
+type object struct {
+	partition string
+	buf       *bytes.Buffer
+	messages  []*router.Message // buffered instead of buf for Parquet objects
+	size      int
+	started   time.Time
+	lines     int
+}
+
+func (a *Adapter) newObject(partition string) *object {
+	o := &object{partition: partition, buf: new(bytes.Buffer), started: time.Now()}
+	if !a.parquet {
+		o.buf.Write(a.formatter.Header())
+	}
+	return o
+}
+
+// add buffers a message in the object
+func (a *Adapter) add(o *object, message *router.Message) error {
+	if a.parquet {
+		o.messages = append(o.messages, message)
+		o.size += len(message.Data)
+	} else {
+		line, err := a.formatter.Format(message)
+		if err != nil {
+			return err
+		}
+		o.buf.Write(line)
+		o.size = o.buf.Len()
+	}
+	if o.lines == 0 {
+		o.started = time.Now()
+	}
+	o.lines++
+	return nil
+}
+
+// Stream buffers log data in an object per partition, and uploads them to S3
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushAfter / 4)
+	defer ticker.Stop()
+	objects := map[string]*object{}
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				for _, o := range objects {
+					a.upload(o)
+				}
+				return
+			}
+			partition, err := renderPartition(a.keyTmpl, format.NewContext(message, a.hostname))
+			if err != nil {
+				log.Println("s3: error rendering key_template:", err)
+				continue
+			}
+			current, exists := objects[partition]
+			if !exists {
+				current = a.newObject(partition)
+				objects[partition] = current
+			}
+			if err := a.add(current, message); err != nil {
+				log.Println("s3:", err)
+				continue
+			}
+			if current.size >= a.maxSize {
+				a.upload(current)
+				delete(objects, partition)
+			}
+		case <-ticker.C:
+			for partition, o := range objects {
+				if time.Since(o.started) >= a.flushAfter {
+					a.upload(o)
+					delete(objects, partition)
+				}
+			}
+		}
+	}
+}
+
+func (a *Adapter) upload(o *object) {
+	if o.lines == 0 {
+		return
+	}
+	key := a.key(o)
+	body := o.buf.Bytes()
+	if a.parquet {
+		var err error
+		if body, err = format.EncodeParquet(o.messages); err != nil {
+			log.Printf("s3: dropping %d lines, error encoding %s: %s\n", o.lines, key, err)
+			return
+		}
+	}
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(100<<uint(attempt)) * time.Millisecond)
+		}
+		_, err = a.uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(a.bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(body),
+		})
+		if err == nil || router.Classify(err) == router.ErrorFatal {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("s3: dropping %d lines, error uploading %s: %s\n", o.lines, key, err)
+	}
+}
+
+// key returns the key to upload an object to
+func (a *Adapter) key(o *object) string {
+	a.seq++
+	name := fmt.Sprintf("%s-%s-%d.%s", o.started.UTC().Format("150405"), a.hostname, a.seq, a.extension())
+	parts := []string{}
+	for _, part := range []string{a.prefix, o.partition, name} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (a *Adapter) extension() string {
+	if a.parquet {
+		return format.Parquet
+	}
+	return a.formatter.Extension()
+}
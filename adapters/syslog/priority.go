@@ -0,0 +1,148 @@
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// FacilityLabel is the container label that overrides the syslog facility
+// of the container's messages
+const FacilityLabel = "logspout.syslog.facility"
+
+var facilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+var severities = map[string]syslog.Priority{
+	"emerg":   syslog.LOG_EMERG,
+	"alert":   syslog.LOG_ALERT,
+	"crit":    syslog.LOG_CRIT,
+	"err":     syslog.LOG_ERR,
+	"error":   syslog.LOG_ERR,
+	"warning": syslog.LOG_WARNING,
+	"warn":    syslog.LOG_WARNING,
+	"notice":  syslog.LOG_NOTICE,
+	"info":    syslog.LOG_INFO,
+	"debug":   syslog.LOG_DEBUG,
+}
+
+// priorities maps messages to a syslog facility and severity
+type priorities struct {
+	facility       syslog.Priority
+	facilitySet    bool // otherwise the facility depends on the message source
+	stdoutSeverity syslog.Priority
+	stderrSeverity syslog.Priority
+	detectLevel    bool
+}
+
+// getOption returns the value of the given route option, or the ENV var,
+// or the provided default value
+func getOption(route *router.Route, key, envKey, defaultVal string) string {
+	if value := route.Options[key]; value != "" {
+		return value
+	}
+	if value := cfg.GetString(envKey); value != "" {
+		return value
+	}
+	return defaultVal
+}
+
+func validateFacility(value string) error {
+	if _, ok := facilities[strings.ToLower(value)]; !ok {
+		return errors.New("unknown syslog facility")
+	}
+	return nil
+}
+
+func validateSeverity(value string) error {
+	if _, ok := severities[strings.ToLower(value)]; !ok {
+		return errors.New("unknown syslog severity")
+	}
+	return nil
+}
+
+func getPriorities(route *router.Route) (*priorities, error) {
+	var p priorities
+	if s := getOption(route, "facility", "SYSLOG_FACILITY", ""); s != "" {
+		facility, ok := facilities[strings.ToLower(s)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility: %s", s)
+		}
+		p.facility, p.facilitySet = facility, true
+	}
+	for _, severity := range []struct {
+		key, envKey, defaultVal string
+		value                   *syslog.Priority
+	}{
+		{"stdout_severity", "SYSLOG_STDOUT_SEVERITY", "info", &p.stdoutSeverity},
+		{"stderr_severity", "SYSLOG_STDERR_SEVERITY", "err", &p.stderrSeverity},
+	} {
+		s := getOption(route, severity.key, severity.envKey, severity.defaultVal)
+		value, ok := severities[strings.ToLower(s)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog severity for %s: %s", severity.key, s)
+		}
+		*severity.value = value
+	}
+	if s := route.Options["detect_level"]; s != "" {
+		p.detectLevel = s == "true"
+	} else {
+		p.detectLevel = cfg.GetBool("SYSLOG_DETECT_LEVEL")
+	}
+	return &p, nil
+}
+
+// priority returns the priority of a message. A level detected in the
+// message takes precedence over the severity of its source, and the
+// facility label of its container over the route's facility.
+func (p *priorities) priority(m *router.Message) syslog.Priority {
+	facility := syslog.LOG_DAEMON
+	if p.facilitySet {
+		facility = p.facility
+	} else if m.Source == "stdout" || m.Source == "stderr" {
+		facility = syslog.LOG_USER
+	}
+	if m.Container != nil && m.Container.Config != nil {
+		if label, ok := facilities[strings.ToLower(m.Container.Config.Labels[FacilityLabel])]; ok {
+			facility = label
+		}
+	}
+
+	severity := syslog.LOG_INFO
+	switch m.Source {
+	case "stdout":
+		severity = p.stdoutSeverity
+	case "stderr":
+		severity = p.stderrSeverity
+	}
+	if p.detectLevel {
+		if level := router.DetectLevel(m.Data); level != router.LevelUnknown {
+			severity = severities[string(level)]
+		}
+	}
+	return facility | severity
+}
@@ -12,7 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"text/template"
 	"time"
 
@@ -49,22 +49,59 @@ type TCPFraming string
 func init() {
 	hostname, _ = os.Hostname()
 	router.AdapterFactories.Register(NewSyslogAdapter, "syslog")
+	cfg.Register(
+		cfg.Option{Name: "SYSLOG_FORMAT", Default: string(defaultFormat),
+			Validate:    cfg.OneOf(string(Rfc5424Format), string(Rfc3164Format)),
+			Description: "syslog format to emit, or per route with the format option"},
+		cfg.Option{Name: "SYSLOG_TCP_FRAMING", Default: string(defaultTCPFraming),
+			Validate:    cfg.OneOf(string(TraditionalTCPFraming), string(OctetCountedTCPFraming)),
+			Description: "framing of messages over tcp and tls, or per route with the tcp_framing option"},
+		cfg.Option{Name: "SYSLOG_PRIORITY", Default: "{{.Priority}}", Validate: validateTemplate,
+			Description: "datum for the priority field"},
+		cfg.Option{Name: "SYSLOG_TIMESTAMP", Default: "{{.Timestamp}}", Validate: validateTemplate,
+			Description: "datum for the timestamp field, or per route with the timestamp option"},
+		cfg.Option{Name: "SYSLOG_HOSTNAME", Default: "{{.Container.Config.Hostname}}", Validate: validateTemplate,
+			Description: "datum for the hostname field, unless /etc/host_hostname exists"},
+		cfg.Option{Name: "SYSLOG_TAG", Validate: validateTemplate,
+			Description: "datum for the tag field (default {{.ContainerName}} and the append_tag route option)"},
+		cfg.Option{Name: "SYSLOG_PID", Default: "{{.Container.State.Pid}}", Validate: validateTemplate,
+			Description: "datum for the pid field"},
+		cfg.Option{Name: "SYSLOG_STRUCTURED_DATA", Validate: validateTemplate,
+			Description: "datum for the structured data field"},
+		cfg.Option{Name: "SYSLOG_DATA", Default: "{{.Data}}", Validate: validateTemplate,
+			Description: "datum for the data field"},
+		cfg.Option{Name: "SYSLOG_FACILITY", Validate: validateFacility,
+			Description: "syslog facility of all messages (default user for stdout and stderr)"},
+		cfg.Option{Name: "SYSLOG_STDOUT_SEVERITY", Default: "info", Validate: validateSeverity,
+			Description: "syslog severity of stdout messages"},
+		cfg.Option{Name: "SYSLOG_STDERR_SEVERITY", Default: "err", Validate: validateSeverity,
+			Description: "syslog severity of stderr messages"},
+		cfg.Option{Name: "SYSLOG_DETECT_LEVEL", Type: cfg.Bool, Default: "false",
+			Description: "derive the syslog severity from the log level detected in each message"},
+		cfg.Option{Name: "RETRY_COUNT", Type: cfg.Int, Default: strconv.Itoa(defaultRetryCount), Validate: cfg.NotNegative,
+			Description: "how many times to retry a broken socket"},
+	)
+}
+
+func validateTemplate(value string) error {
+	_, err := template.New("").Parse(value)
+	return err
 }
 
 func debug(v ...interface{}) {
-	if os.Getenv("DEBUG") != "" {
+	if cfg.GetString("DEBUG") != "" {
 		log.Println(v...)
 	}
 }
 
-func getFormat() (Format, error) {
-	switch s := cfg.GetEnvDefault("SYSLOG_FORMAT", string(defaultFormat)); s {
+func getFormat(route *router.Route) (Format, error) {
+	switch s := getOption(route, "format", "SYSLOG_FORMAT", string(defaultFormat)); s {
 	case string(Rfc5424Format):
 		return Rfc5424Format, nil
 	case string(Rfc3164Format):
 		return Rfc3164Format, nil
 	default:
-		return defaultFormat, fmt.Errorf("unknown SYSLOG_FORMAT value: %s", s)
+		return defaultFormat, fmt.Errorf("unknown syslog format value: %s", s)
 	}
 }
 
@@ -73,7 +110,7 @@ func getHostname() string {
 	if err == nil && len(content) > 0 {
 		hostname = strings.TrimRight(string(content), "\r\n")
 	} else {
-		hostname = cfg.GetEnvDefault("SYSLOG_HOSTNAME", "{{.Container.Config.Hostname}}")
+		hostname = cfg.GetString("SYSLOG_HOSTNAME")
 	}
 	return hostname
 }
@@ -83,13 +120,13 @@ func getFieldTemplates(route *router.Route) (*FieldTemplates, error) {
 	var s string
 	var tmpl FieldTemplates
 
-	s = cfg.GetEnvDefault("SYSLOG_PRIORITY", "{{.Priority}}")
+	s = cfg.GetString("SYSLOG_PRIORITY")
 	if tmpl.priority, err = template.New("priority").Parse(s); err != nil {
 		return nil, err
 	}
 	debug("setting priority to:", s)
 
-	s = cfg.GetEnvDefault("SYSLOG_TIMESTAMP", "{{.Timestamp}}")
+	s = getOption(route, "timestamp", "SYSLOG_TIMESTAMP", "{{.Timestamp}}")
 	if tmpl.timestamp, err = template.New("timestamp").Parse(s); err != nil {
 		return nil, err
 	}
@@ -101,19 +138,21 @@ func getFieldTemplates(route *router.Route) (*FieldTemplates, error) {
 	}
 	debug("setting hostname to:", s)
 
-	s = cfg.GetEnvDefault("SYSLOG_TAG", "{{.ContainerName}}"+route.Options["append_tag"])
+	if s = cfg.GetString("SYSLOG_TAG"); s == "" {
+		s = "{{.ContainerName}}" + route.Options["append_tag"]
+	}
 	if tmpl.tag, err = template.New("tag").Parse(s); err != nil {
 		return nil, err
 	}
 	debug("setting tag to:", s)
 
-	s = cfg.GetEnvDefault("SYSLOG_PID", "{{.Container.State.Pid}}")
+	s = cfg.GetString("SYSLOG_PID")
 	if tmpl.pid, err = template.New("pid").Parse(s); err != nil {
 		return nil, err
 	}
 	debug("setting pid to:", s)
 
-	s = cfg.GetEnvDefault("SYSLOG_STRUCTURED_DATA", "")
+	s = cfg.GetString("SYSLOG_STRUCTURED_DATA")
 	if route.Options["structured_data"] != "" {
 		s = route.Options["structured_data"]
 	}
@@ -127,7 +166,7 @@ func getFieldTemplates(route *router.Route) (*FieldTemplates, error) {
 	}
 	debug("setting structuredData to:", s)
 
-	s = cfg.GetEnvDefault("SYSLOG_DATA", "{{.Data}}")
+	s = cfg.GetString("SYSLOG_DATA")
 	if tmpl.data, err = template.New("data").Parse(s); err != nil {
 		return nil, err
 	}
@@ -136,24 +175,19 @@ func getFieldTemplates(route *router.Route) (*FieldTemplates, error) {
 	return &tmpl, nil
 }
 
-func getTCPFraming() (TCPFraming, error) {
-	switch s := cfg.GetEnvDefault("SYSLOG_TCP_FRAMING", string(defaultTCPFraming)); s {
+func getTCPFraming(route *router.Route) (TCPFraming, error) {
+	switch s := getOption(route, "tcp_framing", "SYSLOG_TCP_FRAMING", string(defaultTCPFraming)); s {
 	case string(TraditionalTCPFraming):
 		return TraditionalTCPFraming, nil
 	case string(OctetCountedTCPFraming):
 		return OctetCountedTCPFraming, nil
 	default:
-		return defaultTCPFraming, fmt.Errorf("unknown SYSLOG_TCP_FRAMING value: %s", s)
+		return defaultTCPFraming, fmt.Errorf("unknown syslog tcp framing value: %s", s)
 	}
 }
 
 func getRetryCount() uint {
-	retryCountStr := cfg.GetEnvDefault("RETRY_COUNT", "")
-	if retryCountStr != "" {
-		retryCount, _ := strconv.Atoi(retryCountStr)
-		return uint(retryCount)
-	}
-	return defaultRetryCount
+	return uint(cfg.GetInt("RETRY_COUNT"))
 }
 
 func isTCPConnection(conn net.Conn) bool {
@@ -162,6 +196,8 @@ func isTCPConnection(conn net.Conn) bool {
 		return true
 	case *tls.Conn:
 		return true
+	case interface{ CloseWrite() error }: // eg: connections to a pool of endpoints
+		return true
 	default:
 		return false
 	}
@@ -178,7 +214,7 @@ func NewSyslogAdapter(route *router.Route) (router.LogAdapter, error) {
 		return nil, err
 	}
 
-	format, err := getFormat()
+	format, err := getFormat(route)
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +230,7 @@ func NewSyslogAdapter(route *router.Route) (router.LogAdapter, error) {
 
 	var tcpFraming TCPFraming
 	if connIsTCP {
-		if tcpFraming, err = getTCPFraming(); err != nil {
+		if tcpFraming, err = getTCPFraming(route); err != nil {
 			return nil, err
 		}
 		debug("setting tcpFraming to:", tcpFraming)
@@ -203,6 +239,11 @@ func NewSyslogAdapter(route *router.Route) (router.LogAdapter, error) {
 	retryCount := getRetryCount()
 	debug("setting retryCount to:", retryCount)
 
+	priorities, err := getPriorities(route)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Adapter{
 		route:      route,
 		conn:       conn,
@@ -212,6 +253,7 @@ func NewSyslogAdapter(route *router.Route) (router.LogAdapter, error) {
 		transport:  transport,
 		tcpFraming: tcpFraming,
 		retryCount: retryCount,
+		priorities: priorities,
 	}, nil
 }
 
@@ -236,20 +278,21 @@ type Adapter struct {
 	transport  router.AdapterTransport
 	tcpFraming TCPFraming
 	retryCount uint
+	priorities *priorities
 }
 
 // Stream sends log data to a connection
 func (a *Adapter) Stream(logstream chan *router.Message) {
 	for message := range logstream {
-		m := &Message{message}
-		buf, err := m.Render(a.format, a.tmpl)
+		buf, err := a.Render(message)
 		if err != nil {
 			log.Println("syslog:", err)
 			return
 		}
 
 		if a.connIsTCP && a.tcpFraming == OctetCountedTCPFraming {
-			buf = append([]byte(fmt.Sprintf("%d ", len(buf))), buf...)
+			framed := strconv.AppendInt(make([]byte, 0, len(buf)+12), int64(len(buf)), 10)
+			buf = append(append(framed, ' '), buf...)
 		}
 
 		if _, err = a.conn.Write(buf); err != nil {
@@ -264,13 +307,17 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 	}
 }
 
+// Render returns a message as the adapter writes it, before any framing
+func (a *Adapter) Render(message *router.Message) ([]byte, error) {
+	m := &Message{Message: message, priorities: a.priorities}
+	return m.Render(a.format, a.tmpl)
+}
+
 func (a *Adapter) retry(buf []byte, err error) error {
-	if opError, ok := err.(*net.OpError); ok {
-		if (opError.Temporary() && !errors.Is(opError, syscall.ECONNRESET)) || opError.Timeout() {
-			retryErr := a.retryTemporary(buf)
-			if retryErr == nil {
-				return nil
-			}
+	if router.Classify(err) == router.ErrorRetryable {
+		retryErr := a.retryTemporary(buf)
+		if retryErr == nil {
+			return nil
 		}
 	}
 	if reconnErr := a.reconnect(); reconnErr != nil {
@@ -341,46 +388,38 @@ func retryExp(fun func() error, tries uint) error {
 // Message extends router.Message for the syslog standard
 type Message struct {
 	*router.Message
+	priorities *priorities
 }
 
-// Render transforms the log message using the Syslog template
-func (m *Message) Render(format Format, tmpl *FieldTemplates) ([]byte, error) {
-	priority := new(bytes.Buffer)
-	if err := tmpl.priority.Execute(priority, m); err != nil {
-		return nil, err
-	}
-
-	timestamp := new(bytes.Buffer)
-	if err := tmpl.timestamp.Execute(timestamp, m); err != nil {
-		return nil, err
-	}
-
-	hostname := new(bytes.Buffer)
-	if err := tmpl.hostname.Execute(hostname, m); err != nil {
-		return nil, err
-	}
-
-	tag := new(bytes.Buffer)
-	if err := tmpl.tag.Execute(tag, m); err != nil {
-		return nil, err
-	}
-
-	pid := new(bytes.Buffer)
-	if err := tmpl.pid.Execute(pid, m); err != nil {
-		return nil, err
-	}
+// fieldBuffers are the buffers the fields of a message are rendered in,
+// reused between messages
+type fieldBuffers struct {
+	priority, timestamp, hostname, tag, pid, structuredData, data bytes.Buffer
+}
 
-	structuredData := new(bytes.Buffer)
-	if err := tmpl.structuredData.Execute(structuredData, m); err != nil {
-		return nil, err
-	}
+var fieldBuffersPool = sync.Pool{New: func() interface{} { return new(fieldBuffers) }}
 
-	data := new(bytes.Buffer)
-	if err := tmpl.data.Execute(data, m); err != nil {
-		return nil, err
+// Render transforms the log message using the Syslog template
+func (m *Message) Render(format Format, tmpl *FieldTemplates) ([]byte, error) {
+	f := fieldBuffersPool.Get().(*fieldBuffers)
+	defer fieldBuffersPool.Put(f)
+	fields := []struct {
+		tmpl *template.Template
+		buf  *bytes.Buffer
+	}{
+		{tmpl.priority, &f.priority}, {tmpl.timestamp, &f.timestamp}, {tmpl.hostname, &f.hostname},
+		{tmpl.tag, &f.tag}, {tmpl.pid, &f.pid}, {tmpl.structuredData, &f.structuredData}, {tmpl.data, &f.data},
+	}
+	size := 16
+	for _, field := range fields {
+		field.buf.Reset()
+		if err := field.tmpl.Execute(field.buf, m); err != nil {
+			return nil, err
+		}
+		size += field.buf.Len()
 	}
 
-	buf := new(bytes.Buffer)
+	buf := make([]byte, 0, size)
 	switch format {
 	case Rfc5424Format:
 		// notes from RFC:
@@ -388,23 +427,47 @@ func (m *Message) Render(format Format, tmpl *FieldTemplates) ([]byte, error) {
 		// - the HOSTNAME field must not exceed 255 characters
 		// - the TAG field must not exceed 48 characters
 		// - the PROCID field must not exceed 128 characters
-		fmt.Fprintf(buf, "<%s>1 %s %.255s %.48s %.128s - %s %s\n",
-			priority, timestamp, hostname, tag, pid, structuredData, data,
-		)
+		buf = append(append(append(buf, '<'), f.priority.Bytes()...), ">1 "...)
+		buf = append(append(buf, f.timestamp.Bytes()...), ' ')
+		buf = append(appendRunes(buf, f.hostname.Bytes(), 255), ' ')
+		buf = append(appendRunes(buf, f.tag.Bytes(), 48), ' ')
+		buf = append(appendRunes(buf, f.pid.Bytes(), 128), " - "...)
+		buf = append(append(buf, f.structuredData.Bytes()...), ' ')
+		buf = append(append(buf, f.data.Bytes()...), '\n')
 	case Rfc3164Format:
 		// notes from RFC:
 		// - the entire message must be <= 1024 bytes
 		// - the TAG field must not exceed 32 characters
-		fmt.Fprintf(buf, "<%s>%s %s %.32s[%s]: %s\n",
-			priority, timestamp, hostname, tag, pid, data,
-		)
+		buf = append(append(append(buf, '<'), f.priority.Bytes()...), '>')
+		buf = append(append(buf, f.timestamp.Bytes()...), ' ')
+		buf = append(append(buf, f.hostname.Bytes()...), ' ')
+		buf = append(appendRunes(buf, f.tag.Bytes(), 32), '[')
+		buf = append(append(buf, f.pid.Bytes()...), "]: "...)
+		buf = append(append(buf, f.data.Bytes()...), '\n')
 	}
 
-	return buf.Bytes(), nil
+	return buf, nil
 }
 
-// Priority returns a syslog.Priority based on the message source
+// appendRunes appends at most max runes of a field, like the precision of
+// a %s verb
+func appendRunes(buf, field []byte, max int) []byte {
+	n := 0
+	for i := range string(field) {
+		if n == max {
+			return append(buf, field[:i]...)
+		}
+		n++
+	}
+	return append(buf, field...)
+}
+
+// Priority returns a syslog.Priority based on the message source, or the
+// facility and severity mapping of the route
 func (m *Message) Priority() syslog.Priority {
+	if m.priorities != nil {
+		return m.priorities.priority(m.Message)
+	}
 	switch m.Message.Source {
 	case "stdout":
 		return syslog.LOG_USER | syslog.LOG_INFO
@@ -425,6 +488,12 @@ func (m *Message) Timestamp() string {
 	return m.Message.Time.Format(time.RFC3339)
 }
 
+// BSDTimestamp returns the message's timestamp in the "Mmm dd hh:mm:ss"
+// format of RFC 3164, for receivers that only accept that format
+func (m *Message) BSDTimestamp() string {
+	return m.Message.Time.Format(time.Stamp)
+}
+
 // ContainerName returns the message's container name
 func (m *Message) ContainerName() string {
 	return m.Message.Container.Name[1:]
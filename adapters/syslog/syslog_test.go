@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"log/syslog"
 	"net"
 	"os"
 	"strconv"
@@ -95,7 +96,7 @@ func TestSysLogFormat(t *testing.T) {
 
 	newFormat := Rfc3164Format
 	os.Setenv("SYSLOG_FORMAT", string(newFormat))
-	format, err := getFormat()
+	format, err := getFormat(&router.Route{})
 	if err != nil {
 		t.Fatal("unexpected error: ", err)
 	}
@@ -104,7 +105,7 @@ func TestSysLogFormat(t *testing.T) {
 	}
 
 	os.Unsetenv("SYSLOG_FORMAT")
-	format, err = getFormat()
+	format, err = getFormat(&router.Route{})
 	if err != nil {
 		t.Fatal("unexpected error: ", err)
 	}
@@ -113,10 +114,19 @@ func TestSysLogFormat(t *testing.T) {
 	}
 
 	os.Setenv("SYSLOG_FORMAT", "invalid-option")
-	_, err = getFormat()
+	_, err = getFormat(&router.Route{})
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
+
+	route := &router.Route{Options: map[string]string{"format": string(newFormat)}}
+	format, err = getFormat(route)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if format != newFormat {
+		t.Errorf("expected route option %v got %v", newFormat, format)
+	}
 }
 
 func TestSysLogTCPFraming(t *testing.T) {
@@ -124,7 +134,7 @@ func TestSysLogTCPFraming(t *testing.T) {
 
 	newTCPFraming := OctetCountedTCPFraming
 	os.Setenv("SYSLOG_TCP_FRAMING", string(newTCPFraming))
-	tcpFraming, err := getTCPFraming()
+	tcpFraming, err := getTCPFraming(&router.Route{})
 	if err != nil {
 		t.Fatal("unexpected error: ", err)
 	}
@@ -133,7 +143,7 @@ func TestSysLogTCPFraming(t *testing.T) {
 	}
 
 	os.Unsetenv("SYSLOG_TCP_FRAMING")
-	tcpFraming, err = getTCPFraming()
+	tcpFraming, err = getTCPFraming(&router.Route{})
 	if err != nil {
 		t.Fatal("unexpected error: ", err)
 	}
@@ -142,10 +152,68 @@ func TestSysLogTCPFraming(t *testing.T) {
 	}
 
 	os.Setenv("SYSLOG_TCP_FRAMING", "invalid-option")
-	_, err = getTCPFraming()
+	_, err = getTCPFraming(&router.Route{})
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
+
+	route := &router.Route{Options: map[string]string{"tcp_framing": string(newTCPFraming)}}
+	tcpFraming, err = getTCPFraming(route)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if tcpFraming != newTCPFraming {
+		t.Errorf("expected route option %v got %v", newTCPFraming, tcpFraming)
+	}
+}
+
+func TestSyslogRfc3164Render(t *testing.T) {
+	route := &router.Route{Options: map[string]string{"format": "rfc3164", "timestamp": "{{.BSDTimestamp}}"}}
+	format, err := getFormat(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := getFieldTemplates(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Message{Message: &router.Message{
+		Container: &docker.Container{Name: "/web", Config: &docker.Config{Hostname: "8dfafdbc3a40"}},
+		Source:    "stdout",
+		Data:      "hello",
+		Time:      time.Date(2020, 6, 1, 9, 5, 3, 0, time.UTC),
+	}}
+	buf, err := m.Render(format, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buf), "<14>Jun  1 09:05:03 ") || !strings.HasSuffix(string(buf), " web[0]: hello\n") {
+		t.Errorf("unexpected rfc3164 message %q", buf)
+	}
+}
+
+func BenchmarkSyslogRender(b *testing.B) {
+	route := &router.Route{Options: map[string]string{}}
+	format, err := getFormat(route)
+	if err != nil {
+		b.Fatal(err)
+	}
+	tmpl, err := getFieldTemplates(route)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := &Message{Message: &router.Message{
+		Container: &docker.Container{Name: "/web", Config: &docker.Config{Hostname: "8dfafdbc3a40"}},
+		Source:    "stdout",
+		Data:      "2020-11-02 10:00:00,000 INFO the kind of line containers log all day long",
+		Time:      time.Now(),
+	}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Render(format, tmpl); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
 
 func TestSyslogRetryCount(t *testing.T) {
@@ -288,3 +356,46 @@ func check(t *testing.T, in string, out string) {
 		t.Errorf("expected: %s\ngot: %s\n", in, out)
 	}
 }
+
+func TestSyslogPriority(t *testing.T) {
+	labeled := &docker.Container{
+		Name:   "/labeled",
+		Config: &docker.Config{Labels: map[string]string{FacilityLabel: "local3"}},
+	}
+	tests := []struct {
+		options   map[string]string
+		container *docker.Container
+		source    string
+		data      string
+		expected  syslog.Priority
+	}{
+		{map[string]string{}, container, "stdout", "WARN low disk", syslog.LOG_USER | syslog.LOG_INFO},
+		{map[string]string{}, container, "stderr", "hello", syslog.LOG_USER | syslog.LOG_ERR},
+		{map[string]string{}, container, "", "hello", syslog.LOG_DAEMON | syslog.LOG_INFO},
+		{map[string]string{"facility": "local0"}, container, "stdout", "hello", syslog.LOG_LOCAL0 | syslog.LOG_INFO},
+		{map[string]string{"facility": "local0"}, labeled, "stdout", "hello", syslog.LOG_LOCAL3 | syslog.LOG_INFO},
+		{map[string]string{"stderr_severity": "warning"}, container, "stderr", "hello", syslog.LOG_USER | syslog.LOG_WARNING},
+		{map[string]string{"detect_level": "true"}, container, "stdout", "WARN low disk", syslog.LOG_USER | syslog.LOG_WARNING},
+		{map[string]string{"detect_level": "true"}, container, "stderr", `{"level":"debug"}`, syslog.LOG_USER | syslog.LOG_DEBUG},
+		{map[string]string{"detect_level": "true"}, container, "stderr", "hello", syslog.LOG_USER | syslog.LOG_ERR},
+	}
+	for _, test := range tests {
+		p, err := getPriorities(&router.Route{Options: test.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &Message{
+			Message:    &router.Message{Container: test.container, Source: test.source, Data: test.data},
+			priorities: p,
+		}
+		if priority := m.Priority(); priority != test.expected {
+			t.Errorf("expected priority %d for %v %s %q, got %d", test.expected, test.options, test.source, test.data, priority)
+		}
+	}
+
+	for _, options := range []map[string]string{{"facility": "nope"}, {"stdout_severity": "loud"}} {
+		if _, err := getPriorities(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v, got nil", options)
+		}
+	}
+}
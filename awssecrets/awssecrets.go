@@ -0,0 +1,209 @@
+// Package awssecrets resolves options set to references to AWS Secrets
+// Manager secrets or SSM Parameter Store parameters, like
+// secretsmanager://logspout/papertrail#token or ssm:///logspout/token, so
+// the credentials of destinations outside AWS can live in the secret
+// stores of AWS too. References are resolved when logspout starts, and
+// again every AWS_SECRETS_REFRESH_INTERVAL for rotated secrets.
+package awssecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"github.com/gliderlabs/logspout/adapters/httpclient"
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Schemes of the references
+const (
+	SchemeSecretsManager = "secretsmanager"
+	SchemeSSM            = "ssm"
+)
+
+const requestTimeout = 10 * time.Second
+
+func init() {
+	router.Jobs.Register(&refresher{}, "aws-secrets")
+	cfg.Register(
+		cfg.Option{Name: "AWS_SECRETS_REGION",
+			Description: "region of the secrets and parameters referenced, if not that of the AWS SDK or their ARN"},
+		cfg.Option{Name: "AWS_SECRETS_REFRESH_INTERVAL", Type: cfg.Duration, Default: "5m",
+			Description: "how often options set to secretsmanager:// and ssm:// references are resolved again, 0 to never"},
+	)
+	cfg.RegisterResolver(SchemeSecretsManager, shared.secret)
+	cfg.RegisterResolver(SchemeSSM, shared.parameter)
+}
+
+var shared = &stores{secretsManager: map[string]secretsmanageriface.SecretsManagerAPI{}, ssm: map[string]ssmiface.SSMAPI{}}
+
+// stores holds the clients of the secret stores, by region, created on the
+// first reference to them
+type stores struct {
+	mu             sync.Mutex
+	sess           *session.Session
+	used           bool // whether any reference was resolved
+	secretsManager map[string]secretsmanageriface.SecretsManagerAPI
+	ssm            map[string]ssmiface.SSMAPI
+}
+
+// secretsManagerOf returns the client of Secrets Manager in a region, or
+// that of the AWS_SECRETS_REGION or the SDK if ""
+func (s *stores) secretsManagerOf(region string) secretsmanageriface.SecretsManagerAPI {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used = true
+	if s.secretsManager[region] == nil {
+		s.secretsManager[region] = secretsmanager.New(s.session(), s.config(region))
+	}
+	return s.secretsManager[region]
+}
+
+// ssmOf returns the client of the Parameter Store in a region
+func (s *stores) ssmOf(region string) ssmiface.SSMAPI {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used = true
+	if s.ssm[region] == nil {
+		s.ssm[region] = ssm.New(s.session(), s.config(region))
+	}
+	return s.ssm[region]
+}
+
+func (s *stores) session() *session.Session {
+	if s.sess == nil {
+		s.sess = session.New()
+	}
+	return s.sess
+}
+
+func (s *stores) config(region string) *aws.Config {
+	if region == "" {
+		region = cfg.GetString("AWS_SECRETS_REGION")
+	}
+	config := httpclient.AWSConfig()
+	if region != "" {
+		config.Region = aws.String(region)
+	}
+	return config
+}
+
+// secret resolves a secretsmanager:// reference, the name or ARN of a
+// secret and, for secrets holding JSON, a key of it after #
+func (s *stores) secret(ref string) (string, error) {
+	id, key := splitKey(ref)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	out, err := s.secretsManagerOf(regionOf(id)).GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secret %s is binary", id)
+	}
+	if key == "" {
+		return *out.SecretString, nil
+	}
+	return jsonKey(*out.SecretString, key)
+}
+
+// parameter resolves an ssm:// reference, the name or ARN of a parameter,
+// which is decrypted if it is a SecureString, and a key of JSON after #
+func (s *stores) parameter(ref string) (string, error) {
+	name, key := splitKey(ref)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	out, err := s.ssmOf(regionOf(name)).GetParameterWithContext(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", err
+	}
+	value := aws.StringValue(out.Parameter.Value)
+	if key == "" {
+		return value, nil
+	}
+	return jsonKey(value, key)
+}
+
+// regionOf returns the region of an ARN, or "" for names
+func regionOf(id string) string {
+	if parsed, err := arn.Parse(id); err == nil {
+		return parsed.Region
+	}
+	return ""
+}
+
+// splitKey splits a reference at its last #, which names a key of JSON
+func splitKey(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// jsonKey returns the value of a key of a JSON object, like the key/value
+// pairs of secrets made in the console of Secrets Manager
+func jsonKey(text, key string) (string, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &object); err != nil {
+		return "", fmt.Errorf("value is not a JSON object with the key %s", key)
+	}
+	value, ok := object[key]
+	if !ok {
+		return "", fmt.Errorf("no key %s", key)
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// refresher is the job resolving the references again every
+// AWS_SECRETS_REFRESH_INTERVAL, for the code using them to pick up rotated
+// secrets
+type refresher struct {
+	interval time.Duration
+}
+
+func (r *refresher) Name() string {
+	if r.interval == 0 {
+		return ""
+	}
+	return "aws-secrets"
+}
+
+func (r *refresher) Setup() error {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	if shared.used {
+		r.interval = cfg.GetDuration("AWS_SECRETS_REFRESH_INTERVAL")
+	}
+	return nil
+}
+
+func (r *refresher) Run() error {
+	if r.interval == 0 {
+		select {}
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := cfg.Refresh(SchemeSecretsManager, SchemeSSM); err != nil {
+			log.Println("aws-secrets:", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package awssecrets
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (f *fakeSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	secret, ok := f.secrets[*input.SecretId]
+	if !ok {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(secret)}, nil
+}
+
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+}
+
+func (f *fakeSSM) GetParameterWithContext(ctx aws.Context, input *ssm.GetParameterInput, opts ...request.Option) (*ssm.GetParameterOutput, error) {
+	if !*input.WithDecryption {
+		return nil, errors.New("expected parameters to be decrypted")
+	}
+	value, ok := f.parameters[*input.Name]
+	if !ok {
+		return nil, errors.New("ParameterNotFound")
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+}
+
+func TestResolveReferences(t *testing.T) {
+	secretsManager := &fakeSecretsManager{secrets: map[string]string{
+		"logspout/papertrail": `{"token":"abc","port":55555}`,
+		"arn:aws:secretsmanager:eu-west-1:123456789012:secret:logspout-Ab12Cd": "plain",
+	}}
+	s := &stores{
+		secretsManager: map[string]secretsmanageriface.SecretsManagerAPI{"": secretsManager, "eu-west-1": secretsManager},
+		ssm:            map[string]ssmiface.SSMAPI{"": &fakeSSM{parameters: map[string]string{"/logspout/token": "xyz"}}},
+	}
+	for ref, expected := range map[string]string{
+		"logspout/papertrail#token": "abc",
+		"logspout/papertrail#port":  "55555",
+		"logspout/papertrail":       `{"token":"abc","port":55555}`,
+		"arn:aws:secretsmanager:eu-west-1:123456789012:secret:logspout-Ab12Cd": "plain",
+	} {
+		if value, err := s.secret(ref); err != nil || value != expected {
+			t.Errorf("expected %s to resolve to %q, got %q %v", ref, expected, value, err)
+		}
+	}
+	if value, err := s.parameter("/logspout/token"); err != nil || value != "xyz" {
+		t.Errorf("expected the parameter, got %q %v", value, err)
+	}
+	if _, err := s.secret("logspout/papertrail#password"); err == nil {
+		t.Error("expected a missing key to be an error")
+	}
+	if _, err := s.parameter("/logspout/missing"); err == nil {
+		t.Error("expected a missing parameter to be an error")
+	}
+	if !s.used {
+		t.Error("expected the stores to be used")
+	}
+}
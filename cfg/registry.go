@@ -0,0 +1,375 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Type is the type of the value of an option
+type Type string
+
+// Types of option values
+const (
+	String   Type = "string"
+	Bool     Type = "bool"
+	Int      Type = "int"
+	Duration Type = "duration"
+)
+
+// SecretsDir is where Docker mounts secrets, read for options whose env var
+// is not set
+var SecretsDir = "/run/secrets"
+
+// Option describes a setting logspout reads from an env var, or a file
+type Option struct {
+	Name        string // the env var
+	Type        Type
+	Default     string // used when the env var is empty, written as it would be set
+	Description string
+	Validate    func(value string) error // optional check of a value of the type
+	Secret      bool                     // hidden where the configuration is shown, like /debug/vars and logspout config
+}
+
+// Redacted replaces the values of secret options where the configuration
+// is shown
+const Redacted = "<redacted>"
+
+var registry = struct {
+	sync.RWMutex
+	options map[string]*Option
+	values  map[string]interface{} // set by Parse
+	texts   map[string]string      // the text of each option that was set, set by Parse
+	sources map[string]string      // where each option that was set was read from, set by Parse
+	refs    map[string]string      // the options set to references, resolved by Refresh
+}{options: map[string]*Option{}}
+
+// Register adds options to the registry, usually from the init function of
+// the package reading them. It panics if an option is registered twice or
+// its default is invalid, as either is a programming error.
+func Register(options ...Option) {
+	registry.Lock()
+	defer registry.Unlock()
+	for i := range options {
+		option := options[i]
+		if _, exists := registry.options[option.Name]; exists {
+			panic("cfg: option registered twice: " + option.Name)
+		}
+		if option.Type == "" {
+			option.Type = String
+		}
+		if _, err := option.parse(option.Default); err != nil {
+			panic(fmt.Sprintf("cfg: invalid default of %s: %s", option.Name, err))
+		}
+		registry.options[option.Name] = &option
+	}
+}
+
+// Parse reads the env var of every registered option, or its file, which
+// are used from then on. It returns an error naming every invalid value, so
+// logspout fails at startup instead of when an option is first used.
+// Values read from files or resolved from references are only kept in the
+// registry, not set in the environment, for them not to show in the
+// environment of the process.
+func Parse() error {
+	if err := parse(); err != nil {
+		return err
+	}
+	if err := Refresh(); err != nil {
+		return err
+	}
+	for _, f := range afterParse {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parse() error {
+	registry.Lock()
+	defer registry.Unlock()
+	values := map[string]interface{}{}
+	texts := map[string]string{}
+	sources := map[string]string{}
+	refs := map[string]string{}
+	var problems []string
+	for name, option := range registry.options {
+		text, source, err := lookup(option)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		if isReference(text) {
+			// has its default until resolved
+			texts[name], sources[name], refs[name] = text, source, text
+			text = option.Default
+		} else if text != "" {
+			texts[name], sources[name] = text, source
+		} else {
+			text = option.Default
+		}
+		value, err := option.parse(text)
+		if err != nil && option.Secret {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%s: %s", name, text, err))
+			continue
+		}
+		values[name] = value
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	registry.values, registry.texts, registry.sources, registry.refs = values, texts, sources, refs
+	return nil
+}
+
+// lookup returns the text of an option and where it was read from: its env
+// var, or the file named by the env var with the _FILE suffix, or else for
+// secret options the file of the SecretsDir named after it, as is or in
+// lower case, like the secrets of Docker and swarm services, so credentials
+// do not show in docker inspect. It returns "" if none is set.
+func lookup(option *Option) (text, source string, err error) {
+	name := option.Name
+	if text := os.Getenv(name); text != "" {
+		return text, "env", nil
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		text, err := readSecret(path)
+		if err != nil {
+			return "", "", fmt.Errorf("reading %s_FILE: %s", name, err)
+		}
+		return text, path, nil
+	}
+	if !option.Secret {
+		return "", "", nil
+	}
+	for _, file := range []string{name, strings.ToLower(name)} {
+		path := filepath.Join(SecretsDir, file)
+		text, err := readSecret(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("reading %s: %s", path, err)
+		}
+		return text, path, nil
+	}
+	return "", "", nil
+}
+
+// readSecret returns the content of a file, without the line ending most
+// editors and echo add
+func readSecret(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// parse converts the text of a value to the type of the option, and
+// validates it
+func (o *Option) parse(text string) (interface{}, error) {
+	value, err := o.convert(text)
+	if err == nil && o.Validate != nil && text != "" {
+		err = o.Validate(text)
+	}
+	return value, err
+}
+
+// convert converts the text of a value to the type of the option
+func (o *Option) convert(text string) (interface{}, error) {
+	var value interface{}
+	var err error
+	switch o.Type {
+	case String:
+		value = text
+	case Bool:
+		value, err = strconv.ParseBool(text)
+		if err != nil {
+			err = errors.New("must be true or false")
+		}
+	case Int:
+		value, err = strconv.Atoi(text)
+		if err != nil {
+			err = errors.New("must be a number")
+		}
+	case Duration:
+		value, err = time.ParseDuration(text)
+		if err != nil {
+			err = errors.New("must be a duration, eg: 30s")
+		}
+	default:
+		err = errors.New("unknown type " + string(o.Type))
+	}
+	return value, err
+}
+
+// value returns the value of an option, as parsed by Parse. Before Parse
+// is called, like in tests, the env var or file is read on every call and
+// left to the caller to validate, but a value not of the type is replaced by
+// the default.
+func value(name string, typ Type) interface{} {
+	registry.RLock()
+	defer registry.RUnlock()
+	option, exists := registry.options[name]
+	if !exists {
+		panic("cfg: option not registered: " + name)
+	}
+	if option.Type != typ {
+		panic(fmt.Sprintf("cfg: option %s is a %s, not a %s", name, option.Type, typ))
+	}
+	if registry.values != nil {
+		return registry.values[name]
+	}
+	if text, _, _ := lookup(option); text != "" {
+		if value, err := option.convert(text); err == nil {
+			return value
+		}
+	}
+	value, _ := option.convert(option.Default)
+	return value
+}
+
+// GetString returns the value of a string option
+func GetString(name string) string {
+	return value(name, String).(string)
+}
+
+// GetBool returns the value of a bool option
+func GetBool(name string) bool {
+	return value(name, Bool).(bool)
+}
+
+// GetInt returns the value of an int option
+func GetInt(name string) int {
+	return value(name, Int).(int)
+}
+
+// GetDuration returns the value of a duration option
+func GetDuration(name string) time.Duration {
+	return value(name, Duration).(time.Duration)
+}
+
+// IsSet returns whether the env var or file of an option is set, rather
+// than the option having its default
+func IsSet(name string) bool {
+	return Text(name) != ""
+}
+
+// Text returns the text an option was set to, or "" if it has its default
+func Text(name string) string {
+	text, _ := set(name)
+	return text
+}
+
+// set returns the text an option was set to, and where it was read from
+func set(name string) (text, source string) {
+	registry.RLock()
+	defer registry.RUnlock()
+	option, exists := registry.options[name]
+	if !exists {
+		panic("cfg: option not registered: " + name)
+	}
+	if registry.values != nil {
+		return registry.texts[name], registry.sources[name]
+	}
+	text, source, _ = lookup(option)
+	return text, source
+}
+
+// Lookup returns the registered option of an env var, and whether there is one
+func Lookup(name string) (Option, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	option, exists := registry.options[name]
+	if !exists {
+		return Option{}, false
+	}
+	return *option, true
+}
+
+// Options returns the registered options, sorted by name
+func Options() []Option {
+	registry.RLock()
+	defer registry.RUnlock()
+	options := make([]Option, 0, len(registry.options))
+	for _, option := range registry.options {
+		options = append(options, *option)
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].Name < options[j].Name })
+	return options
+}
+
+// WriteHelp writes a table of the registered options, with their type,
+// default and description
+func WriteHelp(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VARIABLE\tTYPE\tDEFAULT\tDESCRIPTION") //nolint:errcheck
+	for _, option := range Options() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", option.Name, option.Type, quoted(option.Default), option.Description) //nolint:errcheck
+	}
+	return w.Flush()
+}
+
+// WriteConfig writes the value of every registered option, and whether it
+// was set in the environment or a file, or is the default. The values of
+// secret options that are set are Redacted.
+func WriteConfig(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VARIABLE\tVALUE\tSOURCE") //nolint:errcheck
+	for _, option := range Options() {
+		text, source := set(option.Name)
+		if text == "" {
+			text, source = option.Default, "default"
+		} else if option.Secret {
+			text = Redacted
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", option.Name, quoted(text), source) //nolint:errcheck
+	}
+	return w.Flush()
+}
+
+// quoted returns a value as shown in tables, quoted if it is empty or has
+// spaces or escapes
+func quoted(text string) string {
+	if text == "" || strings.ContainsAny(text, " \t\n\\\"") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+// OneOf returns a Validate function accepting only the given values
+func OneOf(values ...string) func(string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return errors.New("must be one of " + strings.Join(values, ", "))
+	}
+}
+
+// NotNegative is a Validate function of int options that may not be
+// negative
+func NotNegative(value string) error {
+	if i, err := strconv.Atoi(value); err == nil && i < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
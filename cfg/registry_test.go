@@ -0,0 +1,229 @@
+package cfg
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	Register(
+		Option{Name: "CFG_TEST_STRING", Default: "a", Validate: OneOf("a", "b")},
+		Option{Name: "CFG_TEST_BOOL", Type: Bool, Default: "true"},
+		Option{Name: "CFG_TEST_INT", Type: Int, Default: "3", Validate: NotNegative},
+		Option{Name: "CFG_TEST_DURATION", Type: Duration, Default: "1s", Description: "a duration"},
+		Option{Name: "CFG_TEST_SECRET", Secret: true},
+		Option{Name: "CFG_TEST_REF"},
+	)
+}
+
+// unparse forgets the values of Parse
+func unparse() {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.values, registry.texts, registry.sources, registry.refs = nil, nil, nil, nil
+}
+
+func TestDefaults(t *testing.T) {
+	defer unparse()
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if GetString("CFG_TEST_STRING") != "a" || !GetBool("CFG_TEST_BOOL") || GetInt("CFG_TEST_INT") != 3 ||
+		GetDuration("CFG_TEST_DURATION") != time.Second {
+		t.Error("expected the defaults of unset options")
+	}
+	if IsSet("CFG_TEST_STRING") {
+		t.Error("expected option not to be set")
+	}
+}
+
+func TestParseReadsOnce(t *testing.T) {
+	os.Setenv("CFG_TEST_INT", "7")
+	defer os.Unsetenv("CFG_TEST_INT")
+	defer unparse()
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CFG_TEST_INT", "8")
+	if i := GetInt("CFG_TEST_INT"); i != 7 || !IsSet("CFG_TEST_INT") {
+		t.Errorf("expected the value when parsed, got %d", i)
+	}
+}
+
+func TestParseFailsOnInvalidValues(t *testing.T) {
+	for name, value := range map[string]string{
+		"CFG_TEST_STRING":   "c",
+		"CFG_TEST_BOOL":     "maybe",
+		"CFG_TEST_INT":      "-1",
+		"CFG_TEST_DURATION": "5",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	defer unparse()
+	err := Parse()
+	if err == nil {
+		t.Fatal("expected invalid values to fail")
+	}
+	for _, name := range []string{"CFG_TEST_STRING=c", "CFG_TEST_BOOL=maybe", "CFG_TEST_INT=-1", "CFG_TEST_DURATION=5"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to name %s, got %s", name, err)
+		}
+	}
+}
+
+func TestUnparsedReadsEnv(t *testing.T) {
+	os.Setenv("CFG_TEST_DURATION", "2m")
+	defer os.Unsetenv("CFG_TEST_DURATION")
+	if d := GetDuration("CFG_TEST_DURATION"); d != 2*time.Minute {
+		t.Errorf("expected the env var before Parse, got %s", d)
+	}
+	os.Setenv("CFG_TEST_DURATION", "soon")
+	if d := GetDuration("CFG_TEST_DURATION"); d != time.Second {
+		t.Errorf("expected the default for a value not of the type, got %s", d)
+	}
+}
+
+func TestWriteHelp(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteHelp(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "CFG_TEST_DURATION") {
+			if fields := strings.Fields(line); len(fields) != 5 || fields[1] != "duration" || fields[2] != "1s" {
+				t.Errorf("unexpected help line: %s", line)
+			}
+			return
+		}
+	}
+	t.Errorf("expected help to list the option, got %s", out.String())
+}
+
+func TestWriteConfigRedactsSecrets(t *testing.T) {
+	os.Setenv("CFG_TEST_SECRET", "s3cret")
+	defer os.Unsetenv("CFG_TEST_SECRET")
+	var out bytes.Buffer
+	if err := WriteConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "s3cret") || !strings.Contains(out.String(), Redacted) {
+		t.Errorf("expected the value of the secret to be redacted, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), "CFG_TEST_DURATION") {
+		t.Errorf("expected the other options to be shown, got %s", out.String())
+	}
+}
+
+func TestParseReadsFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretsDir := SecretsDir
+	SecretsDir = dir
+	defer func() { SecretsDir = secretsDir }()
+	ioutil.WriteFile(filepath.Join(dir, "cfg_test_secret"), []byte("s3cret\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "cfg_test_int"), []byte("5\n"), 0600) // not a secret
+	ioutil.WriteFile(filepath.Join(dir, "string"), []byte("b"), 0600)
+	os.Setenv("CFG_TEST_STRING_FILE", filepath.Join(dir, "string"))
+	defer os.Unsetenv("CFG_TEST_STRING_FILE")
+	defer os.Unsetenv("CFG_TEST_STRING")
+	defer os.Unsetenv("CFG_TEST_INT")
+	defer unparse()
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if s, secret := GetString("CFG_TEST_STRING"), GetString("CFG_TEST_SECRET"); s != "b" || secret != "s3cret" {
+		t.Errorf("expected the values of the files, got %s and %s", s, secret)
+	}
+	if i := GetInt("CFG_TEST_INT"); i != 3 {
+		t.Errorf("expected only secrets to be read from the secrets directory, got %d", i)
+	}
+	if os.Getenv("CFG_TEST_STRING") != "" || os.Getenv("CFG_TEST_SECRET") != "" {
+		t.Error("expected values read from files not to be set in the environment")
+	}
+	var out bytes.Buffer
+	WriteConfig(&out)
+	if !strings.Contains(out.String(), filepath.Join(dir, "cfg_test_secret")) {
+		t.Errorf("expected the config to show the file an option was read from, got %s", out.String())
+	}
+}
+
+func TestParseFailsOnMissingFile(t *testing.T) {
+	os.Setenv("CFG_TEST_BOOL_FILE", "/nonexistent/secret")
+	defer os.Unsetenv("CFG_TEST_BOOL_FILE")
+	defer unparse()
+	if err := Parse(); err == nil || !strings.Contains(err.Error(), "CFG_TEST_BOOL_FILE") {
+		t.Errorf("expected a missing file to fail, got %v", err)
+	}
+}
+
+func TestReferencesAreResolved(t *testing.T) {
+	secret := "first"
+	RegisterResolver("cfgtest", func(ref string) (string, error) {
+		if ref != "store/logspout" {
+			return "", errors.New("no such secret")
+		}
+		return secret, nil
+	})
+	os.Setenv("CFG_TEST_REF", "cfgtest://store/logspout")
+	defer os.Unsetenv("CFG_TEST_REF")
+	defer unparse()
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+	if s := GetString("CFG_TEST_REF"); s != "first" {
+		t.Errorf("expected the resolved value, got %s", s)
+	}
+	secret = "second"
+	if err := Refresh("other"); err != nil || GetString("CFG_TEST_REF") != "first" {
+		t.Error("expected references of other schemes not to be resolved again")
+	}
+	if err := Refresh("cfgtest"); err != nil || GetString("CFG_TEST_REF") != "second" {
+		t.Errorf("expected the rotated value, got %s %v", GetString("CFG_TEST_REF"), err)
+	}
+	if Text("CFG_TEST_REF") != "cfgtest://store/logspout" {
+		t.Errorf("expected the text of the option to be the reference, got %s", Text("CFG_TEST_REF"))
+	}
+
+	os.Setenv("CFG_TEST_REF", "cfgtest://store/missing")
+	if err := Parse(); err == nil || !strings.Contains(err.Error(), "no such secret") {
+		t.Errorf("expected a reference failing to resolve to fail, got %v", err)
+	}
+}
+
+func TestRefreshReadsFilesAgain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "string")
+	ioutil.WriteFile(path, []byte("a"), 0600)
+	os.Setenv("CFG_TEST_STRING_FILE", path)
+	defer os.Unsetenv("CFG_TEST_STRING_FILE")
+	defer os.Unsetenv("CFG_TEST_STRING")
+	defer unparse()
+	if err := Parse(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(path, []byte("b"), 0600)
+	if err := Refresh(FileSource); err != nil {
+		t.Fatal(err)
+	}
+	if s := GetString("CFG_TEST_STRING"); s != "b" || Text("CFG_TEST_STRING") != "b" {
+		t.Errorf("expected the rotated value of the file, got %s", s)
+	}
+	ioutil.WriteFile(path, []byte("c"), 0600)
+	if err := Refresh(FileSource); err == nil || GetString("CFG_TEST_STRING") != "b" {
+		t.Errorf("expected an invalid value to fail and keep the last one, got %s %v", GetString("CFG_TEST_STRING"), err)
+	}
+}
@@ -0,0 +1,137 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Resolver returns the value a reference to a secret store stands for,
+// given the reference without its scheme, eg: secret/data/logspout#token for
+// vault://secret/data/logspout#token
+type Resolver func(ref string) (string, error)
+
+// FileSource is the scheme Refresh takes to read the options set in files
+// again, like those of Docker secrets
+const FileSource = "file"
+
+var (
+	resolvers  = map[string]Resolver{} // by scheme
+	afterParse []func() error
+)
+
+// RegisterResolver makes options set to references with the scheme, like
+// vault://path, take the value the resolver returns for them, from Parse
+// and each Refresh on. Like Register, it is called from init functions.
+func RegisterResolver(scheme string, resolve Resolver) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, exists := resolvers[scheme]; exists {
+		panic("cfg: resolver registered twice: " + scheme)
+	}
+	resolvers[scheme] = resolve
+}
+
+// AfterParse registers a function Parse calls once options have their
+// values, for modules acting on them before the jobs are set up
+func AfterParse(f func() error) {
+	afterParse = append(afterParse, f)
+}
+
+// isReference returns whether the text of an option is a reference with
+// the scheme of a registered resolver. Called with the registry locked.
+func isReference(text string) bool {
+	scheme := strings.SplitN(text, "://", 2)[0]
+	return resolvers[scheme] != nil && scheme != text
+}
+
+// Refresh resolves the options set to references with the schemes again,
+// and reads those set in files again for the FileSource, or does both for
+// every scheme if none are given, so credentials rotated in their store or
+// file are used from then on by the code getting options when it uses them.
+// Options that fail to resolve keep their value, and are named by the error.
+func Refresh(schemes ...string) error {
+	registry.RLock()
+	refs := map[string]string{}
+	files := map[string]string{} // by option, the file it was read from
+	for name, ref := range registry.refs {
+		scheme := strings.SplitN(ref, "://", 2)[0]
+		if len(schemes) == 0 || contains(schemes, scheme) {
+			refs[name] = ref
+		}
+	}
+	if len(schemes) == 0 || contains(schemes, FileSource) {
+		for name, source := range registry.sources {
+			if source != "env" && registry.refs[name] == "" {
+				files[name] = source
+			}
+		}
+	}
+	registry.RUnlock()
+	// resolvers may get options of their own, so the registry is unlocked
+	var problems []string
+	values := map[string]interface{}{}
+	texts := map[string]string{}
+	for name, ref := range refs {
+		parts := strings.SplitN(ref, "://", 2)
+		registry.RLock()
+		resolve, option := resolvers[parts[0]], registry.options[name]
+		registry.RUnlock()
+		text, err := resolve(parts[1])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%s: %s", name, ref, err))
+			continue
+		}
+		if text == "" {
+			text = option.Default
+		}
+		value, err := option.parse(text)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%s: %s", name, ref, err))
+			continue
+		}
+		values[name], texts[name] = value, text
+	}
+	for name, path := range files {
+		text, err := readSecret(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: reading %s: %s", name, path, err))
+			continue
+		}
+		registry.RLock()
+		option := registry.options[name]
+		registry.RUnlock()
+		if text == "" {
+			continue // being rewritten, or emptied: keeps its value
+		}
+		value, err := option.parse(text)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s from %s: %s", name, path, err))
+			continue
+		}
+		values[name], texts[name] = value, text
+	}
+	registry.Lock()
+	for name, value := range values {
+		registry.values[name] = value
+		if registry.refs[name] == "" {
+			registry.texts[name] = texts[name]
+		}
+	}
+	registry.Unlock()
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
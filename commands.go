@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/adapters/format"
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// command runs instead of the daemon when named by the first argument, its
+// arguments take the place of logspout's. It returns the exit code.
+type command struct {
+	run   func() int
+	usage string
+	help  string
+}
+
+var commands = map[string]command{
+	"validate": {validateCommand, "validate [URIS]", "check that each configured route can be created"},
+	"list":     {listCommand, "list [URIS]", "show running containers and the routes their logs are shipped to"},
+	"tail":     {tailCommand, "tail [OPTIONS]", "print what containers log from now on"},
+	"replay":   {replayCommand, "replay FILE ROUTE [OPTIONS]", "ship the lines of a DEAD_LETTER_FILE through a route"},
+	"import":   {importCommand, "import FILE ROUTE [OPTIONS]", "ship the lines of a log file, or stdin, through a route"},
+	"config":   {configCommand, "config", "show the value of every option, and whether it was set"},
+	"version":  {versionCommand, "version", "print the version"},
+}
+
+// runCommand runs the command named by the first argument, if any
+func runCommand() (int, bool) {
+	if len(os.Args) < 2 {
+		return 0, false
+	}
+	command, found := commands[os.Args[1]]
+	if !found {
+		return 0, false
+	}
+	os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	return command.run(), true
+}
+
+// printHelp prints the usage of logspout, its commands, and the options
+// registered with cfg
+func printHelp() {
+	fmt.Println("Usage: logspout [URIS]")
+	fmt.Println("       logspout COMMAND [ARGUMENTS]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\t%s\n", commands[name].usage, commands[name].help) //nolint:errcheck
+	}
+	w.Flush()
+	fmt.Println()
+	fmt.Println("Options are set with environment variables:")
+	cfg.WriteHelp(os.Stdout) //nolint:errcheck
+}
+
+func configCommand() int {
+	if err := cfg.WriteConfig(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	return 0
+}
+
+func versionCommand() int {
+	fmt.Println(Version)
+	return 0
+}
+
+// routeName returns how a route is shown by the commands
+func routeName(route *router.Route) string {
+	return route.Adapter + "://" + route.Address
+}
+
+// validateCommand checks that each configured route can be added, which
+// creates its adapter, so its options and templates are checked, and most
+// adapters connect to their destination
+func validateCommand() int {
+	routes, err := router.ConfiguredRoutes()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	if len(routes) == 0 {
+		fmt.Println("no routes configured")
+		return 0
+	}
+	failed := 0
+	for _, route := range routes {
+		if err := router.ValidateRoute(route); err != nil {
+			fmt.Printf("FAIL %s: %s\n", routeName(route), err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", routeName(route))
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// listCommand shows the running containers and the routes their logs
+// are shipped to
+func listCommand() int {
+	routes, err := router.ConfiguredRoutes()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	containers, err := client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tNAME\tROUTES") //nolint:errcheck
+	for _, listed := range containers {
+		container, err := client.InspectContainer(listed.ID)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "!!", err)
+			return 1
+		}
+		id, name := container.ID[:12], strings.TrimPrefix(container.Name, "/")
+		var shipped []string
+		if reason := router.IgnoreReason(container); reason != "" {
+			shipped = append(shipped, "(ignored: "+reason+")")
+		} else {
+			for _, route := range routes {
+				if route.MatchContainer(id, name, container.Config.Labels) {
+					shipped = append(shipped, routeName(route))
+				}
+			}
+		}
+		if len(shipped) == 0 {
+			shipped = append(shipped, "-")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", id, name, strings.Join(shipped, ",")) //nolint:errcheck
+	}
+	w.Flush()
+	return 0
+}
+
+// tailCommand prints the logs of running containers as they are written.
+// The optional argument holds route options like a route URI query, eg:
+// "filter.name=*_db&format=ndjson".
+func tailCommand() int {
+	query := ""
+	if len(os.Args) > 1 {
+		query = os.Args[1]
+	}
+	route, err := router.ParseRouteURI("tail://?" + query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	var formatter format.Formatter
+	if route.Options["format"] != "" {
+		if formatter, err = format.New(route); err != nil {
+			fmt.Fprintln(os.Stderr, "!!", err)
+			return 1
+		}
+	}
+
+	// only new lines are printed, and the checkpoints of a running logspout
+	// are left alone
+	os.Unsetenv("CHECKPOINT_FILE") //nolint:errcheck
+	os.Unsetenv("CATCHUP")         //nolint:errcheck
+	if err := cfg.Parse(); err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	pump, found := router.Jobs.Lookup("pump")
+	if !found {
+		fmt.Fprintln(os.Stderr, "!! pump not found")
+		return 1
+	}
+	if err := pump.Setup(); err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	go func() {
+		log.Fatalf("%s ended: %s", pump.Name(), pump.Run())
+	}()
+	logstream := make(chan *router.Message)
+	for _, logRouter := range router.LogRouters.All() {
+		go logRouter.Route(route, logstream)
+	}
+
+	nameWidth := 16
+	for message := range logstream {
+		if formatter != nil {
+			line, err := formatter.Format(message)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "!!", err)
+				continue
+			}
+			os.Stdout.Write(line) //nolint:errcheck
+			continue
+		}
+		name := strings.TrimPrefix(message.Container.Name, "/")
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+		fmt.Printf("%"+strconv.Itoa(nameWidth)+"s|%s\n", name, message.Data)
+	}
+	return 0
+}
+
+// replayCommand ships the lines of a dead-letter file, or stdin if "-",
+// through a route URI or the route of an ID, once its destination is
+// healthy again. The optional argument holds options like a route URI
+// query: rate, the lines shipped per second (default 100, 0 for
+// unlimited), route, to only replay the lines the route of an ID dropped,
+// and failed, the dead-letter file of lines dropped again (default FILE with
+// .failed appended).
+func replayCommand() int {
+	path, target, options, err := fileCommandArgs("replay")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	failed := options.Get("failed")
+	if failed == "" && path != "-" {
+		failed = path + ".failed"
+	}
+	if failed != "" { // lines dropped again are not appended to the file being read
+		os.Setenv("DEAD_LETTER_FILE", failed) //nolint:errcheck
+	}
+	if err := cfg.Parse(); err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	input, err := openInput(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	defer input.Close()
+	s, err := newShipper(target, options, 100)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	skipped := 0
+	err = router.ReadDeadLetters(input, func(entry *router.DeadLetter) error {
+		if from := options.Get("route"); from != "" && entry.RouteID != from {
+			skipped++
+			return nil
+		}
+		s.send(entry.Message())
+		return nil
+	})
+	s.close()
+	fmt.Printf("replayed %d lines to %s, skipped %d\n", s.sent, routeName(s.route), skipped)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	return 0
+}
+
+// importCommand ships the lines of a log file, or stdin if "-", through a
+// route URI or the route of an ID, as if a container had logged them, to
+// backfill historical logs. The optional argument holds options like a
+// route URI query: name, the container name of the lines (default the file
+// name without its extension), hostname, labels, as key:value pairs
+// separated by commas, time, the layout of the timestamp leading each line,
+// or rfc3339, and rate, the lines shipped per second (default unlimited).
+func importCommand() int {
+	path, target, options, err := fileCommandArgs("import")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	layout := options.Get("time")
+	if layout == "rfc3339" {
+		layout = time.RFC3339Nano
+	}
+	if layout != "" && os.Getenv("LOG_TIME") == "" {
+		// adapters taking it, like cloudwatch, timestamp lines with the time parsed
+		os.Setenv("LOG_TIME", "true") //nolint:errcheck
+	}
+	if err := cfg.Parse(); err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	container, err := importContainer(path, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	input, err := openInput(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	defer input.Close()
+	s, err := newShipper(target, options, 0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	logged := time.Now()
+	for scanner.Scan() {
+		line := scanner.Text()
+		if layout != "" {
+			// lines without a timestamp, like those of stack traces, take
+			// that of the line before
+			if t, ok := leadingTime(line, layout); ok {
+				logged = t
+			}
+		} else {
+			logged = time.Now()
+		}
+		s.send(&router.Message{Container: container, Source: "stdout", Data: line, Time: logged})
+	}
+	s.close()
+	fmt.Printf("imported %d lines to %s\n", s.sent, routeName(s.route))
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "!!", err)
+		return 1
+	}
+	return 0
+}
+
+// maxImportLine is the longest line the import command reads
+const maxImportLine = 4 * 1024 * 1024
+
+// importContainer returns the container imported lines are shipped as, so
+// templates of routes, like log group and stream names, render as for
+// containers
+func importContainer(path string, options url.Values) (*docker.Container, error) {
+	name := options.Get("name")
+	if name == "" {
+		name = "stdin"
+		if path != "-" {
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+	}
+	hostname := options.Get("hostname")
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	labels := map[string]string{}
+	if text := options.Get("labels"); text != "" {
+		for _, label := range strings.Split(text, ",") {
+			parts := strings.SplitN(label, ":", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("labels must be key:value pairs, not %q", label)
+			}
+			labels[parts[0]] = parts[1]
+		}
+	}
+	return &docker.Container{
+		ID:         "import-" + name,
+		Name:       "/" + name,
+		Config:     &docker.Config{Hostname: hostname, Labels: labels},
+		HostConfig: &docker.HostConfig{},
+	}, nil
+}
+
+// leadingTime parses the timestamp of a layout at the start of a line, as
+// long as the layout, or up to the first space for layouts of variable
+// length
+func leadingTime(line, layout string) (time.Time, bool) {
+	for _, end := range []int{len(layout), strings.IndexByte(line, ' ')} {
+		if end <= 0 || end > len(line) {
+			continue
+		}
+		if t, err := time.Parse(layout, line[:end]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// fileCommandArgs returns the arguments of commands taking a file, a route
+// and options like a route URI query
+func fileCommandArgs(name string) (path, target string, options url.Values, err error) {
+	if len(os.Args) < 3 {
+		return "", "", nil, fmt.Errorf("usage: logspout %s FILE ROUTE [OPTIONS]", name)
+	}
+	options = url.Values{}
+	if len(os.Args) > 3 {
+		if options, err = url.ParseQuery(os.Args[3]); err != nil {
+			return "", "", nil, err
+		}
+	}
+	return os.Args[1], os.Args[2], options, nil
+}
+
+// openInput opens a file, or returns stdin for "-"
+func openInput(path string) (*os.File, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+// shipper streams lines through the adapter of a route, at the rate option
+// of a command, for commands shipping a fixed set of lines
+type shipper struct {
+	route     *router.Route
+	adapter   router.LogAdapter
+	logstream chan *router.Message
+	streamed  chan struct{}
+	ticker    *time.Ticker // nil if unlimited
+	sent      int
+}
+
+// newShipper returns the shipper of a route URI, or of the configured route
+// of an ID, with the rate option, the lines shipped per second, or the
+// given default if not set
+func newShipper(target string, options url.Values, rate int) (*shipper, error) {
+	if text := options.Get("rate"); text != "" {
+		var err error
+		if rate, err = strconv.Atoi(text); err != nil || rate < 0 {
+			return nil, errors.New("rate must be a number of lines per second")
+		}
+	}
+	route, err := commandRoute(target)
+	if err != nil {
+		return nil, err
+	}
+	factory, found := router.AdapterFactories.Lookup(route.AdapterType())
+	if !found {
+		return nil, errors.New("bad adapter: " + route.Adapter)
+	}
+	adapter, err := factory(route)
+	if err != nil {
+		return nil, err
+	}
+	s := &shipper{
+		route:     route,
+		adapter:   adapter,
+		logstream: make(chan *router.Message),
+		streamed:  make(chan struct{}),
+	}
+	if rate > 0 {
+		s.ticker = time.NewTicker(time.Second / time.Duration(rate))
+	}
+	go func() {
+		adapter.Stream(s.logstream)
+		close(s.streamed)
+	}()
+	return s, nil
+}
+
+func (s *shipper) send(msg *router.Message) {
+	if s.ticker != nil {
+		<-s.ticker.C
+	}
+	s.logstream <- msg
+	s.sent++
+}
+
+// close returns once the lines sent were shipped, or dead-lettered by
+// adapters flushing them
+func (s *shipper) close() {
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	close(s.logstream)
+	<-s.streamed
+	if flusher, ok := s.adapter.(router.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// commandRoute returns the route of a route URI, or the configured route of
+// an ID
+func commandRoute(target string) (*router.Route, error) {
+	if strings.Contains(target, "://") {
+		route, err := router.ParseRouteURI(target)
+		if err == nil {
+			route.ID = "command"
+		}
+		return route, err
+	}
+	routes, err := router.ConfiguredRoutes()
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		if route.ID == target {
+			return route, nil
+		}
+	}
+	return nil, fmt.Errorf("no route %s, give its ID or a route URI", target)
+}
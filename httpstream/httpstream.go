@@ -5,12 +5,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"golang.org/x/net/websocket"
 
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
 )
 
@@ -19,7 +19,7 @@ func init() {
 }
 
 func debug(v ...interface{}) {
-	if os.Getenv("DEBUG") != "" {
+	if cfg.GetString("DEBUG") != "" {
 		log.Println(v...)
 	}
 }
@@ -0,0 +1,306 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// handshakeTimeout is how long a client of the forward protocol may take to
+// authenticate
+const handshakeTimeout = 10 * time.Second
+
+// TagLabel is the label of the containers of forwarded records holding
+// their tag
+const TagLabel = "fluent.tag"
+
+func init() {
+	router.Jobs.Register(&forwarder{}, "forward")
+	cfg.Register(
+		cfg.Option{Name: "FORWARD_ADDRESS",
+			Description: "address to accept the forward protocol of fluentd and fluent-bit on, eg: :24224"},
+		cfg.Option{Name: "FORWARD_SHARED_KEY", Secret: true,
+			Description: "key clients of the forward protocol authenticate with, if set"},
+		cfg.Option{Name: "FORWARD_HOSTNAME",
+			Description: "host name logspout authenticates to clients of the forward protocol with (default the host name)"},
+	)
+}
+
+// forwarder is the job accepting the forward protocol, and shipping the
+// records forwarded through the log router of the ingester
+type forwarder struct {
+	address   string
+	sharedKey string
+	hostname  string
+	ingester  *ingester
+	listener  net.Listener
+}
+
+func (f *forwarder) Name() string {
+	if f.address == "" {
+		return ""
+	}
+	return "forward[" + f.address + "]"
+}
+
+func (f *forwarder) Setup() error {
+	f.address = cfg.GetString("FORWARD_ADDRESS")
+	if f.address == "" {
+		return nil
+	}
+	f.sharedKey = cfg.GetString("FORWARD_SHARED_KEY")
+	f.hostname = cfg.GetString("FORWARD_HOSTNAME")
+	if f.hostname == "" {
+		f.hostname, _ = os.Hostname()
+	}
+	if f.ingester == nil {
+		f.ingester = theIngester
+	}
+	var err error
+	f.listener, err = net.Listen("tcp", f.address)
+	return err
+}
+
+func (f *forwarder) Run() error {
+	if f.address == "" {
+		select {}
+	}
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := f.serve(conn); err != nil && err != io.EOF {
+				log.Printf("forward: %s: %s\n", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serve authenticates a client, if a shared key is set, and ships the
+// records of its messages until it disconnects
+func (f *forwarder) serve(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if f.sharedKey != "" {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
+		if err := f.handshake(conn, r); err != nil {
+			return err
+		}
+		conn.SetDeadline(time.Time{}) //nolint:errcheck
+	}
+	for {
+		msg, err := decodeMsgpack(r)
+		if err != nil {
+			return err
+		}
+		chunk, err := f.receive(msg)
+		if err != nil {
+			return err
+		}
+		if chunk != "" {
+			if _, err := conn.Write(encodeMsgpack(nil, msgpackMap{"ack", chunk})); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handshake sends HELO and checks the PING of the client, answering with PONG
+func (f *forwarder) handshake(conn net.Conn, r *bufio.Reader) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	helo := []interface{}{"HELO", msgpackMap{"nonce", nonce, "auth", []byte{}, "keepalive", true}}
+	if _, err := conn.Write(encodeMsgpack(nil, helo)); err != nil {
+		return err
+	}
+	msg, err := decodeMsgpack(r)
+	if err != nil {
+		return err
+	}
+	ping, _ := msg.([]interface{})
+	if len(ping) < 4 || asString(ping[0]) != "PING" {
+		return errors.New("expected PING")
+	}
+	clientHostname, salt, digest := asString(ping[1]), asString(ping[2]), asString(ping[3])
+	expected := sharedKeyDigest(salt, clientHostname, nonce, f.sharedKey)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(expected)) != 1 {
+		pong := []interface{}{"PONG", false, "shared key mismatch", "", ""}
+		conn.Write(encodeMsgpack(nil, pong)) //nolint:errcheck
+		return fmt.Errorf("client %s: shared key mismatch", clientHostname)
+	}
+	pong := []interface{}{"PONG", true, "", f.hostname, sharedKeyDigest(salt, f.hostname, nonce, f.sharedKey)}
+	_, err = conn.Write(encodeMsgpack(nil, pong))
+	return err
+}
+
+// sharedKeyDigest is the hex SHA-512 digest of the handshake of the
+// forward protocol
+func sharedKeyDigest(salt, hostname string, nonce []byte, key string) string {
+	h := sha512.New()
+	h.Write([]byte(salt))     //nolint:errcheck
+	h.Write([]byte(hostname)) //nolint:errcheck
+	h.Write(nonce)            //nolint:errcheck
+	h.Write([]byte(key))      //nolint:errcheck
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// receive ships the records of a message in any of the modes of the
+// forward protocol, returning the chunk to acknowledge, if any
+func (f *forwarder) receive(msg interface{}) (string, error) {
+	parts, _ := msg.([]interface{})
+	if len(parts) < 2 {
+		return "", errors.New("invalid message")
+	}
+	tag := asString(parts[0])
+	var option map[string]interface{}
+	now := time.Now()
+	switch entries := parts[1].(type) {
+	case []interface{}: // Forward: [tag, [[time, record], ...], option]
+		if len(parts) > 2 {
+			option, _ = parts[2].(map[string]interface{})
+		}
+		for _, e := range entries {
+			pair, _ := e.([]interface{})
+			if len(pair) < 2 {
+				return "", errors.New("invalid entry")
+			}
+			f.ship(tag, pair[0], pair[1], now)
+		}
+	case string, []byte: // PackedForward: [tag, entries, option]
+		if len(parts) > 2 {
+			option, _ = parts[2].(map[string]interface{})
+		}
+		packed := []byte(asString(entries))
+		var r io.Reader = bytes.NewReader(packed)
+		if asString(option["compressed"]) == "gzip" {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return "", err
+			}
+			r = gz
+		}
+		br := bufio.NewReader(r)
+		for {
+			e, err := decodeMsgpack(br)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return "", err
+			}
+			pair, _ := e.([]interface{})
+			if len(pair) < 2 {
+				return "", errors.New("invalid entry")
+			}
+			f.ship(tag, pair[0], pair[1], now)
+		}
+	default: // Message: [tag, time, record, option]
+		if len(parts) < 3 {
+			return "", errors.New("invalid message")
+		}
+		if len(parts) > 3 {
+			option, _ = parts[3].(map[string]interface{})
+		}
+		f.ship(tag, parts[1], parts[2], now)
+	}
+	return asString(option["chunk"]), nil
+}
+
+// ship sends a record as a line of the container it names, or else of a
+// container named after its tag
+func (f *forwarder) ship(tag string, t, r interface{}, received time.Time) {
+	record, _ := r.(map[string]interface{})
+	e := &entry{name: tag, labels: map[string]string{TagLabel: tag}, timeUnixNano: eventTime(t)}
+	if name := asString(record["container_name"]); name != "" {
+		e.name = strings.TrimPrefix(name, "/")
+	}
+	e.id = asString(record["container_id"])
+	if source := asString(record["source"]); source == "stdout" || source == "stderr" {
+		e.source = source
+	}
+	if stream := asString(record["stream"]); stream == "stdout" || stream == "stderr" {
+		e.source = stream
+	}
+	if data, ok := record["log"]; ok {
+		e.data = strings.TrimSuffix(asString(data), "\n")
+	} else if data, ok := record["message"]; ok {
+		e.data = asString(data)
+	} else {
+		data, _ := json.Marshal(jsonValue(record))
+		e.data = string(data)
+	}
+	f.ingester.send(newMessage(e, received))
+}
+
+// eventTime returns the nanoseconds since the epoch of the time of a record,
+// an integer of seconds or an EventTime, and 0 if it has none
+func eventTime(t interface{}) int64 {
+	switch t := t.(type) {
+	case int64:
+		return t * int64(time.Second)
+	case uint64:
+		return int64(t) * int64(time.Second)
+	case float64:
+		return int64(t * float64(time.Second))
+	case msgpackExt:
+		if t.typ == 0 && len(t.data) == 8 {
+			return int64(binary.BigEndian.Uint32(t.data[:4]))*int64(time.Second) + int64(binary.BigEndian.Uint32(t.data[4:]))
+		}
+	}
+	return 0
+}
+
+// asString returns the text of a string or binary value
+func asString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// jsonValue returns a decoded value with the binary values as strings, as
+// fluentd often sends strings as such
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = jsonValue(value)
+		}
+		return values
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			values[key] = jsonValue(value)
+		}
+		return values
+	case msgpackExt:
+		return v.data
+	}
+	return v
+}
@@ -0,0 +1,156 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	values := []interface{}{
+		nil, true, false, int64(-1), int64(-1000), uint64(5), uint64(1 << 40), 1.5, "short",
+		string(make([]byte, 300)), []byte("bin"), msgpackExt{typ: 0, data: make([]byte, 8)},
+		[]interface{}{"a", uint64(1)},
+	}
+	for _, v := range values {
+		decoded, err := decodeMsgpack(bufio.NewReader(bytes.NewReader(encodeMsgpack(nil, v))))
+		if err != nil {
+			t.Fatalf("%v: %s", v, err)
+		}
+		if !bytes.Equal(encodeMsgpack(nil, decoded), encodeMsgpack(nil, v)) {
+			t.Errorf("expected %v, got %v", v, decoded)
+		}
+	}
+	decoded, err := decodeMsgpack(bufio.NewReader(bytes.NewReader(encodeMsgpack(nil, msgpackMap{"k", "v"}))))
+	if m, ok := decoded.(map[string]interface{}); err != nil || !ok || m["k"] != "v" {
+		t.Errorf("unexpected map %v: %v", decoded, err)
+	}
+	if _, err := decodeMsgpack(bufio.NewReader(bytes.NewReader([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}))); err != errMsgpackTooLong {
+		t.Errorf("expected a too long array to be refused, got %v", err)
+	}
+}
+
+func TestMsgpackDepth(t *testing.T) {
+	nested := append(bytes.Repeat([]byte{0x91}, maxMsgpackDepth), 0xc0) // [[...[nil]...]]
+	if _, err := decodeMsgpack(bufio.NewReader(bytes.NewReader(nested))); err != nil {
+		t.Errorf("expected %d nested arrays to be decoded, got %v", maxMsgpackDepth, err)
+	}
+	for _, nested := range [][]byte{
+		bytes.Repeat([]byte{0x91}, 4*1024*1024),
+		bytes.Repeat([]byte{0x81, 0xa1, 'k'}, 1024*1024),
+	} {
+		if _, err := decodeMsgpack(bufio.NewReader(bytes.NewReader(nested))); err != errMsgpackTooDeep {
+			t.Errorf("expected a value nested too deep to be refused, got %v", err)
+		}
+	}
+}
+
+func eventTimeExt(t time.Time) msgpackExt {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(data[4:], uint32(t.Nanosecond()))
+	return msgpackExt{typ: 0, data: data}
+}
+
+func TestForward(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &ingester{routes: map[chan *router.Message]*router.Route{}}
+	f := &forwarder{address: listener.Addr().String(), sharedKey: "secret", hostname: "logspout", ingester: i, listener: listener}
+	go f.Run() //nolint:errcheck
+	defer listener.Close()
+	route := &router.Route{ID: "test"}
+	logstream := make(chan *router.Message, 10)
+	go i.Route(route, logstream)
+	defer route.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", f.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	helo, err := decodeMsgpack(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := helo.([]interface{})[1].(map[string]interface{})["nonce"].([]byte)
+	ping := []interface{}{"PING", "fluent-bit", "salt", sharedKeyDigest("salt", "fluent-bit", nonce, "secret"), "", ""}
+	conn.Write(encodeMsgpack(nil, ping)) //nolint:errcheck
+	pong, err := decodeMsgpack(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := pong.([]interface{}); p[1] != true || p[4] != sharedKeyDigest("salt", "logspout", nonce, "secret") {
+		t.Fatalf("unexpected PONG %v", p)
+	}
+
+	logged := time.Date(2020, 11, 2, 10, 0, 0, 5, time.UTC)
+	conn.Write(encodeMsgpack(nil, []interface{}{"app.web", eventTimeExt(logged), //nolint:errcheck
+		msgpackMap{"log", "message mode\n", "stream", "stderr", "container_name", "/web"}}))
+	conn.Write(encodeMsgpack(nil, []interface{}{"app.db", []interface{}{ //nolint:errcheck
+		[]interface{}{int64(logged.Unix()), msgpackMap{"message", "forward mode"}},
+	}}))
+	var packed bytes.Buffer
+	gz := gzip.NewWriter(&packed)
+	gz.Write(encodeMsgpack(nil, []interface{}{int64(logged.Unix()), msgpackMap{"level", "info"}})) //nolint:errcheck
+	gz.Close()
+	conn.Write(encodeMsgpack(nil, []interface{}{"app.job", packed.Bytes(), //nolint:errcheck
+		msgpackMap{"compressed", "gzip", "chunk", "abc"}}))
+	ack, err := decodeMsgpack(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.(map[string]interface{})["ack"] != "abc" {
+		t.Errorf("unexpected ack %v", ack)
+	}
+
+	expected := []struct{ name, source, data string }{
+		{"/web", "stderr", "message mode"}, {"/app.db", "stdout", "forward mode"}, {"/app.job", "stdout", `{"level":"info"}`},
+	}
+	for _, e := range expected {
+		select {
+		case m := <-logstream:
+			if m.Container.Name != e.name || m.Source != e.source || m.Data != e.data {
+				t.Errorf("expected %q from %s %s, got %q from %s %s", e.data, e.name, e.source, m.Data, m.Container.Name, m.Source)
+			}
+			if e.name == "/web" && (!m.Time.Equal(logged) || m.Container.Config.Labels[TagLabel] != "app.web") {
+				t.Errorf("unexpected time %s and labels %v", m.Time, m.Container.Config.Labels)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %q", e.data)
+		}
+	}
+}
+
+func TestForwardSharedKeyMismatch(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	f := &forwarder{sharedKey: "secret", hostname: "logspout"}
+	done := make(chan error, 1)
+	go func() { done <- f.serve(server) }()
+	r := bufio.NewReader(client)
+	if _, err := decodeMsgpack(r); err != nil {
+		t.Fatal(err)
+	}
+	client.Write(encodeMsgpack(nil, []interface{}{"PING", "fluent-bit", "salt", "wrong", "", ""})) //nolint:errcheck
+	pong, err := decodeMsgpack(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pong.([]interface{})[1] != false {
+		t.Errorf("expected the client to be refused, got %v", pong)
+	}
+	if err := <-done; err == nil {
+		t.Error("expected the connection to end with an error")
+	}
+}
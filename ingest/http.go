@@ -0,0 +1,128 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func init() {
+	cfg.Register(cfg.Option{Name: "HTTP_INGEST", Type: cfg.Bool, Default: "false",
+		Description: "serve POST /ingest, which scripts post log entries to as JSON"})
+}
+
+// jsonEntry is an entry posted to /ingest, with the fields of the Entry of
+// ingest.proto
+type jsonEntry struct {
+	Data     string            `json:"data"`
+	Source   string            `json:"source"`
+	Time     time.Time         `json:"time"`
+	Name     string            `json:"name"`
+	ID       string            `json:"id"`
+	Hostname string            `json:"hostname"`
+	Image    string            `json:"image"`
+	Labels   map[string]string `json:"labels"`
+}
+
+type ingestResponse struct {
+	Accepted int    `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (i *ingester) httpHandler() http.Handler {
+	if !cfg.GetBool("HTTP_INGEST") {
+		return http.NotFoundHandler()
+	}
+	return http.HandlerFunc(i.serveJSON)
+}
+
+// serveJSON ships the entries of a JSON array, or of JSON objects one after
+// the other like NDJSON, answering with the number shipped. Those before an
+// invalid entry are shipped.
+func (i *ingester) serveJSON(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "entries are posted", http.StatusMethodNotAllowed)
+		return
+	}
+	var resp ingestResponse
+	err := decodeEntries(req.Body, func(e *jsonEntry) error {
+		if e.Name == "" {
+			return fmt.Errorf("entry %d: entries need a name", resp.Accepted+1)
+		}
+		i.send(newMessage(&entry{
+			data: e.Data, source: e.Source, name: e.Name, id: e.ID, hostname: e.Hostname, image: e.Image,
+			timeUnixNano: unixNano(e.Time), labels: e.Labels,
+		}, time.Now()))
+		resp.Accepted++
+		return nil
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		resp.Error = err.Error()
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "request body too large") {
+			status = http.StatusRequestEntityTooLarge
+		}
+		w.WriteHeader(status)
+	}
+	json.NewEncoder(w).Encode(&resp) //nolint:errcheck
+}
+
+// decodeEntries calls each for the entries of a JSON array or of JSON
+// objects, until it returns an error
+func decodeEntries(body io.Reader, each func(*jsonEntry) error) error {
+	r := bufio.NewReader(body)
+	array := false
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			r.ReadByte() //nolint:errcheck
+			continue
+		}
+		array = b[0] == '['
+		break
+	}
+	decoder := json.NewDecoder(r)
+	if array {
+		decoder.Token() //nolint:errcheck // the opening bracket
+	}
+	for n := 1; array && decoder.More() || !array; n++ {
+		var e jsonEntry
+		if err := decoder.Decode(&e); err == io.EOF && !array {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("entry %d: %s", n, err)
+		}
+		if e.Labels == nil {
+			e.Labels = map[string]string{}
+		}
+		if err := each(&e); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil { // the closing bracket
+		return err
+	}
+	return nil
+}
+
+// unixNano returns the nanoseconds of a time since the epoch, 0 for the zero
+// time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
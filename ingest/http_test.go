@@ -0,0 +1,61 @@
+package ingest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestIngestJSON(t *testing.T) {
+	os.Setenv("HTTP_INGEST", "true")
+	defer os.Unsetenv("HTTP_INGEST")
+	i := &ingester{routes: map[chan *router.Message]*router.Route{}}
+	handler := i.httpHandler()
+	route := &router.Route{ID: "test"}
+	logstream := make(chan *router.Message, 10)
+	go i.Route(route, logstream)
+	defer route.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	post := func(body string) (int, ingestResponse) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/ingest", strings.NewReader(body)))
+		var resp ingestResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	code, resp := post(`[{"name": "job", "data": "first", "time": "2020-11-02T10:00:00Z", "labels": {"team": "data"}},
+		{"name": "job", "data": "second", "source": "stderr"}]`)
+	if code != http.StatusOK || resp.Accepted != 2 {
+		t.Fatalf("unexpected response %d %+v", code, resp)
+	}
+	first, second := <-logstream, <-logstream
+	if first.Data != "first" || !first.Time.Equal(time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)) ||
+		first.Container.Name != "/job" || first.Container.Config.Labels["team"] != "data" {
+		t.Errorf("unexpected message %+v", first)
+	}
+	if second.Source != "stderr" || second.Time.IsZero() {
+		t.Errorf("unexpected message %+v", second)
+	}
+
+	code, resp = post("{\"name\": \"job\", \"data\": \"a\"}\n{\"name\": \"job\", \"data\": \"b\"}\n")
+	if code != http.StatusOK || resp.Accepted != 2 || len(logstream) != 2 {
+		t.Errorf("unexpected response to NDJSON %d %+v", code, resp)
+	}
+	<-logstream
+	<-logstream
+
+	code, resp = post("{\"name\": \"job\", \"data\": \"a\"}\n{\"data\": \"no name\"}\n")
+	if code != http.StatusBadRequest || resp.Accepted != 1 || !strings.Contains(resp.Error, "entry 2") {
+		t.Errorf("expected the entry without name to be refused, got %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,223 @@
+// Package ingest serves a gRPC service on the HTTP port of logspout, which
+// other agents, like sidecars or applications, push log entries to, POST
+// /ingest, which scripts post them to as JSON, and the forward protocol of
+// fluentd and fluent-bit. The entries are shipped
+// by the routes matching them as the lines of the containers they name,
+// alongside those logspout reads from Docker.
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// service is the gRPC service of ingest.proto, served at /service/method
+const service = "logspout.ingest.v1.Ingest"
+
+// gRPC status codes
+const (
+	codeOK                = 0
+	codeInvalidArgument   = 3
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+)
+
+// theIngester sends the entries of every input to the routes
+var theIngester = &ingester{routes: map[chan *router.Message]*router.Route{}}
+
+func init() {
+	router.HTTPHandlers.Register(theIngester.handler, service)
+	router.HTTPHandlers.Register(theIngester.httpHandler, "ingest")
+	router.LogRouters.Register(theIngester, "ingest")
+	cfg.Register(cfg.Option{Name: "GRPC_INGEST", Type: cfg.Bool, Default: "false",
+		Description: "serve the gRPC service other agents push log entries to on the HTTP port"})
+}
+
+// ingester serves the gRPC service, and is the log router sending the
+// entries pushed to it to the routes
+type ingester struct {
+	routesMu sync.Mutex
+	routes   map[chan *router.Message]*router.Route
+}
+
+// status is the gRPC status of a call
+type status struct {
+	code    int
+	message string
+}
+
+func (i *ingester) handler() http.Handler {
+	if !cfg.GetBool("GRPC_INGEST") {
+		return http.NotFoundHandler()
+	}
+	return http.HandlerFunc(i.serve)
+}
+
+func (i *ingester) serve(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "the ingest service only serves gRPC", http.StatusUnsupportedMediaType)
+		return
+	}
+	if req.ProtoMajor != 2 {
+		http.Error(w, "gRPC needs HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	var accepted uint64
+	s := status{code: codeOK}
+	switch strings.TrimPrefix(req.URL.Path, "/"+service+"/") {
+	case "Push":
+		accepted, s = i.receive(req.Body, false)
+	case "Stream":
+		accepted, s = i.receive(req.Body, true)
+	default:
+		s = status{codeUnimplemented, "unknown method " + req.URL.Path}
+	}
+	if s.code == codeOK {
+		writeMessage(w, encodePushResponse(accepted))
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(s.code))
+	if s.message != "" {
+		w.Header().Set("Grpc-Message", s.message)
+	}
+}
+
+// receive ships the entries of the messages of a call, the first one only
+// unless it streams them
+func (i *ingester) receive(body io.Reader, stream bool) (uint64, status) {
+	var accepted uint64
+	maxSize := cfg.GetInt("HTTP_MAX_BODY_SIZE")
+	for n := 0; stream || n == 0; n++ {
+		msg, err := readMessage(body, maxSize)
+		if err == io.EOF && n > 0 {
+			break
+		}
+		if err != nil {
+			if err == errTooLarge {
+				return accepted, status{codeResourceExhausted, err.Error()}
+			}
+			return accepted, status{codeInvalidArgument, err.Error()}
+		}
+		entries, err := decodePushRequest(msg)
+		if err != nil {
+			return accepted, status{codeInvalidArgument, err.Error()}
+		}
+		now := time.Now()
+		for _, e := range entries {
+			if e.name == "" {
+				return accepted, status{codeInvalidArgument, "entries need a name"}
+			}
+			i.send(newMessage(e, now))
+			accepted++
+		}
+	}
+	return accepted, status{code: codeOK}
+}
+
+var errTooLarge = errors.New("message larger than HTTP_MAX_BODY_SIZE")
+
+// readMessage reads a length-prefixed message of a gRPC call
+func readMessage(r io.Reader, maxSize int) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errTruncated
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if maxSize > 0 && int64(length) > int64(maxSize) {
+		return nil, errTooLarge
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errTruncated
+	}
+	return msg, nil
+}
+
+// writeMessage writes a length-prefixed message of a gRPC call
+func writeMessage(w io.Writer, msg []byte) {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	w.Write(append(frame, msg...)) //nolint:errcheck
+}
+
+// newMessage returns the line of an entry, from a container with the name,
+// ID, host name, image and labels of the entry
+func newMessage(e *entry, received time.Time) *router.Message {
+	id := e.id
+	if id == "" {
+		id = e.name
+	}
+	source := e.source
+	if source == "" {
+		source = "stdout"
+	}
+	logged := received
+	if e.timeUnixNano != 0 {
+		logged = time.Unix(0, e.timeUnixNano)
+	}
+	return &router.Message{
+		Container: &docker.Container{
+			ID:         id,
+			Name:       "/" + strings.TrimPrefix(e.name, "/"),
+			Config:     &docker.Config{Hostname: e.hostname, Image: e.image, Labels: e.labels},
+			HostConfig: &docker.HostConfig{},
+		},
+		Source: source,
+		Data:   e.data,
+		Time:   logged,
+	}
+}
+
+// send sends a line to the routes matching it, waiting for them to take
+// it, so clients pushing faster than the routes ship are held up
+func (i *ingester) send(msg *router.Message) {
+	if router.Filtered(msg.Container) {
+		return
+	}
+	i.routesMu.Lock()
+	defer i.routesMu.Unlock()
+	for logstream, route := range i.routes {
+		if !route.MatchContainer(msg.Container.ID, strings.TrimPrefix(msg.Container.Name, "/"), msg.Container.Config.Labels) ||
+			!route.MatchMessage(msg) {
+			continue
+		}
+		logstream <- msg
+	}
+}
+
+// Route sends the entries matching a route to it, until the route is closed
+func (i *ingester) Route(route *router.Route, logstream chan *router.Message) {
+	i.routesMu.Lock()
+	i.routes[logstream] = route
+	i.routesMu.Unlock()
+	<-route.Closer()
+	i.routesMu.Lock()
+	delete(i.routes, logstream)
+	i.routesMu.Unlock()
+}
+
+// RoutingFrom returns false, as entries are pushed rather than read from
+// containers
+func (i *ingester) RoutingFrom(id string) bool {
+	return false
+}
@@ -0,0 +1,132 @@
+package ingest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// appendField appends a length-delimited field to an encoded message
+func appendField(msg []byte, number int, value []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	msg = append(msg, buf[:binary.PutUvarint(buf, uint64(number)<<3|wireBytes)]...)
+	msg = append(msg, buf[:binary.PutUvarint(buf, uint64(len(value)))]...)
+	return append(msg, value...)
+}
+
+func encodeEntry(name, data string, timeUnixNano int64, labels map[string]string) []byte {
+	var e []byte
+	e = appendField(e, 1, []byte(data))
+	e = appendField(e, 4, []byte(name))
+	if timeUnixNano != 0 {
+		buf := make([]byte, binary.MaxVarintLen64)
+		e = append(e, 3<<3|wireVarint)
+		e = append(e, buf[:binary.PutUvarint(buf, uint64(timeUnixNano))]...)
+	}
+	for key, value := range labels {
+		e = appendField(e, 8, appendField(appendField(nil, 1, []byte(key)), 2, []byte(value)))
+	}
+	return e
+}
+
+func frame(msg []byte) []byte {
+	var b bytes.Buffer
+	writeMessage(&b, msg)
+	return b.Bytes()
+}
+
+func call(t *testing.T, url string, body []byte) (*http.Response, []byte) {
+	t.Helper()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func TestIngest(t *testing.T) {
+	os.Setenv("GRPC_INGEST", "true")
+	defer os.Unsetenv("GRPC_INGEST")
+	i := &ingester{routes: map[chan *router.Message]*router.Route{}}
+	server := httptest.NewServer(h2c.NewHandler(i.handler(), &http2.Server{}))
+	defer server.Close()
+	route := &router.Route{ID: "test", FilterName: "api"}
+	logstream := make(chan *router.Message, 10)
+	go i.Route(route, logstream)
+	defer route.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	logged := time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)
+	push := appendField(nil, 1, encodeEntry("api", "hello", logged.UnixNano(), map[string]string{"team": "core"}))
+	push = appendField(push, 1, encodeEntry("worker", "not routed", 0, nil))
+	resp, data := call(t, server.URL+"/"+service+"/Push", frame(push))
+	if resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("unexpected status %s: %s", resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message"))
+	}
+	if !bytes.Equal(data, frame(encodePushResponse(2))) {
+		t.Errorf("unexpected response %x", data)
+	}
+	select {
+	case m := <-logstream:
+		if m.Data != "hello" || m.Source != "stdout" || !m.Time.Equal(logged) || m.Container.Name != "/api" ||
+			m.Container.ID != "api" || m.Container.Config.Labels["team"] != "core" {
+			t.Errorf("unexpected message %+v from %+v", m, m.Container)
+		}
+	default:
+		t.Fatal("expected a message")
+	}
+	if len(logstream) != 0 {
+		t.Error("expected only the matching entry to be routed")
+	}
+
+	stream := append(frame(appendField(nil, 1, encodeEntry("api", "first", 0, nil))),
+		frame(appendField(nil, 1, encodeEntry("api", "second", 0, nil)))...)
+	resp, data = call(t, server.URL+"/"+service+"/Stream", stream)
+	if resp.Trailer.Get("Grpc-Status") != "0" || !bytes.Equal(data, frame(encodePushResponse(2))) || len(logstream) != 2 {
+		t.Errorf("unexpected status %s and response %x of the stream", resp.Trailer.Get("Grpc-Status"), data)
+	}
+
+	resp, _ = call(t, server.URL+"/"+service+"/Push", frame(appendField(nil, 1, encodeEntry("", "no name", 0, nil))))
+	if resp.Trailer.Get("Grpc-Status") != "3" {
+		t.Errorf("expected an entry without name to be refused, got status %s", resp.Trailer.Get("Grpc-Status"))
+	}
+	resp, _ = call(t, server.URL+"/"+service+"/Other", frame(nil))
+	if resp.Trailer.Get("Grpc-Status") != "12" {
+		t.Errorf("expected an unknown method to be unimplemented, got status %s", resp.Trailer.Get("Grpc-Status"))
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	push := appendField(nil, 1, encodeEntry("api", "hello", 0, nil))
+	if _, err := decodePushRequest(push[:len(push)-2]); err == nil {
+		t.Error("expected a truncated message to be refused")
+	}
+}
@@ -0,0 +1,299 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxMsgpackLength is the longest string, binary, array or map a msgpack
+// value may have, for malformed lengths not to exhaust the memory
+const maxMsgpackLength = 64 * 1024 * 1024
+
+// maxMsgpackDepth is the most arrays and maps a msgpack value may nest, for
+// deeply nested input not to overflow the stack
+const maxMsgpackDepth = 100
+
+var (
+	errMsgpackTooLong = errors.New("msgpack value too long")
+	errMsgpackTooDeep = errors.New("msgpack value nested too deep")
+)
+
+// msgpackExt is a value of an extension type of msgpack
+type msgpackExt struct {
+	typ  int8
+	data []byte
+}
+
+// decodeMsgpack reads a msgpack value, returning nil, bool, int64, uint64,
+// float64, string, []byte, []interface{}, map[string]interface{}, with keys
+// that are not strings formatted, or msgpackExt
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	return decodeValue(r, 0)
+}
+
+// decodeValue reads a msgpack value within depth arrays and maps
+func decodeValue(r *bufio.Reader, depth int) (interface{}, error) { //nolint:gocyclo
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return decodeMap(r, int(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return decodeArray(r, int(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return decodeString(r, int(b&0x1f))
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readLength(r, b-0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(r, n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readLength(r, b-0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return decodeExt(r, n)
+	case 0xca:
+		v, err := readUint(r, 4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := readUint(r, 8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return readUint(r, 1<<(b-0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		v, err := readUint(r, size)
+		shift := uint(64 - 8*size) // sign extended
+		return int64(v<<shift) >> shift, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeExt(r, 1<<(b-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := readLength(r, b-0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return decodeString(r, n)
+	case 0xdc, 0xdd:
+		n, err := readLength(r, b-0xdc+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeArray(r, n, depth)
+	case 0xde, 0xdf:
+		n, err := readLength(r, b-0xde+1)
+		if err != nil {
+			return nil, err
+		}
+		return decodeMap(r, n, depth)
+	}
+	return nil, fmt.Errorf("invalid msgpack type 0x%x", b)
+}
+
+// readUint reads a big-endian unsigned integer of size bytes
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, unexpected(err)
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+// readLength reads a length of 1, 2 or 4 bytes, for sizes 0, 1 and 2
+func readLength(r *bufio.Reader, size byte) (int, error) {
+	n, err := readUint(r, 1<<size)
+	if err != nil {
+		return 0, err
+	}
+	if n > maxMsgpackLength {
+		return 0, errMsgpackTooLong
+	}
+	return int(n), nil
+}
+
+func readBytes(r *bufio.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, unexpected(err)
+}
+
+func decodeString(r *bufio.Reader, n int) (interface{}, error) {
+	b, err := readBytes(r, n)
+	return string(b), err
+}
+
+func decodeExt(r *bufio.Reader, n int) (interface{}, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	data, err := readBytes(r, n)
+	return msgpackExt{typ: int8(typ), data: data}, err
+}
+
+func decodeArray(r *bufio.Reader, n, depth int) (interface{}, error) {
+	if depth >= maxMsgpackDepth {
+		return nil, errMsgpackTooDeep
+	}
+	values := make([]interface{}, 0, minInt(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := decodeValue(r, depth+1)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func decodeMap(r *bufio.Reader, n, depth int) (interface{}, error) {
+	if depth >= maxMsgpackDepth {
+		return nil, errMsgpackTooDeep
+	}
+	values := make(map[string]interface{}, minInt(n, 1024))
+	for i := 0; i < n; i++ {
+		key, err := decodeValue(r, depth+1)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		value, err := decodeValue(r, depth+1)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		switch k := key.(type) {
+		case string:
+			values[k] = value
+		case []byte:
+			values[string(k)] = value
+		default:
+			values[fmt.Sprint(k)] = value
+		}
+	}
+	return values, nil
+}
+
+// unexpected returns io.ErrUnexpectedEOF for an EOF within a value
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// encodeMsgpack appends the msgpack encoding of a value of the types
+// decodeMsgpack returns, with ints too, ordering maps as they are given by
+// msgpackMap
+func encodeMsgpack(buf []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xc0)
+	case bool:
+		if v {
+			return append(buf, 0xc3)
+		}
+		return append(buf, 0xc2)
+	case int:
+		return encodeMsgpack(buf, int64(v))
+	case int64:
+		if v >= 0 {
+			return encodeMsgpack(buf, uint64(v))
+		}
+		if v >= -32 {
+			return append(buf, byte(v))
+		}
+		return appendUint(append(buf, 0xd3), uint64(v), 8)
+	case uint64:
+		if v <= 0x7f {
+			return append(buf, byte(v))
+		}
+		return appendUint(append(buf, 0xcf), v, 8)
+	case float64:
+		return appendUint(append(buf, 0xcb), math.Float64bits(v), 8)
+	case string:
+		switch n := len(v); {
+		case n <= 31:
+			buf = append(buf, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			buf = append(buf, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			buf = appendUint(append(buf, 0xda), uint64(n), 2)
+		default:
+			buf = appendUint(append(buf, 0xdb), uint64(n), 4)
+		}
+		return append(buf, v...)
+	case []byte:
+		switch n := len(v); {
+		case n <= math.MaxUint8:
+			buf = append(buf, 0xc4, byte(n))
+		case n <= math.MaxUint16:
+			buf = appendUint(append(buf, 0xc5), uint64(n), 2)
+		default:
+			buf = appendUint(append(buf, 0xc6), uint64(n), 4)
+		}
+		return append(buf, v...)
+	case msgpackExt:
+		switch n := len(v.data); n {
+		case 1, 2, 4, 8, 16:
+			buf = append(buf, 0xd4+byte(math.Log2(float64(n))))
+		default:
+			buf = appendUint(append(buf, 0xc9), uint64(n), 4)
+		}
+		return append(append(buf, byte(v.typ)), v.data...)
+	case []interface{}:
+		if n := len(v); n <= 15 {
+			buf = append(buf, 0x90|byte(n))
+		} else {
+			buf = appendUint(append(buf, 0xdd), uint64(n), 4)
+		}
+		for _, value := range v {
+			buf = encodeMsgpack(buf, value)
+		}
+		return buf
+	case msgpackMap:
+		if n := len(v) / 2; n <= 15 {
+			buf = append(buf, 0x80|byte(n))
+		} else {
+			buf = appendUint(append(buf, 0xdf), uint64(n), 4)
+		}
+		for _, value := range v {
+			buf = encodeMsgpack(buf, value)
+		}
+		return buf
+	}
+	panic(fmt.Sprintf("msgpack: cannot encode %T", v))
+}
+
+// msgpackMap is a map to encode, as its keys and values one after the other
+type msgpackMap []interface{}
+
+func appendUint(buf []byte, v uint64, size int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return append(buf, b[8-size:]...)
+}
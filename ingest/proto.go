@@ -0,0 +1,145 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+// entry is an Entry of ingest.proto
+type entry struct {
+	data, source, name, id, hostname, image string
+	timeUnixNano                            int64
+	labels                                  map[string]string
+}
+
+// field is a field of a protobuf message, with the value of varints in n
+// and the bytes of length-delimited fields in b
+type field struct {
+	number int
+	wire   int
+	n      uint64
+	b      []byte
+}
+
+// fields calls each for the fields of an encoded message
+func fields(msg []byte, each func(f field) error) error {
+	for len(msg) > 0 {
+		key, size := binary.Uvarint(msg)
+		if size <= 0 {
+			return errTruncated
+		}
+		msg = msg[size:]
+		f := field{number: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.n, size = binary.Uvarint(msg); size <= 0 {
+				return errTruncated
+			}
+			msg = msg[size:]
+		case wireFixed64, wireFixed32:
+			size = 8
+			if f.wire == wireFixed32 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errTruncated
+			}
+			msg = msg[size:]
+		case wireBytes:
+			length, size := binary.Uvarint(msg)
+			if size <= 0 || uint64(len(msg)-size) < length {
+				return errTruncated
+			}
+			f.b, msg = msg[size:size+int(length)], msg[size+int(length):]
+		default:
+			return fmt.Errorf("unsupported wire type %d", f.wire)
+		}
+		if err := each(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodePushRequest returns the entries of a PushRequest
+func decodePushRequest(msg []byte) ([]*entry, error) {
+	var entries []*entry
+	err := fields(msg, func(f field) error {
+		if f.number != 1 || f.wire != wireBytes {
+			return nil // unknown fields are skipped, as protobuf does
+		}
+		e, err := decodeEntry(f.b)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+func decodeEntry(msg []byte) (*entry, error) {
+	e := &entry{labels: map[string]string{}}
+	err := fields(msg, func(f field) error {
+		if f.number == 3 {
+			if f.wire == wireVarint {
+				e.timeUnixNano = int64(f.n)
+			}
+			return nil
+		}
+		if f.wire != wireBytes {
+			return nil
+		}
+		switch f.number {
+		case 1:
+			e.data = string(f.b)
+		case 2:
+			e.source = string(f.b)
+		case 4:
+			e.name = string(f.b)
+		case 5:
+			e.id = string(f.b)
+		case 6:
+			e.hostname = string(f.b)
+		case 7:
+			e.image = string(f.b)
+		case 8: // map entries are messages of a key and a value
+			var key, value string
+			err := fields(f.b, func(kv field) error {
+				if kv.wire == wireBytes && kv.number == 1 {
+					key = string(kv.b)
+				} else if kv.wire == wireBytes && kv.number == 2 {
+					value = string(kv.b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			e.labels[key] = value
+		}
+		return nil
+	})
+	return e, err
+}
+
+// encodePushResponse returns an encoded PushResponse
+func encodePushResponse(accepted uint64) []byte {
+	if accepted == 0 {
+		return nil // fields of default values are left out
+	}
+	msg := make([]byte, 1+binary.MaxVarintLen64)
+	msg[0] = 1<<3 | wireVarint
+	return msg[:1+binary.PutUvarint(msg[1:], accepted)]
+}
@@ -0,0 +1,192 @@
+// Package kubernetes runs logspout as a node agent of a Kubernetes cluster,
+// eg: as a DaemonSet, reading the logs of containers from the files the
+// kubelet links in /var/log/containers instead of from Docker, so logspout
+// ships logs on clusters whose nodes do not run Docker.
+package kubernetes
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Labels of the containers read from the log files, those of pods
+// containers the kubelet ran with Docker have
+const (
+	PodNameLabel       = "io.kubernetes.pod.name"
+	PodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	ContainerNameLabel = "io.kubernetes.container.name"
+)
+
+// dockerPump is the name of the job and log router reading from Docker
+const dockerPump = "pump"
+
+func init() {
+	a := &agent{tailers: map[string]*tailer{}, routes: map[chan *router.Message]*router.Route{}}
+	router.Jobs.Register(a, "kubernetes")
+	router.LogRouters.Register(a, "kubernetes")
+	cfg.Register(
+		cfg.Option{Name: "K8S_LOG_PATH",
+			Description: "directory of the container log files of the kubelet to read instead of Docker, eg: /var/log/containers"},
+		cfg.Option{Name: "K8S_POLL_INTERVAL", Type: cfg.Duration, Default: "1s",
+			Description: "how often the container log files are read"},
+	)
+}
+
+// agent is the job finding the container log files, and the log router
+// sending their lines to the routes
+type agent struct {
+	path     string
+	interval time.Duration
+	mu       sync.Mutex
+	tailers  map[string]*tailer // by file name
+	routesMu sync.Mutex         // not mu, for slow routes not to hold up the scans
+	routes   map[chan *router.Message]*router.Route
+}
+
+func (a *agent) Name() string {
+	if a.path == "" {
+		return ""
+	}
+	return "kubernetes[" + a.path + "]"
+}
+
+// Setup replaces the pump reading from Docker, if K8S_LOG_PATH is set
+func (a *agent) Setup() error {
+	a.path = cfg.GetString("K8S_LOG_PATH")
+	if a.path == "" {
+		router.LogRouters.Unregister("kubernetes")
+		return nil
+	}
+	a.interval = cfg.GetDuration("K8S_POLL_INTERVAL")
+	router.Jobs.Unregister(dockerPump)
+	router.LogRouters.Unregister(dockerPump)
+	return nil
+}
+
+func (a *agent) Run() error {
+	if a.path == "" {
+		select {}
+	}
+	first := true
+	for {
+		a.scan(first)
+		first = false
+		time.Sleep(a.interval)
+	}
+}
+
+// scan starts tailing new log files, from their end if they were there
+// when logspout started, and stops tailing those that are gone
+func (a *agent) scan(existing bool) {
+	paths, err := filepath.Glob(filepath.Join(a.path, "*.log"))
+	if err != nil {
+		log.Println("kubernetes:", err)
+		return
+	}
+	found := map[string]bool{}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		found[name] = true
+		a.mu.Lock()
+		_, tailing := a.tailers[name]
+		a.mu.Unlock()
+		if tailing {
+			continue
+		}
+		container, ok := parseFileName(name)
+		if !ok || !router.InShard(container.ID) {
+			continue
+		}
+		t := newTailer(path, container, a.send)
+		if existing { // like the pump, from when logspout started
+			t.skipToEnd()
+		}
+		a.mu.Lock()
+		a.tailers[name] = t
+		a.mu.Unlock()
+		router.Go("kubernetes.tail", func() { t.run(a.interval) })
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, t := range a.tailers {
+		if !found[name] {
+			t.stop()
+			delete(a.tailers, name)
+		}
+	}
+}
+
+// send sends a line to the routes of its container
+func (a *agent) send(msg *router.Message) {
+	if router.Filtered(msg.Container) {
+		return
+	}
+	a.routesMu.Lock()
+	defer a.routesMu.Unlock()
+	for logstream, route := range a.routes {
+		if !route.MatchContainer(msg.Container.ID, strings.TrimPrefix(msg.Container.Name, "/"), msg.Container.Config.Labels) ||
+			!route.MatchMessage(msg) {
+			continue
+		}
+		logstream <- msg
+	}
+}
+
+// Route sends the lines of the containers matching a route to it, until the
+// route is closed
+func (a *agent) Route(route *router.Route, logstream chan *router.Message) {
+	a.routesMu.Lock()
+	a.routes[logstream] = route
+	a.routesMu.Unlock()
+	<-route.Closer()
+	a.routesMu.Lock()
+	delete(a.routes, logstream)
+	a.routesMu.Unlock()
+}
+
+// RoutingFrom returns whether the log file of a container is read
+func (a *agent) RoutingFrom(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range a.tailers {
+		if strings.HasPrefix(t.container.ID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileName matches the names of the log files of the kubelet,
+// <pod>_<namespace>_<container>-<container ID>.log
+var fileName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{64})\.log$`)
+
+// parseFileName returns the container of a log file, as far as its name
+// tells, with the labels Docker would have
+func parseFileName(name string) (*docker.Container, bool) {
+	parts := fileName.FindStringSubmatch(name)
+	if parts == nil {
+		return nil, false
+	}
+	pod, namespace, container, id := parts[1], parts[2], parts[3], parts[4]
+	return &docker.Container{
+		ID:   id,
+		Name: "/" + pod + "_" + namespace + "_" + container,
+		Config: &docker.Config{
+			Hostname: pod,
+			Labels: map[string]string{
+				PodNameLabel:       pod,
+				PodNamespaceLabel:  namespace,
+				ContainerNameLabel: container,
+			},
+		},
+	}, true
+}
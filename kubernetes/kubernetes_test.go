@@ -0,0 +1,92 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const containerID = "5f0c2e5f3f8a6a9d2a3c5e7b9d1f3a5c7e9b1d3f5a7c9e1b3d5f7a9c1e3b5d7f"
+
+func TestParseFileName(t *testing.T) {
+	container, ok := parseFileName("web-7d4b9_default_nginx-" + containerID + ".log")
+	if !ok {
+		t.Fatal("expected the file name to parse")
+	}
+	if container.ID != containerID || container.Name != "/web-7d4b9_default_nginx" {
+		t.Errorf("unexpected container %s %s", container.ID, container.Name)
+	}
+	expected := map[string]string{PodNameLabel: "web-7d4b9", PodNamespaceLabel: "default", ContainerNameLabel: "nginx"}
+	if !reflect.DeepEqual(container.Config.Labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, container.Config.Labels)
+	}
+	if _, ok := parseFileName("web_default_nginx.log"); ok {
+		t.Error("expected a file name without container ID not to parse")
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	var msgs []*router.Message
+	tl := newTailer("", nil, func(msg *router.Message) { msgs = append(msgs, msg) })
+	tl.parse("2020-11-02T10:00:00.000000001Z stdout P long ")
+	tl.parse("2020-11-02T10:00:00.000000002Z stderr F error")
+	tl.parse("2020-11-02T10:00:00.000000003Z stdout F line")
+	tl.parse(`{"log":"from docker\n","stream":"stdout","time":"2020-11-02T10:00:01Z"}`)
+	tl.parse("not a log line")
+	expected := []struct{ data, source string }{{"error", "stderr"}, {"long line", "stdout"}, {"from docker", "stdout"}}
+	if len(msgs) != len(expected) {
+		t.Fatalf("expected %d messages, got %d", len(expected), len(msgs))
+	}
+	for i, e := range expected {
+		if msgs[i].Data != e.data || msgs[i].Source != e.source {
+			t.Errorf("expected %q from %s, got %q from %s", e.data, e.source, msgs[i].Data, msgs[i].Source)
+		}
+	}
+	if !msgs[2].Time.Equal(time.Date(2020, 11, 2, 10, 0, 1, 0, time.UTC)) {
+		t.Errorf("unexpected time %s", msgs[2].Time)
+	}
+}
+
+func TestTailRotatedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "0.log")
+	write := func(name, lines string, flag int) {
+		f, err := os.OpenFile(filepath.Join(dir, name), flag|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(lines)
+		f.Close()
+	}
+	write("0.log", "2020-11-02T10:00:00Z stdout F before\n", 0)
+
+	var data []string
+	tl := newTailer(path, nil, func(msg *router.Message) { data = append(data, msg.Data) })
+	tl.skipToEnd()
+	tl.read()
+	write("0.log", "2020-11-02T10:00:01Z stdout F one\n2020-11-02T10:00:02Z stdout F tw", os.O_APPEND)
+	tl.read()
+	write("0.log", "o\n", os.O_APPEND)
+	tl.read()
+	// the kubelet rotates the file by renaming it, and logging to a new one
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write("0.log", "2020-11-02T10:00:03Z stdout F three\n", 0)
+	tl.read()
+	tl.file.Close()
+
+	expected := []string{"one", "two", "three"}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
@@ -0,0 +1,167 @@
+package kubernetes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// tailer reads the lines a container logs from its log file as they are
+// written, following the file when the kubelet rotates it
+type tailer struct {
+	path      string
+	container *docker.Container
+	send      func(*router.Message)
+	file      *os.File
+	reader    *bufio.Reader
+	atEnd     bool     // start reading from the end of the file
+	partial   []string // pieces of a line the runtime split, by stream
+	partialOf string
+	done      chan struct{}
+}
+
+func newTailer(path string, container *docker.Container, send func(*router.Message)) *tailer {
+	return &tailer{path: path, container: container, send: send, done: make(chan struct{})}
+}
+
+// skipToEnd makes the tailer skip what was logged before it started
+func (t *tailer) skipToEnd() {
+	t.atEnd = true
+}
+
+func (t *tailer) stop() {
+	close(t.done)
+}
+
+func (t *tailer) run(interval time.Duration) {
+	defer func() {
+		if t.file != nil {
+			t.file.Close()
+		}
+	}()
+	for {
+		t.read()
+		select {
+		case <-t.done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// read sends the complete lines written since the last read, opening the
+// file again when it was rotated or truncated
+func (t *tailer) read() {
+	if t.file == nil && !t.open() {
+		return
+	}
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err == nil {
+			t.parse(strings.TrimSuffix(line, "\n"))
+			continue
+		}
+		if err != io.EOF {
+			log.Println("kubernetes:", t.path+":", err)
+		}
+		// keep the start of a line being written for the next read
+		if line != "" {
+			t.file.Seek(-int64(len(line)), io.SeekCurrent) //nolint:errcheck
+			t.reader.Reset(t.file)
+		}
+		break
+	}
+	if t.rotated() {
+		t.file.Close()
+		t.file = nil
+		t.atEnd = false // the new file was written after the old one was read
+		t.read()
+	}
+}
+
+func (t *tailer) open() bool {
+	file, err := os.Open(t.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("kubernetes:", err)
+		}
+		return false
+	}
+	if t.atEnd {
+		file.Seek(0, io.SeekEnd) //nolint:errcheck
+		t.atEnd = false
+	}
+	t.file, t.reader = file, bufio.NewReader(file)
+	return true
+}
+
+// rotated returns whether the link now points to another file, or the file
+// was truncated
+func (t *tailer) rotated() bool {
+	current, err := os.Stat(t.path)
+	if err != nil {
+		return false // gone: the agent stops the tailer
+	}
+	opened, err := t.file.Stat()
+	if err != nil || !os.SameFile(current, opened) {
+		return true
+	}
+	offset, err := t.file.Seek(0, io.SeekCurrent)
+	return err == nil && current.Size() < offset
+}
+
+// parse sends a line of the log file, in the format of the CRI,
+// "<time> <stream> <P or F> <log>", or that of the json-file log driver
+// of Docker
+func (t *tailer) parse(line string) {
+	if strings.HasPrefix(line, "{") {
+		var entry struct {
+			Log    string    `json:"log"`
+			Stream string    `json:"stream"`
+			Time   time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return
+		}
+		t.sendLine(strings.TrimSuffix(entry.Log, "\n"), entry.Stream, entry.Time)
+		return
+	}
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 {
+		return
+	}
+	logged, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return
+	}
+	data := ""
+	if len(fields) == 4 {
+		data = fields[3]
+	}
+	if fields[2] == "P" { // a piece of a long line, the rest follows
+		t.partial, t.partialOf = append(t.partial, data), fields[1]
+		return
+	}
+	if len(t.partial) > 0 && t.partialOf == fields[1] {
+		data = strings.Join(t.partial, "") + data
+		t.partial = nil
+	}
+	t.sendLine(data, fields[1], logged)
+}
+
+func (t *tailer) sendLine(data, source string, logged time.Time) {
+	t.send(&router.Message{
+		Data:      data,
+		Container: t.container,
+		Source:    source,
+		Time:      logged,
+	})
+}
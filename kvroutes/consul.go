@@ -0,0 +1,63 @@
+package kvroutes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// consulStore reads a key prefix of the Consul KV store with blocking
+// queries, which return as soon as a key under it changes
+type consulStore struct {
+	client *http.Client
+	server string
+	prefix string
+	token  func() string // read for every request, for rotated tokens
+}
+
+type consulEntry struct {
+	Key   string
+	Value []byte // base64 in the JSON, null for folders
+}
+
+func (c *consulStore) read(index uint64) (map[string][]byte, uint64, error) {
+	url := c.server + "/v1/kv/" + c.prefix + "?recurse=true"
+	if index > 0 {
+		url += fmt.Sprintf("&index=%d&wait=%ds", index, int(consulWait.Seconds()))
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token := c.token(); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	values := map[string][]byte{}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound: // no keys under the prefix
+		return values, next, nil
+	default:
+		return nil, 0, fmt.Errorf("consul: reading %s: %s", c.prefix, resp.Status)
+	}
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("consul: reading %s: %s", c.prefix, err)
+	}
+	for _, entry := range entries {
+		key := strings.TrimPrefix(entry.Key, c.prefix)
+		if key == "" || strings.HasSuffix(key, "/") {
+			continue
+		}
+		values[key] = entry.Value
+	}
+	return values, next, nil
+}
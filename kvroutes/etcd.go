@@ -0,0 +1,79 @@
+package kvroutes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etcdStore reads a key prefix of etcd through the JSON gateway of its v3
+// API, every interval
+type etcdStore struct {
+	client   *http.Client
+	server   string
+	prefix   string
+	interval time.Duration
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Kvs []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+}
+
+func (e *etcdStore) read(index uint64) (map[string][]byte, uint64, error) {
+	if index > 0 {
+		time.Sleep(e.interval)
+	}
+	body, err := json.Marshal(etcdRangeRequest{Key: []byte(e.prefix), RangeEnd: rangeEnd(e.prefix)})
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := e.client.Post(e.server+"/v3/kv/range", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("etcd: reading %s: %s", e.prefix, resp.Status)
+	}
+	var ranged etcdRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ranged); err != nil {
+		return nil, 0, fmt.Errorf("etcd: reading %s: %s", e.prefix, err)
+	}
+	// the revision is of the whole store, so it may change while the keys
+	// under the prefix do not, which apply does nothing about
+	next, _ := strconv.ParseUint(ranged.Header.Revision, 10, 64)
+	values := map[string][]byte{}
+	for _, kv := range ranged.Kvs {
+		if key := strings.TrimPrefix(string(kv.Key), e.prefix); key != "" {
+			values[key] = kv.Value
+		}
+	}
+	return values, next, nil
+}
+
+// rangeEnd returns the end of the range of the keys starting with prefix
+func rangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,193 @@
+// Package kvroutes keeps the routes of logspout in step with the keys under
+// a Consul or etcd key prefix, so the routes of a fleet of logspouts are
+// changed in one place instead of redeploying each host's configuration.
+package kvroutes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// idPrefix starts the IDs of the routes read from the key prefix
+	idPrefix = "kv."
+	// consulWait is how long a read of Consul waits for a change
+	consulWait = 5 * time.Minute
+)
+
+func init() {
+	router.Jobs.Register(&watcher{}, "kvroutes")
+	cfg.Register(
+		cfg.Option{Name: "KV_ROUTES", Validate: validateURI,
+			Description: "consul:// or etcd:// URI of a key prefix holding routes, eg: consul://localhost:8500/logspout/routes"},
+		cfg.Option{Name: "KV_TOKEN", Secret: true, Description: "ACL token sent with every request to Consul"},
+		cfg.Option{Name: "KV_POLL_INTERVAL", Type: cfg.Duration, Default: "10s",
+			Description: "how often etcd, or Consul after an error, is read"},
+	)
+}
+
+// store reads the values under a key prefix
+type store interface {
+	// read returns the values of the keys under the prefix, by key relative
+	// to the prefix, and an index that changes with them. Given the index
+	// of the last read, it waits for a change or a while before reading.
+	read(index uint64) (map[string][]byte, uint64, error)
+}
+
+// watcher is the job applying the routes under the key prefix
+type watcher struct {
+	store    store
+	routes   *router.RouteManager
+	interval time.Duration
+	values   map[string]string // the value of each key applied
+}
+
+func (w *watcher) Name() string {
+	if w.store == nil {
+		return ""
+	}
+	return "kvroutes"
+}
+
+func (w *watcher) Setup() error {
+	uri := cfg.GetString("KV_ROUTES")
+	if uri == "" {
+		return nil
+	}
+	w.interval = cfg.GetDuration("KV_POLL_INTERVAL")
+	s, err := newStore(uri, func() string { return cfg.GetString("KV_TOKEN") }, w.interval)
+	if err != nil {
+		return err
+	}
+	w.store, w.routes, w.values = s, router.Routes, map[string]string{}
+	return nil
+}
+
+func (w *watcher) Run() error {
+	if w.store == nil {
+		select {}
+	}
+	var index uint64
+	for {
+		values, next, err := w.store.read(index)
+		if err != nil {
+			log.Println("kvroutes:", err)
+			time.Sleep(w.interval)
+			continue
+		}
+		if index == 0 || next != index {
+			w.apply(values)
+		}
+		if next == 0 || next < index {
+			// the index is missing or was reset, so the next read would not
+			// wait for a change
+			time.Sleep(w.interval)
+			next = 0
+		}
+		index = next
+	}
+}
+
+// apply adds the routes of keys that are new or changed, and removes the
+// routes of keys that are gone, also those left by an earlier run
+func (w *watcher) apply(values map[string][]byte) {
+	current := map[string]bool{}
+	for key, value := range values {
+		id := routeID(key)
+		current[id] = true
+		if applied, exists := w.values[key]; exists && applied == string(value) {
+			continue
+		}
+		route, err := router.ParseRoute(value)
+		if err != nil {
+			log.Printf("kvroutes: invalid route %s: %s\n", key, err)
+			continue
+		}
+		route.ID = id
+		if err := w.routes.Add(route); err != nil {
+			log.Printf("kvroutes: bad route %s: %s\n", key, err)
+			continue
+		}
+		w.values[key] = string(value)
+		log.Printf("kvroutes: applied route %s: %s://%s\n", key, route.Adapter, route.Address)
+	}
+	for key := range w.values {
+		if _, exists := values[key]; !exists {
+			delete(w.values, key)
+		}
+	}
+	routes, _ := w.routes.GetAll()
+	for _, route := range routes {
+		if strings.HasPrefix(route.ID, idPrefix) && !current[route.ID] {
+			w.routes.Remove(route.ID)
+			log.Printf("kvroutes: removed route %s\n", route.ID)
+		}
+	}
+}
+
+var unsafeIDChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// routeID returns the ID of the route of a key, which is also the name of
+// its file in the ROUTESPATH
+func routeID(key string) string {
+	return idPrefix + unsafeIDChars.ReplaceAllString(key, "_")
+}
+
+// parseURI returns the kind of store of a KV_ROUTES URI, the URL of its
+// server, and the key prefix, which ends with a /
+func parseURI(uri string) (kind, server, prefix string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", err
+	}
+	scheme := "http"
+	kind = u.Scheme
+	if strings.HasSuffix(kind, "+https") {
+		kind, scheme = strings.TrimSuffix(kind, "+https"), "https"
+	}
+	if kind != "consul" && kind != "etcd" {
+		return "", "", "", errors.New("must be a consul:// or etcd:// URI")
+	}
+	if u.Host == "" {
+		return "", "", "", errors.New("missing host")
+	}
+	prefix = strings.Trim(u.Path, "/")
+	if prefix == "" {
+		return "", "", "", errors.New("missing key prefix")
+	}
+	return kind, scheme + "://" + u.Host, prefix + "/", nil
+}
+
+func validateURI(value string) error {
+	_, _, _, err := parseURI(value)
+	return err
+}
+
+func newStore(uri string, token func() string, interval time.Duration) (store, error) {
+	kind, server, prefix, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "consul" {
+		return &consulStore{
+			client: &http.Client{Timeout: consulWait + time.Minute},
+			server: server,
+			prefix: prefix,
+			token:  token,
+		}, nil
+	}
+	return &etcdStore{
+		client:   &http.Client{Timeout: time.Minute},
+		server:   server,
+		prefix:   prefix,
+		interval: interval,
+	}, nil
+}
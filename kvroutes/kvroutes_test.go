@@ -0,0 +1,132 @@
+package kvroutes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+type testAdapter struct{}
+
+func (testAdapter) Stream(logstream chan *router.Message) {
+	for range logstream {
+	}
+}
+
+func init() {
+	router.AdapterFactories.Register(func(route *router.Route) (router.LogAdapter, error) {
+		return testAdapter{}, nil
+	}, "kvtest")
+}
+
+func TestParseURI(t *testing.T) {
+	kind, server, prefix, err := parseURI("etcd+https://etcd:2379/logspout/routes/")
+	if err != nil || kind != "etcd" || server != "https://etcd:2379" || prefix != "logspout/routes/" {
+		t.Errorf("unexpected store of URI: %s %s %s %v", kind, server, prefix, err)
+	}
+	for _, uri := range []string{"redis://redis:6379/routes", "consul:///routes", "consul://consul:8500"} {
+		if err := validateURI(uri); err == nil {
+			t.Errorf("expected %s to be invalid", uri)
+		}
+	}
+}
+
+func TestConsulRead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/kv/logspout/routes/" || req.Header.Get("X-Consul-Token") != "secret" {
+			http.NotFound(w, req)
+			return
+		}
+		if req.URL.Query().Get("index") == "7" && req.URL.Query().Get("wait") == "" {
+			t.Error("expected a read after the first to wait for a change")
+		}
+		w.Header().Set("X-Consul-Index", "7")
+		json.NewEncoder(w).Encode([]consulEntry{ //nolint:errcheck
+			{Key: "logspout/routes/"},
+			{Key: "logspout/routes/papertrail", Value: []byte("syslog://logs.papertrailapp.com:55555")},
+		})
+	}))
+	defer server.Close()
+	s, err := newStore("consul://"+server.Listener.Addr().String()+"/logspout/routes", func() string { return "secret" }, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []uint64{0, 7} {
+		values, next, err := s.read(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != 7 || len(values) != 1 || string(values["papertrail"]) != "syslog://logs.papertrailapp.com:55555" {
+			t.Errorf("unexpected read of consul: %v %d", values, next)
+		}
+	}
+}
+
+func TestEtcdRead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ranged etcdRangeRequest
+		if err := json.NewDecoder(req.Body).Decode(&ranged); err != nil || req.URL.Path != "/v3/kv/range" {
+			http.NotFound(w, req)
+			return
+		}
+		if string(ranged.Key) != "logspout/routes/" || string(ranged.RangeEnd) != "logspout/routes0" {
+			t.Errorf("unexpected range: %s to %s", ranged.Key, ranged.RangeEnd)
+		}
+		fmt.Fprintf(w, `{"header": {"revision": "12"}, "kvs": [{"key": "%s", "value": "%s"}]}`,
+			base64.StdEncoding.EncodeToString([]byte("logspout/routes/db")),
+			base64.StdEncoding.EncodeToString([]byte(`{"uri": "kvtest://db:514", "filter_name": "*_db"}`)))
+	}))
+	defer server.Close()
+	s, err := newStore("etcd://"+server.Listener.Addr().String()+"/logspout/routes", func() string { return "" }, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, next, err := s.read(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 12 || len(values) != 1 || values["db"] == nil {
+		t.Errorf("unexpected read of etcd: %v %d", values, next)
+	}
+}
+
+func TestApply(t *testing.T) {
+	w := &watcher{routes: router.Routes, values: map[string]string{}}
+	// a route left by an earlier run, and one added otherwise
+	for _, id := range []string{routeID("stale"), "other"} {
+		if err := router.Routes.Add(&router.Route{ID: id, Adapter: "kvtest"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer router.Routes.Remove("other")
+
+	w.apply(map[string][]byte{
+		"team/db": []byte("kvtest://db:514?filter.name=*_db"),
+		"web":     []byte("kvtest://web:514"),
+		"broken":  []byte("nonexistent://web:514"),
+	})
+	expectRoutes(t, map[string]string{"kv.team_db": "db:514", "kv.web": "web:514", "other": ""})
+
+	w.apply(map[string][]byte{"team/db": []byte("kvtest://db2:514")})
+	expectRoutes(t, map[string]string{"kv.team_db": "db2:514", "other": ""})
+	w.apply(nil)
+}
+
+func expectRoutes(t *testing.T, expected map[string]string) {
+	t.Helper()
+	routes, _ := router.Routes.GetAll()
+	if len(routes) != len(expected) {
+		t.Errorf("expected %d routes, got %d", len(expected), len(routes))
+	}
+	for _, route := range routes {
+		if address, exists := expected[route.ID]; !exists || address != route.Address {
+			t.Errorf("unexpected route %s to %s", route.ID, route.Address)
+		}
+	}
+}
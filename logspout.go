@@ -19,17 +19,44 @@ func main() {
 		fmt.Printf("%s\n", Version)
 		os.Exit(0)
 	}
+	plugins, err := loadPlugins()
+	if err != nil {
+		log.Printf("!! %v\n", err)
+		os.Exit(1)
+	}
+	if len(os.Args) == 2 && (os.Args[1] == "--help" || os.Args[1] == "-h" || os.Args[1] == "help") {
+		printHelp()
+		os.Exit(0)
+	}
+	if err := router.LoadOwnLabels(); err != nil {
+		log.Printf("!! %v\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.Parse(); err != nil {
+		log.Printf("!! %v\n", err)
+		os.Exit(1)
+	}
+	if code, ran := runCommand(); ran {
+		os.Exit(code)
+	}
 
 	log.Printf("# logspout %s by gliderlabs\n", Version)
 	log.Printf("# adapters: %s\n", strings.Join(router.AdapterFactories.Names(), " "))
+	if len(plugins) > 0 {
+		log.Printf("# plugins : %s\n", strings.Join(plugins, " "))
+	}
 	log.Printf("# options : ")
-	if d := cfg.GetEnvDefault("DEBUG", ""); d != "" {
+	if d := cfg.GetString("DEBUG"); d != "" {
 		log.Printf("debug:%s\n", d)
 	}
-	if b := cfg.GetEnvDefault("BACKLOG", ""); b != "" {
-		log.Printf("backlog:%s\n", b)
+	if cfg.IsSet("BACKLOG") {
+		log.Printf("backlog:%t\n", cfg.GetBool("BACKLOG"))
+	}
+	if path := cfg.GetString("ROUTES_FILE"); path != "" {
+		log.Printf("persist:%s\n", path)
+	} else {
+		log.Printf("persist:%s\n", cfg.GetString("ROUTESPATH"))
 	}
-	log.Printf("persist:%s\n", cfg.GetEnvDefault("ROUTESPATH", "/mnt/routes"))
 
 	var jobs []string
 	for _, job := range router.Jobs.All() {
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	expvar.Publish("metrics", expvar.Func(func() interface{} { return Vars() }))
+	expvar.Publish("config", expvar.Func(func() interface{} { return ConfigVars() }))
+	router.HTTPHandlers.Register(Debug, "debug")
+}
+
+// Debug returns a http.Handler for the standard expvar variables at
+// /debug/vars, with the metrics and the configuration of logspout
+func Debug() http.Handler {
+	r := mux.NewRouter()
+	r.Handle("/debug/vars", expvar.Handler())
+	return r
+}
+
+// Vars returns the current value of every metric, by its name and labels
+// as written in the Prometheus format
+func Vars() map[string]float64 {
+	vars := map[string]float64{}
+	for _, s := range Gather() {
+		vars[s.Name+formatLabels(s.Labels)] = s.Value
+	}
+	return vars
+}
+
+// ConfigVars returns the value of every registered option, with those of
+// secret options that are set redacted
+func ConfigVars() map[string]string {
+	vars := map[string]string{}
+	for _, option := range cfg.Options() {
+		value := option.Default
+		if cfg.IsSet(option.Name) {
+			value = cfg.Text(option.Name)
+			if option.Secret {
+				value = cfg.Redacted
+			}
+		}
+		vars[option.Name] = value
+	}
+	return vars
+}
@@ -0,0 +1,14 @@
+package metrics
+
+import "github.com/gliderlabs/logspout/router"
+
+var goroutinesGauge = NewGauge("logspout_goroutines",
+	"Goroutines running for containers, by kind, which grow with leaks.", "kind")
+
+func init() {
+	OnGather(func() {
+		for kind, n := range router.Goroutines() {
+			goroutinesGauge.With(kind).Set(float64(n))
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func init() {
+	router.HTTPHandlers.Register(Metrics, "metrics")
+}
+
+// Metrics returns a http.Handler for the metrics in the Prometheus format
+func Metrics() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteText(w) //nolint:errcheck
+	})
+	return r
+}
@@ -0,0 +1,213 @@
+// Package metrics keeps counters and gauges about the log pipeline, which
+// are exported at /metrics in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kinds of metrics
+const (
+	CounterKind = "counter"
+	GaugeKind   = "gauge"
+)
+
+var (
+	mu         sync.Mutex
+	families   = map[string]*family{}
+	collectors []func() // set metrics read rather than counted, before gathering
+)
+
+// family is a named metric with a value for each set of label values
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]*Value
+}
+
+// Value is the current value of a metric for one set of label values
+type Value struct {
+	labels []string
+	bits   uint64 // float64 bits, accessed atomically
+}
+
+// Counter is a metric that only goes up, like the number of lines shipped
+type Counter struct{ *family }
+
+// Gauge is a metric that goes up and down, like a queue length
+type Gauge struct{ *family }
+
+// NewCounter returns the counter with the given name, creating it if needed
+func NewCounter(name, help string, labelNames ...string) Counter {
+	return Counter{register(name, help, CounterKind, labelNames)}
+}
+
+// NewGauge returns the gauge with the given name, creating it if needed
+func NewGauge(name, help string, labelNames ...string) Gauge {
+	return Gauge{register(name, help, GaugeKind, labelNames)}
+}
+
+func register(name, help, kind string, labelNames []string) *family {
+	mu.Lock()
+	defer mu.Unlock()
+	if f, exists := families[name]; exists {
+		if f.kind != kind || len(f.labelNames) != len(labelNames) {
+			panic(fmt.Sprintf("metrics: %s registered twice with different kinds or labels", name))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labelNames: labelNames, values: map[string]*Value{}}
+	families[name] = f
+	return f
+}
+
+// With returns the value for the given label values, in the order of the
+// label names of the metric
+func (f *family) With(labelValues ...string) *Value {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, exists := f.values[key]
+	if !exists {
+		v = &Value{labels: labelValues}
+		f.values[key] = v
+	}
+	return v
+}
+
+// Delete removes the value for the given label values, eg: when the
+// container it describes is gone
+func (f *family) Delete(labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.values, strings.Join(labelValues, "\xff"))
+}
+
+// Add adds delta to the value
+func (v *Value) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Inc adds one to the value
+func (v *Value) Inc() {
+	v.Add(1)
+}
+
+// Set sets the value, for gauges
+func (v *Value) Set(value float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(value))
+}
+
+// Get returns the value
+func (v *Value) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Sample is the value of a metric for one set of labels
+type Sample struct {
+	Name   string
+	Help   string
+	Kind   string
+	Labels map[string]string
+	Value  float64
+}
+
+// OnGather registers a function setting metrics that are read rather than
+// counted, like the number of goroutines, called before each Gather
+func OnGather(collect func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	collectors = append(collectors, collect)
+}
+
+// Gather returns the current samples of all metrics, sorted by name
+func Gather() []Sample {
+	mu.Lock()
+	collect := collectors
+	mu.Unlock()
+	for _, c := range collect {
+		c()
+	}
+	mu.Lock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	mu.Unlock()
+	sort.Strings(names)
+
+	var samples []Sample
+	for _, name := range names {
+		mu.Lock()
+		f := families[name]
+		mu.Unlock()
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.values))
+		for key := range f.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			v := f.values[key]
+			labels := make(map[string]string, len(f.labelNames))
+			for i, labelName := range f.labelNames {
+				labels[labelName] = v.labels[i]
+			}
+			samples = append(samples, Sample{Name: f.name, Help: f.help, Kind: f.kind, Labels: labels, Value: v.Get()})
+		}
+		f.mu.Unlock()
+	}
+	return samples
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format
+func WriteText(w io.Writer) error {
+	last := ""
+	for _, s := range Gather() {
+		if s.Name != last {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.Name, s.Help, s.Name, s.Kind); err != nil {
+				return err
+			}
+			last = s.Name
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %v\n", s.Name, formatLabels(s.Labels), s.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[name])
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func TestWriteText(t *testing.T) {
+	shipped := NewCounter("test_lines_total", "Lines shipped.", "route")
+	shipped.With("cloudwatch").Add(2)
+	shipped.With("cloudwatch").Inc()
+	shipped.With(`s3"`).Inc()
+	NewGauge("test_queue_length", "Queued lines.").With().Set(4.5)
+
+	buf := new(bytes.Buffer)
+	if err := WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_lines_total Lines shipped.
+# TYPE test_lines_total counter
+test_lines_total{route="cloudwatch"} 3
+test_lines_total{route="s3\""} 1
+# HELP test_queue_length Queued lines.
+# TYPE test_queue_length gauge
+test_queue_length 4.5
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	shipped.Delete(`s3"`)
+	if samples := Gather(); len(samples) != 2 {
+		t.Errorf("expected 2 samples after delete, got %v", samples)
+	}
+	if NewCounter("test_lines_total", "Lines shipped.", "route").With("cloudwatch").Get() != 3 {
+		t.Error("expected the registered counter to be returned")
+	}
+}
+
+func TestVars(t *testing.T) {
+	NewCounter("test_vars_total", "Vars.", "route").With("s3").Add(2)
+	if value := Vars()[`test_vars_total{route="s3"}`]; value != 2 {
+		t.Errorf("expected the metric in the vars, got %v", value)
+	}
+}
+
+func TestConfigVars(t *testing.T) {
+	cfg.Register(
+		cfg.Option{Name: "METRICS_TEST_ADDRESS", Default: "localhost"},
+		cfg.Option{Name: "METRICS_TEST_TOKEN", Secret: true},
+	)
+	os.Setenv("METRICS_TEST_TOKEN", "secret")
+	defer os.Unsetenv("METRICS_TEST_TOKEN")
+	vars := ConfigVars()
+	if vars["METRICS_TEST_ADDRESS"] != "localhost" || vars["METRICS_TEST_TOKEN"] != cfg.Redacted {
+		t.Errorf("expected defaults to be shown and secrets redacted, got %q and %q",
+			vars["METRICS_TEST_ADDRESS"], vars["METRICS_TEST_TOKEN"])
+	}
+}
+
+func TestOnGather(t *testing.T) {
+	gauge := NewGauge("test_gathered", "Read when gathered.")
+	OnGather(func() { gauge.With().Set(7) })
+	if vars := Vars(); vars["test_gathered"] != 7 {
+		t.Errorf("expected the gauge to be set before gathering, got %v", vars["test_gathered"])
+	}
+}
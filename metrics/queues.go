@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+var (
+	queueDepthGauge = NewGauge("logspout_queue_depth",
+		"Messages queued between stages of the pipeline, by stage and route.", "stage", "route")
+	queueHighWaterGauge = NewGauge("logspout_queue_high_water",
+		"Most messages one queue of a stage and route held at once.", "stage", "route")
+	queueCapacityGauge = NewGauge("logspout_queue_capacity",
+		"Messages the queues of a stage and route hold before they block or drop.", "stage", "route")
+)
+
+func init() {
+	var mu sync.Mutex                   // gathers may be concurrent
+	var gathered map[[2]string]struct{} // the label values of the last gather
+	OnGather(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		current := map[[2]string]struct{}{}
+		for _, q := range router.Queues() {
+			current[[2]string{q.Stage, q.Route}] = struct{}{}
+			queueDepthGauge.With(q.Stage, q.Route).Set(float64(q.Depth))
+			queueHighWaterGauge.With(q.Stage, q.Route).Set(float64(q.HighWater))
+			queueCapacityGauge.With(q.Stage, q.Route).Set(float64(q.Capacity))
+		}
+		for labels := range gathered {
+			if _, exists := current[labels]; !exists { // the route or its containers are gone
+				queueDepthGauge.Delete(labels[0], labels[1])
+				queueHighWaterGauge.Delete(labels[0], labels[1])
+				queueCapacityGauge.Delete(labels[0], labels[1])
+			}
+		}
+		gathered = current
+	})
+}
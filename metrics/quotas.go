@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+var (
+	quotaUsedGauge = NewGauge("logspout_quota_used_bytes",
+		"Bytes the containers of each value of the QUOTA_LABEL shipped in the current QUOTA_PERIOD.", "quota")
+	quotaLimitGauge = NewGauge("logspout_quota_limit_bytes",
+		"Bytes the containers of each value of the QUOTA_LABEL may ship every QUOTA_PERIOD.", "quota")
+	quotaDroppedGauge = NewGauge("logspout_quota_dropped_lines",
+		"Lines beyond the quota of each value of the QUOTA_LABEL not shipped in the current QUOTA_PERIOD.", "quota")
+)
+
+func init() {
+	var mu sync.Mutex                // gathers may be concurrent
+	var gathered map[string]struct{} // the values of the last gather
+	OnGather(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		current := map[string]struct{}{}
+		for _, q := range router.Quotas() {
+			current[q.Value] = struct{}{}
+			quotaUsedGauge.With(q.Value).Set(float64(q.Used))
+			quotaLimitGauge.With(q.Value).Set(float64(q.Limit))
+			quotaDroppedGauge.With(q.Value).Set(float64(q.Dropped))
+		}
+		for value := range gathered {
+			if _, exists := current[value]; !exists { // a new period started
+				quotaUsedGauge.Delete(value)
+				quotaLimitGauge.Delete(value)
+				quotaDroppedGauge.Delete(value)
+			}
+		}
+		gathered = current
+	})
+}
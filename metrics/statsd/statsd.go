@@ -0,0 +1,158 @@
+// Package statsd sends the metrics of logspout to a StatsD or DogStatsD
+// server, for monitoring built around StatsD rather than scraping /metrics.
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/metrics"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Flavors of the StatsD protocol
+const (
+	FlavorStatsD    = "statsd"    // labels are folded into the metric names
+	FlavorDogStatsD = "dogstatsd" // labels are sent as tags
+)
+
+// maxPacketSize keeps packets from being fragmented on common networks
+const maxPacketSize = 1432
+
+func init() {
+	router.Jobs.Register(&sink{}, "statsd")
+	cfg.Register(
+		cfg.Option{Name: "STATSD_ADDRESS", Description: "host:port of the StatsD server metrics are sent to over UDP"},
+		cfg.Option{Name: "STATSD_FLAVOR", Default: FlavorStatsD, Validate: cfg.OneOf(FlavorStatsD, FlavorDogStatsD),
+			Description: "statsd folds labels into metric names, dogstatsd sends them as tags"},
+		cfg.Option{Name: "STATSD_PREFIX", Default: "logspout.", Description: "prefix of the names of metrics sent to StatsD"},
+		cfg.Option{Name: "STATSD_TAGS", Description: "comma separated key:value tags sent with every metric, with dogstatsd"},
+		cfg.Option{Name: "STATSD_INTERVAL", Type: cfg.Duration, Default: "10s", Description: "how often metrics are sent to StatsD"},
+	)
+}
+
+// sink is the job sending metrics every interval, gauges as their value and
+// counters as their increase since they were last sent
+type sink struct {
+	conn     net.Conn
+	flavor   string
+	prefix   string
+	tags     []string
+	interval time.Duration
+	sent     map[string]float64 // the last value of each counter, by line
+}
+
+func (s *sink) Name() string {
+	if s.conn == nil {
+		return ""
+	}
+	return "statsd"
+}
+
+func (s *sink) Setup() error {
+	address := cfg.GetString("STATSD_ADDRESS")
+	if address == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return fmt.Errorf("statsd: %s", err)
+	}
+	s.conn = conn
+	s.flavor = cfg.GetString("STATSD_FLAVOR")
+	s.prefix = cfg.GetString("STATSD_PREFIX")
+	s.interval = cfg.GetDuration("STATSD_INTERVAL")
+	s.sent = map[string]float64{}
+	for _, tag := range strings.Split(cfg.GetString("STATSD_TAGS"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.tags = append(s.tags, tag)
+		}
+	}
+	return nil
+}
+
+func (s *sink) Run() error {
+	if s.conn == nil {
+		select {}
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, packet := range s.packets(metrics.Gather()) {
+			if _, err := s.conn.Write(packet); err != nil {
+				log.Println("statsd:", err)
+			}
+		}
+	}
+	return nil
+}
+
+// packets returns the lines of the samples, in packets of at most
+// maxPacketSize bytes
+func (s *sink) packets(samples []metrics.Sample) [][]byte {
+	var packets [][]byte
+	var packet bytes.Buffer
+	for _, sample := range samples {
+		line, ok := s.line(sample)
+		if !ok {
+			continue
+		}
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			packets = append(packets, append([]byte{}, packet.Bytes()...))
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.Bytes())
+	}
+	return packets
+}
+
+// line returns the line of a sample, or false for counters that did not
+// change since they were last sent
+func (s *sink) line(sample metrics.Sample) (string, bool) {
+	names := make([]string, 0, len(sample.Labels))
+	for name := range sample.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	name := s.prefix + sample.Name
+	tags := append([]string{}, s.tags...)
+	for _, label := range names {
+		if s.flavor == FlavorDogStatsD {
+			tags = append(tags, label+":"+sanitize(sample.Labels[label]))
+		} else {
+			name += "." + sanitize(sample.Labels[label])
+		}
+	}
+	value, kind := sample.Value, "g"
+	if sample.Kind == metrics.CounterKind {
+		key := name + "|" + strings.Join(tags, ",")
+		value, kind = sample.Value-s.sent[key], "c"
+		s.sent[key] = sample.Value
+		if value == 0 {
+			return "", false
+		}
+	}
+	line := fmt.Sprintf("%s:%v|%s", name, value, kind)
+	if s.flavor == FlavorDogStatsD && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line, true
+}
+
+// sanitize replaces the characters of a label value that have a meaning in
+// the StatsD protocol
+func sanitize(value string) string {
+	return strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_", " ", "_").Replace(value)
+}
@@ -0,0 +1,53 @@
+package statsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/logspout/metrics"
+)
+
+var samples = []metrics.Sample{
+	{Name: "shipped_total", Kind: metrics.CounterKind, Value: 10},
+	{Name: "backlog_age_seconds", Kind: metrics.GaugeKind, Labels: map[string]string{"route": "r:1"}, Value: 2.5},
+}
+
+func TestStatsDLines(t *testing.T) {
+	s := &sink{flavor: FlavorStatsD, prefix: "logspout.", sent: map[string]float64{}}
+	expected := []byte("logspout.shipped_total:10|c\nlogspout.backlog_age_seconds.r_1:2.5|g")
+	if packets := s.packets(samples); len(packets) != 1 || string(packets[0]) != string(expected) {
+		t.Errorf("unexpected packets: %q", packets)
+	}
+	// counters are sent as their increase, and not at all without one
+	if packets := s.packets(samples); len(packets) != 1 || strings.Contains(string(packets[0]), "shipped") {
+		t.Errorf("expected unchanged counters to be skipped, got %q", packets)
+	}
+}
+
+func TestDogStatsDTags(t *testing.T) {
+	s := &sink{flavor: FlavorDogStatsD, prefix: "", tags: []string{"env:prod"}, sent: map[string]float64{}}
+	lines := strings.Split(string(s.packets(samples)[0]), "\n")
+	expected := []string{"shipped_total:10|c|#env:prod", "backlog_age_seconds:2.5|g|#env:prod,route:r_1"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected lines %q, got %q", expected, lines)
+	}
+}
+
+func TestPacketSize(t *testing.T) {
+	s := &sink{flavor: FlavorStatsD, sent: map[string]float64{}}
+	var many []metrics.Sample
+	for i := 0; i < 100; i++ {
+		many = append(many, metrics.Sample{Name: strings.Repeat("x", 50), Kind: metrics.GaugeKind,
+			Labels: map[string]string{"i": strings.Repeat("y", i%10)}, Value: float64(i)})
+	}
+	packets := s.packets(many)
+	if len(packets) < 2 {
+		t.Fatalf("expected several packets, got %d", len(packets))
+	}
+	for _, packet := range packets {
+		if len(packet) > maxPacketSize {
+			t.Errorf("packet of %d bytes is too large", len(packet))
+		}
+	}
+}
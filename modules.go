@@ -2,13 +2,27 @@ package main
 
 import (
 	_ "github.com/gliderlabs/logspout/adapters/cloudwatch"
+	_ "github.com/gliderlabs/logspout/adapters/failover"
+	_ "github.com/gliderlabs/logspout/adapters/file"
+	_ "github.com/gliderlabs/logspout/adapters/firehose"
+	_ "github.com/gliderlabs/logspout/adapters/kinesis"
+	_ "github.com/gliderlabs/logspout/adapters/lua"
 	_ "github.com/gliderlabs/logspout/adapters/multiline"
 	_ "github.com/gliderlabs/logspout/adapters/raw"
+	_ "github.com/gliderlabs/logspout/adapters/s3"
 	_ "github.com/gliderlabs/logspout/adapters/syslog"
+	_ "github.com/gliderlabs/logspout/awssecrets"
 	_ "github.com/gliderlabs/logspout/healthcheck"
 	_ "github.com/gliderlabs/logspout/httpstream"
+	_ "github.com/gliderlabs/logspout/ingest"
+	_ "github.com/gliderlabs/logspout/kubernetes"
+	_ "github.com/gliderlabs/logspout/kvroutes"
+	_ "github.com/gliderlabs/logspout/metrics"
+	_ "github.com/gliderlabs/logspout/metrics/statsd"
 	_ "github.com/gliderlabs/logspout/routesapi"
+	_ "github.com/gliderlabs/logspout/swarm"
 	_ "github.com/gliderlabs/logspout/transports/tcp"
 	_ "github.com/gliderlabs/logspout/transports/tls"
 	_ "github.com/gliderlabs/logspout/transports/udp"
+	_ "github.com/gliderlabs/logspout/vault"
 )
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func init() {
+	cfg.Register(cfg.Option{Name: "PLUGINS",
+		Description: "comma separated Go plugin files, or directories of them, loaded before anything else to add adapters and other modules"})
+}
+
+// loadPlugins opens the Go plugins of PLUGINS, which register their
+// adapters, jobs and options from their init functions like the modules
+// built in do. It reads the environment rather than cfg, as options are
+// parsed once plugins registered theirs.
+func loadPlugins() ([]string, error) {
+	var loaded []string
+	for _, path := range strings.Split(os.Getenv("PLUGINS"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return loaded, fmt.Errorf("plugins: %s", err)
+		} else if info.IsDir() {
+			// in the order of their names, for plugins relying on others
+			if files, err = filepath.Glob(filepath.Join(path, "*.so")); err != nil {
+				return loaded, fmt.Errorf("plugins: %s", err)
+			}
+		}
+		for _, file := range files {
+			if _, err := plugin.Open(file); err != nil {
+				return loaded, fmt.Errorf("plugins: %s", err)
+			}
+			loaded = append(loaded, filepath.Base(file))
+		}
+	}
+	return loaded, nil
+}
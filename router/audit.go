@@ -0,0 +1,144 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// auditStreamBuffer is how many entries the audit stream may fall behind by
+const auditStreamBuffer = 1024
+
+func init() {
+	Jobs.Register(audit, "audit")
+	cfg.Register(
+		cfg.Option{Name: "AUDIT_FILE", Description: "file the actions of the management API are appended to, as JSON lines"},
+		cfg.Option{Name: "AUDIT_ROUTE", Description: "route URI the actions of the management API are also shipped to"},
+	)
+}
+
+// AuditEntry records an action of the management API: who took it, when,
+// and what it changed
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"` // eg: route.add
+	RouteID      string    `json:"route_id,omitempty"`
+	Selector     string    `json:"selector,omitempty"` // of the containers or stream flushed, paused or resumed
+	Route        *Route    `json:"route,omitempty"`    // as added
+	Previous     *Route    `json:"previous,omitempty"` // as replaced or removed
+	User         string    `json:"user,omitempty"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	Error        string    `json:"error,omitempty"` // why the action failed
+}
+
+// Audit records an action taken through the management API in the
+// AUDIT_FILE and the AUDIT_ROUTE, if set, with who requested it
+func Audit(req *http.Request, entry AuditEntry) {
+	entry.Time = time.Now().UTC()
+	entry.RemoteAddr = req.RemoteAddr
+	entry.ForwardedFor = req.Header.Get("X-Forwarded-For")
+	entry.UserAgent = req.UserAgent()
+	if name := TokenName(req); name != "" {
+		entry.User = "token:" + name
+	} else if user, _, ok := req.BasicAuth(); ok {
+		entry.User = user
+	} else {
+		// set by authenticating proxies
+		entry.User = req.Header.Get("X-Forwarded-User")
+	}
+	audit.record(&entry)
+}
+
+var audit = &auditLog{}
+
+// auditLog is the job shipping the audit entries to the AUDIT_ROUTE, and
+// what appends them to the AUDIT_FILE
+type auditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	adapter LogAdapter
+	stream  chan *Message
+	self    *docker.Container // the container audit entries are shipped as
+}
+
+func (a *auditLog) Name() string {
+	if a.adapter == nil {
+		return ""
+	}
+	return "audit"
+}
+
+func (a *auditLog) Setup() error {
+	if path := cfg.GetString("AUDIT_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("audit: %s", err)
+		}
+		a.file = file
+	}
+	uri := cfg.GetString("AUDIT_ROUTE")
+	if uri == "" {
+		return nil
+	}
+	route, err := ParseRouteURI(uri)
+	if err != nil {
+		return fmt.Errorf("audit: %s", err)
+	}
+	route.ID = "audit"
+	factory, found := AdapterFactories.Lookup(route.AdapterType())
+	if !found {
+		return fmt.Errorf("audit: bad adapter: %s", route.Adapter)
+	}
+	if a.adapter, err = factory(route); err != nil {
+		return fmt.Errorf("audit: %s", err)
+	}
+	hostname, _ := os.Hostname()
+	a.stream = make(chan *Message, auditStreamBuffer)
+	a.self = &docker.Container{
+		Name:       "/logspout-audit",
+		Config:     &docker.Config{Hostname: hostname, Labels: map[string]string{}},
+		HostConfig: &docker.HostConfig{},
+	}
+	return nil
+}
+
+func (a *auditLog) Run() error {
+	if a.adapter == nil {
+		select {}
+	}
+	a.adapter.Stream(a.stream)
+	return nil
+}
+
+func (a *auditLog) record(entry *AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("audit:", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			log.Println("audit:", err)
+		}
+	}
+	if a.stream == nil {
+		return
+	}
+	select {
+	case a.stream <- &Message{Container: a.self, Source: "audit", Data: string(line), Time: entry.Time}:
+	default:
+		log.Println("audit: stream full, not shipped:", string(line))
+	}
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	os.Setenv("AUDIT_FILE", path)
+	defer os.Unsetenv("AUDIT_FILE")
+	a := &auditLog{}
+	if err := a.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func(previous *auditLog) { audit = previous }(audit)
+	audit = a
+
+	req := httptest.NewRequest("DELETE", "/routes/abc", nil)
+	req.SetBasicAuth("alice", "secret")
+	req.Header.Set("X-Forwarded-For", "10.0.0.7")
+	Audit(req, AuditEntry{Action: "route.remove", RouteID: "abc", Previous: &Route{ID: "abc", Adapter: "syslog"}})
+	Audit(httptest.NewRequest("POST", "/config/refresh", nil), AuditEntry{Action: "config.refresh", Error: "invalid"})
+	a.file.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var first, second AuditEntry
+	if err := decoder.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoder.Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Action != "route.remove" || first.User != "alice" || first.ForwardedFor != "10.0.0.7" ||
+		first.Previous == nil || first.Previous.Adapter != "syslog" || first.Time.IsZero() {
+		t.Errorf("unexpected entry %+v", first)
+	}
+	if second.Action != "config.refresh" || second.Error != "invalid" || second.User != "" {
+		t.Errorf("unexpected entry %+v", second)
+	}
+}
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// Scopes of API tokens
+const (
+	ScopeRead   = "read"   // GET the metrics, routes and other resources, and dry run lines
+	ScopeTail   = "tail"   // stream logs from /logs
+	ScopeWrite  = "write"  // change routes and the configuration
+	ScopeIngest = "ingest" // push log entries to the ingest service
+)
+
+// openHandlers are served without a token, for probes of orchestrators
+var openHandlers = map[string]bool{"health": true}
+
+// ingestHandlers take log entries, with the ingest scope, so that agents
+// pushing them cannot change routes
+var ingestHandlers = map[string]bool{"logspout.ingest.v1.Ingest": true, "ingest": true}
+
+func init() {
+	cfg.Register(cfg.Option{Name: "API_TOKENS", Secret: true, Validate: validateTokens,
+		Description: "tokens of the HTTP API, as name:token:scopes[:requests per second] separated by spaces, scopes joined by +"})
+}
+
+// apiToken is a token of the HTTP API, with what it may do
+type apiToken struct {
+	name   string
+	secret string
+	scopes map[string]bool
+	limit  *rateLimiter // nil if unlimited
+}
+
+type tokenKey struct{}
+
+// TokenName returns the name of the API token a request was authorized
+// with, or "" if tokens are not required
+func TokenName(req *http.Request) string {
+	name, _ := req.Context().Value(tokenKey{}).(string)
+	return name
+}
+
+// parseTokens parses the API_TOKENS
+func parseTokens(text string) ([]*apiToken, error) {
+	var tokens []*apiToken
+	for _, field := range strings.Fields(text) {
+		parts := strings.Split(field, ":")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("tokens must be name:token:scopes[:requests per second]")
+		}
+		token := &apiToken{name: parts[0], secret: parts[1], scopes: map[string]bool{}}
+		for _, scope := range strings.Split(parts[2], "+") {
+			if scope != ScopeRead && scope != ScopeTail && scope != ScopeWrite && scope != ScopeIngest {
+				return nil, errors.New("unknown scope " + scope + " of token " + token.name)
+			}
+			token.scopes[scope] = true
+		}
+		if len(parts) == 4 {
+			rate, err := strconv.ParseFloat(parts[3], 64)
+			if err != nil || rate <= 0 {
+				return nil, errors.New("rate of token " + token.name + " must be a number of requests per second")
+			}
+			token.limit = newRateLimiter(rate)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func validateTokens(value string) error {
+	_, err := parseTokens(value)
+	return err
+}
+
+// scopeOf returns the scope a request to a handler needs: tail for logs,
+// ingest to push entries, read to GET a resource or dry run a line, and
+// write to change one
+func scopeOf(handler string, req *http.Request) string {
+	switch {
+	case handler == "logs":
+		return ScopeTail
+	case ingestHandlers[handler]:
+		return ScopeIngest
+	case handler == "dryrun" || req.Method == http.MethodGet || req.Method == http.MethodHead:
+		return ScopeRead
+	default:
+		return ScopeWrite
+	}
+}
+
+// authorize wraps a handler to require a token with the scope of each
+// request, given as a bearer token or the password of basic auth, when
+// API_TOKENS are set
+func authorize(name string, tokens []*apiToken, h http.Handler) http.Handler {
+	if len(tokens) == 0 || openHandlers[name] {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		secret := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := req.BasicAuth(); ok {
+			secret = password
+		}
+		var token *apiToken
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t.secret), []byte(secret)) == 1 {
+				token = t
+			}
+		}
+		switch {
+		case token == nil:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logspout"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case !token.scopes[scopeOf(name, req)]:
+			http.Error(w, "forbidden: token "+token.name+" lacks the "+scopeOf(name, req)+" scope", http.StatusForbidden)
+		case token.limit != nil && !token.limit.allow():
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+		default:
+			h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), tokenKey{}, token.name)))
+		}
+	})
+}
+
+// rateLimiter is a token bucket allowing a rate of requests per second,
+// in bursts of as many
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
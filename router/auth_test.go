@@ -0,0 +1,102 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := parseTokens("grafana:abc:read:2 ops:def:read+tail+write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].name != "grafana" || tokens[0].limit == nil ||
+		!tokens[1].scopes[ScopeWrite] || tokens[1].limit != nil {
+		t.Fatalf("unexpected tokens: %+v", tokens)
+	}
+	for _, bad := range []string{"abc", "grafana:abc:admin", "grafana:abc:read:fast", ":abc:read"} {
+		if _, err := parseTokens(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tokens, err := parseTokens("grafana:abc:read:1 ops:def:read+tail+write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		seen = TokenName(req)
+	})
+	routes := authorize("routes", tokens, h)
+	logs := authorize("logs", tokens, h)
+
+	for _, test := range []struct {
+		handler http.Handler
+		method  string
+		secret  string
+		basic   bool
+		status  int
+		name    string
+	}{
+		{routes, "GET", "", false, http.StatusUnauthorized, ""},
+		{routes, "GET", "wrong", false, http.StatusUnauthorized, ""},
+		{routes, "GET", "abc", false, http.StatusOK, "grafana"},
+		{routes, "POST", "abc", false, http.StatusForbidden, ""},
+		{logs, "GET", "abc", false, http.StatusForbidden, ""},
+		{routes, "GET", "abc", false, http.StatusTooManyRequests, ""},
+		{routes, "POST", "def", true, http.StatusOK, "ops"},
+		{logs, "GET", "def", false, http.StatusOK, "ops"},
+	} {
+		seen = ""
+		req := httptest.NewRequest(test.method, "/", nil)
+		if test.basic {
+			req.SetBasicAuth("", test.secret)
+		} else if test.secret != "" {
+			req.Header.Set("Authorization", "Bearer "+test.secret)
+		}
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, req)
+		if w.Code != test.status || seen != test.name {
+			t.Errorf("%s with %q: got %d for %q, expected %d for %q", test.method, test.secret, w.Code, seen, test.status, test.name)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	authorize("health", tokens, h).ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected /health to be served without a token, got %d", w.Code)
+	}
+}
+
+func TestAuthorizeIngest(t *testing.T) {
+	tokens, err := parseTokens("agent:abc:ingest ops:def:read+tail+write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	ingest := authorize("logspout.ingest.v1.Ingest", tokens, h)
+	post := authorize("ingest", tokens, h)
+	routes := authorize("routes", tokens, h)
+	for _, test := range []struct {
+		handler http.Handler
+		secret  string
+		status  int
+	}{
+		{ingest, "abc", http.StatusOK},
+		{post, "abc", http.StatusOK},
+		{routes, "abc", http.StatusForbidden},
+		{ingest, "def", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+test.secret)
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("POST with %q: got %d, expected %d", test.secret, w.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"context"
+	"sync"
+)
+
+// pauses tracks the route buffers that paused reading the logs of each
+// container, the pump of a container waits for all of them to resume.
+var pauses = newPauseRegistry()
+
+// pauseRegistry holds the logstreams of the routes that paused a container,
+// indexed by container ID
+type pauseRegistry struct {
+	mu      sync.Mutex
+	resumed *sync.Cond
+	streams map[string]map[<-chan *Message]struct{}
+}
+
+func newPauseRegistry() *pauseRegistry {
+	p := &pauseRegistry{streams: map[string]map[<-chan *Message]struct{}{}}
+	p.resumed = sync.NewCond(&p.mu)
+	return p
+}
+
+// pause stops reading the logs of a container for the route of a logstream
+func (p *pauseRegistry) pause(id string, stream <-chan *Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.streams[id] == nil {
+		p.streams[id] = map[<-chan *Message]struct{}{}
+	}
+	p.streams[id][stream] = struct{}{}
+}
+
+// resume undoes the pause of a container for the route of a logstream
+func (p *pauseRegistry) resume(id string, stream <-chan *Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, paused := p.streams[id][stream]; !paused {
+		return
+	}
+	delete(p.streams[id], stream)
+	if len(p.streams[id]) == 0 {
+		delete(p.streams, id)
+		p.resumed.Broadcast()
+	}
+}
+
+// paused returns whether any route paused a container
+func (p *pauseRegistry) paused(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.streams[id]) > 0
+}
+
+// wait blocks while any route paused a container, or until the context is
+// done and wake is called
+func (p *pauseRegistry) wait(ctx context.Context, id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.streams[id]) > 0 && ctx.Err() == nil {
+		p.resumed.Wait()
+	}
+}
+
+// wake has the waits whose contexts are done return
+func (p *pauseRegistry) wake() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resumed.Broadcast()
+}
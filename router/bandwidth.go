@@ -0,0 +1,105 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "BANDWIDTH", Type: cfg.Int, Default: "0", Validate: cfg.NotNegative,
+			Description: "bytes of lines per second each route may ship, 0 for unlimited"},
+		cfg.Option{Name: "BANDWIDTH_BURST", Type: cfg.Int, Default: "0", Validate: cfg.NotNegative,
+			Description: "bytes of lines a route may ship at once beyond its BANDWIDTH (default a second of it)"},
+	)
+}
+
+// shaper holds back the lines of a route to a rate of bytes per second, in
+// bursts of up to burst bytes. The lines wait rather than being dropped,
+// holding up the route like a slow destination does.
+type shaper struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newShaper returns the shaper of the bandwidth and bandwidth_burst options
+// of a route, or the BANDWIDTH and BANDWIDTH_BURST env vars. It returns nil
+// if the bandwidth of the route is not limited.
+func newShaper(route *Route) (*shaper, error) {
+	rate, err := bandwidthOption(route, "bandwidth", cfg.GetInt("BANDWIDTH"))
+	if err != nil || rate == 0 {
+		return nil, err
+	}
+	burst, err := bandwidthOption(route, "bandwidth_burst", cfg.GetInt("BANDWIDTH_BURST"))
+	if err != nil {
+		return nil, err
+	}
+	if burst == 0 {
+		burst = rate
+	}
+	return &shaper{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}, nil
+}
+
+func bandwidthOption(route *Route, name string, dfault int) (int, error) {
+	text, isSet := route.Options[name]
+	if !isSet {
+		return dfault, nil
+	}
+	bytes, err := strconv.Atoi(text)
+	if err != nil || bytes < 0 {
+		return 0, fmt.Errorf("invalid value for %s (must be bytes): %s", name, text)
+	}
+	return bytes, nil
+}
+
+// wait returns once size bytes may be shipped. Lines larger than the burst
+// are shipped once it is full, and those after them held back for longer.
+func (s *shaper) wait(size int, closer <-chan struct{}) {
+	needed := float64(size)
+	if needed > s.burst {
+		needed = s.burst
+	}
+	for {
+		now := time.Now()
+		s.tokens += now.Sub(s.last).Seconds() * s.rate
+		if s.tokens > s.burst {
+			s.tokens = s.burst
+		}
+		s.last = now
+		if s.tokens >= needed {
+			s.tokens -= float64(size)
+			return
+		}
+		sleep(time.Duration((needed-s.tokens)/s.rate*float64(time.Second)), closer)
+		select {
+		case <-closer: // not held up once the route is closed
+			return
+		default:
+		}
+	}
+}
+
+// run moves messages from in to out, at the rate of the shaper, until in
+// is closed
+func (s *shaper) run(route *Route, in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	for msg := range in {
+		s.wait(len(msg.Data), route.Closer())
+		out <- msg
+	}
+}
+
+// sleep waits for a duration, or until closer is closed
+func sleep(d time.Duration, closer <-chan struct{}) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-closer:
+	}
+}
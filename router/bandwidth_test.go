@@ -0,0 +1,43 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShaper(t *testing.T) {
+	route := &Route{Options: map[string]string{"bandwidth": "10000", "bandwidth_burst": "1000"}, closer: make(chan struct{})}
+	s, err := newShaper(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out := make(chan *Message, 4), make(chan *Message, 4)
+	line := &Message{Data: strings.Repeat("x", 1000)}
+	for i := 0; i < 4; i++ {
+		in <- line
+	}
+	close(in)
+	start := time.Now()
+	s.run(route, in, out)
+	// the first line is shipped in the burst, each other after 100ms
+	if elapsed := time.Since(start); elapsed < 280*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected 4 lines of 1000 bytes to take 300ms at 10000 bytes per second, took %s", elapsed)
+	}
+	if len(out) != 4 {
+		t.Errorf("expected every line to be shipped, got %d", len(out))
+	}
+}
+
+func TestShaperOptions(t *testing.T) {
+	if s, err := newShaper(&Route{Options: map[string]string{}}); s != nil || err != nil {
+		t.Errorf("expected routes to be unlimited by default, got %v, %v", s, err)
+	}
+	s, err := newShaper(&Route{Options: map[string]string{"bandwidth": "500"}})
+	if err != nil || s.burst != 500 {
+		t.Errorf("expected the burst to default to a second of bandwidth, got %v, %v", s, err)
+	}
+	if _, err := newShaper(&Route{Options: map[string]string{"bandwidth": "1mb"}}); err == nil {
+		t.Error("expected an invalid bandwidth to fail")
+	}
+}
@@ -0,0 +1,253 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// Drop policies of a route buffer that is full
+const (
+	DropPolicyBlock  = "block"  // wait for the adapter, slowing down all routes
+	DropPolicyNewest = "newest" // drop the incoming message
+	DropPolicyOldest = "oldest" // drop the oldest buffered message
+	DropPolicyPause  = "pause"  // stop reading the logs of the container until there is room
+)
+
+// how often a route buffer logs the number of messages it dropped
+const dropLogInterval = 10 * time.Second
+
+// routeBuffer queues messages between the pump and the adapter of a route,
+// so a slow adapter does not hold up the other routes until its buffer is
+// full. Messages are queued by the QoS class of their container, each with
+// its own size and drop policy, and sent on in the order they arrived.
+type routeBuffer struct {
+	route   *Route
+	stream  <-chan *Message // identifies the route to the containers it pauses
+	classes map[string]*bufferQueue
+	seq     uint64
+	dropped int
+	lastLog time.Time
+	queued  int64 // messages in all classes, accessed atomically
+}
+
+// bufferQueue holds the buffered messages of one QoS class
+type bufferQueue struct {
+	size   int
+	policy string
+	items  []bufferItem
+	paused map[string]struct{} // IDs of the containers paused by the pause policy
+}
+
+type bufferItem struct {
+	seq uint64
+	msg *Message
+}
+
+// newRouteBuffer returns the buffer configured with the buffer_size and
+// drop_policy options of a route, or the BUFFER_SIZE and DROP_POLICY env
+// vars, and their per class variants. It returns nil if the route is not
+// buffered.
+func newRouteBuffer(route *Route) (*routeBuffer, error) {
+	sizeText := route.Options["buffer_size"]
+	if sizeText == "" {
+		sizeText = strconv.Itoa(cfg.GetInt("BUFFER_SIZE"))
+	}
+	size, err := parseBufferSize("buffer_size", sizeText)
+	if err != nil {
+		return nil, err
+	}
+	policy := route.Options["drop_policy"]
+	if policy == "" {
+		policy = cfg.GetString("DROP_POLICY")
+	}
+	if err := validateDropPolicy("drop_policy", policy); err != nil {
+		return nil, err
+	}
+	buffered := size > 0
+	classes := map[string]*bufferQueue{}
+	for _, class := range qosClasses {
+		queue := &bufferQueue{size: size, policy: policy, paused: map[string]struct{}{}}
+		switch class {
+		case QoSGuaranteed:
+			queue.policy = DropPolicyBlock
+		case QoSBestEffort:
+			queue.policy = DropPolicyNewest
+		}
+		if text := QoSOption(route, "buffer_size", class); text != "" {
+			if queue.size, err = parseBufferSize("buffer_size."+class, text); err != nil {
+				return nil, err
+			}
+			buffered = buffered || queue.size > 0
+		}
+		if text := QoSOption(route, "drop_policy", class); text != "" {
+			if err := validateDropPolicy("drop_policy."+class, text); err != nil {
+				return nil, err
+			}
+			queue.policy = text
+		}
+		if queue.size == 0 { // as close to unbuffered as a queue gets
+			queue.size, queue.policy = 1, DropPolicyBlock
+		}
+		classes[class] = queue
+	}
+	if !buffered {
+		return nil, nil
+	}
+	return &routeBuffer{route: route, classes: classes}, nil
+}
+
+func parseBufferSize(name, text string) (int, error) {
+	size, err := strconv.Atoi(text)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid value for %s (must be messages): %s", name, text)
+	}
+	return size, nil
+}
+
+func validateDropPolicy(name, policy string) error {
+	switch policy {
+	case DropPolicyBlock, DropPolicyNewest, DropPolicyOldest, DropPolicyPause:
+		return nil
+	default:
+		return fmt.Errorf("invalid value for %s (must be block, newest, oldest or pause): %s", name, policy)
+	}
+}
+
+// run moves messages from in to out until in is closed
+func (b *routeBuffer) run(in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	b.stream = in
+	defer b.resumeAll()
+	gauge := openGauge(StageSink, b.route.ID, b)
+	defer gauge.close(b)
+	var pending *Message // waits for room in the queue of a blocking class
+	for {
+		recv := in
+		if pending != nil {
+			if b.add(pending) {
+				pending = nil
+			} else {
+				recv = nil
+			}
+		}
+		gauge.observe(b.measure())
+		var send chan<- *Message
+		var next *Message
+		head := b.head()
+		if head != nil {
+			send, next = out, head.items[0].msg
+		}
+		select {
+		case msg, ok := <-recv:
+			if !ok {
+				for head := b.head(); head != nil; head = b.head() {
+					out <- head.pop()
+				}
+				return
+			}
+			if !b.add(msg) {
+				pending = msg
+			}
+		case send <- next:
+			head.pop()
+			if len(head.items) < head.size {
+				b.resume(head)
+			}
+		}
+	}
+}
+
+// add queues a message, or drops it or the oldest message of its class if
+// the queue is full. It returns false if the class blocks until there is
+// room. The pause policy queues the message, and pauses its container.
+func (b *routeBuffer) add(msg *Message) bool {
+	queue := b.classes[QoSClass(msg.Container)]
+	if len(queue.items) >= queue.size {
+		switch queue.policy {
+		case DropPolicyBlock:
+			return false
+		case DropPolicyNewest:
+			b.drop(msg)
+			return true
+		case DropPolicyOldest:
+			b.drop(queue.pop())
+		case DropPolicyPause:
+			if msg.Container != nil {
+				queue.paused[msg.Container.ID] = struct{}{}
+				pauses.pause(msg.Container.ID, b.stream)
+			}
+		}
+	}
+	b.seq++
+	queue.items = append(queue.items, bufferItem{seq: b.seq, msg: msg})
+	return true
+}
+
+// head returns the queue holding the oldest message, or nil if all are empty
+func (b *routeBuffer) head() *bufferQueue {
+	var head *bufferQueue
+	for _, queue := range b.classes {
+		if len(queue.items) > 0 && (head == nil || queue.items[0].seq < head.items[0].seq) {
+			head = queue
+		}
+	}
+	return head
+}
+
+// measure counts the queued messages for the metrics
+func (b *routeBuffer) measure() int {
+	queued := 0
+	for _, queue := range b.classes {
+		queued += len(queue.items)
+	}
+	atomic.StoreInt64(&b.queued, int64(queued))
+	return queued
+}
+
+func (b *routeBuffer) depth() int { return int(atomic.LoadInt64(&b.queued)) }
+
+func (b *routeBuffer) capacity() int {
+	capacity := 0
+	for _, queue := range b.classes {
+		capacity += queue.size
+	}
+	return capacity
+}
+
+// resume lets the pump read the logs of containers a queue paused
+func (b *routeBuffer) resume(queue *bufferQueue) {
+	for id := range queue.paused {
+		pauses.resume(id, b.stream)
+		delete(queue.paused, id)
+	}
+}
+
+func (b *routeBuffer) resumeAll() {
+	for _, queue := range b.classes {
+		b.resume(queue)
+	}
+}
+
+func (q *bufferQueue) pop() *Message {
+	msg := q.items[0].msg
+	q.items[0] = bufferItem{}
+	q.items = q.items[1:]
+	return msg
+}
+
+func (b *routeBuffer) drop(msg *Message) {
+	if msg.exited { // not a line
+		return
+	}
+	WriteDeadLetters(b.route.ID, "buffer full", msg)
+	b.dropped++
+	if time.Since(b.lastLog) >= dropLogInterval {
+		log.Printf("router: route %s buffer full, dropped %d messages\n", b.route.ID, b.dropped)
+		b.dropped, b.lastLog = 0, time.Now()
+	}
+}
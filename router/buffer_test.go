@@ -0,0 +1,168 @@
+package router
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func bufferedData(t *testing.T, options map[string]string, messages ...string) []string {
+	t.Helper()
+	buffer, err := newRouteBuffer(&Route{ID: "test", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out := make(chan *Message), make(chan *Message)
+	done := make(chan struct{})
+	go func() {
+		buffer.run(in, out)
+		close(done)
+	}()
+	// fill the buffer while nothing reads from it
+	for _, data := range messages {
+		in <- &Message{Data: data}
+	}
+	close(in)
+	var received []string
+	for msg := range out {
+		received = append(received, msg.Data)
+	}
+	<-done
+	return received
+}
+
+func TestRouteBufferDropPolicies(t *testing.T) {
+	// nothing is read until all messages were sent, so only 2 are kept
+	tests := []struct {
+		policy   string
+		expected []string
+	}{
+		{DropPolicyNewest, []string{"1", "2"}},
+		{DropPolicyOldest, []string{"4", "5"}},
+	}
+	for _, test := range tests {
+		received := bufferedData(t, map[string]string{"buffer_size": "2", "drop_policy": test.policy},
+			"1", "2", "3", "4", "5")
+		if !reflect.DeepEqual(received, test.expected) {
+			t.Errorf("expected %v for %s, got %v", test.expected, test.policy, received)
+		}
+	}
+}
+
+func TestRouteBufferOptions(t *testing.T) {
+	buffer, err := newRouteBuffer(&Route{Options: map[string]string{}})
+	if err != nil || buffer != nil {
+		t.Errorf("expected no buffer by default, got %v %v", buffer, err)
+	}
+	for _, options := range []map[string]string{
+		{"buffer_size": "lots"},
+		{"buffer_size": "-1"},
+		{"buffer_size": "10", "drop_policy": "random"},
+		{"buffer_size.guaranteed": "lots"},
+		{"buffer_size": "10", "drop_policy.best-effort": "random"},
+	} {
+		if _, err := newRouteBuffer(&Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v, got nil", options)
+		}
+	}
+}
+
+func TestRouteBufferQoSClasses(t *testing.T) {
+	buffer, err := newRouteBuffer(&Route{ID: "test", Options: map[string]string{
+		"buffer_size": "2", "drop_policy": DropPolicyOldest, "buffer_size.guaranteed": "3",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := func(class string) *docker.Container {
+		return &docker.Container{Config: &docker.Config{Labels: map[string]string{QoSLabel: class}}}
+	}
+	guaranteed, bestEffort := container(QoSGuaranteed), container(QoSBestEffort)
+	in, out := make(chan *Message), make(chan *Message)
+	go buffer.run(in, out)
+	// nothing is read until all messages were sent, so each class drops
+	// messages by its own policy
+	for _, msg := range []*Message{
+		{Data: "g1", Container: guaranteed},
+		{Data: "b1", Container: bestEffort},
+		{Data: "d1"},
+		{Data: "b2", Container: bestEffort},
+		{Data: "b3", Container: bestEffort},
+		{Data: "g2", Container: guaranteed},
+		{Data: "d2"},
+		{Data: "d3"},
+		{Data: "g3", Container: guaranteed},
+	} {
+		in <- msg
+	}
+	close(in)
+	var received []string
+	for msg := range out {
+		received = append(received, msg.Data)
+	}
+	expected := []string{"g1", "b1", "b2", "g2", "d2", "d3", "g3"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected %v, got %v", expected, received)
+	}
+}
+
+func TestQoSOption(t *testing.T) {
+	os.Setenv("BUFFER_SIZE_BEST_EFFORT", "100")
+	defer os.Unsetenv("BUFFER_SIZE_BEST_EFFORT")
+	route := &Route{Options: map[string]string{"buffer_size.guaranteed": "5"}}
+	for class, expected := range map[string]string{
+		QoSGuaranteed: "5",
+		QoSBestEffort: "100",
+		"":            "",
+	} {
+		if value := QoSOption(route, "buffer_size", class); value != expected {
+			t.Errorf("expected %q for class %q, got %q", expected, class, value)
+		}
+	}
+	if class := QoSClass(&docker.Container{Config: &docker.Config{Labels: map[string]string{QoSLabel: "platinum"}}}); class != "" {
+		t.Errorf("expected unknown class to be ignored, got %q", class)
+	}
+}
+
+func TestRouteBufferPausePolicy(t *testing.T) {
+	buffer, err := newRouteBuffer(&Route{ID: "test", Options: map[string]string{
+		"buffer_size": "1", "drop_policy": DropPolicyPause,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{ID: "paused"}
+	in, out := make(chan *Message), make(chan *Message)
+	go buffer.run(in, out)
+	in <- &Message{Data: "1", Container: container}
+	if pauses.paused(container.ID) {
+		t.Fatal("expected container to be read while the buffer has room")
+	}
+	in <- &Message{Data: "2", Container: container}
+	for deadline := time.Now().Add(time.Second); !pauses.paused(container.ID); {
+		if time.Now().After(deadline) {
+			t.Fatal("expected full buffer to pause the container instead of dropping")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	resumed := make(chan struct{})
+	go func() {
+		pauses.wait(context.Background(), container.ID)
+		close(resumed)
+	}()
+	for _, expected := range []string{"1", "2"} {
+		if msg := <-out; msg.Data != expected {
+			t.Errorf("expected %s, got %s", expected, msg.Data)
+		}
+	}
+	select {
+	case <-resumed:
+	case <-time.After(time.Second):
+		t.Fatal("expected container to resume once the buffer drained")
+	}
+	close(in)
+}
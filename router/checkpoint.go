@@ -0,0 +1,120 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// how often changed checkpoints are written to the CHECKPOINT_FILE
+const checkpointSaveInterval = time.Second
+
+// checkpoints records when the pump last read a line from each container,
+// so after a restart the logs of running containers are read from where
+// they stopped, instead of missing what they logged in the meantime.
+// All methods are safe to call on a nil *checkpoints, which records nothing.
+type checkpoints struct {
+	path  string
+	mu    sync.Mutex
+	times map[string]time.Time // indexed by container ID
+	dirty bool
+}
+
+// loadCheckpoints reads the CHECKPOINT_FILE, which may not exist yet, and
+// writes changes back to it from then on. It returns nil if it is not set.
+func loadCheckpoints() (*checkpoints, error) {
+	path := cfg.GetString("CHECKPOINT_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	c := &checkpoints{path: path, times: map[string]time.Time{}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &c.times); err != nil {
+			return nil, fmt.Errorf("invalid CHECKPOINT_FILE %s: %s", path, err)
+		}
+	}
+	go c.run()
+	return c, nil
+}
+
+// mark records the time a line was read from a container
+func (c *checkpoints) mark(id string, t time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.times[id]) { // the streams of a container are shipped apart
+		c.times[id] = t
+		c.dirty = true
+	}
+}
+
+// since returns the time the last line was read from a container
+func (c *checkpoints) since(id string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, exists := c.times[id]
+	return t, exists
+}
+
+// forget removes the checkpoint of a container that is gone
+func (c *checkpoints) forget(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.times[id]; exists {
+		delete(c.times, id)
+		c.dirty = true
+	}
+}
+
+func (c *checkpoints) run() {
+	for range time.Tick(checkpointSaveInterval) {
+		if err := c.save(); err != nil {
+			log.Println("pump: error saving CHECKPOINT_FILE:", err)
+		}
+	}
+}
+
+// save writes the checkpoints if they changed, replacing the file
+// atomically so a crash never leaves it half written
+func (c *checkpoints) save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c.times)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
+
+// catchUpSince returns the time to read the logs of a container running
+// when logspout starts from: its checkpoint, or the CATCHUP window before
+// now. Without either, only new lines are read.
+func (c *checkpoints) catchUpSince(id string, now time.Time) time.Time {
+	if t, exists := c.since(id); exists && t.Before(now) {
+		return t
+	}
+	return now.Add(-cfg.GetDuration("CATCHUP"))
+}
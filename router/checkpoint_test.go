@@ -0,0 +1,56 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointsPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("CHECKPOINT_FILE", filepath.Join(dir, "checkpoints.json"))
+	defer os.Unsetenv("CHECKPOINT_FILE")
+
+	c, err := loadCheckpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := time.Now().Add(-time.Hour).Round(time.Second)
+	c.mark("running", read)
+	c.mark("gone", read)
+	c.forget("gone")
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := loadCheckpoints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if since := restarted.catchUpSince("running", now); !since.Equal(read) {
+		t.Errorf("expected to catch up from %s, got %s", read, since)
+	}
+	if since := restarted.catchUpSince("gone", now); !since.Equal(now) {
+		t.Errorf("expected only new lines for a forgotten container, got %s", since)
+	}
+}
+
+func TestCatchUpWindow(t *testing.T) {
+	var c *checkpoints // without a CHECKPOINT_FILE
+	now := time.Now()
+	if since := c.catchUpSince("running", now); !since.Equal(now) {
+		t.Errorf("expected no catch up by default, got %s", since)
+	}
+	os.Setenv("CATCHUP", "5m")
+	defer os.Unsetenv("CATCHUP")
+	if since := c.catchUpSince("running", now); !since.Equal(now.Add(-5 * time.Minute)) {
+		t.Errorf("expected to catch up on the CATCHUP window, got %s", since)
+	}
+}
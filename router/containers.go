@@ -0,0 +1,163 @@
+package router
+
+import (
+	"strings"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// imageLabelPrefix is that of the labels the OCI image spec annotates
+// images with, like org.opencontainers.image.revision
+const imageLabelPrefix = "org.opencontainers.image."
+
+// metadata caches the metadata of the containers the pump inspected,
+// kept current by the events of Docker, so the logs of a container are
+// handled without waiting for Docker to inspect it again
+var metadata = &containerCache{containers: map[string]*docker.Container{}, images: map[string]map[string]string{}}
+
+type inspector interface {
+	InspectContainer(id string) (*docker.Container, error)
+	InspectImage(name string) (*docker.Image, error)
+}
+
+// containerCache holds the inspected containers, indexed by their short ID,
+// and the OCI labels of their images, by image ID
+type containerCache struct {
+	mu         sync.Mutex
+	client     inspector
+	containers map[string]*docker.Container
+	images     map[string]map[string]string
+}
+
+// ImageLabels returns the OCI labels of the image of a container, without
+// their org.opencontainers.image. prefix, eg: revision and version. Those of
+// the container, which it inherits from its image, are returned if the
+// image was not inspected, like for containers the pump did not attach to.
+func ImageLabels(container *docker.Container) map[string]string {
+	if container == nil {
+		return nil
+	}
+	metadata.mu.Lock()
+	labels, inspected := metadata.images[container.Image]
+	metadata.mu.Unlock()
+	if inspected {
+		return labels
+	}
+	if container.Config == nil {
+		return nil
+	}
+	return imageLabels(container.Config.Labels)
+}
+
+// imageLabels returns the OCI labels of labels, without their prefix
+func imageLabels(labels map[string]string) map[string]string {
+	var oci map[string]string
+	for key, value := range labels {
+		if strings.HasPrefix(key, imageLabelPrefix) {
+			if oci == nil {
+				oci = map[string]string{}
+			}
+			oci[strings.TrimPrefix(key, imageLabelPrefix)] = value
+		}
+	}
+	return oci
+}
+
+// LookupContainer returns the metadata of a container the pump inspected,
+// by its ID, without calling Docker
+func LookupContainer(id string) (*docker.Container, bool) {
+	return metadata.lookup(id)
+}
+
+func (c *containerCache) lookup(id string) (*docker.Container, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	container, cached := c.containers[normalID(id)]
+	return container, cached
+}
+
+// inspect returns the metadata of a container, inspecting it only if it is
+// not cached yet. Its name, image, labels and env do not change but for
+// renames, which the events of Docker update the cache with, whereas its
+// state is only current as of when it was last refreshed.
+func (c *containerCache) inspect(id string) (*docker.Container, error) {
+	if container, cached := c.lookup(id); cached {
+		return container, nil
+	}
+	container, err := c.client.InspectContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	c.inspectImage(container.Image)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, isCached := c.containers[normalID(id)]; isCached { // inspected meanwhile
+		return cached, nil
+	}
+	c.containers[normalID(id)] = container
+	return container, nil
+}
+
+// refresh inspects a container again and caches it, like when it starts
+// or restarts, for its state, like its PID, to be that of its current run
+func (c *containerCache) refresh(id string) (*docker.Container, error) {
+	container, err := c.client.InspectContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	c.inspectImage(container.Image)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.containers[normalID(id)] = container
+	return container, nil
+}
+
+// inspectImage caches the OCI labels of an image, unless they are already.
+// Images that fail to be inspected are left out, for the labels of their
+// containers to be used instead.
+func (c *containerCache) inspectImage(id string) {
+	c.mu.Lock()
+	_, cached := c.images[id]
+	c.mu.Unlock()
+	if cached || id == "" {
+		return
+	}
+	image, err := c.client.InspectImage(id)
+	if err != nil || image.Config == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.images == nil {
+		c.images = map[string]map[string]string{}
+	}
+	c.images[id] = imageLabels(image.Config.Labels)
+}
+
+// renamed updates the name of a cached container
+func (c *containerCache) renamed(id, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if container, cached := c.containers[normalID(id)]; cached {
+		container.Name = name
+	}
+}
+
+// forget removes a destroyed container from the cache, and the labels of
+// its image unless another cached container runs it
+func (c *containerCache) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	container, cached := c.containers[normalID(id)]
+	if !cached {
+		return
+	}
+	delete(c.containers, normalID(id))
+	for _, other := range c.containers {
+		if other.Image == container.Image {
+			return
+		}
+	}
+	delete(c.images, container.Image)
+}
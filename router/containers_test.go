@@ -0,0 +1,77 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerCache(t *testing.T) {
+	rt := &FakeRoundTripper{message: &docker.Container{ID: "8dfafdbc3a40", Name: "/web"}, status: http.StatusOK}
+	client := newTestClient(rt)
+	c := &containerCache{client: &client, containers: map[string]*docker.Container{}}
+	for i := 0; i < 2; i++ {
+		container, err := c.inspect("8dfafdbc3a40")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if container.Name != "/web" {
+			t.Errorf("expected /web, got %s", container.Name)
+		}
+	}
+	if len(rt.requests) != 1 {
+		t.Errorf("expected the container to be inspected once, got %d requests", len(rt.requests))
+	}
+	rt.message = &docker.Container{ID: "8dfafdbc3a40", Name: "/web", State: docker.State{Pid: 42}}
+	if container, err := c.refresh("8dfafdbc3a40"); err != nil || container.State.Pid != 42 {
+		t.Fatalf("expected the container to be inspected again, got %+v %v", container, err)
+	}
+	if container, _ := c.inspect("8dfafdbc3a40"); container.State.Pid != 42 {
+		t.Errorf("expected the state of the restarted container to be cached, got PID %d", container.State.Pid)
+	}
+	c.renamed("8dfafdbc3a40", "/api")
+	if container, cached := c.lookup("8dfafdbc3a40"); !cached || container.Name != "/api" {
+		t.Errorf("expected the rename to be cached, got %+v", container)
+	}
+	c.forget("8dfafdbc3a40")
+	if _, cached := c.lookup("8dfafdbc3a40"); cached {
+		t.Error("expected a destroyed container to be forgotten")
+	}
+}
+
+func TestImageLabels(t *testing.T) {
+	defer func(previous *containerCache) { metadata = previous }(metadata)
+	labels := map[string]string{"org.opencontainers.image.version": "1.4.2", "team": "web"}
+	rt := &FakeRoundTripper{status: http.StatusOK, message: map[string]interface{}{ // as the container and its image
+		"Id": "8dfafdbc3a40", "Image": "sha256:4e38e38c8ce0", "Config": map[string]interface{}{"Labels": labels}}}
+	client := newTestClient(rt)
+	metadata = &containerCache{client: &client, containers: map[string]*docker.Container{}}
+	container, err := metadata.inspect("8dfafdbc3a40")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version := ImageLabels(container)["version"]; version != "1.4.2" || len(rt.requests) != 2 {
+		t.Errorf("expected the version of the image inspected, got %q after %d requests", version, len(rt.requests))
+	}
+	if labels := ImageLabels(&docker.Container{Image: "sha256:0f2e", Config: &docker.Config{Labels: labels}}); labels["version"] != "1.4.2" || len(labels) != 1 {
+		t.Errorf("expected the OCI labels of a container not inspected, got %v", labels)
+	}
+	metadata.forget("8dfafdbc3a40")
+	if _, cached := metadata.images["sha256:4e38e38c8ce0"]; cached {
+		t.Error("expected the labels of an image no cached container runs to be forgotten")
+	}
+}
+
+func TestPumpRenameFromEvent(t *testing.T) {
+	rt := &FakeRoundTripper{status: http.StatusInternalServerError}
+	client := newTestClient(rt)
+	p := &LogsPump{client: &client, pumps: map[string]*containerPump{}, routes: map[chan *update]struct{}{}}
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	p.pumps["8dfafdbc3a40"] = &containerPump{container: container, logstreams: map[chan *Message]*Route{}}
+	p.rename(&docker.APIEvents{ID: "8dfafdbc3a40", Status: "rename",
+		Actor: docker.APIActor{ID: "8dfafdbc3a40", Attributes: map[string]string{"name": "api", "oldName": "/web"}}})
+	if container.Name != "/api" || len(rt.requests) != 0 {
+		t.Errorf("expected the name of the event without inspecting, got %s after %d requests", container.Name, len(rt.requests))
+	}
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// maxDeadLetterLine is the longest line of a dead-letter file that is read
+const maxDeadLetterLine = 4 * 1024 * 1024
+
+func init() {
+	cfg.Register(cfg.Option{Name: "DEAD_LETTER_FILE",
+		Description: "file the lines routes drop are appended to, as JSON lines, to be replayed with the replay command"})
+}
+
+// DeadLetter is a line a route dropped, with its container, as written to
+// the DEAD_LETTER_FILE
+type DeadLetter struct {
+	Time      time.Time         `json:"time"` // when it was dropped
+	RouteID   string            `json:"route_id"`
+	Reason    string            `json:"reason"`
+	LogTime   time.Time         `json:"log_time"`
+	Source    string            `json:"source"`
+	Data      string            `json:"data"`
+	Container string            `json:"container_id"`
+	Name      string            `json:"container_name"`
+	Hostname  string            `json:"hostname,omitempty"`
+	Image     string            `json:"image,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Seq       uint64            `json:"seq,omitempty"`
+	// not the env of the container, which often holds credentials
+}
+
+// Message returns the line as it was routed, with a container holding what
+// templates of the routes use
+func (d *DeadLetter) Message() *Message {
+	labels := d.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return &Message{
+		Container: &docker.Container{
+			ID:         d.Container,
+			Name:       d.Name,
+			Config:     &docker.Config{Hostname: d.Hostname, Image: d.Image, Labels: labels},
+			HostConfig: &docker.HostConfig{},
+		},
+		Source: d.Source,
+		Data:   d.Data,
+		Time:   d.LogTime,
+		Seq:    d.Seq,
+	}
+}
+
+// newDeadLetter returns the entry of a line a route dropped, or spooled
+func newDeadLetter(now time.Time, routeID, reason string, msg *Message) DeadLetter {
+	entry := DeadLetter{Time: now, RouteID: routeID, Reason: reason, LogTime: msg.Time, Source: msg.Source, Data: msg.Data, Seq: msg.Seq}
+	if c := msg.Container; c != nil {
+		entry.Container, entry.Name = c.ID, c.Name
+		if c.Config != nil {
+			entry.Hostname, entry.Image, entry.Labels = c.Config.Hostname, c.Config.Image, c.Config.Labels
+		}
+	}
+	return entry
+}
+
+var deadLetters = &deadLetterFile{}
+
+// deadLetterFile appends to the DEAD_LETTER_FILE, opened when a line is
+// first dropped
+type deadLetterFile struct {
+	mu   sync.Mutex
+	file *os.File
+	path string
+}
+
+// WriteDeadLetters records the lines a route dropped in the
+// DEAD_LETTER_FILE, if set, so they can be replayed once their destination
+// is healthy again, runs the route.failed hook, with GAP_MARKERS has the
+// route mark the gaps they leave, and counts them for summary events
+func WriteDeadLetters(routeID, reason string, msgs ...*Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	routeFailed(routeID, reason, len(msgs))
+	for _, msg := range msgs {
+		msg.Ack()
+	}
+	if gapMarkers() {
+		gaps.record(routeID, reason, msgs)
+	}
+	if summarized() {
+		summaries.dropped(msgs)
+	}
+	path := cfg.GetString("DEAD_LETTER_FILE")
+	if path == "" {
+		return
+	}
+	now := time.Now().UTC()
+	deadLetters.mu.Lock()
+	defer deadLetters.mu.Unlock()
+	if deadLetters.file == nil || deadLetters.path != path {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			log.Printf("router: dead letters: %s, dropping %d messages of route %s\n", err, len(msgs), routeID)
+			return
+		}
+		if deadLetters.file != nil {
+			deadLetters.file.Close()
+		}
+		deadLetters.file, deadLetters.path = file, path
+	}
+	w := bufio.NewWriter(deadLetters.file)
+	encoder := json.NewEncoder(w)
+	for _, msg := range msgs {
+		entry := newDeadLetter(now, routeID, reason, msg)
+		if err := encoder.Encode(&entry); err != nil {
+			log.Println("router: dead letters:", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Println("router: dead letters:", err)
+	}
+}
+
+// ReadDeadLetters calls each for the lines of a dead-letter file, until it
+// returns an error
+func ReadDeadLetters(r io.Reader, each func(*DeadLetter) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxDeadLetterLine)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		if err := each(&entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
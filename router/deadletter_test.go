@@ -0,0 +1,65 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead")
+	os.Setenv("DEAD_LETTER_FILE", path)
+	defer os.Unsetenv("DEAD_LETTER_FILE")
+	defer func(previous *deadLetterFile) { deadLetters = previous }(deadLetters)
+	deadLetters = &deadLetterFile{}
+
+	container := &docker.Container{
+		ID:   "abc123",
+		Name: "/api",
+		Config: &docker.Config{Hostname: "api-1", Image: "api:1", Labels: map[string]string{"team": "core"},
+			Env: []string{"PASSWORD=secret"}},
+	}
+	logged := time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)
+	WriteDeadLetters("cloudwatch", "failed 3 times",
+		&Message{Container: container, Source: "stdout", Data: "first", Time: logged},
+		&Message{Container: container, Source: "stderr", Data: "second", Time: logged})
+	deadLetters.file.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "PASSWORD") {
+		t.Error("expected the env of the container not to be written")
+	}
+	var msgs []*Message
+	err = ReadDeadLetters(strings.NewReader(string(data)), func(entry *DeadLetter) error {
+		if entry.RouteID != "cloudwatch" || entry.Reason != "failed 3 times" {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+		msgs = append(msgs, entry.Message())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[1].Data != "second" || msgs[1].Source != "stderr" || !msgs[1].Time.Equal(logged) ||
+		msgs[1].Container.Name != "/api" || msgs[1].Container.Config.Labels["team"] != "core" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+
+	if err := ReadDeadLetters(strings.NewReader("{}\nnot json\n"), func(*DeadLetter) error { return nil }); err == nil ||
+		!strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error naming the invalid line, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func init() {
+	HTTPHandlers.Register(DryRunAPI, "dryrun")
+}
+
+// dryRun is what would be done with a line of a container
+type dryRun struct {
+	Container string        `json:"container"`
+	Name      string        `json:"name"`
+	Ignored   string        `json:"ignored,omitempty"` // why the pump does not read its logs
+	Filtered  bool          `json:"filtered"`          // denied by the CONTAINER_FILTER_FILE
+	Paused    string        `json:"paused,omitempty"`  // the policy of its pause through the pause API
+	Routes    []dryRunRoute `json:"routes"`
+	message   *Message
+	container *docker.Container
+}
+
+// dryRunRoute is what a route would do with the line
+type dryRunRoute struct {
+	ID      string `json:"id"`
+	Adapter string `json:"adapter"`
+	Matched bool   `json:"matched"`
+	Skipped string `json:"skipped,omitempty"` // why not, or why its adapter would drop it
+	Output  string `json:"output,omitempty"`  // as rendered by adapters that can
+	Error   string `json:"error,omitempty"`   // rendering it
+}
+
+// newDryRun returns what the routes would do with a line of a container
+func newDryRun(container *docker.Container, source, line string, routes []*Route) *dryRun {
+	run := &dryRun{
+		Container: normalID(container.ID),
+		Name:      strings.TrimPrefix(container.Name, "/"),
+		Ignored:   IgnoreReason(container),
+		Filtered:  Filtered(container),
+		Routes:    []dryRunRoute{},
+		message:   &Message{Container: container, Source: source, Data: line, Time: time.Now()},
+		container: container,
+	}
+	if p := apiPauses.matching(container); p != nil {
+		run.Paused = p.Policy
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ID < routes[j].ID })
+	for _, route := range routes {
+		run.Routes = append(run.Routes, run.route(route))
+	}
+	return run
+}
+
+func (run *dryRun) route(route *Route) dryRunRoute {
+	result := dryRunRoute{ID: route.ID, Adapter: route.Adapter}
+	if !route.MatchContainer(run.Container, run.Name, run.container.Config.Labels) {
+		result.Skipped = "container not matched"
+		return result
+	}
+	if result.Skipped = route.skipReason(run.message); result.Skipped != "" {
+		return result
+	}
+	result.Matched = true
+	if renderer, ok := route.adapter.(Renderer); ok {
+		output, err := renderer.Render(run.message)
+		if err == ErrDropped {
+			result.Skipped = err.Error()
+		} else if err != nil {
+			result.Error = err.Error()
+		}
+		result.Output = string(output)
+	}
+	return result
+}
+
+// DryRunAPI serves POST /dryrun?container=<id>&source=stdout|stderr, with a
+// sample line as the body, which responds with what would be done with the
+// line were the container to log it: whether its logs are read and
+// filtered, and for each route whether it matches, and if not why, and the
+// line as rendered by its adapter, for adapters that can. Nothing is
+// shipped, so routing rules can be checked without logging real lines.
+func DryRunAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dryrun", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.URL.Query().Get("container")
+		if id == "" {
+			http.Error(w, "Bad request: container must be set", http.StatusBadRequest)
+			return
+		}
+		source := r.URL.Query().Get("source")
+		switch source {
+		case "":
+			source = "stdout"
+		case "stdout", "stderr":
+		default:
+			http.Error(w, "Bad request: source must be stdout or stderr", http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		container, err := dryRunContainer(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		routes, _ := Routes.GetAll()
+		run := newDryRun(container, source, strings.TrimSuffix(string(body), "\n"), routes)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(run)
+	})
+	return mux
+}
+
+// dryRunContainer returns the metadata of a container, inspecting it if the
+// pump did not, without caching it, for the cache to only hold the
+// containers the pump reads
+func dryRunContainer(id string) (*docker.Container, error) {
+	if container, cached := metadata.lookup(id); cached {
+		return container, nil
+	}
+	if metadata.client == nil {
+		return nil, &docker.NoSuchContainer{ID: id}
+	}
+	return metadata.client.InspectContainer(id)
+}
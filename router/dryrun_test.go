@@ -0,0 +1,84 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// renderingAdapter renders lines prefixed with the name of their container
+type renderingAdapter struct{}
+
+func (a *renderingAdapter) Stream(logstream chan *Message) {}
+
+func (a *renderingAdapter) Render(message *Message) ([]byte, error) {
+	return []byte(strings.TrimPrefix(message.Container.Name, "/") + ": " + message.Data + "\n"), nil
+}
+
+func TestDryRun(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40abcdef", Name: "/web",
+		Config:     &docker.Config{Labels: map[string]string{"team": "web"}},
+		HostConfig: &docker.HostConfig{LogConfig: docker.LogConfig{Type: "json-file"}}}
+	routes := []*Route{
+		{ID: "rendered", Adapter: "raw", adapter: &renderingAdapter{}},
+		{ID: "other-team", Adapter: "syslog", FilterLabels: []string{"team:batch"}, adapter: &DummyAdapter{}},
+		{ID: "stderr", Adapter: "syslog", FilterSources: []string{"stderr"}, adapter: &DummyAdapter{}},
+	}
+	run := newDryRun(container, "stdout", "GET /", routes)
+	if run.Container != "8dfafdbc3a40" || run.Name != "web" || run.Ignored != "" || run.Filtered || run.Paused != "" {
+		t.Errorf("unexpected dry run %+v", run)
+	}
+	expected := []dryRunRoute{
+		{ID: "other-team", Adapter: "syslog", Skipped: "container not matched"},
+		{ID: "rendered", Adapter: "raw", Matched: true, Output: "web: GET /\n"},
+		{ID: "stderr", Adapter: "syslog", Skipped: "source not matched"},
+	}
+	if len(run.Routes) != len(expected) {
+		t.Fatalf("expected %d routes, got %+v", len(expected), run.Routes)
+	}
+	for i := range expected {
+		if run.Routes[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], run.Routes[i])
+		}
+	}
+}
+
+func TestDryRunAPI(t *testing.T) {
+	metadata.mu.Lock()
+	metadata.containers["8dfafdbc3a40"] = &docker.Container{ID: "8dfafdbc3a40abcdef", Name: "/web",
+		Config: &docker.Config{}, HostConfig: &docker.HostConfig{LogConfig: docker.LogConfig{Type: "json-file"}}}
+	metadata.mu.Unlock()
+	defer metadata.forget("8dfafdbc3a40")
+	handler := DryRunAPI()
+	for _, test := range []struct {
+		method, url string
+		status      int
+	}{
+		{"GET", "/dryrun?container=8dfafdbc3a40", http.StatusMethodNotAllowed},
+		{"POST", "/dryrun", http.StatusBadRequest},
+		{"POST", "/dryrun?container=8dfafdbc3a40&source=stdin", http.StatusBadRequest},
+		{"POST", "/dryrun?container=abc", http.StatusNotFound},
+		{"POST", "/dryrun?container=8dfafdbc3a40abcdef&source=stderr", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.url, bytes.NewBufferString("GET /\n")))
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.url, test.status, w.Code)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			var run dryRun
+			if err := json.NewDecoder(w.Body).Decode(&run); err != nil || run.Name != "web" {
+				t.Errorf("unexpected response %q: %v", w.Body.String(), err)
+			}
+		}
+	}
+	if scope := scopeOf("dryrun", httptest.NewRequest("POST", "/dryrun", nil)); scope != ScopeRead {
+		t.Errorf("expected dry runs to take the read scope, got %s", scope)
+	}
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schemas of the Envelope, the versions of its fields. Routes keep parsers
+// expecting the fields of an older one working with the schema option.
+const (
+	// EnvelopeSchemaV1 has the fields of the first Envelope, and no schema
+	EnvelopeSchemaV1 = 1
+	// EnvelopeSchemaV2 adds the schema and seq fields
+	EnvelopeSchemaV2 = 2
+	// EnvelopeSchemaV3 adds the image_labels field
+	EnvelopeSchemaV3 = 3
+	// EnvelopeSchema is the latest schema, of the envelopes of routes
+	// without the schema option
+	EnvelopeSchema = EnvelopeSchemaV3
+)
+
+// Envelope is a structured representation of a Message, used by adapters
+// that emit JSON or columnar output rather than raw log lines
+type Envelope struct {
+	Schema        int               `json:"schema,omitempty"`
+	Time          time.Time         `json:"time"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image"`
+	Hostname      string            `json:"hostname"`
+	Source        string            `json:"source"`
+	Message       string            `json:"message"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Seq           uint64            `json:"seq,omitempty"`
+	ImageLabels   map[string]string `json:"image_labels,omitempty"` // OCI labels of the image, see ImageLabels
+}
+
+// EnvelopeSchemaOf returns the schema of the envelopes of a route, the
+// version of its schema option or the latest
+func EnvelopeSchemaOf(route *Route) (int, error) {
+	text := route.Options["schema"]
+	if text == "" {
+		return EnvelopeSchema, nil
+	}
+	schema, err := strconv.Atoi(strings.TrimPrefix(text, "v"))
+	if err != nil || schema < EnvelopeSchemaV1 || schema > EnvelopeSchema {
+		return 0, fmt.Errorf("invalid value for schema (must be 1 to %d): %s", EnvelopeSchema, text)
+	}
+	return schema, nil
+}
+
+// NewEnvelope returns the Envelope for a Message, of the latest schema
+func NewEnvelope(m *Message) *Envelope {
+	return NewEnvelopeOf(m, EnvelopeSchema)
+}
+
+// NewEnvelopeOf returns the Envelope of a schema for a Message, leaving out
+// the fields of later schemas
+func NewEnvelopeOf(m *Message, schema int) *Envelope {
+	e := &Envelope{
+		Time:    m.Time,
+		Source:  m.Source,
+		Message: m.Data,
+	}
+	if schema >= EnvelopeSchemaV2 {
+		e.Schema, e.Seq = schema, m.Seq
+	}
+	if schema >= EnvelopeSchemaV3 {
+		e.ImageLabels = ImageLabels(m.Container)
+	}
+	if m.Container != nil {
+		e.ContainerID = m.Container.ID
+		e.ContainerName = strings.TrimPrefix(m.Container.Name, "/")
+		if m.Container.Config != nil {
+			e.Image = m.Container.Config.Image
+			e.Hostname = m.Container.Config.Hostname
+			e.Labels = m.Container.Config.Labels
+		}
+	}
+	return e
+}
+
+// Field returns the value of an Envelope field by its JSON name. Container
+// labels can be selected with a "label." prefix, eg: label.com.example.team,
+// and image labels with an "image_label." one, eg: image_label.revision
+func (e *Envelope) Field(name string) (string, bool) {
+	switch name {
+	case "schema":
+		return strconv.Itoa(e.Schema), true
+	case "seq":
+		return strconv.FormatUint(e.Seq, 10), true
+	case "time":
+		return e.Time.Format(time.RFC3339Nano), true
+	case "container_id":
+		return e.ContainerID, true
+	case "container_name":
+		return e.ContainerName, true
+	case "image":
+		return e.Image, true
+	case "hostname":
+		return e.Hostname, true
+	case "source":
+		return e.Source, true
+	case "message":
+		return e.Message, true
+	}
+	if strings.HasPrefix(name, "image_label.") {
+		return e.ImageLabels[strings.TrimPrefix(name, "image_label.")], true
+	}
+	if strings.HasPrefix(name, "label.") {
+		return e.Labels[strings.TrimPrefix(name, "label.")], true
+	}
+	return "", false
+}
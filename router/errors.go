@@ -0,0 +1,116 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// ErrorClass tells an adapter what to do with a request that failed, so
+// all adapters retry, recreate and dead-letter on the same errors
+type ErrorClass int
+
+// Error classes, from the least to the most severe
+const (
+	// ErrorRetryable is a failure that passes, like throttling, a 5xx
+	// response or a timeout: send again later
+	ErrorRetryable ErrorClass = iota
+	// ErrorRecoverable is a failure the adapter fixes, like an out of date
+	// sequence token, a missing stream or a broken connection: act, eg:
+	// recreate the stream or reconnect, then send again
+	ErrorRecoverable
+	// ErrorFatal is a failure sending again repeats, like a refused
+	// authorization or an invalid request: dead-letter the lines
+	ErrorFatal
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorRetryable:
+		return "retryable"
+	case ErrorRecoverable:
+		return "recoverable"
+	default:
+		return "fatal"
+	}
+}
+
+// classifiedError is an error an adapter classified itself
+type classifiedError struct {
+	error
+	class ErrorClass
+}
+
+func (e *classifiedError) Unwrap() error { return e.error }
+
+// ClassifyAs returns err classified as class, for errors only the adapter
+// returning them knows the class of
+func ClassifyAs(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{error: err, class: class}
+}
+
+// StatusError is an unexpected HTTP response
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected response: " + e.Status
+}
+
+// Classify returns the class of an error: the class it was given with
+// ClassifyAs, or of one of the ErrorClassifiers, eg: of the AWS adapters, or
+// of its network error or HTTP status. Errors that are not known are
+// retryable, as they were before they were classified.
+func Classify(err error) ErrorClass {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+	for _, classifier := range ErrorClassifiers.All() {
+		if class, known := classifier(err); known {
+			return class
+		}
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return ClassifyStatus(status.StatusCode)
+	}
+	return classifyNetError(err)
+}
+
+// ClassifyStatus returns the class of an HTTP response status
+func ClassifyStatus(code int) ErrorClass {
+	switch {
+	case code == http.StatusTooManyRequests, code == http.StatusRequestTimeout, code >= 500:
+		return ErrorRetryable
+	case code == http.StatusNotFound, code == http.StatusConflict:
+		return ErrorRecoverable
+	default:
+		return ErrorFatal
+	}
+}
+
+// classifyNetError returns whether a network error passes by itself, like a
+// timeout, or needs a new connection, like a connection reset
+func classifyNetError(err error) ErrorClass {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorRetryable
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrClosedPipe):
+		return ErrorRecoverable
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && !netErr.Timeout() && !netErr.Temporary() {
+		return ErrorRecoverable
+	}
+	return ErrorRetryable
+}
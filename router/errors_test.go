@@ -0,0 +1,61 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	reset := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}
+	tests := []struct {
+		err      error
+		expected ErrorClass
+	}{
+		{errors.New("unknown"), ErrorRetryable},
+		{ClassifyAs(ErrorFatal, errors.New("invalid route")), ErrorFatal},
+		{fmt.Errorf("wrapped: %w", ClassifyAs(ErrorRecoverable, errors.New("gone"))), ErrorRecoverable},
+		{&StatusError{StatusCode: 503, Status: "503 Service Unavailable"}, ErrorRetryable},
+		{&StatusError{StatusCode: 429, Status: "429 Too Many Requests"}, ErrorRetryable},
+		{&StatusError{StatusCode: 404, Status: "404 Not Found"}, ErrorRecoverable},
+		{&StatusError{StatusCode: 401, Status: "401 Unauthorized"}, ErrorFatal},
+		{&StatusError{StatusCode: 400, Status: "400 Bad Request"}, ErrorFatal},
+		{&net.OpError{Op: "write", Net: "tcp", Err: timeoutError{}}, ErrorRetryable},
+		{context.DeadlineExceeded, ErrorRetryable},
+		{reset, ErrorRecoverable},
+		{io.EOF, ErrorRecoverable},
+	}
+	for _, test := range tests {
+		if class := Classify(test.err); class != test.expected {
+			t.Errorf("expected %s to be %s, got %s", test.err, test.expected, class)
+		}
+	}
+	if ClassifyAs(ErrorFatal, nil) != nil {
+		t.Error("expected no error to stay nil")
+	}
+}
+
+func TestErrorClassifiers(t *testing.T) {
+	errThrottled := errors.New("throttled")
+	ErrorClassifiers.Register(func(err error) (ErrorClass, bool) {
+		return ErrorRetryable, errors.Is(err, errThrottled)
+	}, "test")
+	defer ErrorClassifiers.Unregister("test")
+	if class := Classify(ClassifyAs(ErrorFatal, errThrottled)); class != ErrorFatal {
+		t.Errorf("expected the class an adapter gives to win, got %s", class)
+	}
+	if class := Classify(&net.OpError{Op: "dial", Err: errThrottled}); class != ErrorRetryable {
+		t.Errorf("expected the class of the classifier, got %s", class)
+	}
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// Values of LIFECYCLE_EVENTS
+const (
+	LifecycleContainer = "container" // in the logs of the container
+	LifecycleHost      = "host"      // in the logs of a container named after the host
+)
+
+// LifecycleSource is the Source of the messages of lifecycle events, which
+// routes select or leave out with filter.sources
+const LifecycleSource = "lifecycle"
+
+// lifecycleStatuses are the Docker events of containers that are shipped
+var lifecycleStatuses = map[string]bool{
+	pumpEventStatusStartName:   true,
+	pumpEventStatusRestartName: true,
+	pumpEventStatusDieName:     true,
+	pumpEventStatusOOMName:     true,
+}
+
+func init() {
+	cfg.Register(cfg.Option{Name: "LIFECYCLE_EVENTS", Validate: cfg.OneOf(LifecycleContainer, LifecycleHost),
+		Description: "ship the start, restart, die and oom events of containers in their logs, or in those of the host"})
+}
+
+// LifecycleEvent is an event of a container, shipped as a line of JSON
+type LifecycleEvent struct {
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	ExitCode      *int      `json:"exit_code,omitempty"` // of die events
+	ContainerID   string    `json:"container_id"`
+	ContainerName string    `json:"container_name"`
+	Image         string    `json:"image"`
+}
+
+// eventTime returns when a Docker event happened, or now if it does not tell
+func eventTime(event *docker.APIEvents) time.Time {
+	if event.TimeNano != 0 {
+		return time.Unix(0, event.TimeNano)
+	} else if event.Time != 0 { // before API 1.22
+		return time.Unix(event.Time, 0)
+	}
+	return time.Now()
+}
+
+// newLifecycleEvent returns the event of a container from a Docker event
+func newLifecycleEvent(event *docker.APIEvents, container *docker.Container) *LifecycleEvent {
+	e := &LifecycleEvent{
+		Time:          eventTime(event).UTC(),
+		Event:         event.Status,
+		ContainerID:   container.ID,
+		ContainerName: strings.TrimPrefix(container.Name, "/"),
+	}
+	if container.Config != nil {
+		e.Image = container.Config.Image
+	}
+	if code, err := strconv.Atoi(event.Actor.Attributes["exitCode"]); err == nil {
+		e.ExitCode = &code
+	}
+	return e
+}
+
+// newHostPump returns the pump the lifecycle events of containers are
+// shipped with when LIFECYCLE_EVENTS is host, as the logs of a container
+// named after the Docker host, so adapters name its stream like those of
+// containers. Summary events are shipped as those of another, named with
+// a suffix.
+func newHostPump(ctx context.Context, client *docker.Client, suffix string) *containerPump {
+	hostname, _ := os.Hostname()
+	if info, err := client.Info(); err == nil && info.Name != "" {
+		hostname = info.Name // logspout runs in a container of its own host name
+	}
+	return &containerPump{
+		container: &docker.Container{
+			ID:         "host-" + hostname + suffix,
+			Name:       "/" + hostname + suffix,
+			Config:     &docker.Config{Hostname: hostname, Labels: map[string]string{}},
+			HostConfig: &docker.HostConfig{},
+		},
+		logstreams: make(map[chan *Message]*Route),
+		ctx:        ctx,
+	}
+}
+
+// shipLifecycle ships a Docker event of a container the pump reads the logs
+// of, with LIFECYCLE_EVENTS
+func (p *LogsPump) shipLifecycle(event *docker.APIEvents) {
+	mode := cfg.GetString("LIFECYCLE_EVENTS")
+	if mode == "" || !lifecycleStatuses[event.Status] {
+		return
+	}
+	container, err := metadata.inspect(normalID(event.ID))
+	if err != nil {
+		debug("pump.shipLifecycle():", normalID(event.ID), err)
+		return
+	}
+	if IgnoreReason(container) != "" {
+		return
+	}
+	e := newLifecycleEvent(event, container)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	pump := p.pumps[normalID(event.ID)]
+	if mode == LifecycleHost {
+		pump = p.host
+	}
+	p.mu.Unlock()
+	if pump == nil { // not attached, as routing stopped
+		return
+	}
+	pump.send(&Message{Container: pump.container, Source: LifecycleSource, Data: string(data), Time: e.Time})
+}
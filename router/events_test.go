@@ -0,0 +1,54 @@
+package router
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestShipLifecycle(t *testing.T) {
+	container := &docker.Container{
+		ID:         "8dfafdbc3a40",
+		Name:       "/web",
+		Config:     &docker.Config{Image: "nginx"},
+		HostConfig: &docker.HostConfig{LogConfig: docker.LogConfig{Type: "json-file"}},
+	}
+	defer func(previous *containerCache) { metadata = previous }(metadata)
+	metadata = &containerCache{containers: map[string]*docker.Container{"8dfafdbc3a40": container}}
+	host := &containerPump{
+		container:  &docker.Container{ID: "host-node1", Name: "/node1", Config: &docker.Config{}},
+		logstreams: map[chan *Message]*Route{},
+		ctx:        context.Background(),
+	}
+	cp := &containerPump{container: container, logstreams: map[chan *Message]*Route{}, ctx: context.Background()}
+	p := &LogsPump{pumps: map[string]*containerPump{"8dfafdbc3a40": cp}, host: host}
+	logstream := make(chan *Message, 1)
+	die := &docker.APIEvents{ID: "8dfafdbc3a40", Status: "die", TimeNano: time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC).UnixNano(),
+		Actor: docker.APIActor{Attributes: map[string]string{"exitCode": "137"}}}
+	expected := `{"time":"2020-11-02T10:00:00Z","event":"die","exit_code":137,"container_id":"8dfafdbc3a40","container_name":"web","image":"nginx"}`
+
+	for _, mode := range []string{LifecycleContainer, LifecycleHost} {
+		os.Setenv("LIFECYCLE_EVENTS", mode)
+		pump := map[string]*containerPump{LifecycleContainer: cp, LifecycleHost: host}[mode]
+		pump.add(logstream, &Route{})
+		p.shipLifecycle(die)
+		p.shipLifecycle(&docker.APIEvents{ID: "8dfafdbc3a40", Status: "kill"}) // not shipped
+		pump.remove(logstream)
+		select {
+		case msg := <-logstream:
+			if msg.Source != LifecycleSource || msg.Data != expected || msg.Container != pump.container {
+				t.Errorf("%s: expected the event in the logs of %s, got %+v", mode, pump.container.Name, msg)
+			}
+		default:
+			t.Errorf("%s: expected the event to be shipped", mode)
+		}
+	}
+	os.Unsetenv("LIFECYCLE_EVENTS")
+	p.shipLifecycle(die)
+	if len(logstream) != 0 {
+		t.Error("expected no events to be shipped unless enabled")
+	}
+}
@@ -328,3 +328,45 @@ func (ep *logRouterExt) Names() []string {
 	}
 	return names
 }
+
+// ErrorClassifier
+
+var ErrorClassifiers = &errorClassifierExt{
+	newExtensionPoint(new(ErrorClassifier)),
+}
+
+type errorClassifierExt struct {
+	*extensionPoint
+}
+
+func (ep *errorClassifierExt) Unregister(name string) bool {
+	return ep.unregister(name)
+}
+
+func (ep *errorClassifierExt) Register(component ErrorClassifier, name string) bool {
+	return ep.register(component, name)
+}
+
+func (ep *errorClassifierExt) Lookup(name string) (ErrorClassifier, bool) {
+	ext, ok := ep.lookup(name)
+	if !ok {
+		return nil, ok
+	}
+	return ext.(ErrorClassifier), ok
+}
+
+func (ep *errorClassifierExt) All() map[string]ErrorClassifier {
+	all := make(map[string]ErrorClassifier)
+	for k, v := range ep.all() {
+		all[k] = v.(ErrorClassifier)
+	}
+	return all
+}
+
+func (ep *errorClassifierExt) Names() []string {
+	var names []string
+	for k := range ep.all() {
+		names = append(names, k)
+	}
+	return names
+}
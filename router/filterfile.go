@@ -0,0 +1,194 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func init() {
+	Jobs.Register(containerFilter, "container-filter")
+	cfg.Register(
+		cfg.Option{Name: "CONTAINER_FILTER_FILE",
+			Description: "file of allow and deny rules of container names and labels, read again when it changes"},
+		cfg.Option{Name: "CONTAINER_FILTER_INTERVAL", Type: cfg.Duration, Default: "5s",
+			Description: "how often the CONTAINER_FILTER_FILE is checked for changes"},
+	)
+}
+
+// filterRule allows or denies the containers whose name, or the value of a
+// label, matches a glob pattern
+type filterRule struct {
+	allow   bool
+	label   string // "" to match the name
+	pattern string
+}
+
+func (r *filterRule) matches(name string, labels map[string]string) bool {
+	text := name
+	if r.label != "" {
+		value, ok := labels[r.label]
+		if !ok {
+			return false
+		}
+		text = value
+	}
+	matched, _ := path.Match(r.pattern, text)
+	return matched
+}
+
+// filterRules are the rules of a CONTAINER_FILTER_FILE, the first matching
+// a container deciding if its logs are shipped. Containers no rule matches
+// are shipped unless there are allow rules.
+type filterRules struct {
+	rules    []filterRule
+	allowing bool // whether any rule allows
+}
+
+func (f *filterRules) allows(name string, labels map[string]string) bool {
+	for i := range f.rules {
+		if f.rules[i].matches(name, labels) {
+			return f.rules[i].allow
+		}
+	}
+	return !f.allowing
+}
+
+// parseFilterRules parses rules, a line each, like:
+//
+//	deny name:noisy-*
+//	deny label:com.example.team=batch
+//	allow label:logging=*
+//
+// Blank lines and those starting with # are skipped.
+func parseFilterRules(text string) (*filterRules, error) {
+	f := &filterRules{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || (fields[0] != "allow" && fields[0] != "deny") {
+			return nil, fmt.Errorf("line %d: rules must be allow or deny, then name:pattern or label:key=pattern", n)
+		}
+		rule := filterRule{allow: fields[0] == "allow"}
+		switch kind, pattern := splitRule(fields[1]); kind {
+		case "name":
+			rule.pattern = pattern
+		case "label":
+			parts := strings.SplitN(pattern, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("line %d: label rules must be label:key=pattern", n)
+			}
+			rule.label, rule.pattern = parts[0], parts[1]
+		default:
+			return nil, fmt.Errorf("line %d: rules match a name: or a label:", n)
+		}
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		f.rules = append(f.rules, rule)
+		f.allowing = f.allowing || rule.allow
+	}
+	return f, scanner.Err()
+}
+
+func splitRule(text string) (kind, pattern string) {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// Filtered returns whether the rules of the CONTAINER_FILTER_FILE deny
+// shipping the logs of a container
+func Filtered(container *docker.Container) bool {
+	f, _ := containerFilter.rules.Load().(*filterRules)
+	if f == nil {
+		return false
+	}
+	return !f.allows(normalName(container.Name), container.Config.Labels)
+}
+
+var containerFilter = &filterFile{}
+
+// filterFile is the job reading the CONTAINER_FILTER_FILE again when it
+// changes. The rules read last are kept while it is invalid.
+type filterFile struct {
+	path     string
+	interval time.Duration
+	modified time.Time
+	size     int64
+	rules    atomic.Value // *filterRules
+}
+
+func (f *filterFile) Name() string {
+	if f.path == "" {
+		return ""
+	}
+	return "container-filter"
+}
+
+func (f *filterFile) Setup() error {
+	f.path = cfg.GetString("CONTAINER_FILTER_FILE")
+	f.interval = cfg.GetDuration("CONTAINER_FILTER_INTERVAL")
+	if f.path == "" {
+		return nil
+	}
+	_, err := f.reload()
+	return err
+}
+
+func (f *filterFile) Run() error {
+	if f.path == "" || f.interval == 0 {
+		select {}
+	}
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, err := f.reload()
+		switch {
+		case err != nil:
+			log.Println(err, "(keeping the rules read before)")
+		case reloaded:
+			log.Println("container-filter: reloaded", f.path)
+		}
+	}
+	return nil
+}
+
+// reload reads the file if it changed since it was last read, and returns
+// whether it did
+func (f *filterFile) reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, fmt.Errorf("container-filter: %s", err)
+	}
+	if info.ModTime().Equal(f.modified) && info.Size() == f.size {
+		return false, nil
+	}
+	f.modified, f.size = info.ModTime(), info.Size()
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return false, fmt.Errorf("container-filter: %s", err)
+	}
+	rules, err := parseFilterRules(string(data))
+	if err != nil {
+		return false, fmt.Errorf("container-filter: %s: %s", f.path, err)
+	}
+	f.rules.Store(rules)
+	return true, nil
+}
@@ -0,0 +1,91 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestParseFilterRules(t *testing.T) {
+	f, err := parseFilterRules(`
+# comment
+deny name:noisy-*
+deny label:team=batch
+allow label:env=prod*
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name   string
+		labels map[string]string
+		allows bool
+	}{
+		{"noisy-worker", map[string]string{"env": "prod"}, false},
+		{"api", map[string]string{"env": "prod-eu", "team": "batch"}, false},
+		{"api", map[string]string{"env": "prod-eu"}, true},
+		{"api", map[string]string{"env": "staging"}, false},
+		{"api", nil, false},
+	} {
+		if allows := f.allows(test.name, test.labels); allows != test.allows {
+			t.Errorf("%s %v: expected allowed %v", test.name, test.labels, test.allows)
+		}
+	}
+
+	f, err = parseFilterRules("deny name:noisy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.allows("api", nil) || f.allows("noisy", nil) {
+		t.Error("expected only the denied container to be filtered without allow rules")
+	}
+
+	for _, bad := range []string{"drop name:x", "deny image:x", "deny label:x", "deny name:[", "deny"} {
+		if _, err := parseFilterRules(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
+
+func TestFilterFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter")
+	if err := ioutil.WriteFile(path, []byte("deny name:noisy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CONTAINER_FILTER_FILE", path)
+	defer os.Unsetenv("CONTAINER_FILTER_FILE")
+	defer func(previous *filterFile) { containerFilter = previous }(containerFilter)
+	containerFilter = &filterFile{}
+	if err := containerFilter.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	noisy := &docker.Container{Name: "/noisy", Config: &docker.Config{}}
+	if !Filtered(noisy) {
+		t.Fatal("expected the container to be filtered")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("deny name:[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second)) //nolint:errcheck
+	if _, err := containerFilter.reload(); err == nil || !Filtered(noisy) {
+		t.Fatal("expected an invalid file to keep the rules read before")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("deny name:other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)) //nolint:errcheck
+	if reloaded, err := containerFilter.reload(); err != nil || !reloaded || Filtered(noisy) {
+		t.Fatalf("expected the edited rules to be used, got %v, %v", reloaded, err)
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func init() {
+	HTTPHandlers.Register(FlushAPI, "flush")
+}
+
+// FlushAPI serves POST /flush?container=<id> and POST /flush?stream=<name>,
+// which have the adapters of routes submit the lines they hold of a
+// container or a stream right away, rather than when their batches are due,
+// to tell whether lines are missing or just not shipped yet. It responds
+// with the IDs of the routes told to, and of those too busy to, or with 503
+// if all were.
+func FlushAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		container, stream := r.URL.Query().Get("container"), r.URL.Query().Get("stream")
+		if (container == "") == (stream == "") {
+			http.Error(w, "Bad request: either container or stream must be set", http.StatusBadRequest)
+			return
+		}
+		selector := "container=" + container
+		if stream != "" {
+			selector = "stream=" + stream
+		}
+		routes, _ := Routes.GetAll()
+		flushed, busy := flush(routes, container, stream)
+		entry := AuditEntry{Action: "flush", Selector: selector}
+		if len(flushed) == 0 && len(busy) > 0 {
+			entry.Error = "busy"
+		}
+		Audit(r, entry)
+		switch {
+		case entry.Error != "":
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "the routes are busy with other flushes, try again", http.StatusServiceUnavailable)
+			return
+		case len(flushed) == 0:
+			http.Error(w, "no route can flush the lines of a container or stream", http.StatusNotFound)
+			return
+		}
+		response := map[string][]string{"routes": flushed}
+		if len(busy) > 0 {
+			response["busy"] = busy
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+	})
+	return mux
+}
+
+// flush tells the adapters of routes able to to submit the lines they hold
+// of a container or a stream, and returns the IDs of the routes that took
+// it and of those too busy to
+func flush(routes []*Route, container, stream string) (flushed, busy []string) {
+	flushed = []string{}
+	for _, route := range routes {
+		if flusher, ok := route.adapter.(ContainerFlusher); ok && container != "" {
+			flusher.FlushContainer(normalID(container))
+			flushed = append(flushed, route.ID)
+		} else if flusher, ok := route.adapter.(StreamFlusher); ok && stream != "" {
+			if flusher.FlushStream(stream) {
+				flushed = append(flushed, route.ID)
+			} else {
+				busy = append(busy, route.ID)
+			}
+		}
+	}
+	return flushed, busy
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// streamFlushingAdapter records the streams it was told to flush, unless
+// busy
+type streamFlushingAdapter struct {
+	flushed []string
+	busy    bool
+}
+
+func (a *streamFlushingAdapter) Stream(logstream chan *Message) {}
+
+func (a *streamFlushingAdapter) FlushStream(name string) bool {
+	if !a.busy {
+		a.flushed = append(a.flushed, name)
+	}
+	return !a.busy
+}
+
+func TestFlushAPI(t *testing.T) {
+	containers, streams, busy := &flushingAdapter{}, &streamFlushingAdapter{}, &streamFlushingAdapter{busy: true}
+	Routes.Lock()
+	previous := Routes.routes
+	Routes.routes = map[string]*Route{
+		"containers": {ID: "containers", adapter: containers},
+		"streams":    {ID: "streams", adapter: streams},
+		"busy":       {ID: "busy", adapter: busy},
+		"dummy":      {ID: "dummy", adapter: &DummyAdapter{}},
+	}
+	Routes.Unlock()
+	defer func() {
+		Routes.Lock()
+		Routes.routes = previous
+		Routes.Unlock()
+	}()
+	handler := FlushAPI()
+	for _, test := range []struct {
+		method, url string
+		status      int
+	}{
+		{"GET", "/flush?container=abc", http.StatusMethodNotAllowed},
+		{"POST", "/flush", http.StatusBadRequest},
+		{"POST", "/flush?container=abc&stream=web", http.StatusBadRequest},
+		{"POST", "/flush?container=8dfafdbc3a40abcdef", http.StatusAccepted},
+		{"POST", "/flush?stream=web", http.StatusAccepted},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.url, test.status, w.Code)
+		}
+	}
+	if len(containers.took) != 1 || containers.took[0] != "flush 8dfafdbc3a40" {
+		t.Errorf("expected the container to be flushed, got %v", containers.took)
+	}
+	if len(streams.flushed) != 1 || streams.flushed[0] != "web" {
+		t.Errorf("expected the stream to be flushed, got %v", streams.flushed)
+	}
+}
+
+func TestFlushReportsBusyRoutes(t *testing.T) {
+	streams, busy := &streamFlushingAdapter{}, &streamFlushingAdapter{busy: true}
+	routes := []*Route{{ID: "streams", adapter: streams}, {ID: "busy", adapter: busy}}
+	flushed, pending := flush(routes, "", "web")
+	if !reflect.DeepEqual(flushed, []string{"streams"}) || !reflect.DeepEqual(pending, []string{"busy"}) {
+		t.Errorf("expected streams to be flushed and busy to be busy, got %v and %v", flushed, pending)
+	}
+
+	Routes.Lock()
+	previous := Routes.routes
+	Routes.routes = map[string]*Route{"busy": {ID: "busy", adapter: busy}}
+	Routes.Unlock()
+	defer func() {
+		Routes.Lock()
+		Routes.routes = previous
+		Routes.Unlock()
+	}()
+	w := httptest.NewRecorder()
+	FlushAPI().ServeHTTP(w, httptest.NewRequest("POST", "/flush?stream=web", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After when all routes are busy, got %d", w.Code)
+	}
+}
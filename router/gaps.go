@@ -0,0 +1,137 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// GapMarkerPrefix starts the lines shipped in place of lines a route
+// dropped, for consumers to tell a gap in the logs from silence
+const GapMarkerPrefix = "logspout: gap in the logs"
+
+// gapMarkers returns whether GAP_MARKERS is set
+func gapMarkers() bool {
+	return cfg.GetBool("GAP_MARKERS")
+}
+
+// gap is the lines of a stream of a container that a route dropped
+type gap struct {
+	from, to time.Time // of the lines dropped
+	seq      uint64    // of the last line dropped
+	lost     int
+	reasons  []string
+}
+
+// marker returns the line shipped before the next line of the stream, eg:
+// logspout: gap in the logs of 2.5s, 120 lines dropped: buffer full
+func (g *gap) marker(next *Message) *Message {
+	return &Message{
+		Container: next.Container,
+		Source:    next.Source,
+		Data: fmt.Sprintf("%s of %s, %d lines dropped: %s",
+			GapMarkerPrefix, g.to.Sub(g.from).Round(time.Millisecond), g.lost, strings.Join(g.reasons, ", ")),
+		Time: g.to,
+	}
+}
+
+// follows returns whether a line was read after the lines of a gap. The
+// lines read before may still be on their way, as a buffer or an adapter
+// dropped lines queued after them.
+func (g *gap) follows(msg *Message) bool {
+	return msg.Seq > g.seq || msg.Time.After(g.to) // Seq starts again as a container restarts
+}
+
+// gapKey identifies a stream of a container
+type gapKey struct {
+	container string
+	source    string
+}
+
+func streamOf(msg *Message) gapKey {
+	if msg.Container == nil {
+		return gapKey{source: msg.Source}
+	}
+	return gapKey{container: msg.Container.ID, source: msg.Source}
+}
+
+var gaps = &gapRegistry{routes: map[string]map[gapKey]*gap{}}
+
+// gapRegistry holds the gaps of each stream of the routes marking them,
+// indexed by route ID
+type gapRegistry struct {
+	mu     sync.Mutex
+	routes map[string]map[gapKey]*gap
+}
+
+// record adds lines a route dropped to the gaps of their streams. Markers
+// are not, dropping a marker only loses the count it holds.
+func (r *gapRegistry) record(routeID, reason string, msgs []*Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	streams, marking := r.routes[routeID]
+	if !marking {
+		return
+	}
+	for _, msg := range msgs {
+		if strings.HasPrefix(msg.Data, GapMarkerPrefix) {
+			continue
+		}
+		key := streamOf(msg)
+		g, exists := streams[key]
+		if !exists {
+			g = &gap{from: msg.Time, to: msg.Time}
+			streams[key] = g
+		}
+		if msg.Time.Before(g.from) {
+			g.from = msg.Time
+		}
+		if msg.Time.After(g.to) {
+			g.to = msg.Time
+		}
+		if msg.Seq > g.seq {
+			g.seq = msg.Seq
+		}
+		g.lost++
+		if len(g.reasons) == 0 || g.reasons[len(g.reasons)-1] != reason {
+			g.reasons = append(g.reasons, reason)
+		}
+	}
+}
+
+// take returns and removes the gap of the stream of a line read after it
+func (r *gapRegistry) take(routeID string, msg *Message) *gap {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := streamOf(msg)
+	g, exists := r.routes[routeID][key]
+	if !exists || !g.follows(msg) {
+		return nil
+	}
+	delete(r.routes[routeID], key)
+	return g
+}
+
+// markGaps sends the messages of a route on to its adapter, with a gap
+// marker before the first line of a stream after lines of the stream the
+// route dropped, until in is closed
+func markGaps(routeID string, in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	gaps.mu.Lock()
+	gaps.routes[routeID] = map[gapKey]*gap{}
+	gaps.mu.Unlock()
+	defer func() {
+		gaps.mu.Lock()
+		delete(gaps.routes, routeID)
+		gaps.mu.Unlock()
+	}()
+	for msg := range in {
+		if g := gaps.take(routeID, msg); g != nil {
+			out <- g.marker(msg)
+		}
+		out <- msg
+	}
+}
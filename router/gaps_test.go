@@ -0,0 +1,49 @@
+package router
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestMarkGaps(t *testing.T) {
+	os.Setenv("GAP_MARKERS", "true")
+	defer os.Unsetenv("GAP_MARKERS")
+	container := &docker.Container{ID: "abc123", Name: "/api"}
+	start := time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)
+	line := func(source string, seq int) *Message {
+		return &Message{Container: container, Source: source, Data: "line", Seq: uint64(seq),
+			Time: start.Add(time.Duration(seq) * time.Second)}
+	}
+	in, out := make(chan *Message), make(chan *Message, 10)
+	go markGaps("route", in, out)
+	in <- line("stdout", 1)
+	<-out // the route is marking
+
+	WriteDeadLetters("route", "buffer full", line("stdout", 3), line("stdout", 4))
+	WriteDeadLetters("route", "failed 3 times", line("stdout", 6))
+	in <- line("stderr", 7) // another stream
+	in <- line("stdout", 2) // read before the gap
+	in <- line("stdout", 7)
+	in <- line("stdout", 8)
+	close(in)
+
+	expected := []string{"line", "line",
+		"logspout: gap in the logs of 3s, 3 lines dropped: buffer full, failed 3 times", "line", "line"}
+	for i, data := range expected {
+		msg := <-out
+		if msg.Data != data {
+			t.Errorf("%d: expected %q, got %q", i, data, msg.Data)
+		}
+	}
+	if _, open := <-out; open {
+		t.Error("expected the output to be closed")
+	}
+	gaps.mu.Lock()
+	defer gaps.mu.Unlock()
+	if _, marking := gaps.routes["route"]; marking {
+		t.Error("expected the gaps of the route to be released")
+	}
+}
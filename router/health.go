@@ -0,0 +1,148 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Values of the health.starting option of routes, what is done with the
+// lines of containers whose Docker health status is starting
+const (
+	HealthShip     = "ship"     // shipped as usual
+	HealthDrop     = "drop"     // not shipped until the health check of the container passes or fails
+	HealthSeparate = "separate" // shipped apart, to a stream of their own by adapters naming streams
+)
+
+// healthStarting is the health status of containers with a HEALTHCHECK
+// until it first passes or fails
+const healthStarting = "starting"
+
+// healthEventPrefix is that of the status of the Docker events of health
+// status changes, like health_status: healthy
+const healthEventPrefix = "health_status: "
+
+// healths caches the Docker health status of containers, inspected once a
+// line of theirs is routed to a route with health.starting set, and kept
+// current by the events of Docker
+var healths = &healthCache{statuses: map[string]string{}}
+
+type healthCache struct {
+	mu       sync.Mutex
+	inspect  func(id string) string // returns the status of a container, set by the pump
+	statuses map[string]string      // by short ID, "" for containers without health checks
+}
+
+// Starting returns whether the Docker health status of a container is
+// starting, as that of containers whose HEALTHCHECK did not pass yet
+func Starting(container *docker.Container) bool {
+	return container != nil && healths.status(container.ID) == healthStarting
+}
+
+// status returns the health status of a container, inspecting it unless
+// cached
+func (h *healthCache) status(id string) string {
+	id = normalID(id)
+	h.mu.Lock()
+	status, cached := h.statuses[id]
+	inspect := h.inspect
+	h.mu.Unlock()
+	if cached || inspect == nil {
+		return status
+	}
+	status = inspect(id)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if current, changed := h.statuses[id]; changed { // by an event meanwhile
+		return current
+	}
+	h.statuses[id] = status
+	return status
+}
+
+// set caches the health status of a container told by an event
+func (h *healthCache) set(id, status string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.statuses[normalID(id)] = status
+}
+
+// forget removes the health status of a container, for it to be inspected
+// again once it starts: it is starting again if it has a health check
+func (h *healthCache) forget(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.statuses, normalID(id))
+}
+
+// healthEvent caches the status of a Docker event of a health status change,
+// and returns whether it was one
+func healthEvent(event *docker.APIEvents) bool {
+	if !strings.HasPrefix(event.Status, healthEventPrefix) {
+		return false
+	}
+	healths.set(event.ID, strings.TrimPrefix(event.Status, healthEventPrefix))
+	return true
+}
+
+// routeHealth returns the health.starting option of a route
+func routeHealth(route *Route) (string, error) {
+	switch value := route.Options["health.starting"]; value {
+	case "", HealthShip:
+		return HealthShip, nil
+	case HealthDrop, HealthSeparate:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid value for health.starting (must be %s, %s or %s): %s", HealthShip, HealthDrop, HealthSeparate, value)
+	}
+}
+
+// newHealthInspector returns the function inspecting the health status of
+// containers on the endpoint of a Docker client, which predates health
+// checks
+func newHealthInspector(client *docker.Client) func(id string) string {
+	endpoint, err := url.Parse(client.Endpoint())
+	if err != nil {
+		return nil
+	}
+	transport := &http.Transport{TLSClientConfig: client.TLSConfig}
+	base := "http://" + endpoint.Host
+	switch {
+	case endpoint.Scheme == "unix":
+		socket := endpoint.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		base = "http://docker"
+	case endpoint.Scheme == "https" || client.TLSConfig != nil:
+		base = "https://" + endpoint.Host
+	}
+	httpClient := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	return func(id string) string {
+		resp, err := httpClient.Get(base + "/containers/" + id + "/json")
+		if err != nil {
+			debug("health: inspecting", id, ":", err)
+			return ""
+		}
+		defer resp.Body.Close()
+		var inspected struct {
+			State struct {
+				Health *struct {
+					Status string
+				}
+			}
+		}
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&inspected) != nil || inspected.State.Health == nil {
+			return ""
+		}
+		return inspected.State.Health.Status
+	}
+}
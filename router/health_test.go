@@ -0,0 +1,56 @@
+package router
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestRouteDropsLinesOfStartingContainers(t *testing.T) {
+	inspected := 0
+	healths.mu.Lock()
+	healths.inspect = func(id string) string {
+		inspected++
+		return healthStarting
+	}
+	healths.mu.Unlock()
+	defer func() {
+		healths.mu.Lock()
+		healths.inspect, healths.statuses = nil, map[string]string{}
+		healths.mu.Unlock()
+	}()
+	health, err := routeHealth(&Route{Options: map[string]string{"health.starting": HealthDrop}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := &Route{health: health}
+	msg := &Message{Container: &docker.Container{ID: "abc123"}, Data: "warming up"}
+	if route.MatchMessage(msg) || route.MatchMessage(msg) {
+		t.Error("expected the lines of a starting container to be dropped")
+	}
+	if inspected != 1 {
+		t.Errorf("expected the container to be inspected once, got %d", inspected)
+	}
+	if !(&Route{}).MatchMessage(msg) {
+		t.Error("expected routes without health.starting to ship them")
+	}
+	healthEvent(&docker.APIEvents{ID: "abc123", Status: "health_status: healthy"})
+	if !route.MatchMessage(msg) {
+		t.Error("expected the lines of a healthy container to be shipped")
+	}
+	healths.forget("abc123") // restarted
+	if route.MatchMessage(msg) || inspected != 2 {
+		t.Errorf("expected a restarted container to be inspected again, got %d inspections", inspected)
+	}
+}
+
+func TestRouteHealthOption(t *testing.T) {
+	for value, expected := range map[string]string{"": HealthShip, "ship": HealthShip, "drop": HealthDrop, "separate": HealthSeparate} {
+		if health, err := routeHealth(&Route{Options: map[string]string{"health.starting": value}}); err != nil || health != expected {
+			t.Errorf("%q: expected %s, got %s, %v", value, expected, health, err)
+		}
+	}
+	if _, err := routeHealth(&Route{Options: map[string]string{"health.starting": "hide"}}); err == nil {
+		t.Error("expected an invalid value to be refused")
+	}
+}
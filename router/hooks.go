@@ -0,0 +1,129 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// Events of the pipeline hooks run on
+const (
+	EventRouteFailed     = "route.failed"     // a route dropped lines
+	EventCircuitOpen     = "circuit.open"     // a failover sink went down
+	EventCircuitClose    = "circuit.close"    // a failover sink recovered
+	EventContainerAttach = "container.attach" // the logs of a container are read
+	EventContainerDetach = "container.detach" // the container stopped
+)
+
+// hookOptions are the options of the command of each event
+var hookOptions = map[string]string{
+	EventRouteFailed:     "HOOK_ROUTE_FAILED",
+	EventCircuitOpen:     "HOOK_CIRCUIT_OPEN",
+	EventCircuitClose:    "HOOK_CIRCUIT_CLOSE",
+	EventContainerAttach: "HOOK_CONTAINER_ATTACH",
+	EventContainerDetach: "HOOK_CONTAINER_DETACH",
+}
+
+// maxRunningHooks is how many hooks run at once, the others wait for them
+const maxRunningHooks = 4
+
+// routeFailedInterval is how often the hook of a failing route runs at most
+const routeFailedInterval = time.Minute
+
+func init() {
+	for _, event := range []string{EventRouteFailed, EventCircuitOpen, EventCircuitClose,
+		EventContainerAttach, EventContainerDetach} {
+		cfg.Register(cfg.Option{Name: hookOptions[event],
+			Description: "shell command run on " + event + " events, with their context in LOGSPOUT_ variables"})
+	}
+	cfg.Register(cfg.Option{Name: "HOOK_TIMEOUT", Type: cfg.Duration, Default: "30s",
+		Description: "how long a hook may run before it is killed"})
+}
+
+var hooks = &hookRunner{running: make(chan struct{}, maxRunningHooks), failed: map[string]time.Time{}}
+
+// hookRunner runs the commands of events
+type hookRunner struct {
+	running chan struct{}
+
+	mu     sync.Mutex
+	failed map[string]time.Time // when the hook of each failing route last ran
+}
+
+// RunHook runs the command set for an event, if any, in the background,
+// with the event in LOGSPOUT_EVENT and each of vars in a LOGSPOUT_
+// variable, eg: ROUTE_ID in LOGSPOUT_ROUTE_ID
+func RunHook(event string, vars map[string]string) {
+	command := cfg.GetString(hookOptions[event])
+	if command == "" {
+		return
+	}
+	running := hooks.running
+	go func() {
+		running <- struct{}{}
+		defer func() { <-running }()
+		if err := runHook(command, event, vars); err != nil {
+			log.Printf("hooks: %s: %s\n", event, err)
+		}
+	}()
+}
+
+// routeFailed runs the hook of a route dropping lines, at most once every
+// routeFailedInterval for each route
+func routeFailed(routeID, reason string, dropped int) {
+	if cfg.GetString(hookOptions[EventRouteFailed]) == "" {
+		return
+	}
+	now := time.Now()
+	hooks.mu.Lock()
+	if now.Sub(hooks.failed[routeID]) < routeFailedInterval {
+		hooks.mu.Unlock()
+		return
+	}
+	hooks.failed[routeID] = now
+	hooks.mu.Unlock()
+	RunHook(EventRouteFailed, map[string]string{"ROUTE_ID": routeID, "REASON": reason, "DROPPED": fmt.Sprint(dropped)})
+}
+
+// runHook runs the command of an event with sh, until it exits or
+// HOOK_TIMEOUT passes
+func runHook(command, event string, vars map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GetDuration("HOOK_TIMEOUT"))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), "LOGSPOUT_EVENT="+event)
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, "LOGSPOUT_"+name+"="+vars[name])
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// containerHookVars returns the context of the events of a container
+func containerHookVars(container *docker.Container) map[string]string {
+	vars := map[string]string{"CONTAINER_ID": container.ID, "CONTAINER_NAME": normalName(container.Name)}
+	if container.Config != nil {
+		vars["CONTAINER_IMAGE"], vars["CONTAINER_HOSTNAME"] = container.Config.Image, container.Config.Hostname
+	}
+	return vars
+}
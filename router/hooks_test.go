@@ -0,0 +1,76 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	err = runHook(`echo "$LOGSPOUT_EVENT $LOGSPOUT_ROUTE_ID $LOGSPOUT_DROPPED" >> `+out, EventRouteFailed,
+		map[string]string{"ROUTE_ID": "abc", "DROPPED": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "route.failed abc 3\n" {
+		t.Errorf("unexpected output %q", data)
+	}
+
+	if err := runHook("echo broken; exit 2", EventCircuitOpen, nil); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected an error with the output of the command, got %v", err)
+	}
+	os.Setenv("HOOK_TIMEOUT", "10ms")
+	defer os.Unsetenv("HOOK_TIMEOUT")
+	if err := runHook("exec sleep 5", EventCircuitOpen, nil); err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("expected the command to time out, got %v", err)
+	}
+}
+
+func TestRouteFailedHookIsThrottled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	os.Setenv("HOOK_ROUTE_FAILED", `echo "$LOGSPOUT_ROUTE_ID" >> `+out)
+	defer os.Unsetenv("HOOK_ROUTE_FAILED")
+	defer func(previous *hookRunner) { hooks = previous }(hooks)
+	hooks = &hookRunner{running: make(chan struct{}, 1), failed: map[string]time.Time{}}
+
+	routeFailed("first", "buffer full", 1)
+	routeFailed("first", "buffer full", 1)
+	routeFailed("second", "buffer full", 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(out)
+		if lines := strings.Fields(string(data)); len(lines) == 2 {
+			if !(lines[0] == "first" && lines[1] == "second") && !(lines[0] == "second" && lines[1] == "first") {
+				t.Errorf("unexpected hooks run %v", lines)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a hook for each route, got %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // for a third hook to show
+	if data, _ := ioutil.ReadFile(out); len(strings.Fields(string(data))) != 2 {
+		t.Errorf("expected the repeated failure not to run the hook, got %q", data)
+	}
+}
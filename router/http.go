@@ -2,16 +2,19 @@ package router
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
+
 	"github.com/gliderlabs/logspout/cfg"
 )
 
 func init() {
-	bindAddress := cfg.GetEnvDefault("HTTP_BIND_ADDRESS", "0.0.0.0")
-	port := cfg.GetEnvDefault("PORT", cfg.GetEnvDefault("HTTP_PORT", "80"))
-	Jobs.Register(&httpService{bindAddress, port}, "http")
+	Jobs.Register(&httpService{}, "http")
 }
 
 type httpService struct {
@@ -25,8 +28,17 @@ func (s *httpService) Name() string {
 }
 
 func (s *httpService) Setup() error {
+	s.bindAddress = cfg.GetString("HTTP_BIND_ADDRESS")
+	s.port = cfg.GetString("PORT")
+	if s.port == "" {
+		s.port = cfg.GetString("HTTP_PORT")
+	}
+	tokens, err := parseTokens(cfg.GetString("API_TOKENS"))
+	if err != nil {
+		return err
+	}
 	for name, handler := range HTTPHandlers.All() {
-		h := handler()
+		h := authorize(name, tokens, handler())
 		http.Handle("/"+name, h)
 		http.Handle("/"+name+"/", h)
 	}
@@ -34,5 +46,13 @@ func (s *httpService) Setup() error {
 }
 
 func (s *httpService) Run() error {
-	return http.ListenAndServe(s.bindAddress+":"+s.port, nil)
+	listener, err := net.Listen("tcp", s.bindAddress+":"+s.port)
+	if err != nil {
+		return err
+	}
+	if max := cfg.GetInt("HTTP_MAX_CONNECTIONS"); max > 0 {
+		listener = netutil.LimitListener(listener, max)
+	}
+	// HTTP/2 without TLS too, for gRPC
+	return http.Serve(listener, h2c.NewHandler(limit(http.DefaultServeMux), &http2.Server{}))
 }
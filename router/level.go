@@ -0,0 +1,84 @@
+package router
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Level is the severity of a log line, as detected from its content
+type Level string
+
+// Levels in order of decreasing severity, named like syslog severities
+const (
+	LevelUnknown   Level = ""
+	LevelEmergency Level = "emerg"
+	LevelAlert     Level = "alert"
+	LevelCritical  Level = "crit"
+	LevelError     Level = "error"
+	LevelWarning   Level = "warning"
+	LevelNotice    Level = "notice"
+	LevelInfo      Level = "info"
+	LevelDebug     Level = "debug"
+)
+
+// the leading bytes of a line that are searched for a bare level word,
+// which is long enough to skip a timestamp and logger name
+const levelPrefixLen = 64
+
+var (
+	// level fields of structured lines, eg: {"level":"warn"}, level=warn
+	levelFieldRegexp = regexp.MustCompile(`(?i)"?\b(?:level|lvl|severity|loglevel)"?\s*[:=]\s*"?([a-z]+)`)
+	// level words near the start of unstructured lines, eg: [WARN] or WARNING:
+	levelWordRegexp = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(emerg|emergency|alert|crit|critical|fatal|panic|err|error|warn|warning|notice|info|debug|trace)(?:$|[^a-z0-9])`)
+)
+
+var levelNames = map[string]Level{
+	"emerg":     LevelEmergency,
+	"emergency": LevelEmergency,
+	"panic":     LevelEmergency,
+	"alert":     LevelAlert,
+	"crit":      LevelCritical,
+	"critical":  LevelCritical,
+	"fatal":     LevelCritical,
+	"err":       LevelError,
+	"error":     LevelError,
+	"warn":      LevelWarning,
+	"warning":   LevelWarning,
+	"notice":    LevelNotice,
+	"info":      LevelInfo,
+	"debug":     LevelDebug,
+	"trace":     LevelDebug,
+}
+
+// ParseLevel returns the Level of a level name like "WARN" or "fatal"
+func ParseLevel(name string) Level {
+	return levelNames[strings.ToLower(name)]
+}
+
+// DetectLevel returns the level of a log line, from a level field of a
+// JSON or key=value line, or else from the first level word near the start
+// of the line. It returns LevelUnknown if no level was found.
+func DetectLevel(data string) Level {
+	if match := levelFieldRegexp.FindStringSubmatch(data); match != nil {
+		if level := ParseLevel(match[1]); level != LevelUnknown {
+			return level
+		}
+	}
+	prefix := data
+	if len(prefix) > levelPrefixLen {
+		prefix = prefix[:levelPrefixLen]
+	}
+	if match := levelWordRegexp.FindStringSubmatch(prefix); match != nil {
+		return ParseLevel(match[1])
+	}
+	return LevelUnknown
+}
+
+// Severe returns true for levels of error and above
+func (l Level) Severe() bool {
+	switch l {
+	case LevelEmergency, LevelAlert, LevelCritical, LevelError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package router
+
+import "testing"
+
+func TestDetectLevel(t *testing.T) {
+	for data, level := range map[string]Level{
+		"ERROR failed to connect":                                               LevelError,
+		"2020-06-01 12:00:00,950 INFO success: no errors":                       LevelInfo,
+		"[warn] disk almost full":                                               LevelWarning,
+		`{"msg":"retrying","level":"debug"}`:                                    LevelDebug,
+		`time=2020-06-01T12:00:00Z level=fatal msg="out of memory"`:             LevelCritical,
+		"panic: runtime error: invalid memory address":                          LevelEmergency,
+		"GET /index.html 200":                                                   LevelUnknown,
+		"information about the interrupted download":                            LevelUnknown,
+		"a long line without a level near the start that does mention an error": LevelUnknown,
+	} {
+		if got := DetectLevel(data); got != level {
+			t.Errorf("expected level %q for %q, got %q", level, data, got)
+		}
+	}
+}
+
+func TestLevelSevere(t *testing.T) {
+	if !LevelCritical.Severe() || !LevelError.Severe() {
+		t.Error("expected crit and error to be severe")
+	}
+	if LevelWarning.Severe() || LevelUnknown.Severe() {
+		t.Error("expected warning and unknown not to be severe")
+	}
+}
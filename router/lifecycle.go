@@ -0,0 +1,42 @@
+package router
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// goroutines counts the running goroutines started with Go, by kind
+var goroutines = struct {
+	sync.Mutex
+	counts map[string]*int64
+}{counts: map[string]*int64{}}
+
+// Go runs f in a goroutine counted by its kind, eg: pump.read, so the
+// goroutines of containers that are gone show up in the metrics if they
+// are not stopped
+func Go(kind string, f func()) {
+	goroutines.Lock()
+	count, exists := goroutines.counts[kind]
+	if !exists {
+		count = new(int64)
+		goroutines.counts[kind] = count
+	}
+	goroutines.Unlock()
+	atomic.AddInt64(count, 1)
+	go func() {
+		defer atomic.AddInt64(count, -1)
+		f()
+	}()
+}
+
+// Goroutines returns the number of running goroutines started with Go, by
+// kind
+func Goroutines() map[string]int64 {
+	goroutines.Lock()
+	defer goroutines.Unlock()
+	counts := make(map[string]int64, len(goroutines.counts))
+	for kind, count := range goroutines.counts {
+		counts[kind] = atomic.LoadInt64(count)
+	}
+	return counts
+}
@@ -0,0 +1,98 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// clientIdle is how long a client makes no request before its rate limiter
+// is forgotten
+const clientIdle = 5 * time.Minute
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "HTTP_RATE_LIMIT", Type: cfg.Int, Default: "0", Validate: cfg.NotNegative,
+			Description: "requests per second each client address may make to the HTTP API, 0 for unlimited"},
+		cfg.Option{Name: "HTTP_MAX_CONNECTIONS", Type: cfg.Int, Default: "0", Validate: cfg.NotNegative,
+			Description: "connections the HTTP API accepts at once, further ones wait, 0 for unlimited"},
+		cfg.Option{Name: "HTTP_MAX_BODY_SIZE", Type: cfg.Int, Default: "1048576", Validate: cfg.NotNegative,
+			Description: "bytes the body of a request to the HTTP API may have, 0 for unlimited"},
+	)
+}
+
+// clientLimiter limits the rate of requests of each client address, and the
+// size of their bodies
+type clientLimiter struct {
+	rate    int
+	maxBody int64
+	next    http.Handler
+
+	mu      sync.Mutex
+	clients map[string]*rateLimiter
+	pruned  time.Time
+}
+
+// limit wraps a handler with the HTTP_RATE_LIMIT and HTTP_MAX_BODY_SIZE
+func limit(next http.Handler) http.Handler {
+	rate, maxBody := cfg.GetInt("HTTP_RATE_LIMIT"), cfg.GetInt("HTTP_MAX_BODY_SIZE")
+	if rate == 0 && maxBody == 0 {
+		return next
+	}
+	return &clientLimiter{rate: rate, maxBody: int64(maxBody), next: next, clients: map[string]*rateLimiter{}, pruned: time.Now()}
+}
+
+func (l *clientLimiter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if l.rate > 0 && !l.allow(clientOf(req)) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	// gRPC streams may last for long, their messages are limited instead
+	if l.maxBody > 0 && !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		if req.ContentLength > l.maxBody {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		req.Body = http.MaxBytesReader(w, req.Body, l.maxBody)
+	}
+	l.next.ServeHTTP(w, req)
+}
+
+// allow returns whether a client may make a request now, forgetting the
+// clients idle for a while
+func (l *clientLimiter) allow(client string) bool {
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.pruned) > clientIdle {
+		for address, limiter := range l.clients {
+			limiter.mu.Lock()
+			if now.Sub(limiter.last) > clientIdle {
+				delete(l.clients, address)
+			}
+			limiter.mu.Unlock()
+		}
+		l.pruned = now
+	}
+	limiter := l.clients[client]
+	if limiter == nil {
+		limiter = newRateLimiter(float64(l.rate))
+		l.clients[client] = limiter
+	}
+	l.mu.Unlock()
+	return limiter.allow()
+}
+
+// clientOf returns the address a request came from, without its port. The
+// X-Forwarded-For header is not trusted, as clients can set it.
+func clientOf(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientLimiter(t *testing.T) {
+	var read int
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		read = len(data)
+	})
+	l := &clientLimiter{rate: 2, maxBody: 8, next: next, clients: map[string]*rateLimiter{}}
+
+	serve := func(remote, body string, length int64) int {
+		req := httptest.NewRequest("POST", "/routes", strings.NewReader(body))
+		req.RemoteAddr = remote
+		req.ContentLength = length
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := serve("10.0.0.1:1000", "small", 5); code != http.StatusOK || read != 5 {
+		t.Fatalf("expected a small body to be read, got %d", code)
+	}
+	if code := serve("10.0.0.1:1001", "far too large", 13); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a declared large body to be refused, got %d", code)
+	}
+	if code := serve("10.0.0.2:1000", "far too large", -1); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected an undeclared large body to be cut, got %d", code)
+	}
+	// the first client made 2 requests from different ports, its burst
+	if code := serve("10.0.0.1:1002", "", 0); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the client to be limited, got %d", code)
+	}
+	if code := serve("10.0.0.3:1000", "", 0); code != http.StatusOK {
+		t.Fatalf("expected another client not to be limited, got %d", code)
+	}
+}
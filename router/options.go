@@ -0,0 +1,90 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+var validDropPolicy = cfg.OneOf(DropPolicyBlock, DropPolicyNewest, DropPolicyOldest, DropPolicyPause)
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "DEBUG", Description: "emit debug logs when set to any value"},
+		cfg.Option{Name: "ALLOW_TTY", Type: cfg.Bool, Default: "false",
+			Description: "include logs from containers started with -t or --tty"},
+		cfg.Option{Name: "BACKLOG", Type: cfg.Bool, Default: "true",
+			Description: "set to false to suppress the tail backlog of started containers"},
+		cfg.Option{Name: "TAIL", Default: "all", Validate: validateTail,
+			Description: "number of lines of the log tail to read when logspout starts, or all"},
+		cfg.Option{Name: "EXCLUDE_LABELS",
+			Description: "labels of ignored containers, as label or label:value separated by ;"},
+		cfg.Option{Name: "EXCLUDE_LABEL",
+			Description: "label of ignored containers, unless EXCLUDE_LABELS is set"},
+		cfg.Option{Name: "INACTIVITY_TIMEOUT", Type: cfg.Duration, Default: "0",
+			Description: "restart Docker log streams inactive for this long, 0 to never"},
+		cfg.Option{Name: "CATCHUP", Type: cfg.Duration, Default: "0",
+			Description: "how far back to read containers without a checkpoint when logspout starts"},
+		cfg.Option{Name: "CHECKPOINT_FILE",
+			Description: "file recording when each container was last read, to catch up after a restart"},
+		cfg.Option{Name: "BUFFER_SIZE", Type: cfg.Int, Default: "0", Validate: cfg.NotNegative,
+			Description: "number of lines each route may buffer for a slow destination"},
+		cfg.Option{Name: "DROP_POLICY", Default: DropPolicyBlock,
+			Validate: validDropPolicy, Description: "what a route does when its buffer is full"},
+		cfg.Option{Name: "GAP_MARKERS", Type: cfg.Bool, Default: "false",
+			Description: "ship a line marking where a route dropped lines of a container, before its next line"},
+		cfg.Option{Name: "PUMP_QUEUE_SIZE", Type: cfg.Int, Default: "1024", Validate: validateQueueSize,
+			Description: "number of lines read ahead of the routes for each stream of a container"},
+		cfg.Option{Name: "TRANSFORM_QUEUE_SIZE", Type: cfg.Int, Default: "100", Validate: validateQueueSize,
+			Description: "number of lines adapters like multiline queue for their sub-adapter"},
+		cfg.Option{Name: "ROUTE_URIS", Description: "comma separated route URIs, unless given as the argument"},
+		cfg.Option{Name: "LOGSPOUT_CONTAINER",
+			Description: "ID or name of the container logspout runs in, to read labels from, instead of the hostname"},
+		cfg.Option{Name: "ROUTESPATH", Default: "/mnt/routes", Description: "directory routes are persisted in, if it exists"},
+		cfg.Option{Name: "ROUTES_FILE", Description: "file routes are persisted in, instead of the ROUTESPATH"},
+		cfg.Option{Name: "HTTP_BIND_ADDRESS", Default: "0.0.0.0", Description: "interface address to listen on"},
+		cfg.Option{Name: "HTTP_PORT", Default: "80", Description: "port to listen on"},
+		cfg.Option{Name: "PORT", Description: "port to listen on, instead of HTTP_PORT"},
+	)
+	for _, class := range qosClasses[1:] {
+		cfg.Register(
+			cfg.Option{Name: qosEnvName("buffer_size", class), Validate: validateBufferSize,
+				Description: "BUFFER_SIZE of routes for containers of the " + class + " class"},
+			cfg.Option{Name: qosEnvName("drop_policy", class), Validate: validDropPolicy,
+				Description: "DROP_POLICY of routes for containers of the " + class + " class"},
+		)
+	}
+}
+
+func validateBufferSize(value string) error {
+	if size, err := strconv.Atoi(value); err != nil || size < 0 {
+		return errors.New("must be a number of messages")
+	}
+	return nil
+}
+
+func validateQueueSize(value string) error {
+	if size, err := strconv.Atoi(value); err != nil || size < 1 {
+		return errors.New("must be a positive number of messages")
+	}
+	return nil
+}
+
+func validateInterval(value string) error {
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		return errors.New("must be a duration, eg: 30s")
+	}
+	return nil
+}
+
+func validateTail(value string) error {
+	if value == "all" {
+		return nil
+	}
+	if lines, err := strconv.Atoi(value); err != nil || lines < 0 {
+		return errors.New("must be a number of lines or all")
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	// ownOptionLabel starts the labels of the logspout container setting an option
+	ownOptionLabel = "logspout.env."
+	// ownRouteLabel starts the labels of the logspout container adding a route
+	ownRouteLabel = "logspout.route."
+	// ownInspectTimeout limits how long startup waits for Docker to inspect
+	// the logspout container
+	ownInspectTimeout = 5 * time.Second
+)
+
+// ownRoutes are the route URIs labelled on the logspout container, by route ID
+var ownRoutes = map[string]string{}
+
+// LoadOwnLabels reads the configuration labelled on the container logspout
+// runs in, so it is set once in a compose file or Swarm service instead of
+// in long env strings. A label logspout.env.NAME=value sets the option NAME,
+// unless it is set in the environment, and a label logspout.route.ID=uri
+// adds the route ID. It must be called before cfg.Parse, and does nothing
+// when logspout does not run in a container Docker can inspect.
+func LoadOwnLabels() error {
+	id := cfg.GetString("LOGSPOUT_CONTAINER")
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		debug("ownlabels: no docker client:", err)
+		return nil
+	}
+	client.SetTimeout(ownInspectTimeout)
+	container, err := client.InspectContainer(id)
+	if err != nil {
+		debug("ownlabels: not inspecting own container:", err)
+		return nil
+	}
+	return applyOwnLabels(container.Config.Labels)
+}
+
+// applyOwnLabels sets the options and keeps the routes of the labels
+func applyOwnLabels(labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := labels[key]
+		switch {
+		case strings.HasPrefix(key, ownOptionLabel):
+			name := strings.TrimPrefix(key, ownOptionLabel)
+			if _, exists := cfg.Lookup(name); !exists {
+				return fmt.Errorf("label %s: unknown option %s", key, name)
+			}
+			if _, set := os.LookupEnv(name); set {
+				continue
+			}
+			if err := os.Setenv(name, value); err != nil {
+				return err
+			}
+		case strings.HasPrefix(key, ownRouteLabel):
+			id := strings.TrimPrefix(key, ownRouteLabel)
+			if id == "" {
+				return fmt.Errorf("label %s: missing route ID", key)
+			}
+			ownRoutes[id] = value
+		}
+	}
+	return nil
+}
+
+// labelledRoutes returns the routes labelled on the logspout container
+func labelledRoutes() ([]*Route, error) {
+	ids := make([]string, 0, len(ownRoutes))
+	for id := range ownRoutes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	routes := make([]*Route, 0, len(ids))
+	for _, id := range ids {
+		route, err := ParseRouteURI(ownRoutes[id])
+		if err != nil {
+			return nil, fmt.Errorf("label %s%s: %s", ownRouteLabel, id, err)
+		}
+		route.ID = id
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
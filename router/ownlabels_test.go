@@ -0,0 +1,40 @@
+package router
+
+import (
+	"os"
+	"testing"
+)
+
+func TestApplyOwnLabels(t *testing.T) {
+	os.Setenv("BUFFER_SIZE", "10")
+	defer os.Unsetenv("BUFFER_SIZE")
+	defer os.Unsetenv("DROP_POLICY")
+	defer func() { ownRoutes = map[string]string{} }()
+
+	err := applyOwnLabels(map[string]string{
+		"logspout.env.BUFFER_SIZE":   "500",
+		"logspout.env.DROP_POLICY":   "oldest",
+		"logspout.route.papertrail":  "syslog+tls://logs.papertrailapp.com:55555?filter.name=*_db",
+		"com.docker.compose.project": "logging",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := os.Getenv("BUFFER_SIZE"); size != "10" {
+		t.Errorf("expected the environment to win over labels, got BUFFER_SIZE=%s", size)
+	}
+	if policy := os.Getenv("DROP_POLICY"); policy != "oldest" {
+		t.Errorf("expected label to set DROP_POLICY, got %q", policy)
+	}
+	routes, err := labelledRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].ID != "papertrail" || routes[0].Address != "logs.papertrailapp.com:55555" || routes[0].FilterName != "*_db" {
+		t.Errorf("unexpected labelled routes: %+v", routes)
+	}
+
+	if err := applyOwnLabels(map[string]string{"logspout.env.NO_SUCH_OPTION": "1"}); err == nil {
+		t.Error("expected a label of an unknown option to be rejected")
+	}
+}
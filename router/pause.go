@@ -0,0 +1,242 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Policies of pauses, what is done with the lines of containers paused
+const (
+	PauseBuffer = "buffer" // left with Docker until resumed, as when a route buffer is full
+	PauseDrop   = "drop"   // not shipped
+)
+
+func init() {
+	HTTPHandlers.Register(PauseAPI, "pause")
+	HTTPHandlers.Register(PauseAPI, "resume")
+}
+
+// apiPause stops shipping the lines of the containers matching a selector,
+// either their ID or name or the value of a label
+type apiPause struct {
+	Selector string `json:"selector"` // as given, like container=web or label=team=batch
+	Policy   string `json:"policy"`
+	rule     filterRule
+	id       string // ID prefix of the container, if selected by one, see isIDPrefix
+}
+
+// shortIDLen is the length of the short IDs of containers
+const shortIDLen = 12
+
+// isIDPrefix returns whether a selector is taken for the prefix of a
+// container ID, being hex of at least the length of a short ID, for names
+// like db or cafe not to select the containers whose IDs start with them
+func isIDPrefix(selector string) bool {
+	if len(selector) < shortIDLen {
+		return false
+	}
+	for _, c := range selector {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *apiPause) matches(container *docker.Container) bool {
+	if p.id != "" && strings.HasPrefix(container.ID, p.id) {
+		return true
+	}
+	var labels map[string]string
+	if container.Config != nil {
+		labels = container.Config.Labels
+	}
+	return p.rule.matches(strings.TrimPrefix(container.Name, "/"), labels)
+}
+
+// newAPIPause returns the pause of the container or label selected by the
+// query of a request
+func newAPIPause(query url.Values, policy string) (*apiPause, error) {
+	container, label := query.Get("container"), query.Get("label")
+	if (container == "") == (label == "") {
+		return nil, errors.New("either container or label must be set")
+	}
+	p := &apiPause{Policy: policy}
+	if container != "" {
+		p.Selector = "container=" + container
+		p.rule.pattern = strings.TrimPrefix(container, "/")
+		if isIDPrefix(container) {
+			p.id = container
+		}
+	} else {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("label must be key=pattern")
+		}
+		p.Selector = "label=" + label
+		p.rule.label, p.rule.pattern = parts[0], parts[1]
+	}
+	if _, err := path.Match(p.rule.pattern, ""); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// apiPauses are the pauses asked through the pause API, kept until resumed
+// or logspout restarts, apart from those of route buffers
+var apiPauses = &apiPauseRegistry{pauses: map[string]*apiPause{}, resumed: make(chan struct{})}
+
+type apiPauseRegistry struct {
+	count   int32 // of pauses, accessed atomically for pumps not to lock
+	mu      sync.Mutex
+	pauses  map[string]*apiPause // by selector
+	resumed chan struct{}        // closed once the pauses changed
+}
+
+func (r *apiPauseRegistry) add(p *apiPause) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pauses[p.Selector] = p
+	r.changed()
+}
+
+// remove lifts a pause, and returns whether there was one
+func (r *apiPauseRegistry) remove(selector string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.pauses[selector]; !ok {
+		return false
+	}
+	delete(r.pauses, selector)
+	r.changed()
+	return true
+}
+
+// changed wakes up the pumps waiting, for them to check the pauses
+// again, once they changed
+func (r *apiPauseRegistry) changed() {
+	atomic.StoreInt32(&r.count, int32(len(r.pauses)))
+	close(r.resumed)
+	r.resumed = make(chan struct{})
+}
+
+func (r *apiPauseRegistry) all() []*apiPause {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	all := make([]*apiPause, 0, len(r.pauses))
+	for _, p := range r.pauses {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Selector < all[j].Selector })
+	return all
+}
+
+// matching returns the pause of a container, that of the drop policy if
+// several match, or nil if it is not paused
+func (r *apiPauseRegistry) matching(container *docker.Container) *apiPause {
+	if atomic.LoadInt32(&r.count) == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matched *apiPause
+	for _, p := range r.pauses {
+		if p.matches(container) && (matched == nil || p.Policy == PauseDrop) {
+			matched = p
+		}
+	}
+	return matched
+}
+
+// wait blocks while a container is paused with the buffer policy, leaving
+// its lines with Docker, or until the context is done
+func (r *apiPauseRegistry) wait(ctx context.Context, container *docker.Container) {
+	for {
+		r.mu.Lock()
+		resumed := r.resumed
+		r.mu.Unlock()
+		if p := r.matching(container); p == nil || p.Policy != PauseBuffer {
+			return
+		}
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drops returns whether a container is paused with the drop policy
+func (r *apiPauseRegistry) drops(container *docker.Container) bool {
+	p := r.matching(container)
+	return p != nil && p.Policy == PauseDrop
+}
+
+// PauseAPI serves POST /pause?container=<id or name>&policy=buffer|drop,
+// or with label=<key>=<pattern> rather than a container, which stops
+// shipping the lines of the containers selected until a POST /resume with
+// the same selector, eg: to keep a workload flooding the pipeline from
+// holding up others during an incident. GET /pause lists the pauses.
+func PauseAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(apiPauses.all())
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		policy := r.URL.Query().Get("policy")
+		switch policy {
+		case "":
+			policy = PauseBuffer
+		case PauseBuffer, PauseDrop:
+		default:
+			http.Error(w, "Bad request: policy must be "+PauseBuffer+" or "+PauseDrop, http.StatusBadRequest)
+			return
+		}
+		p, err := newAPIPause(r.URL.Query(), policy)
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		apiPauses.add(p)
+		Audit(r, AuditEntry{Action: "container.pause", Selector: p.Selector})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		p, err := newAPIPause(r.URL.Query(), "")
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !apiPauses.remove(p.Selector) {
+			http.NotFound(w, r)
+			return
+		}
+		Audit(r, AuditEntry{Action: "container.resume", Selector: p.Selector})
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestPauseAPI(t *testing.T) {
+	defer func() {
+		for _, p := range apiPauses.all() {
+			apiPauses.remove(p.Selector)
+		}
+	}()
+	handler := PauseAPI()
+	for _, test := range []struct {
+		method, url string
+		status      int
+	}{
+		{"POST", "/pause", http.StatusBadRequest},
+		{"POST", "/pause?container=web&label=team=batch", http.StatusBadRequest},
+		{"POST", "/pause?label=team", http.StatusBadRequest},
+		{"POST", "/pause?container=web&policy=hide", http.StatusBadRequest},
+		{"POST", "/pause?container=8dfafdbc3a40", http.StatusOK},
+		{"POST", "/pause?label=team=batch&policy=drop", http.StatusOK},
+		{"POST", "/resume?container=8dfafdbc3a40", http.StatusNoContent},
+		{"POST", "/resume?container=8dfafdbc3a40", http.StatusNotFound},
+		{"DELETE", "/resume?container=web", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.url, nil))
+		if w.Code != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.url, test.status, w.Code)
+		}
+	}
+	if all := apiPauses.all(); len(all) != 1 || all[0].Selector != "label=team=batch" || all[0].Policy != PauseDrop {
+		t.Errorf("expected the pause of the label to remain, got %+v", all)
+	}
+	batch := &docker.Container{ID: "abc", Name: "/cron", Config: &docker.Config{Labels: map[string]string{"team": "batch"}}}
+	web := &docker.Container{ID: "def", Name: "/web", Config: &docker.Config{}}
+	if !apiPauses.drops(batch) || apiPauses.drops(web) {
+		t.Error("expected only the lines of the containers with the label to be dropped")
+	}
+}
+
+func TestAPIPauseOfName(t *testing.T) {
+	db := &docker.Container{ID: "0a1b2c3d4e5f6a7b", Name: "/db", Config: &docker.Config{}}
+	other := &docker.Container{ID: "db8dfafdbc3a40ab", Name: "/web", Config: &docker.Config{}}
+	pause, err := newAPIPause(map[string][]string{"container": {"db"}}, PauseDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pause.matches(db) || pause.matches(other) {
+		t.Error("expected only the container named db to be paused, not the one whose ID starts with db")
+	}
+	pause, err = newAPIPause(map[string][]string{"container": {"db8dfafdbc3a"}}, PauseDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pause.matches(db) || !pause.matches(other) {
+		t.Error("expected only the container of the short ID to be paused")
+	}
+}
+
+func TestAPIPauseBuffers(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40abcdef", Name: "/web", Config: &docker.Config{}}
+	pause, err := newAPIPause(map[string][]string{"container": {"web"}}, PauseBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiPauses.add(pause)
+	defer apiPauses.remove(pause.Selector)
+	if apiPauses.drops(container) {
+		t.Error("expected the lines of a buffering pause not to be dropped")
+	}
+	waited := make(chan struct{})
+	go func() {
+		apiPauses.wait(context.Background(), container)
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("expected reading the container to wait while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	apiPauses.remove(pause.Selector)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("expected reading the container to go on once resumed")
+	}
+}
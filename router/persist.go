@@ -2,11 +2,15 @@ package router
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // RouteFileStore represents a directory for storing routes
@@ -51,17 +55,113 @@ func (fs RouteFileStore) GetAll() ([]*Route, error) {
 
 // Add writes a marshaled *Route to the RouteFileStore
 func (fs RouteFileStore) Add(route *Route) error {
-	return ioutil.WriteFile(fs.Filename(route.ID), marshal(route), 0644)
+	return writeFileAtomic(fs.Filename(route.ID), marshal(route))
 }
 
 // Remove removes route from the RouteFileStore based on id
 func (fs RouteFileStore) Remove(id string) bool {
-	if _, err := os.Stat(fs.Filename(id)); err == nil {
-		if err := os.Remove(fs.Filename(id)); err != nil {
-			return true
-		}
+	return os.Remove(fs.Filename(id)) == nil
+}
+
+// RouteStateFile is a RouteStore keeping all routes in a single file
+type RouteStateFile struct {
+	path   string
+	mu     sync.Mutex
+	routes map[string]*Route
+}
+
+// NewRouteStateFile returns the RouteStateFile at path, reading its routes
+// if it exists
+func NewRouteStateFile(path string) (*RouteStateFile, error) {
+	sf := &RouteStateFile{path: path, routes: map[string]*Route{}}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var routes []*Route
+	if err := unmarshal(file, &routes); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid routes file %s: %s", path, err)
+	}
+	for _, route := range routes {
+		sf.routes[route.ID] = route
+	}
+	return sf, nil
+}
+
+// Get returns *Route based on an id
+func (sf *RouteStateFile) Get(id string) (*Route, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	route, exists := sf.routes[id]
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	return route, nil
+}
+
+// GetAll returns a slice of *Route for the entire RouteStateFile, ordered
+// by id
+func (sf *RouteStateFile) GetAll() ([]*Route, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.all(), nil
+}
+
+func (sf *RouteStateFile) all() []*Route {
+	routes := make([]*Route, 0, len(sf.routes))
+	for _, route := range sf.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ID < routes[j].ID })
+	return routes
+}
+
+// Add adds a route to the RouteStateFile, and writes the file
+func (sf *RouteStateFile) Add(route *Route) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.routes[route.ID] = route
+	return writeFileAtomic(sf.path, marshal(sf.all()))
+}
+
+// Remove removes route from the RouteStateFile based on id, and writes the
+// file
+func (sf *RouteStateFile) Remove(id string) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, exists := sf.routes[id]; !exists {
+		return false
+	}
+	delete(sf.routes, id)
+	if err := writeFileAtomic(sf.path, marshal(sf.all())); err != nil {
+		log.Println("persistor:", err)
+	}
+	return true
+}
+
+// writeFileAtomic replaces the file at path with data, so a crash never
+// leaves it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
 	}
-	return false
+	return os.Rename(tmp.Name(), path)
 }
 
 func marshal(obj interface{}) []byte {
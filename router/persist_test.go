@@ -0,0 +1,56 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRouteStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "routes.json")
+	store, err := NewRouteStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b", "a", "c"} {
+		if err := store.Add(&Route{ID: id, Adapter: "syslog", Address: id + ":514"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !store.Remove("c") || store.Remove("missing") {
+		t.Error("expected Remove to report whether the route was removed")
+	}
+
+	restored, err := NewRouteStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, _ := restored.GetAll()
+	if len(routes) != 2 || routes[0].ID != "a" || routes[1].Address != "b:514" {
+		t.Errorf("expected routes to be restored from the file, got %+v", routes)
+	}
+}
+
+func TestRouteFileStoreRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := RouteFileStore(dir)
+	if err := store.Add(&Route{ID: "stored", Adapter: "syslog"}); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Remove("stored") || store.Remove("stored") {
+		t.Error("expected Remove to report whether the route was removed")
+	}
+	if routes, _ := store.GetAll(); len(routes) != 0 {
+		t.Errorf("expected no routes left, got %+v", routes)
+	}
+}
@@ -2,17 +2,19 @@ package router
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
 	"log"
-	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 
 	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/tracing"
 )
 
 const (
@@ -21,17 +23,25 @@ const (
 	pumpEventStatusRestartName = "restart"
 	pumpEventStatusRenameName  = "rename"
 	pumpEventStatusDieName     = "die"
-	trueString                 = "true"
+	pumpEventStatusOOMName     = "oom"
+	pumpEventStatusDestroyName = "destroy"
 )
 
 var (
 	allowTTY bool
 )
 
+// detachGrace is how long the lines read from a container that died may
+// take to be sent to the routes, before those left are dropped
+const detachGrace = 10 * time.Second
+
 func init() {
+	ctx, cancel := context.WithCancel(context.Background())
 	pump := &LogsPump{
 		pumps:  make(map[string]*containerPump),
 		routes: make(map[chan *update]struct{}),
+		ctx:    ctx,
+		cancel: cancel,
 	}
 	setAllowTTY()
 	LogRouters.Register(pump, defaultPumpName)
@@ -39,17 +49,17 @@ func init() {
 }
 
 func debug(v ...interface{}) {
-	if os.Getenv("DEBUG") != "" {
+	if cfg.GetString("DEBUG") != "" {
 		log.Println(v...)
 	}
 }
 
 func backlog() bool {
-	return os.Getenv("BACKLOG") == "false"
+	return !cfg.GetBool("BACKLOG")
 }
 
 func setAllowTTY() {
-	if t := cfg.GetEnvDefault("ALLOW_TTY", ""); t == trueString {
+	if cfg.GetBool("ALLOW_TTY") {
 		allowTTY = true
 	}
 	debug("setting allowTTY to:", allowTTY)
@@ -89,10 +99,10 @@ func ignoreContainer(container *docker.Container) bool {
 		}
 	}
 
-	excludeLabel := cfg.GetEnvDefault("EXCLUDE_LABELS", "")
+	excludeLabel := cfg.GetString("EXCLUDE_LABELS")
 
 	if excludeLabel == "" {
-		excludeLabel = cfg.GetEnvDefault("EXCLUDE_LABEL", "")
+		excludeLabel = cfg.GetString("EXCLUDE_LABEL")
 	}
 	excludeValue := "true"
 	// support EXCLUDE_LABEL having multiple custom label values
@@ -122,10 +132,25 @@ func ignoreContainerTTY(container *docker.Container) bool {
 	return false
 }
 
+// IgnoreReason returns why the pump does not read the logs of a container,
+// or "" if it does
+func IgnoreReason(container *docker.Container) string {
+	switch {
+	case ignoreContainerTTY(container):
+		return "tty enabled"
+	case ignoreContainer(container):
+		return "environ ignore"
+	case !logDriverSupported(container):
+		return "log driver not supported"
+	case !InShard(container.ID):
+		return "shipped by another shard"
+	default:
+		return ""
+	}
+}
+
 func getInactivityTimeoutFromEnv() time.Duration {
-	inactivityTimeout, err := time.ParseDuration(cfg.GetEnvDefault("INACTIVITY_TIMEOUT", "0"))
-	assert(err, "Couldn't parse env var INACTIVITY_TIMEOUT. See https://golang.org/pkg/time/#ParseDuration for valid format.")
-	return inactivityTimeout
+	return cfg.GetDuration("INACTIVITY_TIMEOUT")
 }
 
 type update struct {
@@ -135,10 +160,15 @@ type update struct {
 
 // LogsPump is responsible for "pumping" logs to their configured destinations
 type LogsPump struct {
-	mu     sync.Mutex
-	pumps  map[string]*containerPump
-	routes map[chan *update]struct{}
-	client *docker.Client
+	mu          sync.Mutex
+	pumps       map[string]*containerPump
+	routes      map[chan *update]struct{}
+	client      *docker.Client
+	checkpoints *checkpoints
+	host        *containerPump  // ships lifecycle events with LIFECYCLE_EVENTS=host
+	summary     *containerPump  // ships summary events with SUMMARY_INTERVAL
+	ctx         context.Context // done once the pump stops, detaching every container
+	cancel      context.CancelFunc
 }
 
 // Name returns the name of the pump
@@ -148,26 +178,51 @@ func (p *LogsPump) Name() string {
 
 // Setup configures the pump
 func (p *LogsPump) Setup() error {
+	if err := validateShard(); err != nil {
+		return err
+	}
 	var err error
 	p.client, err = docker.NewClientFromEnv()
+	if err != nil {
+		return err
+	}
+	metadata.client = p.client
+	healths.mu.Lock()
+	healths.inspect = newHealthInspector(p.client)
+	healths.mu.Unlock()
+	if cfg.GetString("LIFECYCLE_EVENTS") == LifecycleHost {
+		p.host = newHostPump(p.ctx, p.client, "")
+	}
+	if summarized() {
+		p.summary = newHostPump(p.ctx, p.client, summarySuffix)
+	}
+	p.checkpoints, err = loadCheckpoints()
 	return err
 }
 
 func (p *LogsPump) rename(event *docker.APIEvents) {
+	name := event.Actor.Attributes["name"]
+	if name != "" {
+		name = "/" + name // as inspecting returns it
+	} else { // events before API 1.22 have no attributes
+		container, err := p.client.InspectContainer(event.ID)
+		assert(err, defaultPumpName)
+		name = container.Name
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	container, err := p.client.InspectContainer(event.ID)
-	assert(err, defaultPumpName)
+	metadata.renamed(event.ID, name)
 	pump, ok := p.pumps[normalID(event.ID)]
 	if !ok {
-		debug("pump.rename(): ignore: pump not found, state:", container.State.StateString())
+		debug("pump.rename(): ignore: pump not found")
 		return
 	}
-	pump.container.Name = container.Name
+	pump.container.Name = name
 }
 
 // Run executes the pump
 func (p *LogsPump) Run() error {
+	defer p.cancel()
 	inactivityTimeout := getInactivityTimeoutFromEnv()
 	debug("pump.Run(): using inactivity timeout: ", inactivityTimeout)
 
@@ -175,11 +230,17 @@ func (p *LogsPump) Run() error {
 	if err != nil {
 		return err
 	}
+	now := time.Now()
 	for idx := range containers {
+		id := normalID(containers[idx].ID)
+		// catch up on what was logged while logspout was not running
 		p.pumpLogs(&docker.APIEvents{
-			ID:     normalID(containers[idx].ID),
+			ID:     id,
 			Status: pumpEventStatusStartName,
-		}, false, inactivityTimeout)
+		}, p.checkpoints.catchUpSince(id, now), inactivityTimeout)
+	}
+	if p.summary != nil {
+		Go("pump.summary", func() { p.shipSummaries(cfg.GetDuration("SUMMARY_INTERVAL")) })
 	}
 	events := make(chan *docker.APIEvents)
 	err = p.client.AddEventListener(events)
@@ -190,42 +251,53 @@ func (p *LogsPump) Run() error {
 		debug("pump.Run() event:", normalID(event.ID), event.Status)
 		switch event.Status {
 		case pumpEventStatusStartName, pumpEventStatusRestartName:
-			go p.pumpLogs(event, backlog(), inactivityTimeout)
+			healths.forget(event.ID)
+			// from when it started, for the lines of containers logging
+			// before they are attached, or exiting right away, to be read
+			sinceTime := eventTime(event)
+			if backlog() {
+				sinceTime = time.Unix(0, 0)
+			}
+			go func(event *docker.APIEvents) {
+				p.pumpLogs(event, sinceTime, inactivityTimeout)
+				p.shipLifecycle(event) // once routes are attached
+			}(event)
 		case pumpEventStatusRenameName:
 			go p.rename(event)
 		case pumpEventStatusDieName:
 			go p.update(event)
+			go p.shipLifecycle(event)
+		case pumpEventStatusOOMName:
+			go p.shipLifecycle(event)
+		case pumpEventStatusDestroyName:
+			metadata.forget(event.ID)
+			healths.forget(event.ID)
+		default:
+			healthEvent(event)
 		}
 	}
 	return errors.New("docker event stream closed")
 }
 
-func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTimeout time.Duration) { //nolint:gocyclo
+func (p *LogsPump) pumpLogs(event *docker.APIEvents, sinceTime time.Time, inactivityTimeout time.Duration) { //nolint:gocyclo
 	id := normalID(event.ID)
-	container, err := p.client.InspectContainer(id)
+	span := tracing.Start(nil, "pump.attach").Set("container.id", id)
+	defer span.End()
+	inspect := tracing.Start(span, "docker.inspect")
+	container, err := metadata.refresh(id) // for the state of this run, like its PID
+	inspect.Fail(err)
+	inspect.End()
 	assert(err, defaultPumpName)
-	if ignoreContainerTTY(container) {
-		debug("pump.pumpLogs():", id, "ignored: tty enabled")
-		return
-	}
-	if ignoreContainer(container) {
-		debug("pump.pumpLogs():", id, "ignored: environ ignore")
-		return
-	}
-	if !logDriverSupported(container) {
-		debug("pump.pumpLogs():", id, "ignored: log driver not supported")
+	span.Set("container.name", normalName(container.Name))
+	if reason := IgnoreReason(container); reason != "" {
+		debug("pump.pumpLogs():", id, "ignored:", reason)
+		span.Set("ignored", reason)
 		return
 	}
 
-	var tail = cfg.GetEnvDefault("TAIL", "all")
-	var sinceTime time.Time
-	if backlog {
-		sinceTime = time.Unix(0, 0)
-	} else {
-		sinceTime = time.Now()
-	}
+	var tail = cfg.GetString("TAIL")
 
-	p.mu.Lock()
+	tracing.Lock(span, "pump.lock", &p.mu)
 	if _, exists := p.pumps[id]; exists {
 		p.mu.Unlock()
 		debug("pump.pumpLogs():", id, "pump exists")
@@ -240,10 +312,24 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 	}
 	outrd, outwr := io.Pipe()
 	errrd, errwr := io.Pipe()
-	p.pumps[id] = newContainerPump(container, outrd, errrd)
+	ctx, cancel := context.WithCancel(p.ctx)
+	pump := newContainerPump(ctx, container, outrd, errrd)
+	pump.checkpoints = p.checkpoints
+	p.pumps[id] = pump
 	p.mu.Unlock()
 	p.update(event)
-	go func() {
+	RunHook(EventContainerAttach, containerHookVars(container))
+	if mode := cfg.GetString("STATS_EVENTS"); mode != "" {
+		Go("pump.stats", func() { pump.sampleStats(p.client, mode, cfg.GetDuration("STATS_INTERVAL")) })
+	}
+	Go("pump.watch", func() {
+		<-ctx.Done() // stops reading, and Docker writing the logs
+		outrd.CloseWithError(ctx.Err())
+		errrd.CloseWithError(ctx.Err())
+		pauses.wake()
+	})
+	Go("pump.attach", func() {
+		defer cancel()
 		for {
 			debug("pump.pumpLogs():", id, "started, tail:", tail)
 			err := p.client.Logs(docker.LogsOptions{
@@ -260,10 +346,16 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 			})
 			if err != nil {
 				debug("pump.pumpLogs():", id, "stopped with error:", err)
+				if summarized() && err != docker.ErrInactivityTimeout && ctx.Err() == nil {
+					summaries.failed(pump.container)
+				}
 			} else {
 				debug("pump.pumpLogs():", id, "stopped")
 			}
 
+			if ctx.Err() != nil { // the pump stopped
+				return
+			}
 			sinceTime = time.Now()
 			if err == docker.ErrInactivityTimeout {
 				sinceTime = sinceTime.Add(-inactivityTimeout)
@@ -282,12 +374,21 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 			debug("pump.pumpLogs():", id, "dead")
 			outwr.Close()
 			errwr.Close()
+			select { // the lines read are sent, unless a route holds them up
+			case <-pump.shipped:
+				pump.flush()
+			case <-time.After(detachGrace):
+				debug("pump.pumpLogs():", id, "lines not sent within", detachGrace)
+			}
 			p.mu.Lock()
 			delete(p.pumps, id)
+			vars := containerHookVars(pump.container) // renamed under mu
 			p.mu.Unlock()
+			p.checkpoints.forget(id)
+			RunHook(EventContainerDetach, vars)
 			return
 		}
-	}()
+	})
 }
 
 func (p *LogsPump) update(event *docker.APIEvents) {
@@ -327,6 +428,14 @@ func (p *LogsPump) Route(route *Route, logstream chan *Message) {
 			defer pump.remove(logstream)
 		}
 	}
+	for _, host := range []*containerPump{p.host, p.summary} {
+		if host != nil && route.MatchContainer(
+			normalID(host.container.ID), normalName(host.container.Name), host.container.Config.Labels) {
+
+			host.add(logstream, route)
+			defer host.remove(logstream)
+		}
+	}
 	updates := make(chan *update)
 	p.routes[updates] = struct{}{}
 	p.mu.Unlock()
@@ -364,18 +473,36 @@ func (p *LogsPump) Route(route *Route, logstream chan *Message) {
 
 type containerPump struct {
 	sync.Mutex
-	container  *docker.Container
-	logstreams map[chan *Message]*Route
+	container   *docker.Container
+	logstreams  map[chan *Message]*Route
+	checkpoints *checkpoints
+	ctx         context.Context // done once the container is detached
+	shipping    int32           // streams still shipping, accessed atomically
+	shipped     chan struct{}   // closed once both streams were shipped
+	errors      chan struct{}   // signals error lines to sampleStats, with STATS_EVENTS=error
 }
 
-func newContainerPump(container *docker.Container, stdout, stderr io.Reader) *containerPump {
+func newContainerPump(ctx context.Context, container *docker.Container, stdout, stderr io.Reader) *containerPump {
 	cp := &containerPump{
 		container:  container,
 		logstreams: make(map[chan *Message]*Route),
+		ctx:        ctx,
+		shipping:   2,
+		shipped:    make(chan struct{}),
+		errors:     statsErrors(),
 	}
+	// each stream is read into a ring that its shipper sends on to the
+	// routes, so reading never waits for the lock of the routes, and the
+	// messages of a stream are shipped in the order of their Seq
 	pump := func(source string, input io.Reader) {
+		queue := newRing(pumpQueueSize())
+		Go("pump.ship", func() { cp.ship(queue) })
+		defer queue.close()
 		buf := bufio.NewReader(input)
+		var seq uint64
 		for {
+			pauses.wait(ctx, container.ID) // leave lines with Docker while a route is full
+			apiPauses.wait(ctx, container) // and while paused through the API
 			line, err := buf.ReadString('\n')
 			if err != nil {
 				if err != io.EOF {
@@ -383,27 +510,91 @@ func newContainerPump(container *docker.Container, stdout, stderr io.Reader) *co
 				}
 				return
 			}
-			cp.send(&Message{
+			seq++
+			queue.push(&Message{
 				Data:      strings.TrimSuffix(line, "\n"),
 				Container: container,
 				Time:      time.Now(),
 				Source:    source,
+				Seq:       seq,
 			})
 		}
 	}
-	go pump("stdout", stdout)
-	go pump("stderr", stderr)
+	Go("pump.read", func() { pump("stdout", stdout) })
+	Go("pump.read", func() { pump("stderr", stderr) })
 	return cp
 }
 
+// ship sends the messages of a stream to the routes until its reader is
+// done, dropping them once the container is detached
+func (cp *containerPump) ship(queue *ring) {
+	defer func() {
+		if atomic.AddInt32(&cp.shipping, -1) == 0 {
+			close(cp.shipped)
+		}
+	}()
+	for {
+		msg, ok := queue.pop()
+		if !ok {
+			return
+		}
+		if cp.ctx.Err() == nil && !apiPauses.drops(cp.container) && quotas.allow(cp.container, msg, time.Now()) {
+			cp.send(msg)
+			if summarized() {
+				summaries.shipped(cp.container, msg)
+			}
+		} else if summarized() && cp.ctx.Err() == nil { // beyond its quota, or paused
+			summaries.dropped([]*Message{msg})
+		}
+		if cp.errors != nil && DetectLevel(msg.Data).Severe() {
+			select {
+			case cp.errors <- struct{}{}:
+			default: // a sample is due already
+			}
+		}
+	}
+}
+
 func (cp *containerPump) send(msg *Message) {
 	cp.Lock()
 	defer cp.Unlock()
 	for logstream, route := range cp.logstreams {
+		if Filtered(cp.container) { // denied by the CONTAINER_FILTER_FILE, though still read
+			break
+		}
 		if !route.MatchMessage(msg) {
 			continue
 		}
-		logstream <- msg
+		select { // a route that stopped reading does not hold up the container
+		case logstream <- msg:
+		case <-route.Closer():
+		case <-cp.ctx.Done():
+			return
+		}
+	}
+	if len(cp.logstreams) > 0 { // lines read before routing starts are not shipped
+		cp.checkpoints.mark(normalID(cp.container.ID), msg.Time)
+	}
+}
+
+// flush has the adapters of the routes of a container that exited ship
+// the lines they hold of it right away, rather than wait for more, with a
+// message sent after its last line, for the adapters to be flushed once
+// they took them
+func (cp *containerPump) flush() {
+	exited := &Message{Container: cp.container, Time: time.Now(), exited: true}
+	cp.Lock()
+	defer cp.Unlock()
+	for logstream, route := range cp.logstreams {
+		if _, ok := route.adapter.(ContainerFlusher); !ok {
+			continue
+		}
+		select {
+		case logstream <- exited:
+		case <-route.Closer():
+		case <-cp.ctx.Done():
+			return
+		}
 	}
 }
 
@@ -417,4 +608,5 @@ func (cp *containerPump) remove(logstream chan *Message) {
 	cp.Lock()
 	defer cp.Unlock()
 	delete(cp.logstreams, logstream)
+	pauses.resume(cp.container.ID, logstream)
 }
@@ -2,13 +2,19 @@ package router
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 )
@@ -192,7 +198,7 @@ func TestPumpContainerRename(t *testing.T) {
 		Name:   "foo",
 		Config: config,
 	}
-	p.pumps["8dfafdbc3a40"] = newContainerPump(container, os.Stdout, os.Stderr)
+	p.pumps["8dfafdbc3a40"] = newContainerPump(context.Background(), container, os.Stdout, os.Stderr)
 	if name := p.pumps["8dfafdbc3a40"].container.Name; name != "foo" {
 		t.Errorf("containerPump should have name: 'foo' got name: '%s'", name)
 	}
@@ -210,7 +216,7 @@ func TestPumpNewContainerPump(t *testing.T) {
 		ID:     "8dfafdbc3a40",
 		Config: config,
 	}
-	pump := newContainerPump(container, os.Stdout, os.Stderr)
+	pump := newContainerPump(context.Background(), container, os.Stdout, os.Stderr)
 	if pump == nil {
 		t.Error("pump nil")
 		return
@@ -225,7 +231,7 @@ func TestPumpContainerPump(t *testing.T) {
 		ID:     "8dfafdbc3a40",
 		Config: config,
 	}
-	pump := newContainerPump(container, os.Stdout, os.Stderr)
+	pump := newContainerPump(context.Background(), container, os.Stdout, os.Stderr)
 	logstream, route := make(chan *Message), &Route{}
 	go func() {
 		for msg := range logstream {
@@ -244,6 +250,65 @@ func TestPumpContainerPump(t *testing.T) {
 	}
 }
 
+func TestPumpContainerPumpOrder(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	pump := newContainerPump(context.Background(), container, outrd, errrd)
+	logstream := make(chan *Message)
+	pump.add(logstream, &Route{})
+	const lines = 500
+	for _, w := range []*io.PipeWriter{outwr, errwr} {
+		go func(w *io.PipeWriter) {
+			for i := 1; i <= lines; i++ {
+				fmt.Fprintf(w, "line %d\n", i)
+			}
+			w.Close()
+		}(w)
+	}
+	last := map[string]uint64{}
+	for n := 0; n < 2*lines; n++ {
+		select {
+		case msg := <-logstream:
+			if msg.Seq != last[msg.Source]+1 || msg.Data != fmt.Sprintf("line %d", msg.Seq) {
+				t.Fatalf("expected line %d of %s, got %q with seq %d", last[msg.Source]+1, msg.Source, msg.Data, msg.Seq)
+			}
+			last[msg.Source] = msg.Seq
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d lines, got %v", 2*lines, last)
+		}
+	}
+}
+
+func TestPumpContainerPumpDetachStopsGoroutines(t *testing.T) {
+	before := Goroutines()
+	container := &docker.Container{ID: "8dfafdbc3a40", Config: &docker.Config{}}
+	outrd, outwr := io.Pipe()
+	errrd, _ := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	pump := newContainerPump(ctx, container, outrd, errrd)
+	pump.add(make(chan *Message), &Route{}) // a route that stopped reading
+	go fmt.Fprintf(outwr, "held up\nby the route\n") //nolint:errcheck
+	time.Sleep(10 * time.Millisecond)
+	cancel() // as the pump detaching the container does
+	outrd.CloseWithError(ctx.Err())
+	errrd.CloseWithError(ctx.Err())
+	select {
+	case <-pump.shipped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the streams to stop shipping once detached")
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		after := Goroutines()
+		if after["pump.read"] <= before["pump.read"] && after["pump.ship"] <= before["pump.ship"] { // those of other tests may end too
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the goroutines of the container to end, got %v, had %v", after, before)
+		}
+	}
+}
+
 func TestPumpRoutingFrom(t *testing.T) {
 	container := &docker.Container{
 		ID: "8dfafdbc3a40",
@@ -268,3 +333,73 @@ func TestPumpRoutingFrom(t *testing.T) {
 		t.Errorf("expected RoutingFrom to return 'false'")
 	}
 }
+
+// flushingAdapter records the lines it took and the containers it was
+// told to flush, in order
+type flushingAdapter struct {
+	sync.Mutex
+	took []string
+}
+
+func (a *flushingAdapter) Stream(logstream chan *Message) {
+	for msg := range logstream {
+		a.Lock()
+		a.took = append(a.took, msg.Data)
+		a.Unlock()
+	}
+}
+
+func (a *flushingAdapter) FlushContainer(id string) {
+	a.Lock()
+	defer a.Unlock()
+	a.took = append(a.took, "flush "+id)
+}
+
+func TestPumpFlushesRoutesOfExitedContainer(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40abcdef", Config: &docker.Config{}}
+	pump := newContainerPump(context.Background(), container, os.Stdout, os.Stderr)
+	flushing, other := make(chan *Message, 1), make(chan *Message, 1)
+	pump.add(flushing, &Route{adapter: &flushingAdapter{}})
+	pump.add(other, &Route{adapter: &DummyAdapter{}})
+	pump.flush()
+	select {
+	case msg := <-flushing:
+		if !msg.exited || msg.Container != container {
+			t.Errorf("expected a message telling the container exited, got %+v", msg)
+		}
+	default:
+		t.Error("expected a message telling the container exited")
+	}
+	if len(other) != 0 {
+		t.Error("expected no message for a route that does not flush")
+	}
+}
+
+func TestFlushExitedAfterTheLastLine(t *testing.T) {
+	container := &docker.Container{ID: "8dfafdbc3a40abcdef"}
+	in, out := make(chan *Message, 3), make(chan *Message)
+	in <- &Message{Container: container, Data: "first"}
+	in <- &Message{Container: container, Data: "last"}
+	in <- &Message{Container: container, exited: true}
+	close(in)
+	adapter := &flushingAdapter{}
+	go flushExited(adapter, in, out)
+	adapter.Stream(out)
+	expected := []string{"first", "last", "flush 8dfafdbc3a40"}
+	if !reflect.DeepEqual(adapter.took, expected) {
+		t.Errorf("expected %v, got %v", expected, adapter.took)
+	}
+}
+
+func TestEventTime(t *testing.T) {
+	at := time.Date(2020, 1, 1, 12, 0, 0, 500, time.UTC)
+	if got := eventTime(&docker.APIEvents{TimeNano: at.UnixNano(), Time: at.Unix()}); !got.Equal(at) {
+		t.Errorf("expected the time of the event, got %s", got)
+	}
+	if got := eventTime(&docker.APIEvents{Time: at.Unix()}); !got.Equal(at.Truncate(time.Second)) {
+		t.Errorf("expected the time of the event in seconds, got %s", got)
+	}
+	if got := eventTime(&docker.APIEvents{}); time.Since(got) > time.Minute {
+		t.Errorf("expected now for events without time, got %s", got)
+	}
+}
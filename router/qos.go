@@ -0,0 +1,55 @@
+package router
+
+import (
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// QoSLabel selects the quality of service class of a container's logs
+const QoSLabel = "logspout.qos"
+
+// Quality of service classes. Containers without a valid QoSLabel use the
+// settings of the route itself.
+const (
+	QoSGuaranteed = "guaranteed"  // never dropped, blocks when its buffer is full
+	QoSBestEffort = "best-effort" // dropped first when its buffer is full
+)
+
+// qosClasses are the classes with their own settings, the default class first
+var qosClasses = []string{"", QoSGuaranteed, QoSBestEffort}
+
+// QoSClass returns the quality of service class of a container, or "" if
+// its QoSLabel is missing or unknown
+func QoSClass(container *docker.Container) string {
+	if container == nil || container.Config == nil {
+		return ""
+	}
+	switch class := container.Config.Labels[QoSLabel]; class {
+	case QoSGuaranteed, QoSBestEffort:
+		return class
+	default:
+		return ""
+	}
+}
+
+// QoSOption returns the setting of a route option for a class, from the
+// route option like "buffer_size.guaranteed", or the env var like
+// BUFFER_SIZE_GUARANTEED, which must be registered with cfg. It returns ""
+// for the default class or if the setting is missing.
+func QoSOption(route *Route, key, class string) string {
+	if class == "" {
+		return ""
+	}
+	if value := route.Options[key+"."+class]; value != "" {
+		return value
+	}
+	return cfg.GetString(qosEnvName(key, class))
+}
+
+// qosEnvName returns the env var of a setting for a class
+func qosEnvName(key, class string) string {
+	return strings.ToUpper(strings.Replace(key+"_"+class, "-", "_", -1))
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Stages of the pipeline that queues hold messages between
+const (
+	StageAttach    = "attach"    // lines read from containers, waiting for the routes
+	StageTransform = "transform" // lines transformed by an adapter like multiline, waiting for its sub-adapter
+	StageSink      = "sink"      // lines in the buffer of a route, waiting for its adapter
+)
+
+// QueueStats measures the queues of a stage of the pipeline for a route,
+// or for every route at the attach stage
+type QueueStats struct {
+	Stage     string
+	Route     string
+	Depth     int64 // messages queued
+	HighWater int64 // most messages one of the queues held at once
+	Capacity  int64
+}
+
+// measured is a queue whose depth is read when the metrics are gathered
+type measured interface {
+	depth() int
+	capacity() int
+}
+
+// queueGauge measures the queues of a stage of a route
+type queueGauge struct {
+	stage     string
+	route     string
+	highWater int64 // accessed atomically
+	queues    map[measured]struct{}
+}
+
+var queueGauges = struct {
+	sync.Mutex
+	gauges map[[2]string]*queueGauge
+}{gauges: map[[2]string]*queueGauge{}}
+
+// openGauge adds a queue to the gauge of its stage and route
+func openGauge(stage, route string, q measured) *queueGauge {
+	queueGauges.Lock()
+	defer queueGauges.Unlock()
+	key := [2]string{stage, route}
+	g, exists := queueGauges.gauges[key]
+	if !exists {
+		g = &queueGauge{stage: stage, route: route, queues: map[measured]struct{}{}}
+		queueGauges.gauges[key] = g
+	}
+	g.queues[q] = struct{}{}
+	return g
+}
+
+// close removes a queue that is done with from its gauge, and the gauge
+// once it has none left
+func (g *queueGauge) close(q measured) {
+	queueGauges.Lock()
+	defer queueGauges.Unlock()
+	delete(g.queues, q)
+	if len(g.queues) == 0 {
+		delete(queueGauges.gauges, [2]string{g.stage, g.route})
+	}
+}
+
+// observe records the depth of a queue after a message was queued
+func (g *queueGauge) observe(depth int) {
+	for {
+		high := atomic.LoadInt64(&g.highWater)
+		if int64(depth) <= high || atomic.CompareAndSwapInt64(&g.highWater, high, int64(depth)) {
+			return
+		}
+	}
+}
+
+// Queues returns the measures of the queues of each stage and route, by
+// stage and route
+func Queues() []QueueStats {
+	queueGauges.Lock()
+	defer queueGauges.Unlock()
+	stats := make([]QueueStats, 0, len(queueGauges.gauges))
+	for _, g := range queueGauges.gauges {
+		s := QueueStats{Stage: g.stage, Route: g.route, HighWater: atomic.LoadInt64(&g.highWater)}
+		for q := range g.queues {
+			s.Depth += int64(q.depth())
+			s.Capacity += int64(q.capacity())
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Stage != stats[j].Stage {
+			return stats[i].Stage < stats[j].Stage
+		}
+		return stats[i].Route < stats[j].Route
+	})
+	return stats
+}
+
+// Queue is a bounded queue of messages between stages of the pipeline,
+// measured in the metrics. Messages are sent with Send, and received from
+// C, which is closed by Close.
+type Queue struct {
+	C     chan *Message
+	gauge *queueGauge
+}
+
+// NewQueue returns a queue of size messages of a stage of a route
+func NewQueue(stage, route string, size int) *Queue {
+	q := &Queue{C: make(chan *Message, size)}
+	q.gauge = openGauge(stage, route, q)
+	return q
+}
+
+// Send queues a message, waiting for room
+func (q *Queue) Send(msg *Message) {
+	q.C <- msg
+	q.gauge.observe(len(q.C))
+}
+
+// Close tells the receiver no more messages are sent
+func (q *Queue) Close() {
+	close(q.C)
+	q.gauge.close(q)
+}
+
+func (q *Queue) depth() int    { return len(q.C) }
+func (q *Queue) capacity() int { return cap(q.C) }
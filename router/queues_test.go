@@ -0,0 +1,63 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func queueStats(stage, route string) (QueueStats, bool) {
+	for _, s := range Queues() {
+		if s.Stage == stage && s.Route == route {
+			return s, true
+		}
+	}
+	return QueueStats{}, false
+}
+
+func TestQueueStats(t *testing.T) {
+	q := NewQueue(StageTransform, "queuetest", 4)
+	for i := 0; i < 3; i++ {
+		q.Send(&Message{})
+	}
+	<-q.C
+	<-q.C
+	s, _ := queueStats(StageTransform, "queuetest")
+	if s.Depth != 1 || s.HighWater != 3 || s.Capacity != 4 {
+		t.Errorf("expected a depth of 1, a high water mark of 3 and a capacity of 4, got %+v", s)
+	}
+	q.Close()
+	if s, exists := queueStats(StageTransform, "queuetest"); exists {
+		t.Errorf("expected a closed queue to be removed, got %+v", s)
+	}
+}
+
+func TestRouteBufferQueueStats(t *testing.T) {
+	buffer, err := newRouteBuffer(&Route{ID: "queuetest", Options: map[string]string{"buffer_size": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out := make(chan *Message), make(chan *Message)
+	done := make(chan struct{})
+	go func() {
+		buffer.run(in, out)
+		close(done)
+	}()
+	in <- &Message{}
+	in <- &Message{}
+	deadline := time.Now().Add(5 * time.Second)
+	s, _ := queueStats(StageSink, "queuetest")
+	for s.Depth != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		s, _ = queueStats(StageSink, "queuetest")
+	}
+	if s.Depth != 2 || s.HighWater != 2 || s.Capacity != int64(2*len(qosClasses)) {
+		t.Errorf("expected a depth and high water mark of 2, and room for 2 per class, got %+v", s)
+	}
+	close(in)
+	for range out {
+	}
+	<-done
+	if s, exists := queueStats(StageSink, "queuetest"); exists {
+		t.Errorf("expected the gauge of a stopped buffer to be removed, got %+v", s)
+	}
+}
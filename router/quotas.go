@@ -0,0 +1,201 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// Values of QUOTA_ENFORCEMENT, what is done with the lines of a label value
+// beyond its quota
+const (
+	QuotaWarn   = "warn"   // shipped, once logged that the quota is exceeded
+	QuotaSample = "sample" // one in QUOTA_SAMPLE shipped
+	QuotaStop   = "stop"   // dropped until the next period
+)
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "QUOTA_LABEL",
+			Description: "label of containers whose values the QUOTAS are of, eg: team"},
+		cfg.Option{Name: "QUOTAS", Validate: validateQuotas,
+			Description: "comma separated bytes each value of the QUOTA_LABEL may ship every QUOTA_PERIOD, eg: payments=5GB,search=500MB"},
+		cfg.Option{Name: "QUOTA_DEFAULT", Validate: validateQuotaSize,
+			Description: "quota of the values of the QUOTA_LABEL not in the QUOTAS (default unlimited)"},
+		cfg.Option{Name: "QUOTA_PERIOD", Type: cfg.Duration, Default: "24h", Validate: validateInterval,
+			Description: "period quotas are of, from midnight UTC"},
+		cfg.Option{Name: "QUOTA_ENFORCEMENT", Default: QuotaWarn, Validate: cfg.OneOf(QuotaWarn, QuotaSample, QuotaStop),
+			Description: "what is done with the lines beyond a quota: warn, sample or stop shipping them"},
+		cfg.Option{Name: "QUOTA_SAMPLE", Type: cfg.Int, Default: "100", Validate: validateQueueSize,
+			Description: "one in this many lines beyond a quota is shipped with QUOTA_ENFORCEMENT=sample"},
+	)
+}
+
+var quotaUnits = []struct {
+	suffix string
+	bytes  int64
+}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// parseQuotaSize parses a number of bytes, with a KB, MB, GB or TB suffix
+// of powers of 1024
+func parseQuotaSize(text string) (int64, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	unit := int64(1)
+	for _, u := range quotaUnits {
+		if strings.HasSuffix(text, u.suffix) {
+			text, unit = strings.TrimSpace(strings.TrimSuffix(text, u.suffix)), u.bytes
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(text, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("must be bytes, eg: 5GB")
+	}
+	return int64(size * float64(unit)), nil
+}
+
+func validateQuotaSize(value string) error {
+	_, err := parseQuotaSize(value)
+	return err
+}
+
+// parseQuotas parses the QUOTAS, as value=bytes separated by commas
+func parseQuotas(text string) (map[string]int64, error) {
+	quotas := map[string]int64{}
+	for _, quota := range strings.Split(text, ",") {
+		if quota = strings.TrimSpace(quota); quota == "" {
+			continue
+		}
+		parts := strings.SplitN(quota, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid quota %q (must be value=bytes)", quota)
+		}
+		size, err := parseQuotaSize(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota %q: %s", quota, err)
+		}
+		quotas[strings.TrimSpace(parts[0])] = size
+	}
+	return quotas, nil
+}
+
+func validateQuotas(value string) error {
+	_, err := parseQuotas(value)
+	return err
+}
+
+// quotaUse is the bytes a value of the QUOTA_LABEL shipped in a period
+type quotaUse struct {
+	used    int64
+	over    int64 // lines beyond the quota
+	dropped int64
+}
+
+// quotaTracker counts the bytes of the lines of each value of the
+// QUOTA_LABEL that were shipped in the current period
+type quotaTracker struct {
+	sync.Mutex
+	text   string           // of the QUOTAS parsed, read again as it changes
+	limits map[string]int64 // by value
+	start  time.Time        // of the period
+	values map[string]*quotaUse
+}
+
+var quotas = &quotaTracker{values: map[string]*quotaUse{}}
+
+// limit returns the quota of a value, or -1 if it has none, under the lock
+func (q *quotaTracker) limit(value string) int64 {
+	if text := cfg.GetString("QUOTAS"); text != q.text || q.limits == nil {
+		q.limits, _ = parseQuotas(text) // validated
+		q.text = text
+	}
+	if limit, isSet := q.limits[value]; isSet {
+		return limit
+	}
+	if text := cfg.GetString("QUOTA_DEFAULT"); text != "" {
+		limit, _ := parseQuotaSize(text)
+		return limit
+	}
+	return -1
+}
+
+// allow returns whether a line of a container is shipped, counting its
+// bytes against the quota of the value of its QUOTA_LABEL. Lines of
+// containers without the label are not limited.
+func (q *quotaTracker) allow(container *docker.Container, msg *Message, now time.Time) bool {
+	label := cfg.GetString("QUOTA_LABEL")
+	if label == "" || container.Config == nil {
+		return true
+	}
+	value := container.Config.Labels[label]
+	if value == "" {
+		return true
+	}
+	q.Lock()
+	defer q.Unlock()
+	limit := q.limit(value)
+	if limit < 0 {
+		return true
+	}
+	period := cfg.GetDuration("QUOTA_PERIOD")
+	if start := now.UTC().Truncate(period); !start.Equal(q.start) {
+		q.start, q.values = start, map[string]*quotaUse{}
+	}
+	use := q.values[value]
+	if use == nil {
+		use = &quotaUse{}
+		q.values[value] = use
+	}
+	size := int64(len(msg.Data))
+	if use.used+size <= limit {
+		use.used += size
+		return true
+	}
+	enforcement := cfg.GetString("QUOTA_ENFORCEMENT")
+	use.over++
+	if use.over == 1 {
+		log.Printf("router: quota of %s=%s exceeded, %d bytes every %s, enforcement: %s\n", label, value, limit, period, enforcement)
+	}
+	ship := enforcement == QuotaWarn ||
+		(enforcement == QuotaSample && (use.over-1)%int64(cfg.GetInt("QUOTA_SAMPLE")) == 0)
+	if ship {
+		use.used += size
+	} else {
+		use.dropped++
+	}
+	return ship
+}
+
+// QuotaStats is the use of the quota of a value of the QUOTA_LABEL in the
+// current period, as read by the metrics
+type QuotaStats struct {
+	Value   string
+	Used    int64 // bytes shipped
+	Limit   int64 // bytes of the quota
+	Dropped int64 // lines beyond the quota that were not shipped
+}
+
+// Quotas returns the use of the quotas of the values of the QUOTA_LABEL that
+// shipped lines in the current period, by value
+func Quotas() []QuotaStats {
+	quotas.Lock()
+	defer quotas.Unlock()
+	if !time.Now().UTC().Truncate(cfg.GetDuration("QUOTA_PERIOD")).Equal(quotas.start) {
+		return nil // nothing was shipped in the current period
+	}
+	stats := make([]QuotaStats, 0, len(quotas.values))
+	for value, use := range quotas.values {
+		stats = append(stats, QuotaStats{Value: value, Used: use.used, Limit: quotas.limit(value), Dropped: use.dropped})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Value < stats[j].Value })
+	return stats
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := parseQuotas("payments=5GB, search=1.5kb,ops=100")
+	if err != nil || quotas["payments"] != 5<<30 || quotas["search"] != 1536 || quotas["ops"] != 100 {
+		t.Errorf("unexpected quotas %v, %v", quotas, err)
+	}
+	for _, text := range []string{"payments", "=5GB", "payments=5XB", "payments=-1"} {
+		if _, err := parseQuotas(text); err == nil {
+			t.Errorf("expected %q to be invalid", text)
+		}
+	}
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	os.Setenv("QUOTA_LABEL", "team")
+	os.Setenv("QUOTAS", "payments=100B")
+	os.Setenv("QUOTA_SAMPLE", "3")
+	defer os.Unsetenv("QUOTA_LABEL")
+	defer os.Unsetenv("QUOTAS")
+	defer os.Unsetenv("QUOTA_SAMPLE")
+	defer os.Unsetenv("QUOTA_ENFORCEMENT")
+	payments := &docker.Container{Config: &docker.Config{Labels: map[string]string{"team": "payments"}}}
+	search := &docker.Container{Config: &docker.Config{Labels: map[string]string{"team": "search"}}}
+	line := &Message{Data: strings.Repeat("x", 40)}
+	day := time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)
+
+	for mode, expected := range map[string]string{
+		QuotaWarn:   "yyyyyyy",
+		QuotaSample: "yyynnyn", // the first beyond the quota, then one in 3
+		QuotaStop:   "yynnnnn",
+	} {
+		os.Setenv("QUOTA_ENFORCEMENT", mode)
+		q := &quotaTracker{values: map[string]*quotaUse{}}
+		var shipped strings.Builder
+		for i := 0; i < len(expected); i++ {
+			if q.allow(payments, line, day) {
+				shipped.WriteString("y")
+			} else {
+				shipped.WriteString("n")
+			}
+		}
+		if shipped.String() != expected {
+			t.Errorf("%s: expected %s to be shipped, got %s", mode, expected, shipped.String())
+		}
+		if !q.allow(search, line, day) {
+			t.Errorf("%s: expected values without a quota to be unlimited", mode)
+		}
+		if !q.allow(payments, line, day.Add(24*time.Hour)) {
+			t.Errorf("%s: expected the quota to be reset the next day", mode)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func init() {
+	Jobs.Register(&refresher{}, "refresh")
+	HTTPHandlers.Register(ConfigAPI, "config")
+	cfg.Register(cfg.Option{Name: "CONFIG_REFRESH_INTERVAL", Type: cfg.Duration, Default: "1m",
+		Description: "how often options set in files, like Docker secrets, are read again, 0 to never"})
+}
+
+// refresher is the job reading the options set in files again, so rotated
+// credentials are used without restarting logspout
+type refresher struct {
+	interval time.Duration
+}
+
+func (r *refresher) Name() string {
+	if r.interval == 0 {
+		return ""
+	}
+	return "refresh"
+}
+
+func (r *refresher) Setup() error {
+	r.interval = cfg.GetDuration("CONFIG_REFRESH_INTERVAL")
+	return nil
+}
+
+func (r *refresher) Run() error {
+	if r.interval == 0 {
+		select {}
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := cfg.Refresh(cfg.FileSource); err != nil {
+			log.Println("refresh:", err)
+		}
+	}
+	return nil
+}
+
+// ConfigAPI serves POST /config/refresh, which reads the options set
+// in files and resolves the references of options again right away, for
+// rotations to apply without waiting for the next refresh
+func ConfigAPI() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err := cfg.Refresh()
+		entry := AuditEntry{Action: "config.refresh"}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		Audit(r, entry)
+		if err != nil {
+			log.Println("refresh:", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
@@ -0,0 +1,98 @@
+package router
+
+import (
+	"sync/atomic"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// pumpQueueSize returns the number of lines a pump reads ahead of the
+// shipper of a stream, the PUMP_QUEUE_SIZE rounded up to a power of two
+func pumpQueueSize() int {
+	size := 1
+	for size < cfg.GetInt("PUMP_QUEUE_SIZE") {
+		size <<= 1
+	}
+	return size
+}
+
+// ring is a lock-free queue of messages between one producer and one
+// consumer, the reader of a stream of a container and its shipper. push
+// blocks while it is full and pop while it is empty.
+type ring struct {
+	head   uint64 // next slot to pop, only advanced by the consumer
+	tail   uint64 // next slot to push, only advanced by the producer
+	closed uint32
+	mask   uint64
+	slots  []*Message
+	pushed chan struct{} // wakes up the consumer
+	popped chan struct{} // wakes up the producer
+	gauge  *queueGauge   // of the attach stage
+}
+
+// newRing returns a ring of size slots, a power of two
+func newRing(size int) *ring {
+	r := &ring{
+		mask:   uint64(size - 1),
+		slots:  make([]*Message, size),
+		pushed: make(chan struct{}, 1),
+		popped: make(chan struct{}, 1),
+	}
+	r.gauge = openGauge(StageAttach, "", r)
+	return r
+}
+
+// push queues a message, waiting for room
+func (r *ring) push(msg *Message) {
+	tail := atomic.LoadUint64(&r.tail)
+	for tail-atomic.LoadUint64(&r.head) > r.mask {
+		<-r.popped
+	}
+	r.slots[tail&r.mask] = msg
+	atomic.StoreUint64(&r.tail, tail+1)
+	wake(r.pushed)
+	r.gauge.observe(int(tail + 1 - atomic.LoadUint64(&r.head)))
+}
+
+// pop returns the oldest message, waiting for one, or false once the ring
+// is closed and empty
+func (r *ring) pop() (*Message, bool) {
+	head := atomic.LoadUint64(&r.head)
+	for {
+		closed := atomic.LoadUint32(&r.closed) == 1 // before tail, so no push is missed
+		if head != atomic.LoadUint64(&r.tail) {
+			break
+		}
+		if closed {
+			return nil, false
+		}
+		<-r.pushed
+	}
+	msg := r.slots[head&r.mask]
+	r.slots[head&r.mask] = nil
+	atomic.StoreUint64(&r.head, head+1)
+	wake(r.popped)
+	return msg, true
+}
+
+// close tells the consumer no more messages are pushed
+func (r *ring) close() {
+	atomic.StoreUint32(&r.closed, 1)
+	wake(r.pushed)
+	r.gauge.close(r)
+}
+
+func (r *ring) depth() int {
+	return int(atomic.LoadUint64(&r.tail) - atomic.LoadUint64(&r.head))
+}
+
+func (r *ring) capacity() int { return len(r.slots) }
+
+// wake signals a waiting side of a ring, a signal left for a side that is
+// not waiting makes it check again
+func wake(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
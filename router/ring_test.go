@@ -0,0 +1,27 @@
+package router
+
+import "testing"
+
+func TestRingOrder(t *testing.T) {
+	r := newRing(4) // smaller than the messages, so the producer waits
+	go func() {
+		for i := uint64(1); i <= 1000; i++ {
+			r.push(&Message{Seq: i})
+		}
+		r.close()
+	}()
+	var last uint64
+	for {
+		msg, ok := r.pop()
+		if !ok {
+			break
+		}
+		if msg.Seq != last+1 {
+			t.Fatalf("expected message %d, got %d", last+1, msg.Seq)
+		}
+		last = msg.Seq
+	}
+	if last != 1000 {
+		t.Errorf("expected 1000 messages before the ring closed, got %d", last)
+	}
+}
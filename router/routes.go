@@ -2,6 +2,7 @@ package router
 
 import (
 	"crypto/sha1" //nolint:gosec
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -75,8 +76,8 @@ func (rm *RouteManager) Remove(id string) bool {
 	rm.Lock()
 	defer rm.Unlock()
 	route, ok := rm.routes[id]
-	if ok && route.closer != nil {
-		route.closer <- struct{}{}
+	if ok {
+		route.Close()
 	}
 	delete(rm.routes, id)
 	if rm.persistor != nil {
@@ -85,22 +86,55 @@ func (rm *RouteManager) Remove(id string) bool {
 	return ok
 }
 
+// pathAdapters are the adapters addressing a path rather than a host
+var pathAdapters sync.Map
+
+// RegisterPathAdapter has the path of the route URIs of an adapter kept in
+// the address of their route, for adapters addressing a path rather than a
+// host, like file:///var/log/containers.log or s3://bucket/prefix
+func RegisterPathAdapter(name string) {
+	pathAdapters.Store(name, true)
+}
+
+// addressesPath returns whether any adapter of the scheme of a route URI,
+// like lua+file, addresses a path
+func addressesPath(scheme string) bool {
+	for _, name := range strings.Split(scheme, "+") {
+		if _, registered := pathAdapters.Load(name); registered {
+			return true
+		}
+	}
+	return false
+}
+
 // AddFromURI creates a new route from an URI string and adds it to the RouteManager
 func (rm *RouteManager) AddFromURI(uri string) error {
+	r, err := ParseRouteURI(uri)
+	if err != nil {
+		return err
+	}
+	return rm.Add(r)
+}
+
+// ParseRouteURI returns the route described by an URI string
+func ParseRouteURI(uri string) (*Route, error) {
 	expandedRoute := os.ExpandEnv(uri)
 	u, err := url.Parse(expandedRoute)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	r := &Route{
 		Address: u.Host,
 		Adapter: u.Scheme,
 		Options: make(map[string]string),
 	}
+	if u.Path != "" && u.Path != "/" && addressesPath(u.Scheme) {
+		r.Address += u.Path
+	}
 	if u.RawQuery != "" {
 		params, err := url.ParseQuery(u.RawQuery)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for key := range params {
 			value := params.Get(key)
@@ -118,18 +152,87 @@ func (rm *RouteManager) AddFromURI(uri string) error {
 			}
 		}
 	}
-	return rm.Add(r)
+	return r, nil
+}
+
+// ParseRoute returns the route described by a route URI, or by a JSON
+// route, which may give a route URI in its uri field and set more fields
+// of the route on top of it
+func ParseRoute(data []byte) (*Route, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, "{") {
+		if text == "" {
+			return nil, errors.New("missing route")
+		}
+		return ParseRouteURI(text)
+	}
+	var fromURI struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(data, &fromURI); err != nil {
+		return nil, err
+	}
+	route := new(Route)
+	if fromURI.URI != "" {
+		var err error
+		if route, err = ParseRouteURI(fromURI.URI); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, route); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// newRouteAdapter returns the adapter, buffer and shaper of a route, or an
+// error if its adapter or options are invalid
+func newRouteAdapter(route *Route) (LogAdapter, *routeBuffer, *shaper, error) {
+	factory, found := AdapterFactories.Lookup(route.AdapterType())
+	if !found {
+		return nil, nil, nil, errors.New("bad adapter: " + route.Adapter)
+	}
+	buffer, err := newRouteBuffer(route)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	shaper, err := newShaper(route)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	adapter, err := factory(route)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return adapter, buffer, shaper, nil
+}
+
+// ValidateRoute returns the error adding a route would fail with, by
+// creating its adapter without routing any logs to it
+func ValidateRoute(route *Route) error {
+	if _, err := newRouteSchedule(route); err != nil {
+		return err
+	}
+	if _, err := routeHealth(route); err != nil {
+		return err
+	}
+	_, _, _, err := newRouteAdapter(route)
+	return err
 }
 
 // Add adds a route to the RouteManager
 func (rm *RouteManager) Add(route *Route) error {
 	rm.Lock()
 	defer rm.Unlock()
-	factory, found := AdapterFactories.Lookup(route.AdapterType())
-	if !found {
-		return errors.New("bad adapter: " + route.Adapter)
+	schedule, err := newRouteSchedule(route)
+	if err != nil {
+		return err
 	}
-	adapter, err := factory(route)
+	health, err := routeHealth(route)
+	if err != nil {
+		return err
+	}
+	adapter, buffer, shaper, err := newRouteAdapter(route)
 	if err != nil {
 		return err
 	}
@@ -140,9 +243,13 @@ func (rm *RouteManager) Add(route *Route) error {
 	}
 	route.closer = make(chan struct{})
 	route.adapter = adapter
+	route.buffer = buffer
+	route.shaper = shaper
+	route.schedule = schedule
+	route.health = health
 	// Stop any existing route with this ID:
 	if rm.routes[route.ID] != nil {
-		rm.routes[route.ID].closer <- struct{}{}
+		rm.routes[route.ID].Close()
 	}
 
 	rm.routes[route.ID] = route
@@ -160,8 +267,56 @@ func (rm *RouteManager) Add(route *Route) error {
 func (rm *RouteManager) route(route *Route) {
 	logstream := make(chan *Message)
 	defer route.Close()
+	stream := logstream
+	spool, err := openSpool(route)
+	if err != nil {
+		log.Printf("router: spool of route %s: %s, not spooling\n", route.ID, err)
+	} else if spool != nil {
+		spooled := make(chan *Message)
+		go spool.run(stream, spooled)
+		stream = spooled
+	}
+	if route.buffer != nil {
+		buffered := make(chan *Message)
+		go route.buffer.run(stream, buffered)
+		stream = buffered
+	}
+	if gapMarkers() {
+		marked := make(chan *Message)
+		go markGaps(route.ID, stream, marked)
+		stream = marked
+	}
+	if route.shaper != nil {
+		shaped := make(chan *Message)
+		go route.shaper.run(route, stream, shaped)
+		stream = shaped
+	}
+	if _, acknowledges := route.adapter.(Acknowledger); spool != nil && !acknowledges {
+		taken := make(chan *Message)
+		go acknowledgeTaken(route, stream, taken)
+		stream = taken
+	}
+	if flusher, ok := route.adapter.(ContainerFlusher); ok {
+		flushing := make(chan *Message)
+		go flushExited(flusher, stream, flushing)
+		stream = flushing
+	}
 	rm.Route(route, logstream)
-	route.adapter.Stream(logstream)
+	route.adapter.Stream(stream)
+}
+
+// flushExited sends the messages of a route on to its adapter, flushing the
+// lines it holds of a container once it took the last of the container
+// that exited, until in is closed
+func flushExited(flusher ContainerFlusher, in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	for msg := range in {
+		if msg.exited {
+			flusher.FlushContainer(normalID(msg.Container.ID))
+			continue
+		}
+		out <- msg
+	}
 }
 
 // Route takes a logstream and route and passes them off to all configure LogRouters
@@ -208,25 +363,83 @@ func (rm *RouteManager) Name() string {
 
 // Setup configures the RouteManager
 func (rm *RouteManager) Setup() error {
-	var uris string
-	if os.Getenv("ROUTE_URIS") != "" {
-		uris = os.Getenv("ROUTE_URIS")
+	for _, uri := range routeURIs() {
+		err := rm.AddFromURI(uri)
+		if err != nil {
+			return err
+		}
+	}
+	labelled, err := labelledRoutes()
+	if err != nil {
+		return err
+	}
+	for _, route := range labelled {
+		if err = rm.Add(route); err != nil {
+			return err
+		}
+	}
+
+	store, err := routeStore()
+	if err != nil || store == nil {
+		return err
 	}
+	return rm.Load(store)
+}
+
+// routeURIs returns the route URIs given as the first argument, or in
+// ROUTE_URIS
+func routeURIs() []string {
+	uris := cfg.GetString("ROUTE_URIS")
 	if len(os.Args) > 1 {
 		uris = os.Args[1]
 	}
-	if uris != "" {
-		for _, uri := range strings.Split(uris, ",") {
-			err := rm.AddFromURI(uri)
-			if err != nil {
-				return err
-			}
-		}
+	if uris == "" {
+		return nil
 	}
+	return strings.Split(uris, ",")
+}
 
-	persistPath := cfg.GetEnvDefault("ROUTESPATH", "/mnt/routes")
-	if _, err := os.Stat(persistPath); err == nil {
-		return rm.Load(RouteFileStore(persistPath))
+// routeStore returns where routes added at runtime are persisted: the
+// ROUTES_FILE if set, or the ROUTESPATH if it exists. It returns nil if
+// routes are not persisted.
+func routeStore() (RouteStore, error) {
+	if path := cfg.GetString("ROUTES_FILE"); path != "" {
+		return NewRouteStateFile(path)
 	}
-	return nil
+	persistPath := cfg.GetString("ROUTESPATH")
+	if _, err := os.Stat(persistPath); err != nil {
+		return nil, nil
+	}
+	return RouteFileStore(persistPath), nil
+}
+
+// ConfiguredRoutes returns the routes logspout starts with, from its
+// arguments or ROUTE_URIS, the labels of its container and those persisted,
+// without adding them
+func ConfiguredRoutes() ([]*Route, error) {
+	var routes []*Route
+	for _, uri := range routeURIs() {
+		route, err := ParseRouteURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	labelled, err := labelledRoutes()
+	if err != nil {
+		return nil, err
+	}
+	routes = append(routes, labelled...)
+	store, err := routeStore()
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		persisted, err := store.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, persisted...)
+	}
+	return routes, nil
 }
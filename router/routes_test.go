@@ -1,6 +1,8 @@
 package router
 
 import (
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -57,3 +59,58 @@ func TestRouterNoDuplicateIds(t *testing.T) {
 		t.Errorf("route1 was not closed after route2 added.")
 	}
 }
+
+func TestRouterAddFromURIPath(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "file")
+	AdapterFactories.Register(newDummyAdapter, "lua")
+	RegisterPathAdapter("file")
+	for uri, address := range map[string]string{
+		"file:///var/log/containers.log":     "/var/log/containers.log",
+		"file://logs/containers.log":         "logs/containers.log",
+		"lua+file:///var/log/containers.log": "/var/log/containers.log",
+		"syslog://logs.example.com:514/":     "logs.example.com:514",
+		"syslog://logs.example.com:514/logs": "logs.example.com:514",
+	} {
+		routes := &RouteManager{routes: make(map[string]*Route)}
+		if err := routes.AddFromURI(uri); err != nil {
+			t.Fatal(err)
+		}
+		all, _ := routes.GetAll()
+		if len(all) != 1 || all[0].Address != address {
+			t.Errorf("expected route with address %s for %s, got %v", address, uri, all)
+		}
+	}
+}
+
+func TestConfiguredRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := RouteFileStore(dir).Add(&Route{ID: "stored", Adapter: "syslog", Address: "stored:514"}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ROUTESPATH", dir)
+	defer os.Unsetenv("ROUTESPATH")
+	os.Setenv("ROUTE_URIS", "syslog://first:514,raw://second:5000?filter.name=*_db")
+	defer os.Unsetenv("ROUTE_URIS")
+	args := os.Args
+	os.Args = args[:1]
+	defer func() { os.Args = args }()
+
+	routes, err := ConfiguredRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addresses []string
+	for _, route := range routes {
+		addresses = append(addresses, route.Address)
+	}
+	if expected := []string{"first:514", "second:5000", "stored:514"}; !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("expected routes to %v, got %v", expected, addresses)
+	}
+	if routes[1].FilterName != "*_db" {
+		t.Errorf("expected filter of the route URI to be parsed, got %+v", routes[1])
+	}
+}
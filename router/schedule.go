@@ -0,0 +1,155 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is when a route ships logs: during the minutes matching any of
+// its cron expressions, in its time zone
+type schedule struct {
+	windows  []cronExpr
+	location *time.Location
+}
+
+// cronExpr is a cron expression of minutes, hours, days of the month,
+// months and days of the week, as bits of the values each field matches
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+// cronFields are the names and ranges of the fields of cron expressions
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// newRouteSchedule returns the schedule of the schedule and
+// schedule.timezone options of a route, or nil if it always ships logs
+func newRouteSchedule(route *Route) (*schedule, error) {
+	text := strings.TrimSpace(route.Options["schedule"])
+	if text == "" {
+		return nil, nil
+	}
+	s := &schedule{location: time.Local}
+	if zone := route.Options["schedule.timezone"]; zone != "" {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule.timezone: %s", err)
+		}
+		s.location = location
+	}
+	for _, window := range strings.Split(text, ";") {
+		expr, err := parseCron(window)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %s", strings.TrimSpace(window), err)
+		}
+		s.windows = append(s.windows, expr)
+	}
+	return s, nil
+}
+
+// active returns whether the schedule ships logs at a time
+func (s *schedule) active(t time.Time) bool {
+	t = t.In(s.location)
+	for i := range s.windows {
+		if s.windows[i].matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *cronExpr) matches(t time.Time) bool {
+	if e.minute&(1<<uint(t.Minute())) == 0 || e.hour&(1<<uint(t.Hour())) == 0 || e.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := e.dom&(1<<uint(t.Day())) != 0
+	dow := e.dow&(1<<uint(t.Weekday())) != 0
+	// like cron, either day matches when both are restricted
+	switch {
+	case e.anyDom:
+		return dow
+	case e.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// parseCron parses an expression of the five fields of crontab, each *, a
+// value, a range like 9-17, a step like */15 or 0-30/10, or a list of
+// those separated by commas. Days of the week are 0 to 7, Sunday being 0
+// or 7.
+func parseCron(text string) (cronExpr, error) {
+	fields := strings.Fields(text)
+	if len(fields) != len(cronFields) {
+		return cronExpr{}, fmt.Errorf("must have %d fields: minute hour day-of-month month day-of-week", len(cronFields))
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max); err != nil {
+			return cronExpr{}, fmt.Errorf("%s: %s", cronFields[i].name, err)
+		}
+	}
+	expr := cronExpr{
+		minute: bits[0], hour: bits[1], dom: bits[2], month: bits[3], dow: bits[4],
+		anyDom: fields[2] == "*", anyDow: fields[4] == "*",
+	}
+	if expr.dow&(1<<7) != 0 {
+		expr.dow |= 1 // Sunday
+	}
+	return expr, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		switch bounds := strings.SplitN(part, "-", 2); {
+		case part == "*":
+		case len(bounds) == 2:
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[1])
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			low, high = value, value
+			if step > 1 { // like 5/15, from 5 to the end
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%s is out of the range %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
@@ -0,0 +1,57 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	s, err := newRouteSchedule(&Route{Options: map[string]string{
+		"schedule":          "* 9-17 * * 1-5; 0-29 12 * * 0,6",
+		"schedule.timezone": "UTC",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		time   string
+		active bool
+	}{
+		{"2020-11-02T09:00:00Z", true},  // Monday
+		{"2020-11-02T17:59:00Z", true},  // Monday
+		{"2020-11-02T18:00:00Z", false}, // Monday
+		{"2020-11-02T08:59:00Z", false}, // Monday
+		{"2020-11-07T10:00:00Z", false}, // Saturday
+		{"2020-11-08T12:15:00Z", true},  // Sunday
+		{"2020-11-08T12:30:00Z", false}, // Sunday
+	} {
+		at, _ := time.Parse(time.RFC3339, test.time)
+		if active := s.active(at); active != test.active {
+			t.Errorf("%s: expected active %v", test.time, test.active)
+		}
+	}
+
+	if s, err := newRouteSchedule(&Route{Options: map[string]string{}}); s != nil || err != nil {
+		t.Errorf("expected no schedule without the option, got %v, %v", s, err)
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	expr, err := parseCron("*/15 0 1,15 * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr.minute != 1|1<<15|1<<30|1<<45 || expr.dom != 1<<1|1<<15 || expr.dow&1 == 0 {
+		t.Errorf("unexpected expression: %+v", expr)
+	}
+	// both days restricted match either, like cron
+	at, _ := time.Parse(time.RFC3339, "2020-11-08T00:30:00Z") // a Sunday, the 8th
+	if !expr.matches(at) {
+		t.Error("expected the day of the week to match on its own")
+	}
+	for _, bad := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "*/0 * * * *", "* * * * mon", "* * 0 * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
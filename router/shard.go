@@ -0,0 +1,58 @@
+package router
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "SHARD_COUNT", Type: cfg.Int, Default: "1", Validate: validateShardCount,
+			Description: "number of logspout instances sharing the containers of a host between them"},
+		cfg.Option{Name: "SHARD", Type: cfg.Int, Default: "1", Validate: validateShardCount,
+			Description: "which of the SHARD_COUNT shards of containers this instance ships, from 1"},
+	)
+}
+
+// InShard returns whether this instance ships the logs of a container, by
+// its full ID, when SHARD_COUNT instances share the containers of a host.
+// Containers are spread with a consistent hash, so changing the number of
+// instances moves as few of them as it can from one instance to another.
+func InShard(id string) bool {
+	count := cfg.GetInt("SHARD_COUNT")
+	if count <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id)) //nolint:errcheck
+	return jumpHash(h.Sum64(), count) == cfg.GetInt("SHARD")-1
+}
+
+func validateShard() error {
+	if shard, count := cfg.GetInt("SHARD"), cfg.GetInt("SHARD_COUNT"); shard > count {
+		return errors.New("SHARD " + strconv.Itoa(shard) + " is over the SHARD_COUNT of " + strconv.Itoa(count))
+	}
+	return nil
+}
+
+func validateShardCount(value string) error {
+	if n, err := strconv.Atoi(value); err != nil || n < 1 {
+		return errors.New("must be a number from 1")
+	}
+	return nil
+}
+
+// jumpHash returns the bucket of a key out of n, with the jump consistent
+// hash of Lamping and Veach
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,71 @@
+package router
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func shardsOf(id string, count int) []int {
+	var shards []int
+	os.Setenv("SHARD_COUNT", strconv.Itoa(count))
+	for shard := 1; shard <= count; shard++ {
+		os.Setenv("SHARD", strconv.Itoa(shard))
+		if InShard(id) {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+func TestShardShipsEachContainerOnce(t *testing.T) {
+	defer os.Unsetenv("SHARD_COUNT")
+	defer os.Unsetenv("SHARD")
+	perShard := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		shards := shardsOf(fmt.Sprintf("%064x", i), 4)
+		if len(shards) != 1 {
+			t.Fatalf("expected container %d to be in one shard, got %v", i, shards)
+		}
+		perShard[shards[0]]++
+	}
+	for shard := 1; shard <= 4; shard++ {
+		if perShard[shard] < 200 || perShard[shard] > 300 {
+			t.Errorf("expected about 250 containers in shard %d, got %d", shard, perShard[shard])
+		}
+	}
+}
+
+func TestShardCountChangeMovesFewContainers(t *testing.T) {
+	defer os.Unsetenv("SHARD_COUNT")
+	defer os.Unsetenv("SHARD")
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("%064x", i)
+		before, after := shardsOf(id, 3)[0], shardsOf(id, 4)[0]
+		if before != after {
+			if after != 4 {
+				t.Errorf("expected container %d to move to the new shard, not from %d to %d", i, before, after)
+			}
+			moved++
+		}
+	}
+	if moved > 300 {
+		t.Errorf("expected about a quarter of the containers to move, %d did", moved)
+	}
+}
+
+func TestShardValidation(t *testing.T) {
+	defer os.Unsetenv("SHARD_COUNT")
+	defer os.Unsetenv("SHARD")
+	os.Setenv("SHARD_COUNT", "2")
+	os.Setenv("SHARD", "3")
+	if validateShard() == nil {
+		t.Error("expected a SHARD over the SHARD_COUNT to be an error")
+	}
+	os.Unsetenv("SHARD_COUNT")
+	if !InShard("anything") {
+		t.Error("expected a single instance to ship every container")
+	}
+}
@@ -0,0 +1,619 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// maxUnacknowledged is the most lines forwarded that the spool waits to be
+// acknowledged, beyond it the oldest is given up on as if it was
+const maxUnacknowledged = 1 << 16
+
+const (
+	spoolExt     = ".spool"
+	spoolZstdExt = ".spool.zst" // of segments of records of compressed lines
+)
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "SPOOL_DIR",
+			Description: "directory routes write the lines to before forwarding them from it, unless set per route with the spool option"},
+		cfg.Option{Name: "SPOOL_SEGMENT_SIZE", Type: cfg.Int, Default: "16777216", Validate: validateSpoolSize,
+			Description: "bytes of each file of a spool, once written the spool rolls over to the next"},
+		cfg.Option{Name: "SPOOL_MAX_SIZE", Type: cfg.Int, Default: "1073741824", Validate: cfg.NotNegative,
+			Description: "bytes the files of a spool may hold, the oldest lines are dropped beyond it, 0 for unlimited"},
+		cfg.Option{Name: "SPOOL_FSYNC_INTERVAL", Type: cfg.Duration, Default: "1s", Validate: validateInterval,
+			Description: "how often spooled lines are synced to disk, those after it are lost if the host crashes"},
+		cfg.Option{Name: "SPOOL_COMPRESSION", Default: SpoolUncompressed, Validate: cfg.OneOf(SpoolUncompressed, SpoolZstd),
+			Description: "format of the segments written: none for lines of JSON, zstd for checksummed records of them compressed"},
+	)
+}
+
+func validateSpoolSize(value string) error {
+	if size, err := strconv.Atoi(value); err != nil || size < 1 {
+		return errors.New("must be a positive number of bytes")
+	}
+	return nil
+}
+
+// spool writes the lines of a route to files of a directory, which a
+// forwarder ships from, so reading containers never waits for delivery. At
+// most the lines written since the last sync are lost if the host crashes,
+// and when it restarts the lines are forwarded again from the first that
+// was not acknowledged by then, delivered or dead-lettered.
+type spool struct {
+	route       *Route
+	dir         string
+	segmentSize int64
+	maxSize     int64
+	compress    bool          // whether the segments written are compressed
+	closed      chan struct{} // once the spool was closed
+
+	mu         sync.Mutex
+	segments   []uint64         // of the files, oldest first, the last one written
+	sizes      map[uint64]int64 // bytes of each segment
+	compressed map[uint64]bool  // whether each segment is compressed
+	size       int64            // of every segment
+	file       *os.File         // of the last segment
+	pending    []byte           // lines not compressed into a record yet
+	readSeg    uint64           // segment and offset of the next record forwarded
+	readOff    int64
+	readLines  int            // lines of that record forwarded already
+	inflight   []*spooledLine // forwarded, oldest first, from the first not acknowledged
+	unacked    map[uint64]int // lines forwarded of each segment not acknowledged
+	wake       chan struct{}  // signals the forwarder records were written
+}
+
+// spooledLine is where a line forwarded is in the spool, for the spool to
+// resume from it after a restart until it is acknowledged
+type spooledLine struct {
+	seg   uint64
+	off   int64 // of its record
+	line  int   // of the lines of the record
+	acked bool
+}
+
+// spoolPosition is what the forwarder forwarded, persisted with every sync
+type spoolPosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+	Lines   int    `json:"lines,omitempty"`
+}
+
+// openSpools holds the spools open, by directory, for a route replaced by
+// one with the same ID to close its spool before the new one opens it
+var openSpools = struct {
+	sync.Mutex
+	dirs map[string]chan struct{}
+}{dirs: map[string]chan struct{}{}}
+
+var unsafeSpoolName = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// openSpool opens the spool of a route, in a directory named after the
+// route in its spool option or the SPOOL_DIR. It returns nil if neither is
+// set.
+func openSpool(route *Route) (*spool, error) {
+	dir := route.Options["spool"]
+	if _, isSet := route.Options["spool"]; !isSet {
+		dir = cfg.GetString("SPOOL_DIR")
+	}
+	if dir == "" {
+		return nil, nil
+	}
+	dir = filepath.Join(dir, unsafeSpoolName.ReplaceAllString(route.ID, "_"))
+	for {
+		openSpools.Lock()
+		previous, open := openSpools.dirs[dir]
+		if !open {
+			openSpools.dirs[dir] = make(chan struct{})
+			openSpools.Unlock()
+			break
+		}
+		openSpools.Unlock()
+		<-previous
+	}
+	s, err := loadSpool(route, dir)
+	if err != nil {
+		openSpools.Lock()
+		close(openSpools.dirs[dir])
+		delete(openSpools.dirs, dir)
+		openSpools.Unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadSpool reads the segments left in the directory of a spool, and opens
+// a new segment to write
+func loadSpool(route *Route, dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &spool{
+		route:       route,
+		dir:         dir,
+		segmentSize: int64(cfg.GetInt("SPOOL_SEGMENT_SIZE")),
+		maxSize:     int64(cfg.GetInt("SPOOL_MAX_SIZE")),
+		compress:    cfg.GetString("SPOOL_COMPRESSION") == SpoolZstd,
+		closed:      make(chan struct{}),
+		sizes:       map[uint64]int64{},
+		compressed:  map[uint64]bool{},
+		unacked:     map[uint64]int{},
+		wake:        make(chan struct{}, 1),
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := file.Name()
+		compressed := strings.HasSuffix(name, spoolZstdExt)
+		seg, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSuffix(name, spoolZstdExt), spoolExt), 10, 64)
+		if err != nil || !compressed && !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		s.segments = append(s.segments, seg)
+		s.sizes[seg] = file.Size()
+		s.compressed[seg] = compressed
+		s.size += file.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+	var position spoolPosition
+	if data, err := ioutil.ReadFile(s.path("position")); err == nil {
+		json.Unmarshal(data, &position) //nolint:errcheck
+	}
+	next := uint64(1)
+	if n := len(s.segments); n > 0 {
+		next = s.segments[n-1] + 1
+		s.readSeg = s.segments[0]
+		if _, exists := s.sizes[position.Segment]; exists {
+			for s.segments[0] != position.Segment { // forwarded before a crash
+				s.remove(s.segments[0])
+			}
+			s.readSeg, s.readOff, s.readLines = position.Segment, position.Offset, position.Lines
+		}
+	} else {
+		s.readSeg = next
+	}
+	return s, s.roll(next)
+}
+
+func (s *spool) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *spool) segmentPath(seg uint64) string {
+	if s.compressed[seg] {
+		return s.path(fmt.Sprintf("%020d%s", seg, spoolZstdExt))
+	}
+	return s.path(fmt.Sprintf("%020d%s", seg, spoolExt))
+}
+
+// roll closes the segment written, if any, and creates the next, under the
+// lock
+func (s *spool) roll(seg uint64) error {
+	if s.file != nil {
+		s.file.Sync()
+		s.file.Close()
+	}
+	s.compressed[seg] = s.compress
+	file, err := os.OpenFile(s.segmentPath(seg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.segments = append(s.segments, seg)
+	s.sizes[seg] = 0
+	return nil
+}
+
+// run writes the messages of in to the spool, and forwards those spooled
+// to out, until in is closed and every message was forwarded, or the
+// route is closed
+func (s *spool) run(in <-chan *Message, out chan<- *Message) {
+	forwarded := make(chan struct{})
+	go func() {
+		s.forward(out)
+		close(forwarded)
+	}()
+	defer func() {
+		<-forwarded
+		s.mu.Lock()
+		s.savePosition() // of the last lines forwarded
+		s.mu.Unlock()
+		openSpools.Lock()
+		close(openSpools.dirs[s.dir])
+		delete(openSpools.dirs, s.dir)
+		openSpools.Unlock()
+	}()
+	defer s.close()
+	ticker := time.NewTicker(cfg.GetDuration("SPOOL_FSYNC_INTERVAL"))
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				return
+			}
+			if msg.exited { // the forwarder sends lines long after
+				continue
+			}
+			if err := s.write(msg); err != nil {
+				log.Printf("router: spool of route %s: %s\n", s.route.ID, err)
+				WriteDeadLetters(s.route.ID, "spool failed", msg)
+			}
+		case <-ticker.C:
+			s.sync()
+		case <-s.route.Closer():
+			return
+		}
+	}
+}
+
+// write appends a message to the spool, as a line of JSON, or to the lines
+// compressed into the next record once they fill a block or are synced
+func (s *spool) write(msg *Message) error {
+	entry := newDeadLetter(time.Now().UTC(), s.route.ID, "", msg)
+	data, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if s.compress && len(data) > spoolMaxRecordSize/2 {
+		return errors.New("line too long to compress")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.compress {
+		return s.writeRecord(data)
+	}
+	s.pending = append(s.pending, data...)
+	if len(s.pending) >= spoolBlockSize {
+		s.flush()
+	}
+	return nil
+}
+
+// flush writes the pending lines as a compressed record, writing them to
+// the DEAD_LETTER_FILE if it fails, under the lock
+func (s *spool) flush() {
+	if len(s.pending) == 0 {
+		return
+	}
+	lines := s.pending
+	s.pending = nil
+	if err := s.writeRecord(encodeRecord(lines)); err != nil {
+		log.Printf("router: spool of route %s: %s\n", s.route.ID, err)
+		var msgs []*Message
+		ReadDeadLetters(bytes.NewReader(lines), func(entry *DeadLetter) error { //nolint:errcheck
+			msgs = append(msgs, entry.Message())
+			return nil
+		})
+		WriteDeadLetters(s.route.ID, "spool failed", msgs...)
+	}
+}
+
+// writeRecord appends a record to the last segment, rolling over to a new
+// segment once it is full, and dropping the oldest segments beyond the
+// SPOOL_MAX_SIZE, under the lock
+func (s *spool) writeRecord(record []byte) error {
+	last := s.segments[len(s.segments)-1]
+	if s.sizes[last] > 0 && s.sizes[last]+int64(len(record)) > s.segmentSize {
+		if err := s.roll(last + 1); err != nil {
+			return err
+		}
+		last++
+	}
+	if _, err := s.file.Write(record); err != nil {
+		return err
+	}
+	s.sizes[last] += int64(len(record))
+	s.size += int64(len(record))
+	for s.maxSize > 0 && s.size > s.maxSize && len(s.segments) > 1 {
+		s.dropOldest()
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default: // the forwarder was woken already
+	}
+	return nil
+}
+
+// dropOldest removes the oldest segment, writing the lines of it not yet
+// forwarded to the DEAD_LETTER_FILE, under the lock
+func (s *spool) dropOldest() {
+	seg := s.segments[0]
+	if seg == s.readSeg {
+		dropped := s.unforwarded(seg, s.readOff, s.readLines)
+		log.Printf("router: spool of route %s full, dropped %d messages\n", s.route.ID, len(dropped))
+		WriteDeadLetters(s.route.ID, "spool full", dropped...)
+		s.readSeg, s.readOff, s.readLines = s.segments[1], 0, 0
+	}
+	s.remove(seg)
+}
+
+// unforwarded returns the messages of a segment from an offset on, but the
+// lines of the record there already forwarded, up to a corrupt record
+func (s *spool) unforwarded(seg uint64, offset int64, forwarded int) []*Message {
+	file, err := os.Open(s.segmentPath(seg))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+	var msgs []*Message
+	reader := bufio.NewReader(file)
+	for {
+		lines, _, err := readRecord(reader, s.compressed[seg])
+		if err != nil {
+			return msgs
+		}
+		for i, line := range lines {
+			var entry DeadLetter
+			if i >= forwarded && json.Unmarshal(line, &entry) == nil {
+				msgs = append(msgs, entry.Message())
+			}
+		}
+		forwarded = 0
+	}
+}
+
+// remove deletes the oldest segment, under the lock
+func (s *spool) remove(seg uint64) {
+	os.Remove(s.segmentPath(seg))
+	s.size -= s.sizes[seg]
+	delete(s.sizes, seg)
+	delete(s.compressed, seg)
+	delete(s.unacked, seg)
+	s.segments = s.segments[1:]
+}
+
+// sync writes the pending lines and syncs the segment written to disk, and
+// persists what was forwarded
+func (s *spool) sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+	s.flush()
+	if err := s.file.Sync(); err != nil {
+		log.Printf("router: spool of route %s: %s\n", s.route.ID, err)
+	}
+	s.savePosition()
+}
+
+// savePosition persists where to resume forwarding from, the first line
+// not acknowledged, under the lock
+func (s *spool) savePosition() {
+	position := spoolPosition{Segment: s.readSeg, Offset: s.readOff, Lines: s.readLines}
+	if len(s.inflight) > 0 {
+		first := s.inflight[0]
+		position = spoolPosition{Segment: first.seg, Offset: first.off, Lines: first.line}
+	}
+	data, _ := json.Marshal(position)
+	if err := writeFileAtomic(s.path("position"), data); err != nil {
+		log.Printf("router: spool of route %s: %s\n", s.route.ID, err)
+	}
+}
+
+// close syncs and closes the spool, once no line is written any more, for
+// the forwarder to stop once it forwarded the rest
+func (s *spool) close() {
+	s.sync()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file.Close()
+	s.file = nil
+	close(s.closed)
+}
+
+// acknowledgeTaken acknowledges the messages of in once the adapter took
+// them from out, for adapters that do not acknowledge messages themselves
+func acknowledgeTaken(route *Route, in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	for msg := range in {
+		select {
+		case out <- msg:
+			msg.Ack()
+		case <-route.Closer():
+			return
+		}
+	}
+}
+
+// forward sends the spooled messages to out, oldest first, removing each
+// segment once it was forwarded, until the spool is closed
+func (s *spool) forward(out chan<- *Message) {
+	defer close(out)
+	var file *os.File
+	var reader *bufio.Reader
+	var seg uint64
+	var off int64
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for {
+		s.mu.Lock()
+		if file == nil || seg != s.readSeg || off != s.readOff { // not where the spool was read up to
+			if file != nil {
+				file.Close()
+				file = nil
+			}
+			seg, off = s.readSeg, s.readOff
+			if f, err := os.Open(s.segmentPath(seg)); err == nil {
+				f.Seek(off, io.SeekStart) //nolint:errcheck
+				file, reader = f, bufio.NewReader(f)
+			}
+		}
+		compressed, forwarded := s.compressed[seg], s.readLines
+		s.mu.Unlock()
+		var lines [][]byte
+		var size int64
+		err := io.EOF
+		if file != nil {
+			lines, size, err = readRecord(reader, compressed)
+		}
+		if err == errCorruptRecord {
+			s.skipCorrupt(seg, off)
+			file.Close()
+			file = nil
+			continue
+		}
+		if err != nil { // read up to what was written
+			if file != nil { // the rest of the record may be being written
+				file.Close()
+				file = nil
+			}
+			if s.next(seg) {
+				continue
+			}
+			select {
+			case <-s.wake:
+			case <-s.closed:
+				if s.drained() {
+					return
+				}
+			case <-s.route.Closer():
+				return
+			}
+			continue
+		}
+		for i := forwarded; i < len(lines); i++ {
+			var entry DeadLetter
+			if err := json.Unmarshal(lines[i], &entry); err == nil {
+				msg := entry.Message()
+				if container, cached := metadata.lookup(entry.Container); cached {
+					msg.Container = container
+				}
+				line := s.forwarding(seg, off, i)
+				msg.ack = func() { s.acknowledge(line) }
+				select {
+				case out <- msg:
+				case <-s.route.Closer():
+					return
+				}
+			}
+			s.mu.Lock()
+			if seg == s.readSeg && off == s.readOff { // not dropped meanwhile
+				s.readLines = i + 1
+			}
+			s.mu.Unlock()
+		}
+		s.mu.Lock()
+		if seg == s.readSeg && off == s.readOff {
+			s.readOff, s.readLines = s.readOff+size, 0
+		}
+		off += size
+		s.mu.Unlock()
+	}
+}
+
+// forwarding tracks a line forwarded until it is acknowledged
+func (s *spool) forwarding(seg uint64, off int64, line int) *spooledLine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := &spooledLine{seg: seg, off: off, line: line}
+	s.inflight = append(s.inflight, l)
+	s.unacked[seg]++
+	if len(s.inflight) > maxUnacknowledged { // the adapter lost it
+		first := s.inflight[0]
+		log.Printf("router: spool of route %s: record at %d of segment %d not acknowledged, no longer waiting for it\n",
+			s.route.ID, first.off, first.seg)
+		s.acked(first)
+	}
+	return l
+}
+
+// acknowledge records that a line forwarded was delivered or dead-lettered,
+// removing the segments every line of which was
+func (s *spool) acknowledge(l *spooledLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked(l)
+}
+
+// acked marks a line acknowledged, under the lock
+func (s *spool) acked(l *spooledLine) {
+	if l.acked {
+		return
+	}
+	l.acked = true
+	if _, exists := s.unacked[l.seg]; exists {
+		s.unacked[l.seg]--
+	}
+	for len(s.inflight) > 0 && s.inflight[0].acked {
+		s.inflight[0] = nil
+		s.inflight = s.inflight[1:]
+	}
+	s.removeAcknowledged()
+}
+
+// removeAcknowledged removes the oldest segments forwarded, once every line
+// of them was acknowledged, under the lock
+func (s *spool) removeAcknowledged() {
+	for len(s.segments) > 1 && s.segments[0] != s.readSeg && s.unacked[s.segments[0]] == 0 {
+		s.remove(s.segments[0])
+	}
+}
+
+// skipCorrupt moves the forwarder from a corrupt record of a segment to the
+// next whole one. Records are written under the lock, so none after it is
+// read partly written.
+func (s *spool) skipCorrupt(seg uint64, off int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seg != s.readSeg || off != s.readOff { // dropped meanwhile
+		return
+	}
+	next := resync(s.segmentPath(seg), off)
+	log.Printf("router: spool of route %s: corrupt record in segment %d, skipped %d bytes\n", s.route.ID, seg, next-off)
+	s.readOff, s.readLines = next, 0
+}
+
+// next moves the forwarder to the segment after a segment that was read to
+// its end, removing it once its lines are acknowledged, and returns whether
+// it did: not if it is the segment written
+func (s *spool) next(seg uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seg != s.readSeg {
+		return true // dropped meanwhile
+	}
+	i := sort.Search(len(s.segments), func(i int) bool { return s.segments[i] > seg })
+	if i == len(s.segments) {
+		return false
+	}
+	s.readSeg, s.readOff, s.readLines = s.segments[i], 0, 0
+	s.removeAcknowledged()
+	return true
+}
+
+// drained returns whether every line written to the closed spool was
+// forwarded
+func (s *spool) drained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.segments[len(s.segments)-1]
+	return s.readSeg == last && s.readOff >= s.sizes[last] && len(s.pending) == 0
+}
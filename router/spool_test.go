@@ -0,0 +1,227 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestSpoolForwardsAfterRestart(t *testing.T) {
+	testSpoolForwardsAfterRestart(t, SpoolUncompressed)
+}
+
+func TestSpoolZstdForwardsAfterRestart(t *testing.T) {
+	testSpoolForwardsAfterRestart(t, SpoolZstd) // the lines forwarded of a record are not again
+}
+
+func testSpoolForwardsAfterRestart(t *testing.T, compression string) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SPOOL_SEGMENT_SIZE", "300") // a few lines each
+	os.Setenv("SPOOL_COMPRESSION", compression)
+	os.Setenv("SPOOL_FSYNC_INTERVAL", "10ms")
+	defer os.Unsetenv("SPOOL_SEGMENT_SIZE")
+	defer os.Unsetenv("SPOOL_COMPRESSION")
+	defer os.Unsetenv("SPOOL_FSYNC_INTERVAL")
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	route, in, out, stopped := startSpool(t, dir)
+	for i := 1; i <= 6; i++ {
+		in <- &Message{Container: container, Source: "stdout", Data: fmt.Sprintf("line %d", i), Seq: uint64(i)}
+	}
+	for i := 1; i <= 2; i++ {
+		msg := <-out
+		if msg.Data != fmt.Sprintf("line %d", i) || msg.Seq != uint64(i) || msg.Container.Name != "/web" {
+			t.Errorf("expected line %d, got %+v", i, msg)
+		}
+		msg.Ack()
+	}
+	route.Close() // like logspout stopping
+	<-stopped
+
+	_, in, out, stopped = startSpool(t, dir)
+	for i := 3; i <= 6; i++ {
+		select {
+		case msg := <-out:
+			if msg.Data != fmt.Sprintf("line %d", i) {
+				t.Errorf("expected the lines not forwarded to be forwarded after a restart, got %s for line %d", msg.Data, i)
+			}
+			msg.Ack()
+		case <-time.After(time.Second):
+			t.Fatalf("expected line %d to be forwarded", i)
+		}
+	}
+	close(in)
+	if _, open := <-out; open {
+		t.Error("expected the forwarder to stop once the spool was closed and drained")
+	}
+	<-stopped
+	if files, _ := ioutil.ReadDir(dir + "/r1"); len(files) > 2 { // the last segment and the position
+		t.Errorf("expected the segments forwarded to be removed, got %d files", len(files))
+	}
+}
+
+// startSpool runs the spool of a route in a directory, until the route is
+// closed or in is
+func startSpool(t *testing.T, dir string) (*Route, chan *Message, chan *Message, chan struct{}) {
+	route := &Route{ID: "r1", Options: map[string]string{"spool": dir}, closer: make(chan struct{})}
+	s, err := openSpool(route)
+	if err != nil || s == nil {
+		t.Fatalf("expected the spool to open, got %v", err)
+	}
+	in, out, stopped := make(chan *Message), make(chan *Message), make(chan struct{})
+	go func() {
+		s.run(in, out)
+		close(stopped)
+	}()
+	return route, in, out, stopped
+}
+
+func TestSpoolResumesFromUnacknowledged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SPOOL_SEGMENT_SIZE", "300")
+	defer os.Unsetenv("SPOOL_SEGMENT_SIZE")
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	route, in, out, stopped := startSpool(t, dir)
+	for i := 1; i <= 6; i++ {
+		in <- &Message{Container: container, Data: fmt.Sprintf("line %d", i)}
+	}
+	var msgs []*Message
+	for i := 1; i <= 5; i++ {
+		msgs = append(msgs, <-out)
+	}
+	for _, i := range []int{0, 1, 3} { // line 3 is still being delivered
+		msgs[i].Ack()
+	}
+	route.Close()
+	<-stopped
+
+	_, in, out, stopped = startSpool(t, dir)
+	for i := 3; i <= 6; i++ { // line 4 again, though it was acknowledged
+		select {
+		case msg := <-out:
+			if msg.Data != fmt.Sprintf("line %d", i) {
+				t.Errorf("expected the lines from the first not acknowledged to be forwarded after a restart, got %s for line %d", msg.Data, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected line %d to be forwarded", i)
+		}
+	}
+	close(in)
+	<-out
+	<-stopped
+}
+
+func TestSpoolMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SPOOL_SEGMENT_SIZE", "300")
+	os.Setenv("SPOOL_MAX_SIZE", "600")
+	defer os.Unsetenv("SPOOL_SEGMENT_SIZE")
+	defer os.Unsetenv("SPOOL_MAX_SIZE")
+	route := &Route{ID: "r1", Options: map[string]string{"spool": dir}, closer: make(chan struct{})}
+	s, err := openSpool(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	for i := 1; i <= 20; i++ { // not forwarded
+		if err := s.write(&Message{Container: container, Data: fmt.Sprintf("line %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.size > 600+300 || s.readSeg != s.segments[0] || s.readOff != 0 {
+		t.Errorf("expected the oldest segments to be dropped, got %d bytes from segment %d", s.size, s.readSeg)
+	}
+	lines := s.unforwarded(s.readSeg, s.readOff, s.readLines)
+	if len(lines) == 0 || lines[0].Data == "line 1" {
+		t.Errorf("expected the oldest lines to be dropped, got %d lines", len(lines))
+	}
+	close(route.closer)
+	s.run(make(chan *Message), make(chan *Message))
+}
+
+func TestSpoolSkipsCorruptRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("SPOOL_COMPRESSION", SpoolZstd)
+	defer os.Unsetenv("SPOOL_COMPRESSION")
+	route := &Route{ID: "r1", Options: map[string]string{"spool": dir}, closer: make(chan struct{})}
+	s, err := openSpool(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	for i := 1; i <= 9; i++ { // a record of every 3 lines
+		if err := s.write(&Message{Container: container, Data: fmt.Sprintf("line %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			s.sync()
+		}
+	}
+	path := s.segmentPath(s.readSeg)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[spoolHeaderSize+4] ^= 0xff  // the frame of the first record
+	copy(data[len(data)-4:], "junk") // the end of the last
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	in, out := make(chan *Message), make(chan *Message)
+	go s.run(in, out)
+	for i := 4; i <= 6; i++ {
+		select {
+		case msg := <-out:
+			if msg.Data != fmt.Sprintf("line %d", i) {
+				t.Errorf("expected the lines of the whole record to be forwarded, got %s for line %d", msg.Data, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected line %d to be forwarded", i)
+		}
+	}
+	close(in)
+	if msg, open := <-out; open {
+		t.Errorf("expected the corrupt records to be skipped, got %s", msg.Data)
+	}
+}
+
+func TestSpoolRecordLengthOutOfBounds(t *testing.T) {
+	first, second := encodeRecord([]byte("line 1\n")), encodeRecord([]byte("line 2\n"))
+	binary.LittleEndian.PutUint32(first[4:], 0xffffffff)
+	data := append(append([]byte{}, first...), second...)
+	if _, _, err := readRecord(bufio.NewReader(bytes.NewReader(data)), true); err != errCorruptRecord {
+		t.Errorf("expected a record of a length out of bounds to be corrupt, got %v", err)
+	}
+	file, err := ioutil.TempFile("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(data) //nolint:errcheck
+	file.Close()
+	if off := resync(file.Name(), 0); off != int64(len(first)) {
+		t.Errorf("expected the next record to be resynced to at %d, got %d", len(first), off)
+	}
+}
@@ -0,0 +1,119 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/gliderlabs/logspout/zstd"
+)
+
+// Values of SPOOL_COMPRESSION
+const (
+	SpoolUncompressed = "none" // lines of JSON
+	SpoolZstd         = "zstd" // records of zstd compressed lines of JSON
+)
+
+// spoolBlockSize is the bytes of lines compressed into a record, at most,
+// those written since the last sync are compressed into one otherwise
+const spoolBlockSize = 256 * 1024
+
+// spoolMaxRecordSize is the bytes of the zstd frame of a record, at most,
+// those of a larger length are corrupt; lines are at most half of it, for
+// a block and a line to compress into it
+const spoolMaxRecordSize = 64 * 1024 * 1024
+
+// spoolMagic starts every record of a compressed segment, for a corrupt
+// record to be skipped up to the next
+var spoolMagic = []byte("LSPZ")
+
+// spoolHeaderSize is the bytes of the magic, length and checksum of records
+const spoolHeaderSize = 12
+
+var errCorruptRecord = errors.New("corrupt record")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecord returns a record of lines: the magic, the length and the
+// CRC-32C of their zstd frame, and the frame
+func encodeRecord(lines []byte) []byte {
+	frame := zstd.Compress(lines)
+	record := make([]byte, spoolHeaderSize, spoolHeaderSize+len(frame))
+	copy(record, spoolMagic)
+	binary.LittleEndian.PutUint32(record[4:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[8:], crc32.Checksum(frame, castagnoli))
+	return append(record, frame...)
+}
+
+// readRecord returns the lines of the next record of a segment, and its
+// size: a line of JSON of uncompressed segments, or the lines of a record
+// of compressed ones. It returns io.EOF if the record was not written
+// whole yet, and errCorruptRecord if its length is out of bounds or it fails
+// its checksum.
+func readRecord(reader *bufio.Reader, compressed bool) ([][]byte, int64, error) {
+	if !compressed {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, 0, io.EOF
+		}
+		return [][]byte{line}, int64(len(line)), nil
+	}
+	header, err := reader.Peek(spoolHeaderSize)
+	if err != nil {
+		return nil, 0, io.EOF
+	}
+	if !bytes.Equal(header[:4], spoolMagic) {
+		return nil, 0, errCorruptRecord
+	}
+	size := int(binary.LittleEndian.Uint32(header[4:]))
+	if size > spoolMaxRecordSize {
+		return nil, 0, errCorruptRecord
+	}
+	checksum := binary.LittleEndian.Uint32(header[8:])
+	record := make([]byte, spoolHeaderSize+size)
+	if _, err := io.ReadFull(reader, record); err != nil {
+		return nil, 0, io.EOF
+	}
+	frame := record[spoolHeaderSize:]
+	if crc32.Checksum(frame, castagnoli) != checksum {
+		return nil, 0, errCorruptRecord
+	}
+	data, err := zstd.Decompress(frame)
+	if err != nil {
+		return nil, 0, errCorruptRecord
+	}
+	var lines [][]byte
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		lines, data = append(lines, data[:end]), data[end:]
+	}
+	return lines, int64(len(record)), nil
+}
+
+// resync returns the offset of the first record of a compressed segment
+// after a corrupt one at an offset, or that of the end of the segment if
+// none is whole
+func resync(path string, corrupt int64) int64 {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return corrupt + 1
+	}
+	for off := corrupt + 1; off < int64(len(data)); off++ {
+		i := bytes.Index(data[off:], spoolMagic)
+		if i < 0 {
+			break
+		}
+		off += int64(i)
+		if _, _, err := readRecord(bufio.NewReader(bytes.NewReader(data[off:])), true); err == nil {
+			return off
+		}
+	}
+	return int64(len(data))
+}
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// Values of STATS_EVENTS, and the triggers of stats events
+const (
+	StatsOnError    = "error"    // after error lines of a container
+	StatsOnInterval = "interval" // every STATS_INTERVAL
+)
+
+// StatsSource is the Source of the messages of stats events, which routes
+// select or leave out with filter.sources
+const StatsSource = "stats"
+
+// statsTimeout limits how long Docker may take to sample a container
+const statsTimeout = 10 * time.Second
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "STATS_EVENTS", Validate: cfg.OneOf(StatsOnError, StatsOnInterval),
+			Description: "ship the CPU and memory use of containers in their logs after their error lines, or every STATS_INTERVAL"},
+		cfg.Option{Name: "STATS_INTERVAL", Type: cfg.Duration, Default: "1m", Validate: validateInterval,
+			Description: "how often stats events are shipped for each container, at most, after error lines"},
+	)
+}
+
+// StatsEvent is the resource use of a container, shipped as a line of JSON
+type StatsEvent struct {
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	Trigger       string    `json:"trigger"`
+	ContainerID   string    `json:"container_id"`
+	ContainerName string    `json:"container_name"`
+	Image         string    `json:"image"`
+	CPUPercent    float64   `json:"cpu_percent"` // of one CPU, like docker stats
+	MemoryUsage   uint64    `json:"memory_usage"`
+	MemoryLimit   uint64    `json:"memory_limit"`
+	MemoryPercent float64   `json:"memory_percent"`
+	Pids          uint64    `json:"pids"`
+}
+
+// newStatsEvent returns the stats event of a container from a sample of
+// Docker, computed like docker stats does
+func newStatsEvent(trigger string, container *docker.Container, stats *docker.Stats) *StatsEvent {
+	e := &StatsEvent{
+		Time:          stats.Read.UTC(),
+		Event:         StatsSource,
+		Trigger:       trigger,
+		ContainerID:   container.ID,
+		ContainerName: strings.TrimPrefix(container.Name, "/"),
+		MemoryLimit:   stats.MemoryStats.Limit,
+		Pids:          stats.PidsStats.Current,
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if container.Config != nil {
+		e.Image = container.Config.Image
+	}
+	cpu := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	system := float64(stats.CPUStats.SystemCPUUsage) - float64(stats.PreCPUStats.SystemCPUUsage)
+	if cpu > 0 && system > 0 {
+		cpus := math.Max(1, float64(len(stats.CPUStats.CPUUsage.PercpuUsage)))
+		e.CPUPercent = round2(cpu / system * cpus * 100)
+	}
+	e.MemoryUsage = stats.MemoryStats.Usage
+	if cache := stats.MemoryStats.Stats.Cache; cache < e.MemoryUsage { // reclaimable
+		e.MemoryUsage -= cache
+	}
+	if e.MemoryLimit > 0 {
+		e.MemoryPercent = round2(float64(e.MemoryUsage) / float64(e.MemoryLimit) * 100)
+	}
+	return e
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// statsErrors returns the channel ship signals error lines on for stats
+// events, or nil unless STATS_EVENTS is error
+func statsErrors() chan struct{} {
+	if cfg.GetString("STATS_EVENTS") != StatsOnError {
+		return nil
+	}
+	return make(chan struct{}, 1)
+}
+
+// statser samples the resource use of containers, like a Docker client
+type statser interface {
+	Stats(opts docker.StatsOptions) error
+}
+
+// sampleStats ships the stats events of a container with STATS_EVENTS,
+// until it is detached: every interval, or after an error line, at most
+// once an interval
+func (cp *containerPump) sampleStats(client statser, mode string, interval time.Duration) {
+	var tick <-chan time.Time
+	if mode == StatsOnInterval {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		trigger := StatsOnInterval
+		select {
+		case <-cp.ctx.Done():
+			return
+		case <-tick:
+		case <-cp.errors:
+			trigger = StatsOnError
+		}
+		cp.shipStats(client, trigger)
+		if trigger == StatsOnError {
+			select {
+			case <-cp.ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			select { // errors of the interval were sampled
+			case <-cp.errors:
+			default:
+			}
+		}
+	}
+}
+
+// shipStats ships a sample of the resource use of the container
+func (cp *containerPump) shipStats(client statser, trigger string) {
+	samples := make(chan *docker.Stats, 1)
+	err := client.Stats(docker.StatsOptions{
+		ID: cp.container.ID, Stats: samples, Timeout: statsTimeout, InactivityTimeout: statsTimeout,
+	})
+	sample := <-samples
+	if err != nil || sample == nil {
+		debug("pump.shipStats():", normalID(cp.container.ID), err)
+		return
+	}
+	e := newStatsEvent(trigger, cp.container, sample)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	cp.send(&Message{Container: cp.container, Source: StatsSource, Data: string(data), Time: e.Time})
+}
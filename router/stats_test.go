@@ -0,0 +1,58 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// fakeStatser samples the stats it holds, like Docker without streaming
+type fakeStatser struct {
+	stats *docker.Stats
+}
+
+func (f *fakeStatser) Stats(opts docker.StatsOptions) error {
+	opts.Stats <- f.stats
+	close(opts.Stats)
+	return nil
+}
+
+func TestSampleStatsOnError(t *testing.T) {
+	stats := &docker.Stats{Read: time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC)}
+	stats.CPUStats.CPUUsage.TotalUsage, stats.PreCPUStats.CPUUsage.TotalUsage = 300, 100
+	stats.CPUStats.SystemCPUUsage, stats.PreCPUStats.SystemCPUUsage = 2000, 1000
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{150, 150}
+	stats.MemoryStats.Usage, stats.MemoryStats.Stats.Cache, stats.MemoryStats.Limit = 300, 100, 800
+	stats.PidsStats.Current = 7
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cp := &containerPump{
+		container:  &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{Image: "nginx"}},
+		logstreams: map[chan *Message]*Route{},
+		ctx:        ctx,
+		errors:     make(chan struct{}, 1),
+	}
+	logstream := make(chan *Message, 1)
+	cp.add(logstream, &Route{})
+	go cp.sampleStats(&fakeStatser{stats: stats}, StatsOnError, time.Hour)
+
+	cp.errors <- struct{}{}
+	select {
+	case msg := <-logstream:
+		expected := `{"time":"2020-11-02T10:00:00Z","event":"stats","trigger":"error","container_id":"8dfafdbc3a40",` +
+			`"container_name":"web","image":"nginx","cpu_percent":40,"memory_usage":200,"memory_limit":800,"memory_percent":25,"pids":7}`
+		if msg.Source != StatsSource || msg.Data != expected {
+			t.Errorf("expected %s, got %s from %s", expected, msg.Data, msg.Source)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the stats to be shipped after an error")
+	}
+	cp.errors <- struct{}{} // within the interval
+	select {
+	case msg := <-logstream:
+		t.Errorf("expected one sample an interval, got %s", msg.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
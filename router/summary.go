@@ -0,0 +1,156 @@
+package router
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// SummarySource is the Source of the messages of summary events, which
+// routes select or leave out with filter.sources
+const SummarySource = "summary"
+
+// summarySuffix names the container summary events are shipped as the logs
+// of, after the host
+const summarySuffix = "-summary"
+
+func init() {
+	cfg.Register(cfg.Option{Name: "SUMMARY_INTERVAL", Type: cfg.Duration, Default: "0",
+		Description: "how often a summary of what was shipped is shipped in the logs of a container named after the host, 0 to not ship it"})
+}
+
+// SummaryEvent is what the pump shipped over a period, shipped as a line
+// of JSON
+type SummaryEvent struct {
+	Time       time.Time           `json:"time"`
+	Event      string              `json:"event"`
+	Period     string              `json:"period"`
+	Attached   int                 `json:"containers_attached"`
+	Events     int64               `json:"events"`
+	Bytes      int64               `json:"bytes"`
+	Dropped    int64               `json:"dropped"`
+	Errors     int64               `json:"errors"`
+	Containers []*ContainerSummary `json:"containers"`
+}
+
+// ContainerSummary is what was shipped of a container over a period: its
+// lines sent to routes, those routes or quotas dropped, and the errors
+// reading them
+type ContainerSummary struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	Events        int64  `json:"events"`
+	Bytes         int64  `json:"bytes"`
+	Dropped       int64  `json:"dropped"`
+	Errors        int64  `json:"errors"`
+}
+
+// summaryCounts counts what was shipped of each container since the last
+// summary event, while SUMMARY_INTERVAL is set
+type summaryCounts struct {
+	sync.Mutex
+	containers map[string]*ContainerSummary // by ID
+}
+
+var summaries = &summaryCounts{containers: map[string]*ContainerSummary{}}
+
+func summarized() bool {
+	return cfg.GetDuration("SUMMARY_INTERVAL") > 0
+}
+
+// of returns the counts of a container, under the lock
+func (s *summaryCounts) of(container *docker.Container) *ContainerSummary {
+	id := normalID(container.ID)
+	summary, exists := s.containers[id]
+	if !exists {
+		summary = &ContainerSummary{ContainerID: id}
+		s.containers[id] = summary
+	}
+	summary.ContainerName = normalName(container.Name) // as last renamed
+	return summary
+}
+
+func (s *summaryCounts) shipped(container *docker.Container, msg *Message) {
+	s.Lock()
+	defer s.Unlock()
+	summary := s.of(container)
+	summary.Events++
+	summary.Bytes += int64(len(msg.Data))
+}
+
+func (s *summaryCounts) dropped(msgs []*Message) {
+	s.Lock()
+	defer s.Unlock()
+	for _, msg := range msgs {
+		if msg.Container != nil {
+			s.of(msg.Container).Dropped++
+		}
+	}
+}
+
+func (s *summaryCounts) failed(container *docker.Container) {
+	s.Lock()
+	defer s.Unlock()
+	s.of(container).Errors++
+}
+
+// take returns the summary event of the counts, with those of the
+// containers attached that shipped nothing, and resets them
+func (s *summaryCounts) take(now time.Time, period time.Duration, attached []*docker.Container) *SummaryEvent {
+	s.Lock()
+	for _, container := range attached {
+		s.of(container)
+	}
+	containers := s.containers
+	s.containers = map[string]*ContainerSummary{}
+	s.Unlock()
+	e := &SummaryEvent{Time: now.UTC(), Event: SummarySource, Period: period.String(), Attached: len(attached),
+		Containers: make([]*ContainerSummary, 0, len(containers))}
+	for _, summary := range containers {
+		e.Events += summary.Events
+		e.Bytes += summary.Bytes
+		e.Dropped += summary.Dropped
+		e.Errors += summary.Errors
+		e.Containers = append(e.Containers, summary)
+	}
+	sort.Slice(e.Containers, func(i, j int) bool {
+		return e.Containers[i].ContainerName < e.Containers[j].ContainerName
+	})
+	return e
+}
+
+// shipSummaries ships a summary event every interval with the summary
+// pump, until the pump stops
+func (p *LogsPump) shipSummaries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.shipSummary(now, interval)
+		}
+	}
+}
+
+// shipSummary ships the summary event of a period
+func (p *LogsPump) shipSummary(now time.Time, period time.Duration) {
+	p.mu.Lock()
+	attached := make([]*docker.Container, 0, len(p.pumps))
+	for _, pump := range p.pumps {
+		attached = append(attached, &docker.Container{ID: pump.container.ID, Name: pump.container.Name})
+	}
+	p.mu.Unlock()
+	e := summaries.take(now, period, attached)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	p.summary.send(&Message{Container: p.summary.container, Source: SummarySource, Data: string(data), Time: e.Time})
+}
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestShipSummary(t *testing.T) {
+	os.Setenv("SUMMARY_INTERVAL", "1h")
+	defer os.Unsetenv("SUMMARY_INTERVAL")
+	defer func(previous *summaryCounts) { summaries = previous }(summaries)
+	summaries = &summaryCounts{containers: map[string]*ContainerSummary{}}
+	web := &docker.Container{ID: "8dfafdbc3a40", Name: "/web", Config: &docker.Config{}}
+	idle := &docker.Container{ID: "9cb3e1a7d2f5", Name: "/idle", Config: &docker.Config{}}
+	summary := &containerPump{
+		container:  &docker.Container{ID: "host-node1-summary", Name: "/node1-summary", Config: &docker.Config{}},
+		logstreams: map[chan *Message]*Route{},
+		ctx:        context.Background(),
+	}
+	p := &LogsPump{pumps: map[string]*containerPump{
+		"8dfafdbc3a40": {container: web}, "9cb3e1a7d2f5": {container: idle},
+	}, summary: summary}
+	summaries.shipped(web, &Message{Data: "hello"})
+	summaries.shipped(web, &Message{Data: "world!"})
+	summaries.failed(web)
+	WriteDeadLetters("r1", "buffer full", &Message{Container: web, Data: "dropped"})
+
+	logstream := make(chan *Message, 2)
+	summary.add(logstream, &Route{})
+	p.shipSummary(time.Date(2020, 11, 2, 10, 0, 0, 0, time.UTC), time.Hour)
+	p.shipSummary(time.Date(2020, 11, 2, 11, 0, 0, 0, time.UTC), time.Hour)
+	expected := []string{
+		`{"time":"2020-11-02T10:00:00Z","event":"summary","period":"1h0m0s","containers_attached":2,"events":2,"bytes":11,"dropped":1,"errors":1,` +
+			`"containers":[{"container_id":"9cb3e1a7d2f5","container_name":"idle","events":0,"bytes":0,"dropped":0,"errors":0},` +
+			`{"container_id":"8dfafdbc3a40","container_name":"web","events":2,"bytes":11,"dropped":1,"errors":1}]}`,
+		`{"time":"2020-11-02T11:00:00Z","event":"summary","period":"1h0m0s","containers_attached":2,"events":0,"bytes":0,"dropped":0,"errors":0,` +
+			`"containers":[{"container_id":"9cb3e1a7d2f5","container_name":"idle","events":0,"bytes":0,"dropped":0,"errors":0},` +
+			`{"container_id":"8dfafdbc3a40","container_name":"web","events":0,"bytes":0,"dropped":0,"errors":0}]}`,
+	}
+	for _, data := range expected {
+		msg := <-logstream
+		if msg.Source != SummarySource || msg.Data != data || msg.Container != summary.container {
+			t.Errorf("expected %s, got %s from %s", data, msg.Data, msg.Source)
+		}
+	}
+}
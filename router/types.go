@@ -1,11 +1,13 @@
-//go:generate go-extpoints . AdapterFactory HttpHandler AdapterTransport LogRouter Job
+//go:generate go-extpoints . AdapterFactory HttpHandler AdapterTransport LogRouter Job ErrorClassifier
 package router
 
 import (
+	"errors"
 	"net"
 	"net/http"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
@@ -22,11 +24,61 @@ type AdapterTransport interface {
 	Dial(addr string, options map[string]string) (net.Conn, error)
 }
 
+// ErrorClassifier is an extension type for classifying the errors of the
+// clients of adapters, like the AWS SDK. It returns false for errors it
+// does not know.
+type ErrorClassifier func(err error) (ErrorClass, bool)
+
 // LogAdapter is a streamed log
 type LogAdapter interface {
 	Stream(logstream chan *Message)
 }
 
+// HealthChecker is implemented by LogAdapters that can tell whether their
+// destination is currently accepting logs
+type HealthChecker interface {
+	Healthy() bool
+}
+
+// Flusher is implemented by LogAdapters holding messages after they were
+// streamed, like batching adapters. Flush returns once the messages of a
+// closed logstream were shipped or dead-lettered.
+type Flusher interface {
+	Flush()
+}
+
+// ContainerFlusher is implemented by LogAdapters holding messages after
+// they were streamed, like batching adapters, to ship those of a container
+// that exited right away, once they were streamed. It must not block.
+type ContainerFlusher interface {
+	FlushContainer(id string)
+}
+
+// StreamFlusher is implemented by LogAdapters naming the streams they ship
+// lines to, like cloudwatch://, to ship the lines they hold of a stream
+// right away, as asked through the flush API. It must not block, and
+// returns false when it cannot take the flush now, as others are pending.
+type StreamFlusher interface {
+	FlushStream(name string) bool
+}
+
+// Renderer is implemented by LogAdapters to return a message as they would
+// ship it, without shipping it, for the dry run API
+type Renderer interface {
+	Render(message *Message) ([]byte, error)
+}
+
+// ErrDropped is returned by Renderers that would drop a message rather than
+// ship it, like routes whose script drops it
+var ErrDropped = errors.New("dropped by the adapter")
+
+// Acknowledger is implemented by LogAdapters calling Ack on each message
+// once it was delivered or dead-lettered. The messages a spool forwards to
+// other adapters are acknowledged once the adapter took them.
+type Acknowledger interface {
+	Acknowledges()
+}
+
 // Job is a thing to be done
 type Job interface {
 	Run() error
@@ -54,6 +106,17 @@ type Message struct {
 	Source    string
 	Data      string
 	Time      time.Time
+	Seq       uint64 // among the messages of its container and source, from 1, if set
+	ack       func() // acknowledges it to the spool it was forwarded from, if any
+	exited    bool   // not a line: sent after the last of a container that exited
+}
+
+// Ack acknowledges that a message was delivered, or dead-lettered, for the
+// spool it was forwarded from not to forward it again after a restart
+func (m *Message) Ack() {
+	if m.ack != nil {
+		m.ack()
+	}
 }
 
 // Route represents what subset of logs should go where
@@ -67,9 +130,14 @@ type Route struct {
 	Address       string            `json:"address"`
 	Options       map[string]string `json:"options,omitempty"`
 	adapter       LogAdapter
+	buffer        *routeBuffer
+	shaper        *shaper   // nil if the bandwidth of the route is not limited
+	schedule      *schedule // nil if the route always ships logs
+	health        string    // what is done with the lines of containers starting, of the health.starting option
 	closed        bool
 	closer        chan struct{}
 	closerRcv     <-chan struct{} // used instead of closer when set
+	closeOnce     sync.Once
 }
 
 // AdapterType returns a route's adapter type string
@@ -99,9 +167,16 @@ func (r *Route) OverrideCloser(closer <-chan struct{}) {
 	r.closerRcv = closer
 }
 
-// Close sends true to a Route.closer
+// Close closes a Route.closer, which stops routing to it from every
+// LogRouter. It is safe to call more than once, and before the route is
+// routed.
 func (r *Route) Close() {
-	r.closer <- struct{}{}
+	r.closeOnce.Do(func() {
+		r.closed = true
+		if r.closer != nil {
+			close(r.closer)
+		}
+	})
 }
 
 func (r *Route) matchAll() bool {
@@ -145,13 +220,25 @@ func (r *Route) MatchContainer(id, name string, labels map[string]string) bool {
 
 // MatchMessage returns whether the Route is responsible for a given Message
 func (r *Route) MatchMessage(message *Message) bool {
+	return r.skipReason(message) == ""
+}
+
+// skipReason returns why the Route is not responsible for a message, or ""
+// if it is
+func (r *Route) skipReason(message *Message) string {
+	if r.schedule != nil && !r.schedule.active(time.Now()) {
+		return "outside the schedule"
+	}
+	if r.health == HealthDrop && Starting(message.Container) {
+		return "container starting"
+	}
 	if r.matchAll() {
-		return true
+		return ""
 	}
 	if len(r.FilterSources) > 0 && !contains(r.FilterSources, message.Source) {
-		return false
+		return "source not matched"
 	}
-	return true
+	return ""
 }
 
 func contains(strs []string, str string) bool {
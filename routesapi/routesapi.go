@@ -3,6 +3,7 @@ package routesapi
 import (
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 
@@ -32,9 +33,12 @@ func RoutesAPI() http.Handler {
 
 	r.HandleFunc("/routes/{id}", func(w http.ResponseWriter, req *http.Request) {
 		params := mux.Vars(req)
+		previous, _ := routes.Get(params["id"])
 		if ok := routes.Remove(params["id"]); !ok {
 			http.NotFound(w, req)
+			return
 		}
+		router.Audit(req, router.AuditEntry{Action: "route.remove", RouteID: params["id"], Previous: previous})
 	}).Methods("DELETE")
 
 	r.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
@@ -44,16 +48,27 @@ func RoutesAPI() http.Handler {
 	}).Methods("GET")
 
 	r.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
-		route := new(router.Route)
-		if err := unmarshal(req.Body, route); err != nil {
+		route, err := readRoute(req)
+		if err != nil {
 			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		err := routes.Add(route)
+		var previous *router.Route
+		if route.ID != "" {
+			previous, _ = routes.Get(route.ID)
+		}
+		err = routes.Add(route)
+		entry := router.AuditEntry{Action: "route.add", RouteID: route.ID, Route: route, Previous: previous}
+		if previous != nil {
+			entry.Action = "route.replace"
+		}
 		if err != nil {
+			entry.Error = err.Error()
+			router.Audit(req, entry)
 			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		router.Audit(req, entry)
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		w.Write(append(marshal(route), '\n'))
@@ -62,6 +77,15 @@ func RoutesAPI() http.Handler {
 	return r
 }
 
+// readRoute reads the route of a POST /routes, see router.ParseRoute
+func readRoute(req *http.Request) (*router.Route, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	return router.ParseRoute(body)
+}
+
 func marshal(obj interface{}) []byte {
 	bytes, err := json.MarshalIndent(obj, "", "  ")
 	if err != nil {
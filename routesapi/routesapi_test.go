@@ -0,0 +1,73 @@
+package routesapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+type testAdapter struct{}
+
+func (testAdapter) Stream(logstream chan *router.Message) {
+	for range logstream {
+	}
+}
+
+func init() {
+	router.AdapterFactories.Register(func(route *router.Route) (router.LogAdapter, error) {
+		return testAdapter{}, nil
+	}, "routesapitest")
+}
+
+func postRoute(t *testing.T, body string) (*router.Route, int) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/routes", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	RoutesAPI().ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		return nil, rec.Code
+	}
+	route := new(router.Route)
+	if err := json.Unmarshal(rec.Body.Bytes(), route); err != nil {
+		t.Fatal(err)
+	}
+	return route, rec.Code
+}
+
+func TestPostRouteURI(t *testing.T) {
+	route, code := postRoute(t, "routesapitest://collector:514?filter.name=*_db&format=ndjson\n")
+	if code != http.StatusCreated {
+		t.Fatalf("expected route URI to be created, got %d", code)
+	}
+	defer router.Routes.Remove(route.ID)
+	if route.ID == "" || route.Address != "collector:514" || route.FilterName != "*_db" || route.Options["format"] != "ndjson" {
+		t.Errorf("unexpected route from URI: %+v", route)
+	}
+	if added, _ := router.Routes.Get(route.ID); added == nil {
+		t.Error("expected route to be added")
+	}
+}
+
+func TestPostRouteJSONWithURI(t *testing.T) {
+	route, code := postRoute(t, `{"uri": "routesapitest://collector:514?format=ndjson", "filter_sources": ["stderr"], "options": {"append_tag": ".db"}}`)
+	if code != http.StatusCreated {
+		t.Fatalf("expected route to be created, got %d", code)
+	}
+	defer router.Routes.Remove(route.ID)
+	if route.Adapter != "routesapitest" || len(route.FilterSources) != 1 ||
+		route.Options["format"] != "ndjson" || route.Options["append_tag"] != ".db" {
+		t.Errorf("unexpected route from JSON: %+v", route)
+	}
+}
+
+func TestPostInvalidRoute(t *testing.T) {
+	for _, body := range []string{"", "missing://collector:514", `{"adapter": "missing"}`} {
+		if _, code := postRoute(t, body); code != http.StatusBadRequest {
+			t.Errorf("expected route %q to be rejected, got %d", body, code)
+		}
+	}
+}
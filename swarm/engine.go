@@ -0,0 +1,164 @@
+package swarm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// apiVersion is the version of the Docker API with the logs of services
+const apiVersion = "v1.29"
+
+// engine is a client of the few endpoints of the swarm API the agent uses,
+// which the Docker client of the pump predates. It connects to DOCKER_HOST
+// like the Docker client does.
+type engine struct {
+	base string
+	http *http.Client
+}
+
+func newEngine() (*engine, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("swarm: DOCKER_HOST: %s", err)
+	}
+	transport := &http.Transport{}
+	e := &engine{http: &http.Client{Transport: transport}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		e.base = "http://docker"
+	case "tcp", "http", "https":
+		e.base = "http://" + u.Host
+		if u.Scheme == "https" || os.Getenv("DOCKER_TLS_VERIFY") != "" {
+			if transport.TLSClientConfig, err = dockerTLS(); err != nil {
+				return nil, err
+			}
+			e.base = "https://" + u.Host
+		}
+	default:
+		return nil, fmt.Errorf("swarm: unsupported DOCKER_HOST %s", host)
+	}
+	return e, nil
+}
+
+// dockerTLS returns the TLS configuration of the certificates in
+// DOCKER_CERT_PATH, as the Docker CLI reads them
+func dockerTLS() (*tls.Config, error) {
+	dir := os.Getenv("DOCKER_CERT_PATH")
+	if dir == "" {
+		return nil, errors.New("swarm: DOCKER_CERT_PATH is not set")
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("swarm: %s", err)
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("swarm: %s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}, nil
+}
+
+// get sends a GET request, returning the body of a successful response for
+// the caller to close
+func (e *engine) get(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	u := e.base + "/" + apiVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("swarm: %s", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure) //nolint:errcheck
+		return nil, fmt.Errorf("swarm: GET %s: %s %s", path, resp.Status, strings.TrimSpace(failure.Message))
+	}
+	return resp.Body, nil
+}
+
+// getJSON decodes the response to a GET request into out
+func (e *engine) getJSON(ctx context.Context, path string, out interface{}) error {
+	body, err := e.get(ctx, path, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(out); err != nil {
+		return fmt.Errorf("swarm: GET %s: %s", path, err)
+	}
+	return nil
+}
+
+// info is what the agent uses of the response of /info
+type info struct {
+	Swarm struct {
+		NodeID           string
+		ControlAvailable bool // whether the node is a manager
+	}
+}
+
+// service is what the agent uses of a service
+type service struct {
+	ID   string
+	Spec struct {
+		Name         string
+		Labels       map[string]string
+		TaskTemplate struct {
+			ContainerSpec struct {
+				Image  string
+				Labels map[string]string
+				Env    []string
+				TTY    bool
+			}
+			LogDriver *struct {
+				Name string
+			}
+		}
+	}
+}
+
+// task is what the agent uses of a task
+type task struct {
+	ID        string
+	ServiceID string
+	NodeID    string
+	Slot      int
+}
+
+// node is what the agent uses of a node
+type node struct {
+	ID          string
+	Description struct {
+		Hostname string
+	}
+}
@@ -0,0 +1,428 @@
+// Package swarm runs logspout on a manager of a Docker swarm, reading the
+// logs of every service from the API of the swarm, like docker service
+// logs, instead of the containers of its host, so a single logspout ships
+// the logs of a whole small cluster. The lines of each task are shipped as
+// those of a container named and labelled like the container of the task.
+package swarm
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Labels Docker sets on the containers of tasks, which the containers of
+// the lines of tasks have too
+const (
+	ServiceIDLabel   = "com.docker.swarm.service.id"
+	ServiceNameLabel = "com.docker.swarm.service.name"
+	TaskIDLabel      = "com.docker.swarm.task.id"
+	TaskNameLabel    = "com.docker.swarm.task.name"
+	NodeIDLabel      = "com.docker.swarm.node.id"
+)
+
+// dockerPump is the name of the job and log router reading from Docker
+const dockerPump = "pump"
+
+// reconnectDelay is the wait before reading the logs of a service again,
+// once their stream ended
+const reconnectDelay = time.Second
+
+func init() {
+	a := &agent{
+		followers: map[string]*follower{},
+		tasks:     map[string]*taskContainer{},
+		nodes:     map[string]string{},
+		routes:    map[chan *router.Message]*router.Route{},
+	}
+	router.Jobs.Register(a, "swarm")
+	router.LogRouters.Register(a, "swarm")
+	cfg.Register(
+		cfg.Option{Name: "SWARM_SERVICES", Type: cfg.Bool, Default: "false",
+			Description: "read the logs of every service of the swarm from the manager logspout runs on, instead of the containers of its host"},
+		cfg.Option{Name: "SWARM_POLL_INTERVAL", Type: cfg.Duration, Default: "10s",
+			Description: "how often the services and tasks of the swarm are listed"},
+	)
+}
+
+// agent is the job following the logs of the services, and the log router
+// sending their lines to the routes
+type agent struct {
+	enabled  bool
+	interval time.Duration
+	engine   *engine
+
+	mu        sync.Mutex
+	followers map[string]*follower      // by service ID
+	tasks     map[string]*taskContainer // by task ID
+	nodes     map[string]string         // host names by node ID
+
+	routesMu sync.Mutex // not mu, for slow routes not to hold up the scans
+	routes   map[chan *router.Message]*router.Route
+}
+
+// taskContainer is the container the lines of a task are shipped as, and
+// why they are not if they are ignored
+type taskContainer struct {
+	container *docker.Container
+	ignored   string
+}
+
+// follower reads the logs of a service until it is cancelled
+type follower struct {
+	service *service
+	cancel  context.CancelFunc
+}
+
+func (a *agent) Name() string {
+	if !a.enabled {
+		return ""
+	}
+	return "swarm"
+}
+
+// Setup replaces the pump reading from Docker, if SWARM_SERVICES is set
+// and logspout runs on a manager
+func (a *agent) Setup() error {
+	a.enabled = cfg.GetBool("SWARM_SERVICES")
+	if !a.enabled {
+		router.LogRouters.Unregister("swarm")
+		return nil
+	}
+	a.interval = cfg.GetDuration("SWARM_POLL_INTERVAL")
+	var err error
+	if a.engine, err = newEngine(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var i info
+	if err := a.engine.getJSON(ctx, "/info", &i); err != nil {
+		return err
+	}
+	if !i.Swarm.ControlAvailable {
+		return fmt.Errorf("swarm: SWARM_SERVICES needs logspout to run on a manager of a swarm")
+	}
+	router.Jobs.Unregister(dockerPump)
+	router.LogRouters.Unregister(dockerPump)
+	return nil
+}
+
+func (a *agent) Run() error {
+	if !a.enabled {
+		select {}
+	}
+	first := true
+	for {
+		if err := a.scan(first); err != nil {
+			log.Println(err)
+		}
+		first = false
+		time.Sleep(a.interval)
+	}
+}
+
+// scan follows the logs of new services, from now on for those there when
+// logspout started, stops following those that are gone, and lists the
+// tasks of the swarm
+func (a *agent) scan(existing bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var services []*service
+	if err := a.engine.getJSON(ctx, "/services", &services); err != nil {
+		return err
+	}
+	var tasks []*task
+	if err := a.engine.getJSON(ctx, "/tasks", &tasks); err != nil {
+		return err
+	}
+	since := time.Unix(0, 0)
+	if existing { // like the pump, from when logspout started
+		since = time.Now()
+	}
+
+	a.mu.Lock()
+	found := map[string]*service{}
+	for _, s := range services {
+		found[s.ID] = s
+		if f, following := a.followers[s.ID]; following {
+			f.service = s // its labels may have changed
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		f := &follower{service: s, cancel: cancel}
+		a.followers[s.ID] = f
+		router.Go("swarm.follow", func() { a.follow(ctx, f, since) })
+	}
+	for id, f := range a.followers {
+		if found[id] == nil {
+			f.cancel()
+			delete(a.followers, id)
+		}
+	}
+	var added []*task
+	for _, t := range tasks {
+		if a.tasks[t.ID] == nil && found[t.ServiceID] != nil {
+			added = append(added, t)
+		}
+	}
+	a.mu.Unlock()
+
+	containers := map[string]*taskContainer{}
+	for _, t := range added { // not holding mu, as the host names of nodes are fetched
+		containers[t.ID] = a.newTaskContainer(ctx, found[t.ServiceID], t)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, tc := range containers {
+		if a.tasks[id] == nil {
+			a.tasks[id] = tc
+		}
+	}
+	// only the tasks Docker still lists are remembered
+	listed := map[string]bool{}
+	for _, t := range tasks {
+		listed[t.ID] = true
+	}
+	for id := range a.tasks {
+		if !listed[id] {
+			delete(a.tasks, id)
+		}
+	}
+	return nil
+}
+
+// follow reads the logs of a service, reading them again from the last
+// line read when their stream ends, until cancelled
+func (a *agent) follow(ctx context.Context, f *follower, since time.Time) {
+	a.mu.Lock()
+	id, name, tty := f.service.ID, f.service.Spec.Name, f.service.Spec.TaskTemplate.ContainerSpec.TTY
+	a.mu.Unlock()
+	for ctx.Err() == nil {
+		query := url.Values{
+			"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"},
+			"timestamps": {"1"}, "details": {"1"},
+			"since": {fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())},
+		}
+		body, err := a.engine.get(ctx, "/services/"+id+"/logs", query)
+		if err == nil {
+			err = readStream(body, tty, func(source, line string) {
+				if logged := a.line(ctx, f, source, line); logged.After(since) {
+					since = logged
+				}
+			})
+			body.Close()
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("swarm: service %s: %s\n", name, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(reconnectDelay):
+		}
+		since = since.Add(time.Nanosecond) // after the last line read
+	}
+}
+
+// line sends a line of the logs of a service to the routes of its task, and
+// returns its time
+func (a *agent) line(ctx context.Context, f *follower, source, text string) time.Time {
+	logged, details, data := parseLine(text)
+	taskID, nodeID := details[TaskIDLabel], details[NodeIDLabel]
+	a.mu.Lock()
+	tc := a.tasks[taskID]
+	service := f.service
+	a.mu.Unlock()
+	if tc == nil {
+		// started since the last scan
+		t := &task{ID: taskID, ServiceID: service.ID}
+		if err := a.engine.getJSON(ctx, "/tasks/"+taskID, t); err != nil {
+			t.NodeID = nodeID
+		}
+		tc = a.newTaskContainer(ctx, service, t)
+		a.mu.Lock()
+		a.tasks[taskID] = tc
+		a.mu.Unlock()
+	}
+	if tc.ignored == "" {
+		a.send(&router.Message{Container: tc.container, Source: source, Data: data, Time: logged})
+	}
+	return logged
+}
+
+// newTaskContainer returns the container the lines of a task are shipped
+// as, named like Docker names the containers of tasks
+func (a *agent) newTaskContainer(ctx context.Context, s *service, t *task) *taskContainer {
+	spec := s.Spec.TaskTemplate.ContainerSpec
+	labels := map[string]string{}
+	for key, value := range s.Spec.Labels {
+		labels[key] = value
+	}
+	for key, value := range spec.Labels {
+		labels[key] = value
+	}
+	name := s.Spec.Name + "." + strconv.Itoa(t.Slot) + "." + t.ID
+	if t.Slot == 0 { // of global services
+		name = s.Spec.Name + "." + t.NodeID + "." + t.ID
+	}
+	labels[ServiceIDLabel], labels[ServiceNameLabel] = s.ID, s.Spec.Name
+	labels[TaskIDLabel], labels[TaskNameLabel], labels[NodeIDLabel] = t.ID, name, t.NodeID
+	driver := "json-file"
+	if s.Spec.TaskTemplate.LogDriver != nil && s.Spec.TaskTemplate.LogDriver.Name != "" {
+		driver = s.Spec.TaskTemplate.LogDriver.Name
+	}
+	container := &docker.Container{
+		ID:   t.ID,
+		Name: "/" + name,
+		Config: &docker.Config{
+			Hostname: a.hostname(ctx, t.NodeID),
+			Image:    spec.Image,
+			Env:      spec.Env,
+			Labels:   labels,
+			Tty:      spec.TTY,
+		},
+		HostConfig: &docker.HostConfig{LogConfig: docker.LogConfig{Type: driver}},
+	}
+	return &taskContainer{container: container, ignored: router.IgnoreReason(container)}
+}
+
+// hostname returns the host name of a node, or its ID if it is unknown
+func (a *agent) hostname(ctx context.Context, nodeID string) string {
+	a.mu.Lock()
+	hostname, known := a.nodes[nodeID]
+	a.mu.Unlock()
+	if known || nodeID == "" {
+		return hostname
+	}
+	var n node
+	if err := a.engine.getJSON(ctx, "/nodes/"+nodeID, &n); err != nil {
+		return nodeID
+	}
+	a.mu.Lock()
+	a.nodes[nodeID] = n.Description.Hostname
+	a.mu.Unlock()
+	return n.Description.Hostname
+}
+
+// send sends a line to the routes of its container
+func (a *agent) send(msg *router.Message) {
+	if router.Filtered(msg.Container) {
+		return
+	}
+	a.routesMu.Lock()
+	defer a.routesMu.Unlock()
+	for logstream, route := range a.routes {
+		if !route.MatchContainer(msg.Container.ID, strings.TrimPrefix(msg.Container.Name, "/"), msg.Container.Config.Labels) ||
+			!route.MatchMessage(msg) {
+			continue
+		}
+		logstream <- msg
+	}
+}
+
+// Route sends the lines of the tasks matching a route to it, until the
+// route is closed
+func (a *agent) Route(route *router.Route, logstream chan *router.Message) {
+	a.routesMu.Lock()
+	a.routes[logstream] = route
+	a.routesMu.Unlock()
+	<-route.Closer()
+	a.routesMu.Lock()
+	delete(a.routes, logstream)
+	a.routesMu.Unlock()
+}
+
+// RoutingFrom returns whether the lines of a task are shipped
+func (a *agent) RoutingFrom(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for taskID, tc := range a.tasks {
+		if strings.HasPrefix(taskID, id) && tc.ignored == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// readStream calls each for the lines of a stream of logs, multiplexed with
+// the headers of Docker unless the service has a TTY
+func readStream(r io.Reader, tty bool, each func(source, line string)) error {
+	if tty {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			each("stdout", scanner.Text())
+		}
+		return scanner.Err()
+	}
+	partial := map[string]string{} // of lines split across frames
+	reader := bufio.NewReader(r)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		source := "stdout"
+		if header[0] == 2 {
+			source = "stderr"
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return err
+		}
+		text := partial[source] + string(payload)
+		for {
+			i := strings.IndexByte(text, '\n')
+			if i < 0 {
+				break
+			}
+			each(source, text[:i])
+			text = text[i+1:]
+		}
+		partial[source] = text
+	}
+}
+
+// parseLine splits a line of the logs of a service, read with timestamps
+// and details, into its time, details and text
+func parseLine(line string) (time.Time, map[string]string, string) {
+	details := map[string]string{}
+	parts := strings.SplitN(line, " ", 3)
+	logged, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Now(), details, line
+	}
+	if len(parts) < 2 {
+		return logged, details, ""
+	}
+	if len(parts) == 3 && strings.Contains(parts[1], TaskIDLabel+"=") {
+		for _, pair := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) == 2 {
+				value, err := url.QueryUnescape(kv[1])
+				if err != nil {
+					value = kv[1]
+				}
+				details[kv[0]] = value
+			}
+		}
+		return logged, details, parts[2]
+	}
+	return logged, details, strings.Join(parts[1:], " ")
+}
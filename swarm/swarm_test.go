@@ -0,0 +1,127 @@
+package swarm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func frame(source byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = source
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+func TestReadStream(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(frame(1, "first\nsec"))
+	stream.Write(frame(2, "error\n"))
+	stream.Write(frame(1, "ond\n"))
+	var lines []string
+	err := readStream(&stream, false, func(source, line string) { lines = append(lines, source+" "+line) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "stdout first|stderr error|stdout second"
+	if strings.Join(lines, "|") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(lines, "|"))
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	logged, details, data := parseLine("2020-11-02T10:00:00.000000001Z " +
+		"com.docker.swarm.node.id=n1,com.docker.swarm.service.id=s1,com.docker.swarm.task.id=t1 hello world")
+	if !logged.Equal(time.Date(2020, 11, 2, 10, 0, 0, 1, time.UTC)) {
+		t.Errorf("unexpected time %s", logged)
+	}
+	if details[TaskIDLabel] != "t1" || details[NodeIDLabel] != "n1" || data != "hello world" {
+		t.Errorf("unexpected details %v and data %q", details, data)
+	}
+	if _, details, data := parseLine("2020-11-02T10:00:00Z no details"); len(details) != 0 || data != "no details" {
+		t.Errorf("unexpected details %v and data %q", details, data)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	logs := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		write := func(v interface{}) { json.NewEncoder(w).Encode(v) } //nolint:errcheck
+		switch strings.TrimPrefix(req.URL.Path, "/"+apiVersion) {
+		case "/info":
+			write(map[string]interface{}{"Swarm": map[string]interface{}{"NodeID": "n1", "ControlAvailable": true}})
+		case "/services":
+			write([]map[string]interface{}{{"ID": "s1", "Spec": map[string]interface{}{
+				"Name": "web", "Labels": map[string]string{"team": "core"},
+				"TaskTemplate": map[string]interface{}{"ContainerSpec": map[string]interface{}{"Image": "nginx"}},
+			}}})
+		case "/tasks/t1":
+			write(map[string]interface{}{"ID": "t1", "ServiceID": "s1", "NodeID": "n1", "Slot": 2})
+		case "/tasks":
+			write([]map[string]interface{}{{"ID": "t1", "ServiceID": "s1", "NodeID": "n1", "Slot": 2}})
+		case "/nodes/n1":
+			write(map[string]interface{}{"ID": "n1", "Description": map[string]string{"Hostname": "manager-1"}})
+		case "/services/s1/logs":
+			select {
+			case logs <- req.URL.Query().Get("since"):
+			default:
+			}
+			w.Write(frame(1, "2020-11-02T10:00:00Z com.docker.swarm.node.id=n1,com.docker.swarm.task.id=t1 hello\n")) //nolint:errcheck
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	os.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+	defer os.Unsetenv("DOCKER_HOST")
+
+	engine, err := newEngine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &agent{
+		engine:    engine,
+		followers: map[string]*follower{},
+		tasks:     map[string]*taskContainer{},
+		nodes:     map[string]string{},
+		routes:    map[chan *router.Message]*router.Route{},
+	}
+	route := &router.Route{ID: "test"}
+	logstream := make(chan *router.Message, 10)
+	go a.Route(route, logstream)
+	defer route.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := a.scan(false); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, f := range a.followers {
+			f.cancel()
+		}
+	}()
+	if since := <-logs; since != "0.000000000" {
+		t.Errorf("expected the logs of a new service from the start, got since %s", since)
+	}
+	select {
+	case msg := <-logstream:
+		c := msg.Container
+		if msg.Data != "hello" || c.ID != "t1" || c.Name != "/web.2.t1" || c.Config.Hostname != "manager-1" ||
+			c.Config.Labels["team"] != "core" || c.Config.Labels[ServiceNameLabel] != "web" {
+			t.Errorf("unexpected message %q from %s %+v", msg.Data, c.Name, c.Config)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a message")
+	}
+	if !a.RoutingFrom("t1") {
+		t.Error("expected the task to be routed")
+	}
+}
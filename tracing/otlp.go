@@ -0,0 +1,212 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	exportInterval = 5 * time.Second
+	maxQueuedSpans = 2048 // spans ended while the queue is full are dropped
+	maxExportBatch = 512
+)
+
+// otlpExporter sends ended spans to a collector in batches, as the JSON
+// encoding of OTLP over HTTP
+type otlpExporter struct {
+	url      string
+	headers  map[string]string
+	resource otlpResource
+	client   *http.Client
+	spans    chan *Span
+	dropped  uint64 // since the last export, accessed atomically
+}
+
+func newExporter(endpoint, headers, service string) *otlpExporter {
+	e := &otlpExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers: map[string]string{},
+		client:  &http.Client{Timeout: 10 * time.Second},
+		spans:   make(chan *Span, maxQueuedSpans),
+	}
+	for _, header := range strings.Split(headers, ",") {
+		if parts := strings.SplitN(header, "=", 2); len(parts) == 2 {
+			e.headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	hostname, _ := os.Hostname()
+	e.resource.Attributes = otlpAttributes(map[string]interface{}{
+		"service.name": service,
+		"host.name":    hostname,
+	})
+	return e
+}
+
+// queue adds an ended span to the next export, without blocking
+func (e *otlpExporter) queue(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+func (e *otlpExporter) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.spans:
+			if batch = append(batch, s); len(batch) < maxExportBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		if dropped := atomic.SwapUint64(&e.dropped, 0); dropped > 0 {
+			log.Printf("tracing: dropped %d spans, the export queue was full\n", dropped)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("tracing: dropped %d spans: %s\n", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+// export sends spans to the collector
+func (e *otlpExporter) export(spans []*Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("exporting to %s: %s", e.url, resp.Status)
+	}
+	return nil
+}
+
+// the JSON encoding of OTLP, see the opentelemetry-proto repository
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is an error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func (e *otlpExporter) request(spans []*Span) otlpRequest {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scope.Scope.Name = "github.com/gliderlabs/logspout"
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attributes),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != "" {
+			span.Status = &otlpStatus{Code: statusCodeError, Message: s.err}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{Resource: e.resource, ScopeSpans: []otlpScopeSpans{scope}}}}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, key := range keys {
+		value := attributes[key]
+		var typed map[string]interface{}
+		switch v := value.(type) {
+		case bool:
+			typed = map[string]interface{}{"boolValue": v}
+		case int:
+			typed = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			typed = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			typed = map[string]interface{}{"doubleValue": v}
+		default:
+			typed = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: key, Value: typed})
+	}
+	return encoded
+}
@@ -0,0 +1,169 @@
+// Package tracing records spans of the log pipeline, like attaching to
+// containers and submitting batches, and exports them to an OpenTelemetry
+// collector with OTLP over HTTP, so operators see where latency
+// accumulates. Spans are only recorded when OTEL_EXPORTER_OTLP_ENDPOINT is
+// set, and all methods do nothing on the nil spans returned otherwise.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mathrand "math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// LockWaitThreshold is how long Lock waits before the wait is recorded
+const LockWaitThreshold = time.Millisecond
+
+func init() {
+	cfg.Register(
+		cfg.Option{Name: "OTEL_EXPORTER_OTLP_ENDPOINT",
+			Description: "URL of the OTLP/HTTP collector spans are exported to, eg: http://localhost:4318"},
+		cfg.Option{Name: "OTEL_EXPORTER_OTLP_HEADERS", Secret: true,
+			Description: "headers sent with exported spans, as key=value separated by ,"},
+		cfg.Option{Name: "OTEL_SERVICE_NAME", Default: "logspout", Description: "service name of exported spans"},
+		cfg.Option{Name: "OTEL_TRACES_SAMPLER_ARG", Default: "1", Validate: validateRatio,
+			Description: "ratio of traces recorded, from 0 to 1"},
+	)
+}
+
+var (
+	setupOnce sync.Once
+	exporter  *otlpExporter // nil unless spans are exported
+	ratio     float64
+)
+
+// setup starts exporting spans if configured, when the first span starts,
+// which is after the configuration was parsed
+func setup() {
+	endpoint := cfg.GetString("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return
+	}
+	ratio, _ = strconv.ParseFloat(cfg.GetString("OTEL_TRACES_SAMPLER_ARG"), 64)
+	exporter = newExporter(endpoint, cfg.GetString("OTEL_EXPORTER_OTLP_HEADERS"), cfg.GetString("OTEL_SERVICE_NAME"))
+	go exporter.run()
+}
+
+// Span is a timed operation of a trace
+type Span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	start, end time.Time
+	attributes map[string]interface{}
+	err        string
+}
+
+// Start begins a span, as a child of parent, or of a new trace if parent
+// is nil. It returns nil if spans are not recorded, or the trace was not
+// sampled.
+func Start(parent *Span, name string) *Span {
+	return StartAt(parent, name, time.Now())
+}
+
+// StartAt begins a span at the given time, for operations timed before
+// knowing whether they are worth a span
+func StartAt(parent *Span, name string, start time.Time) *Span {
+	setupOnce.Do(setup)
+	if exporter == nil {
+		return nil
+	}
+	s := &Span{name: name, start: start}
+	if parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		if ratio < 1 && mathrand.Float64() >= ratio { //nolint:gosec
+			return nil
+		}
+		rand.Read(s.traceID[:]) //nolint:errcheck
+	}
+	rand.Read(s.spanID[:]) //nolint:errcheck
+	return s
+}
+
+// Set sets an attribute of the span, a string, bool, int or float64
+func (s *Span) Set(key string, value interface{}) *Span {
+	if s == nil {
+		return nil
+	}
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+	s.attributes[key] = value
+	return s
+}
+
+// Fail marks the span as failed with err, if not nil
+func (s *Span) Fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// End ends the span, and queues it to be exported
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends the span at the given time
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.end = end
+	exporter.queue(s)
+}
+
+// TraceID returns the ID of the trace of the span, in hex
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// Lock locks l, recording the wait as a child span of parent when it took
+// longer than the LockWaitThreshold
+func Lock(parent *Span, name string, l sync.Locker) {
+	if parent == nil {
+		l.Lock()
+		return
+	}
+	start := time.Now()
+	l.Lock()
+	if end := time.Now(); end.Sub(start) >= LockWaitThreshold {
+		StartAt(parent, name, start).EndAt(end)
+	}
+}
+
+type contextKey struct{}
+
+// ContextWith returns a context carrying the span, for calls taking one
+func ContextWith(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the span of a context, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
+
+func validateRatio(value string) error {
+	if f, err := strconv.ParseFloat(value, 64); err != nil || f < 0 || f > 1 {
+		return errors.New("must be a number from 0 to 1")
+	}
+	return nil
+}
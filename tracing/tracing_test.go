@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDisabledSpans(t *testing.T) {
+	if span := (*Span)(nil).Set("key", "value"); span != nil {
+		t.Error("expected attributes of a nil span to be ignored")
+	}
+	var span *Span
+	span.Fail(errors.New("ignored"))
+	span.End()
+	if id := span.TraceID(); id != "" {
+		t.Errorf("expected no trace ID of a nil span, got %s", id)
+	}
+}
+
+func TestExport(t *testing.T) {
+	received := make(chan otlpRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var decoded otlpRequest
+		if req.URL.Path != "/v1/traces" || req.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("unexpected export to %s", req.URL.Path)
+		}
+		if err := json.NewDecoder(req.Body).Decode(&decoded); err != nil {
+			t.Error(err)
+		}
+		received <- decoded
+	}))
+	defer server.Close()
+	setupOnce.Do(func() {})
+	exporter, ratio = newExporter(server.URL+"/", "Authorization=Bearer secret", "logspout"), 1
+	defer func() { exporter = nil }()
+
+	parent := Start(nil, "cloudwatch.batch").Set("events", 3)
+	child := StartAt(parent, "aws.PutLogEvents", time.Now().Add(-time.Second))
+	child.Fail(errors.New("throttled"))
+	child.End()
+	parent.End()
+	if err := exporter.export([]*Span{<-exporter.spans, <-exporter.spans}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := (<-received).ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "aws.PutLogEvents" || spans[1].Name != "cloudwatch.batch" {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+	if spans[0].TraceID != parent.TraceID() || spans[0].ParentSpanID != spans[1].SpanID || spans[1].ParentSpanID != "" {
+		t.Errorf("expected the child to be in the trace of its parent: %+v", spans)
+	}
+	if spans[0].Status == nil || spans[0].Status.Message != "throttled" {
+		t.Errorf("expected the child to have failed, got %+v", spans[0].Status)
+	}
+	if attr := spans[1].Attributes; len(attr) != 1 || attr[0].Key != "events" || attr[0].Value["intValue"] != "3" {
+		t.Errorf("unexpected attributes: %+v", attr)
+	}
+}
@@ -0,0 +1,330 @@
+// Package pool spreads the messages written to one connection across a
+// pool of connections to several endpoints.
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultRetryAfter     = 10 * time.Second
+	defaultHealthInterval = 5 * time.Second
+)
+
+func init() {
+	cfg.Register(cfg.Option{Name: "RESOLVE_INTERVAL", Validate: validateInterval,
+		Description: "re-resolve the host names of tcp and tls routes on this interval"})
+}
+
+func validateInterval(value string) error {
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		return errors.New("must be a duration, eg: 30s")
+	}
+	return nil
+}
+
+// DialFunc connects to a single endpoint
+type DialFunc func(addr string) (net.Conn, error)
+
+// Enabled returns whether the route options configure more than one
+// endpoint, or re-resolving endpoints on an interval
+func Enabled(options map[string]string) bool {
+	return options["endpoints"] != "" || resolveInterval(options) != ""
+}
+
+// resolveInterval returns the resolve_interval route option, or the
+// RESOLVE_INTERVAL env var
+func resolveInterval(options map[string]string) string {
+	if s := options["resolve_interval"]; s != "" {
+		return s
+	}
+	return cfg.GetString("RESOLVE_INTERVAL")
+}
+
+type endpoint struct {
+	addr      string
+	weight    int
+	current   int // smooth weighted round-robin state
+	conn      net.Conn
+	downUntil time.Time
+}
+
+// Conn is a net.Conn that writes each message to the next healthy endpoint,
+// in weighted round-robin order. Endpoints that fail to connect or to accept
+// a write are skipped until retry_after has passed, and are reconnected in the
+// background every health_interval. With a resolve_interval, endpoints are
+// reconnected when their host name resolves to a new address.
+type Conn struct {
+	mu         sync.Mutex
+	dial       DialFunc
+	endpoints  []*endpoint
+	last       *endpoint
+	retryAfter time.Duration
+	deadline   time.Time
+	done       chan struct{}
+}
+
+// parseEndpoints returns the endpoints of the route address and of the
+// endpoints option, eg: collector-b:514|collector-c:514@2
+func parseEndpoints(addr string, options map[string]string) ([]*endpoint, error) {
+	endpoints := []*endpoint{{addr: addr, weight: 1}}
+	for _, s := range strings.Split(options["endpoints"], "|") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		e := &endpoint{addr: s, weight: 1}
+		if i := strings.LastIndex(s, "@"); i >= 0 {
+			weight, err := strconv.Atoi(s[i+1:])
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid endpoint weight: %s", s)
+			}
+			e.addr, e.weight = s[:i], weight
+		}
+		if _, _, err := net.SplitHostPort(e.addr); err != nil {
+			return nil, fmt.Errorf("invalid endpoint %s: %s", s, err)
+		}
+		endpoints = append(endpoints, e)
+	}
+	if w := options["weight"]; w != "" {
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid value for weight: %s", w)
+		}
+		endpoints[0].weight = weight
+	}
+	return endpoints, nil
+}
+
+func getDuration(options map[string]string, key string, dfault time.Duration) (time.Duration, error) {
+	s := options[key]
+	if s == "" {
+		return dfault, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s (must be duration): %s", key, s)
+	}
+	return d, nil
+}
+
+// Dial connects to the route address and the endpoints of the route options.
+// It succeeds as long as one endpoint can be connected.
+func Dial(dial DialFunc, addr string, options map[string]string) (*Conn, error) {
+	endpoints, err := parseEndpoints(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	retryAfter, err := getDuration(options, "retry_after", defaultRetryAfter)
+	if err != nil {
+		return nil, err
+	}
+	healthInterval, err := getDuration(options, "health_interval", defaultHealthInterval)
+	if err != nil {
+		return nil, err
+	}
+	var resolveEvery time.Duration
+	if s := resolveInterval(options); s != "" {
+		if resolveEvery, err = time.ParseDuration(s); err != nil || resolveEvery <= 0 {
+			return nil, fmt.Errorf("invalid value for resolve_interval (must be duration): %s", s)
+		}
+	}
+	c := &Conn{dial: dial, endpoints: endpoints, retryAfter: retryAfter, done: make(chan struct{})}
+	var lastErr error
+	for _, e := range endpoints {
+		if lastErr = c.connect(e); lastErr == nil {
+			c.last = e
+		}
+	}
+	if c.last == nil {
+		return nil, lastErr
+	}
+	go c.checkHealth(healthInterval)
+	if resolveEvery > 0 {
+		go c.resolveLoop(resolveEvery)
+	}
+	return c, nil
+}
+
+// connect dials an endpoint, it must be called with the lock held or before
+// the Conn is shared
+func (c *Conn) connect(e *endpoint) error {
+	conn, err := c.dial(e.addr)
+	if err != nil {
+		c.markDown(e, err)
+		return err
+	}
+	if !c.deadline.IsZero() {
+		conn.SetDeadline(c.deadline) //nolint:errcheck
+	}
+	e.conn, e.downUntil = conn, time.Time{}
+	return nil
+}
+
+func (c *Conn) markDown(e *endpoint, err error) {
+	if e.downUntil.IsZero() {
+		log.Printf("pool: endpoint %s is down: %s\n", e.addr, err)
+	}
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+	e.downUntil = time.Now().Add(c.retryAfter)
+}
+
+// checkHealth reconnects endpoints that are down once retry_after has passed,
+// so they are ready before messages are written to them
+func (c *Conn) checkHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		for _, e := range c.endpoints {
+			if e.conn == nil && time.Now().After(e.downUntil) {
+				if c.connect(e) == nil {
+					log.Printf("pool: endpoint %s is up\n", e.addr)
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// next returns the next endpoint in smooth weighted round-robin order that
+// is not down, see https://github.com/phusion/nginx/commit/27e94984486058d73157038f7950a0a36ecc6e35
+func (c *Conn) next(skip map[*endpoint]bool) *endpoint {
+	var best *endpoint
+	total := 0
+	for _, e := range c.endpoints {
+		if skip[e] || (e.conn == nil && time.Now().Before(e.downUntil)) {
+			continue
+		}
+		e.current += e.weight
+		total += e.weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	if best != nil {
+		best.current -= total
+	}
+	return best
+}
+
+// Write writes b to the next healthy endpoint, trying the others in turn if
+// it fails
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := errors.New("pool: all endpoints are down")
+	tried := map[*endpoint]bool{}
+	for e := c.next(tried); e != nil; e = c.next(tried) {
+		tried[e] = true
+		if e.conn == nil {
+			if err = c.connect(e); err != nil {
+				continue
+			}
+		}
+		var n int
+		if n, err = e.conn.Write(b); err == nil {
+			c.last = e
+			return n, nil
+		}
+		c.markDown(e, err)
+	}
+	return 0, err
+}
+
+// Read is not supported, log adapters only write to their connection
+func (c *Conn) Read(b []byte) (int, error) {
+	return 0, errors.New("pool: read not supported")
+}
+
+// Close closes the connections to all endpoints
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return nil
+	default:
+		close(c.done)
+	}
+	for _, e := range c.endpoints {
+		if e.conn != nil {
+			e.conn.Close()
+			e.conn = nil
+		}
+	}
+	return nil
+}
+
+// CloseWrite shuts down the writing side of stream connections, which also
+// identifies a Conn as a stream connection for adapters
+func (c *Conn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.endpoints {
+		if cw, ok := e.conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite() //nolint:errcheck
+		}
+	}
+	return nil
+}
+
+// LocalAddr returns the local address of the last used endpoint connection
+func (c *Conn) LocalAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil || c.last.conn == nil {
+		return nil
+	}
+	return c.last.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the last used endpoint connection
+func (c *Conn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil || c.last.conn == nil {
+		return nil
+	}
+	return c.last.conn.RemoteAddr()
+}
+
+// SetDeadline sets the deadline of all endpoint connections
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	for _, e := range c.endpoints {
+		if e.conn != nil {
+			e.conn.SetDeadline(t) //nolint:errcheck
+		}
+	}
+	return nil
+}
+
+// SetReadDeadline is a no-op, since Read is not supported
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of all endpoint connections
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
@@ -0,0 +1,137 @@
+package pool
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// fakeConn counts writes per endpoint, and fails them while its endpoint
+// is marked as broken
+type fakeConn struct {
+	net.Conn
+	net    *fakeNet
+	addr   string
+	remote net.Addr
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) {
+	c.net.mu.Lock()
+	defer c.net.mu.Unlock()
+	if c.net.broken[c.addr] {
+		return 0, errors.New("broken pipe")
+	}
+	c.net.writes[c.addr]++
+	return len(b), nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) RemoteAddr() net.Addr { return c.remote }
+
+type fakeNet struct {
+	mu     sync.Mutex
+	broken map[string]bool
+	writes map[string]int
+	ips    map[string]string // host names to the IP they resolve to
+}
+
+func (n *fakeNet) dial(addr string) (net.Conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.broken[addr] {
+		return nil, errors.New("connection refused")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return &fakeConn{net: n, addr: addr, remote: &net.TCPAddr{IP: net.ParseIP(n.ips[host]), Port: 514}}, nil
+}
+
+func (n *fakeNet) lookupHost(host string) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return []string{n.ips[host]}, nil
+}
+
+func TestPoolWeightedRoundRobin(t *testing.T) {
+	n := &fakeNet{broken: map[string]bool{}, writes: map[string]int{}}
+	conn, err := Dial(n.dial, "a:514", map[string]string{"endpoints": "b:514|c:514@2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 8; i++ {
+		if _, err := conn.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for addr, count := range map[string]int{"a:514": 2, "b:514": 2, "c:514": 4} {
+		if n.writes[addr] != count {
+			t.Errorf("expected %d writes to %s, got %d", count, addr, n.writes[addr])
+		}
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	n := &fakeNet{broken: map[string]bool{"b:514": true}, writes: map[string]int{}}
+	conn, err := Dial(n.dial, "a:514", map[string]string{"endpoints": "b:514"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 4; i++ {
+		if _, err := conn.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n.writes["a:514"] != 4 {
+		t.Errorf("expected all writes to a:514 while b:514 is down, got %v", n.writes)
+	}
+
+	n.mu.Lock()
+	n.broken["a:514"] = true
+	n.mu.Unlock()
+	if _, err := conn.Write([]byte("hello\n")); err == nil {
+		t.Error("expected error when all endpoints are down, got nil")
+	}
+}
+
+func TestPoolInvalidOptions(t *testing.T) {
+	n := &fakeNet{broken: map[string]bool{}, writes: map[string]int{}}
+	for _, options := range []map[string]string{
+		{"endpoints": "b:514@0"},
+		{"endpoints": "nope"},
+		{"endpoints": "b:514", "retry_after": "later"},
+		{"endpoints": "b:514", "weight": "heavy"},
+	} {
+		if _, err := Dial(n.dial, "a:514", options); err == nil {
+			t.Errorf("expected error for %v, got nil", options)
+		}
+	}
+}
+
+func TestPoolResolve(t *testing.T) {
+	n := &fakeNet{broken: map[string]bool{}, writes: map[string]int{}, ips: map[string]string{"a": "10.0.0.1"}}
+	lookupHost = n.lookupHost
+	defer func() { lookupHost = net.LookupHost }()
+	conn, err := Dial(n.dial, "a:514", map[string]string{"resolve_interval": "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.resolve()
+	if ip := conn.RemoteAddr().(*net.TCPAddr).IP.String(); ip != "10.0.0.1" {
+		t.Errorf("expected connection to stay on 10.0.0.1, got %s", ip)
+	}
+	n.mu.Lock()
+	n.ips["a"] = "10.0.0.2"
+	n.mu.Unlock()
+	conn.resolve()
+	if ip := conn.RemoteAddr().(*net.TCPAddr).IP.String(); ip != "10.0.0.2" {
+		t.Errorf("expected reconnect to 10.0.0.2, got %s", ip)
+	}
+	if !Enabled(map[string]string{"resolve_interval": "30s"}) || Enabled(map[string]string{}) {
+		t.Error("expected the pool to be enabled by resolve_interval only")
+	}
+}
@@ -0,0 +1,84 @@
+package pool
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// lookupHost is replaced in tests
+var lookupHost = net.LookupHost
+
+// resolveLoop reconnects endpoints every interval when the address
+// their host name resolves to has changed, eg: after a DNS based failover
+func (c *Conn) resolveLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.resolve()
+		}
+	}
+}
+
+func (c *Conn) resolve() {
+	type connected struct {
+		e    *endpoint
+		conn net.Conn
+	}
+	c.mu.Lock()
+	var current []connected
+	for _, e := range c.endpoints {
+		if e.conn != nil {
+			current = append(current, connected{e, e.conn})
+		}
+	}
+	c.mu.Unlock()
+
+	for _, cur := range current {
+		// lookups are done without the lock, so writes are not held up
+		if !stale(cur.e.addr, cur.conn) {
+			continue
+		}
+		c.mu.Lock()
+		if cur.e.conn == cur.conn { // not reconnected in the meantime
+			if conn, err := c.dial(cur.e.addr); err != nil {
+				log.Printf("pool: %s resolves to a new address, but reconnecting failed: %s\n", cur.e.addr, err)
+			} else {
+				log.Printf("pool: %s resolves to a new address, reconnected to %s\n", cur.e.addr, conn.RemoteAddr())
+				if !c.deadline.IsZero() {
+					conn.SetDeadline(c.deadline) //nolint:errcheck
+				}
+				cur.conn.Close()
+				cur.e.conn = conn
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// stale returns whether the remote address of a connection to addr is no
+// longer one of the addresses its host name resolves to
+func stale(addr string, conn net.Conn) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return false
+	}
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ips, err := lookupHost(host)
+	if err != nil || len(ips) == 0 {
+		return false // keep the connection while DNS is unavailable
+	}
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.Equal(remote.IP) {
+			return false
+		}
+	}
+	return true
+}
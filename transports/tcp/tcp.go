@@ -5,6 +5,7 @@ import (
 
 	"github.com/gliderlabs/logspout/adapters/raw"
 	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/transports/pool"
 )
 
 func init() {
@@ -21,6 +22,17 @@ func rawTCPAdapter(route *router.Route) (router.LogAdapter, error) {
 type tcpTransport int
 
 func (t *tcpTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	if pool.Enabled(options) {
+		conn, err := pool.Dial(dialTCP, addr, options)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	return dialTCP(addr)
+}
+
+func dialTCP(addr string) (net.Conn, error) {
 	raddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return nil, err
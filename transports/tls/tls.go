@@ -9,11 +9,12 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
-	"os"
 	"strings"
 
 	"github.com/gliderlabs/logspout/adapters/raw"
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
+	"github.com/gliderlabs/logspout/transports/pool"
 )
 
 const (
@@ -23,7 +24,6 @@ const (
 	envClientCert         = "LOGSPOUT_TLS_CLIENT_CERT"
 	envClientKey          = "LOGSPOUT_TLS_CLIENT_KEY"
 	envTLSHardening       = "LOGSPOUT_TLS_HARDENING"
-	trueString            = "true"
 )
 
 var (
@@ -63,6 +63,18 @@ func init() {
 	router.AdapterTransports.Register(new(tlsTransport), "tls")
 	// convenience adapters around raw adapter
 	router.AdapterFactories.Register(rawTLSAdapter, "tls")
+	cfg.Register(
+		cfg.Option{Name: envDisableSystemRoots, Type: cfg.Bool, Default: "false",
+			Description: "do not load the system trust store into the trust store of logspout"},
+		cfg.Option{Name: envCaCerts,
+			Description: "comma separated paths of PEM encoded CA certificates to trust"},
+		cfg.Option{Name: envClientCert,
+			Description: "path of the PEM encoded client certificate for TLS mutual authentication"},
+		cfg.Option{Name: envClientKey,
+			Description: "path of the PEM encoded client private key for TLS mutual authentication"},
+		cfg.Option{Name: envTLSHardening, Type: cfg.Bool, Default: "false",
+			Description: "use stricter client TLS settings, mitigating known TLS vulnerabilities"},
+	)
 
 	// we should load our TLS configuration only once
 	// since it is not expected to change during runtime
@@ -87,18 +99,29 @@ func (t *tlsTransport) Dial(addr string, options map[string]string) (conn net.Co
 		return
 	}
 
-	// attempt to establish the TLS connection
-	conn, err = tls.Dial("tcp", addr, clientTLSConfig)
+	// attempt to establish the TLS connection, to each endpoint if there are several
+	if pool.Enabled(options) {
+		var pooled *pool.Conn
+		if pooled, err = pool.Dial(dialTLS, addr, options); err == nil {
+			conn = pooled
+		}
+		return
+	}
+	conn, err = dialTLS(addr)
 	return
 }
 
+func dialTLS(addr string) (net.Conn, error) {
+	return tls.Dial("tcp", addr, clientTLSConfig)
+}
+
 // createTLSConfig creates the required TLS configuration that we need to establish a TLS connection
 func createTLSConfig() (tlsConfig *tls.Config, err error) {
 	tlsConfig = &tls.Config{}
 
 	// use stronger TLS settings if enabled
 	// TODO: perhaps this should be default setting
-	if os.Getenv(envTLSHardening) == trueString {
+	if cfg.GetBool(envTLSHardening) {
 		tlsConfig.InsecureSkipVerify = false
 		tlsConfig.MinVersion = hardenedMinVersion
 		tlsConfig.CipherSuites = hardenedCiphers
@@ -113,7 +136,7 @@ func createTLSConfig() (tlsConfig *tls.Config, err error) {
 	// if we cannot, then it's fatal.
 	// NOTE that we ONLY fail if SystemCertPool returns an error,
 	// not if our system trust store is empty or doesn't exist!
-	if os.Getenv(envDisableSystemRoots) != trueString {
+	if !cfg.GetBool(envDisableSystemRoots) {
 		tlsConfig.RootCAs, err = x509.SystemCertPool()
 		if err != nil {
 			return
@@ -126,7 +149,7 @@ func createTLSConfig() (tlsConfig *tls.Config, err error) {
 	// as the user may not wish to send logs through an untrusted TLS connection
 	// also note that each file specified above can contain one or more certificates
 	// and we also _DO NOT_ check if they are CA certificates (in case of self-signed)
-	if certsEnv := os.Getenv(envCaCerts); certsEnv != "" {
+	if certsEnv := cfg.GetString(envCaCerts); certsEnv != "" {
 		certFilePaths := strings.Split(certsEnv, ",")
 		for _, certFilePath := range certFilePaths {
 			// each pem file may contain more than one certficate
@@ -144,8 +167,8 @@ func createTLSConfig() (tlsConfig *tls.Config, err error) {
 
 	// load a client certificate and key if enabled
 	// we should only attempt this if BOTH cert and key are defined
-	clientCertFilePath := os.Getenv(envClientCert)
-	clientKeyFilePath := os.Getenv(envClientKey)
+	clientCertFilePath := cfg.GetString(envClientCert)
+	clientKeyFilePath := cfg.GetString(envClientKey)
 	if clientCertFilePath != "" && clientKeyFilePath != "" {
 		var clientCert tls.Certificate
 		clientCert, err = tls.LoadX509KeyPair(clientCertFilePath, clientKeyFilePath)
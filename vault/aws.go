@@ -0,0 +1,99 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// awsExpiryWindow is how long before credentials expire the AWS SDK asks
+// for new ones, plus a margin
+const awsExpiryWindow = 6 * time.Minute
+
+// awsCredentials are the credentials the AWS SDK reads from an endpoint
+type awsCredentials struct {
+	AccessKeyID     string     `json:"AccessKeyId"`
+	SecretAccessKey string     `json:"SecretAccessKey"`
+	Token           string     `json:"Token,omitempty"`
+	Expiration      *time.Time `json:"Expiration,omitempty"`
+}
+
+// serveAWSCredentials serves the credentials of the VAULT_AWS_PATH to the
+// AWS SDK, on a port of the loopback interface it is pointed at with
+// AWS_CONTAINER_CREDENTIALS_FULL_URI, like the credentials of ECS tasks.
+// The SDK asks for them again before they expire, so the AWS adapters use
+// new credentials without being created again.
+func serveAWSCredentials() error {
+	path := cfg.GetString("VAULT_AWS_PATH")
+	if path == "" {
+		return nil
+	}
+	c, err := vaultClient()
+	if err != nil {
+		return err
+	}
+	// fail at startup rather than on the first request of an adapter
+	if _, err := awsCredentialsOf(c, path); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(secret)
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "http://"+listener.Addr().String()+"/")
+	os.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", token)
+	go http.Serve(listener, awsCredentialsHandler(c, path, token)) //nolint:errcheck
+	return nil
+}
+
+func awsCredentialsHandler(c *client, path, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != token {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		creds, err := awsCredentialsOf(c, path)
+		if err != nil {
+			log.Println("vault:", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]string{"code": "VaultError", "message": err.Error()}) //nolint:errcheck
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(creds) //nolint:errcheck
+	})
+}
+
+// awsCredentialsOf returns the credentials of an AWS secrets engine path,
+// read again before the SDK would find them about to expire
+func awsCredentialsOf(c *client, path string) (*awsCredentials, error) {
+	s, err := c.read(path, awsExpiryWindow)
+	if err != nil {
+		return nil, err
+	}
+	creds := &awsCredentials{}
+	if creds.AccessKeyID, err = s.field("access_key"); err != nil {
+		return nil, err
+	}
+	if creds.SecretAccessKey, err = s.field("secret_key"); err != nil {
+		return nil, err
+	}
+	creds.Token, _ = s.field("security_token") // only of STS credentials
+	if !s.expires.IsZero() {
+		creds.Expiration = &s.expires
+	}
+	return creds, nil
+}
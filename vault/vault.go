@@ -0,0 +1,329 @@
+// Package vault fetches the credentials of logspout from HashiCorp Vault.
+// Options set to references like vault://secret/data/logspout#token take
+// the value of that field of the secret, read again before its lease
+// expires, and the AWS adapters can take their credentials from an AWS
+// secrets engine, so credentials are neither in the environment nor long
+// lived. logspout logs in to Vault with AppRole, Kubernetes or a token.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Methods of logging in to Vault
+const (
+	MethodAppRole    = "approle"
+	MethodKubernetes = "kubernetes"
+	MethodToken      = "token"
+)
+
+const requestTimeout = 10 * time.Second
+
+func init() {
+	router.Jobs.Register(&refresher{}, "vault")
+	cfg.Register(
+		cfg.Option{Name: "VAULT_ADDR", Description: "URL of the Vault server credentials are read from, eg: https://vault:8200"},
+		cfg.Option{Name: "VAULT_AUTH_METHOD", Default: MethodAppRole,
+			Validate:    cfg.OneOf(MethodAppRole, MethodKubernetes, MethodToken),
+			Description: "how logspout logs in to Vault"},
+		cfg.Option{Name: "VAULT_AUTH_PATH", Description: "path the auth method is mounted at, if not its name"},
+		cfg.Option{Name: "VAULT_ROLE_ID", Description: "role ID of the approle auth method"},
+		cfg.Option{Name: "VAULT_SECRET_ID", Secret: true, Description: "secret ID of the approle auth method"},
+		cfg.Option{Name: "VAULT_ROLE", Description: "role of the kubernetes auth method"},
+		cfg.Option{Name: "VAULT_K8S_TOKEN_FILE", Default: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+			Description: "service account token of the kubernetes auth method"},
+		cfg.Option{Name: "VAULT_TOKEN", Secret: true, Description: "token of the token auth method"},
+		cfg.Option{Name: "VAULT_REFRESH_INTERVAL", Type: cfg.Duration, Default: "1m",
+			Description: "how often options set to vault:// references are read again"},
+		cfg.Option{Name: "VAULT_AWS_PATH",
+			Description: "path of AWS credentials for the AWS adapters, eg: aws/creds/logspout"},
+	)
+	cfg.RegisterResolver("vault", resolve)
+	cfg.AfterParse(serveAWSCredentials)
+}
+
+var (
+	sharedOnce sync.Once
+	shared     *client
+)
+
+// vaultClient returns the client of the VAULT_ADDR, once options are parsed
+func vaultClient() (*client, error) {
+	sharedOnce.Do(func() {
+		if addr := cfg.GetString("VAULT_ADDR"); addr != "" {
+			shared = newClient(addr, cfg.GetString("VAULT_AUTH_METHOD"), cfg.GetString("VAULT_AUTH_PATH"))
+		}
+	})
+	if shared == nil {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	return shared, nil
+}
+
+// resolve returns the field of a secret a vault:// reference names, as
+// path#field
+func resolve(ref string) (string, error) {
+	c, err := vaultClient()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(ref, "#", 2)
+	s, err := c.read(parts[0], 0)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 1 {
+		return s.only()
+	}
+	return s.field(parts[1])
+}
+
+// client reads secrets from Vault, logging in again when its token expires
+type client struct {
+	addr   string
+	method string
+	mount  string
+	http   *http.Client
+
+	mu      sync.Mutex
+	token   string
+	renewAt time.Time // when to log in again, zero if the token does not expire
+	cache   map[string]*secret
+}
+
+func newClient(addr, method, mount string) *client {
+	if mount == "" {
+		mount = method
+	}
+	return &client{
+		addr:   strings.TrimSuffix(addr, "/"),
+		method: method,
+		mount:  mount,
+		http:   &http.Client{Timeout: requestTimeout},
+		cache:  map[string]*secret{},
+	}
+}
+
+// secret is the response of Vault to a read or a login
+type secret struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+
+	expires time.Time // when its lease ends, zero without one
+	renewAt time.Time // when it is read again
+}
+
+// leased sets when a secret read at a time expires and is read again, at
+// two thirds of its lease
+func (s *secret) leased(now time.Time, seconds int) {
+	if seconds <= 0 {
+		s.expires, s.renewAt = time.Time{}, now
+		return
+	}
+	lease := time.Duration(seconds) * time.Second
+	s.expires, s.renewAt = now.Add(lease), now.Add(lease*2/3)
+}
+
+// field returns a field of the data of a secret, or of the data of a
+// secret of the version 2 KV secrets engine
+func (s *secret) field(name string) (string, error) {
+	data := s.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, direct := data[name]; !direct {
+			data = nested
+		}
+	}
+	value, ok := data[name]
+	if !ok {
+		return "", fmt.Errorf("vault: no field %s", name)
+	}
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", nil
+	default:
+		text, err := json.Marshal(value)
+		return string(text), err
+	}
+}
+
+// only returns the field of a secret with a single one
+func (s *secret) only() (string, error) {
+	data := s.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	if len(data) != 1 {
+		return "", errors.New("vault: a field must be given as path#field for secrets with several")
+	}
+	for name := range data {
+		return s.field(name)
+	}
+	return "", nil
+}
+
+// read returns a secret, as read before unless it is due to be read again,
+// or expires in less than valid
+func (c *client) read(path string, valid time.Duration) (*secret, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if s := c.cache[path]; s != nil && now.Before(s.renewAt) && now.Add(valid).Before(s.expires) {
+		return s, nil
+	}
+	s := &secret{}
+	if err := c.request("GET", path, nil, s); err != nil {
+		return nil, err
+	}
+	s.leased(now, s.LeaseDuration)
+	c.cache[path] = s
+	return s, nil
+}
+
+// request sends a request to Vault with the token, logging in first if
+// need be, and once again if the token was refused
+func (c *client) request(method, path string, body, out interface{}) error {
+	if err := c.authenticate(false); err != nil {
+		return err
+	}
+	err := c.send(method, path, body, out)
+	if err == errForbidden && c.method != MethodToken {
+		if err = c.authenticate(true); err == nil {
+			err = c.send(method, path, body, out)
+		}
+	}
+	return err
+}
+
+var errForbidden = errors.New("vault: permission denied")
+
+// authenticate logs in to Vault, if it has no token yet or it is due to
+// expire, at two thirds of its lease, or again if it was refused
+func (c *client) authenticate(again bool) error {
+	if c.method == MethodToken {
+		c.token = cfg.GetString("VAULT_TOKEN")
+		return nil
+	}
+	if !again && c.token != "" && (c.renewAt.IsZero() || time.Now().Before(c.renewAt)) {
+		return nil
+	}
+	body := map[string]string{
+		"role_id":   cfg.GetString("VAULT_ROLE_ID"),
+		"secret_id": cfg.GetString("VAULT_SECRET_ID"),
+	}
+	if c.method == MethodKubernetes {
+		jwt, err := ioutil.ReadFile(cfg.GetString("VAULT_K8S_TOKEN_FILE"))
+		if err != nil {
+			return fmt.Errorf("vault: %s", err)
+		}
+		body = map[string]string{"role": cfg.GetString("VAULT_ROLE"), "jwt": strings.TrimSpace(string(jwt))}
+	}
+	now := time.Now()
+	c.token = ""
+	login := &secret{}
+	if err := c.send("POST", "auth/"+c.mount+"/login", body, login); err != nil {
+		return err
+	}
+	if login.Auth == nil || login.Auth.ClientToken == "" {
+		return errors.New("vault: no token in the response to logging in")
+	}
+	c.token = login.Auth.ClientToken
+	c.renewAt = time.Time{}
+	if login.Auth.LeaseDuration > 0 {
+		c.renewAt = now.Add(time.Duration(login.Auth.LeaseDuration) * time.Second * 2 / 3)
+	}
+	return nil
+}
+
+// send sends a request to the API of Vault, decoding the response into out
+func (c *client) send(method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.addr+"/v1/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("vault: %s", err)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %s", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("vault: %s %s: %s", method, path, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return errForbidden
+	case resp.StatusCode >= 300:
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(data, &failure) //nolint:errcheck
+		return fmt.Errorf("vault: %s %s: %s %s", method, path, resp.Status, strings.Join(failure.Errors, ", "))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("vault: %s %s: %s", method, path, err)
+	}
+	return nil
+}
+
+// refresher is the job reading the options set to vault:// references
+// again every VAULT_REFRESH_INTERVAL, for the code using them to pick up
+// rotated secrets
+type refresher struct {
+	interval time.Duration
+}
+
+func (r *refresher) Name() string {
+	if r.interval == 0 {
+		return ""
+	}
+	return "vault"
+}
+
+func (r *refresher) Setup() error {
+	if cfg.GetString("VAULT_ADDR") != "" {
+		r.interval = cfg.GetDuration("VAULT_REFRESH_INTERVAL")
+	}
+	return nil
+}
+
+func (r *refresher) Run() error {
+	if r.interval == 0 {
+		select {}
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := cfg.Refresh("vault"); err != nil {
+			log.Println("vault:", err)
+		}
+	}
+	return nil
+}
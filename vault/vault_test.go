@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeVault answers logins with the approle auth method, and reads of a KV
+// secret and AWS credentials, counting the requests of each path
+type fakeVault struct {
+	requests map[string]int
+	token    string
+	leases   int // lease of AWS credentials, in seconds
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests[r.URL.Path]++
+	var response interface{}
+	switch {
+	case r.URL.Path == "/v1/auth/approle/login":
+		var login map[string]string
+		json.NewDecoder(r.Body).Decode(&login)
+		if login["role_id"] != "role" || login["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["invalid role or secret ID"]}`))
+			return
+		}
+		response = map[string]interface{}{"auth": map[string]interface{}{"client_token": f.token, "lease_duration": 3600}}
+	case r.Header.Get("X-Vault-Token") != f.token:
+		w.WriteHeader(http.StatusForbidden)
+		return
+	case r.URL.Path == "/v1/secret/data/logspout":
+		response = map[string]interface{}{"data": map[string]interface{}{
+			"data":     map[string]interface{}{"token": "s3cr3t", "port": 514},
+			"metadata": map[string]interface{}{"version": 2},
+		}}
+	case r.URL.Path == "/v1/aws/creds/logspout":
+		response = map[string]interface{}{"lease_duration": f.leases, "data": map[string]interface{}{
+			"access_key": "AKID", "secret_key": "SECRET", "security_token": nil,
+		}}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *client, func()) {
+	os.Setenv("VAULT_ROLE_ID", "role")
+	os.Setenv("VAULT_SECRET_ID", "secret")
+	f := &fakeVault{requests: map[string]int{}, token: "t1", leases: 3600}
+	server := httptest.NewServer(f)
+	return f, newClient(server.URL, MethodAppRole, ""), func() {
+		server.Close()
+		os.Unsetenv("VAULT_ROLE_ID")
+		os.Unsetenv("VAULT_SECRET_ID")
+	}
+}
+
+func TestReadKVSecret(t *testing.T) {
+	f, c, done := newFakeVault(t)
+	defer done()
+	s, err := c.read("secret/data/logspout", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := s.field("token"); err != nil || token != "s3cr3t" {
+		t.Errorf("expected the token of the secret, got %q %v", token, err)
+	}
+	if port, err := s.field("port"); err != nil || port != "514" {
+		t.Errorf("expected numbers as text, got %q %v", port, err)
+	}
+	if _, err := s.field("password"); err == nil {
+		t.Error("expected a missing field to be an error")
+	}
+	if _, err := s.only(); err == nil {
+		t.Error("expected a field to be required for secrets with several")
+	}
+	if f.requests["/v1/auth/approle/login"] != 1 {
+		t.Errorf("expected to log in once, got %d", f.requests["/v1/auth/approle/login"])
+	}
+}
+
+func TestLoginAgainWhenRefused(t *testing.T) {
+	f, c, done := newFakeVault(t)
+	defer done()
+	if _, err := c.read("secret/data/logspout", 0); err != nil {
+		t.Fatal(err)
+	}
+	f.token = "t2" // revoked
+	if _, err := c.read("secret/data/logspout", 0); err != nil {
+		t.Fatal(err)
+	}
+	if f.requests["/v1/auth/approle/login"] != 2 || c.token != "t2" {
+		t.Errorf("expected to log in again, got %d logins", f.requests["/v1/auth/approle/login"])
+	}
+}
+
+func TestLeasedSecretsAreReadAgain(t *testing.T) {
+	f, c, done := newFakeVault(t)
+	defer done()
+	for i := 0; i < 2; i++ {
+		if _, err := awsCredentialsOf(c, "aws/creds/logspout"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.requests["/v1/aws/creds/logspout"] != 1 {
+		t.Errorf("expected credentials to be read once within their lease, got %d", f.requests["/v1/aws/creds/logspout"])
+	}
+	c.cache["aws/creds/logspout"].renewAt = c.cache["aws/creds/logspout"].renewAt.Add(-time.Hour)
+	if _, err := awsCredentialsOf(c, "aws/creds/logspout"); err != nil {
+		t.Fatal(err)
+	}
+	if f.requests["/v1/aws/creds/logspout"] != 2 {
+		t.Error("expected credentials to be read again at two thirds of their lease")
+	}
+}
+
+func TestAWSCredentialsHandler(t *testing.T) {
+	_, c, done := newFakeVault(t)
+	defer done()
+	handler := awsCredentialsHandler(c, "aws/creds/logspout", "letmein")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected requests without the token to be refused, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "letmein")
+	handler.ServeHTTP(w, r)
+	var creds awsCredentials
+	if err := json.Unmarshal(w.Body.Bytes(), &creds); err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "SECRET" || creds.Token != "" || creds.Expiration == nil {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
@@ -0,0 +1,43 @@
+// Package zstd writes and reads zstd frames, for the adapters compressing
+// records and the spool of the router, with the encoder and decoder of
+// github.com/klauspost/compress
+package zstd
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrCorrupt is returned for frames that are corrupt, or truncated
+var ErrCorrupt = errors.New("zstd: corrupt frame")
+
+// magic starts every frame
+var magic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// encoder and decoder are shared, their EncodeAll and DecodeAll are safe
+// for concurrent use
+var (
+	encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithZeroFrames(true))
+	decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+)
+
+// Compress returns data as a single zstd frame, with the checksum of its
+// content
+func Compress(data []byte) []byte {
+	return encoder.EncodeAll(data, make([]byte, 0, len(data)/2+32))
+}
+
+// Decompress returns the content of a zstd frame, like one written by
+// Compress or the zstd command
+func Decompress(frame []byte) ([]byte, error) {
+	if !bytes.HasPrefix(frame, magic) { // which the decoder skips
+		return nil, ErrCorrupt
+	}
+	data, err := decoder.DecodeAll(frame, nil)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
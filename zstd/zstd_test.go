@@ -0,0 +1,71 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+)
+
+func TestDecompress(t *testing.T) {
+	var logs bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&logs, `{"time":"2020-06-01T12:00:%02dZ","container_name":"web","source":"stdout","message":"GET /api/%d 200"}`+"\n", i%60, i)
+	}
+	for _, in := range [][]byte{nil, []byte("a"), logs.Bytes()[:100], logs.Bytes(), bytes.Repeat([]byte{'x'}, 300*1024)} {
+		if out, err := Decompress(Compress(in)); err != nil || !bytes.Equal(out, in) {
+			t.Errorf("expected %d bytes back, got %d: %v", len(in), len(out), err)
+		}
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	var logs bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&logs, "level=info msg=\"GET /api/%d 200\"\n", i)
+	}
+	frame := Compress(logs.Bytes())
+	for n := 0; n < len(frame); n++ { // truncated, as by a crash while writing
+		if _, err := Decompress(frame[:n]); err == nil {
+			t.Fatalf("expected a frame truncated to %d bytes to fail", n)
+		}
+	}
+	for i := range frame { // flipped bits fail or decode to other content, never panic
+		corrupt := append([]byte{}, frame...)
+		corrupt[i] ^= 0x5a
+		Decompress(corrupt) //nolint:errcheck
+	}
+}
+
+// the frames of testdata were written by the reference zstd command, as
+// zstd -19 --check logs.ndjson
+func TestDecompressReferenceFrame(t *testing.T) {
+	expected, err := ioutil.ReadFile("testdata/logs.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ioutil.ReadFile("testdata/logs.ndjson.zst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Decompress(frame); err != nil || !bytes.Equal(out, expected) {
+		t.Errorf("expected the %d bytes the zstd command compressed, got %d: %v", len(expected), len(out), err)
+	}
+}
+
+func TestReferenceDecompress(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("the zstd command is not installed")
+	}
+	in, err := ioutil.ReadFile("testdata/logs.ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("zstd", "-d", "-c")
+	cmd.Stdin = bytes.NewReader(Compress(in))
+	out, err := cmd.Output()
+	if err != nil || !bytes.Equal(out, in) {
+		t.Errorf("expected the zstd command to decompress the %d bytes, got %d: %v", len(in), len(out), err)
+	}
+}